@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"code.google.com/p/weed-fs/go/storage"
 	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
 	"strings"
 )
 
@@ -19,7 +20,7 @@ func NewFileId(VolumeId storage.VolumeId, Key uint64, Hashcode uint32) *FileId {
 func ParseFileId(fid string) *FileId {
 	a := strings.Split(fid, ",")
 	if len(a) != 2 {
-		println("Invalid fid", fid, ", split length", len(a))
+		wlog.Debug("invalid fid", "fid", fid, "parts", len(a))
 		return nil
 	}
 	vid_string, key_hash_string := a[0], a[1]
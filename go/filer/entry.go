@@ -0,0 +1,47 @@
+package filer
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// Chunk is a part of a file's content, stored under its own fid.
+type Chunk struct {
+	Fid    string `json:"fid"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// Entry is a file or a directory. The content of a file is the concatenation
+// of its chunks, in the order of their offsets.
+type Entry struct {
+	FullPath    string    `json:"path"`
+	IsDirectory bool      `json:"isDirectory,omitempty"`
+	Mime        string    `json:"mime,omitempty"`
+	Size        int64     `json:"size"`
+	Mtime       time.Time `json:"mtime"`
+	Chunks      []Chunk   `json:"chunks,omitempty"`
+	Md5         string    `json:"md5,omitempty"` //hex md5 of the content, if written in one piece
+
+	//attributes of the applications on top of the filer, e.g. user metadata of an s3 object
+	Extended map[string]string `json:"extended,omitempty"`
+}
+
+func (e *Entry) Name() string {
+	return path.Base(e.FullPath)
+}
+
+func (e *Entry) Dir() string {
+	return path.Dir(e.FullPath)
+}
+
+//the clean absolute form of a path, / for the root
+func CleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// IsUnder is whether p is dir itself or somewhere below it.
+func IsUnder(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
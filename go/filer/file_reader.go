@@ -0,0 +1,98 @@
+package filer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// FileReader reads the content of a file, downloading each chunk when the read
+// reaches it. Seeking only moves the offset, so http.ServeContent can serve
+// ranges of a file without downloading the chunks before them.
+type FileReader struct {
+	ctx    context.Context
+	filer  *Filer
+	entry  *Entry
+	offset int64
+
+	body    io.ReadCloser //the chunk being read, at offset
+	bodyEnd int64         //offset of the end of the chunk being read
+}
+
+// Open returns a reader of the content of a file, to close after use.
+func (f *Filer) Open(ctx context.Context, entry *Entry) *FileReader {
+	return &FileReader{ctx: ctx, filer: f, entry: entry}
+}
+
+//starts downloading the chunk at the offset
+func (r *FileReader) openChunk() error {
+	for _, c := range r.entry.Chunks {
+		if c.Offset <= r.offset && r.offset < c.Offset+c.Size {
+			file, err := r.filer.Client.Download(r.ctx, c.Fid)
+			if err != nil {
+				return err
+			}
+			if _, err = io.CopyN(ioutil.Discard, file.Body, r.offset-c.Offset); err != nil {
+				file.Body.Close()
+				return err
+			}
+			r.body, r.bodyEnd = file.Body, c.Offset+c.Size
+			return nil
+		}
+	}
+	//the chunks do not cover the size of the file
+	return io.ErrUnexpectedEOF
+}
+
+func (r *FileReader) Read(p []byte) (int, error) {
+	if r.offset >= r.entry.Size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		if err := r.openChunk(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > r.bodyEnd-r.offset {
+		p = p[:r.bodyEnd-r.offset]
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if r.offset == r.bodyEnd || err != nil {
+		r.body.Close()
+		r.body = nil
+		if err == io.EOF && r.offset < r.bodyEnd {
+			err = io.ErrUnexpectedEOF
+		} else if err == io.EOF {
+			err = nil
+		}
+	}
+	return n, err
+}
+
+func (r *FileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.entry.Size
+	}
+	if offset < 0 {
+		return r.offset, errors.New("seek before the start of the file")
+	}
+	if offset != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *FileReader) Close() error {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package filer
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"code.google.com/p/weed-fs/go/operation"
+	"code.google.com/p/weed-fs/go/wlog"
+	"sync"
+	"time"
+)
+
+// Filer maps paths to files stored on the volume servers. Files are split into
+// chunks of at most ChunkSize bytes, each uploaded under its own fid.
+type Filer struct {
+	Store  Store
+	Client *operation.Client
+
+	ChunkSize   int64
+	Replication string //replication type of the chunks, empty for the master's default
+
+	lock sync.Mutex //serializes the changes of the namespace, not the uploads
+}
+
+func NewFiler(store Store, client *operation.Client) *Filer {
+	return &Filer{Store: store, Client: client, ChunkSize: 8 * 1024 * 1024}
+}
+
+//the entry at the path, the root directory included
+func (f *Filer) Get(fullPath string) (*Entry, error) {
+	fullPath = CleanPath(fullPath)
+	if fullPath == "/" {
+		return &Entry{FullPath: "/", IsDirectory: true}, nil
+	}
+	return f.Store.Get(fullPath)
+}
+
+func (f *Filer) List(dir string, startAfter string, limit int) ([]*Entry, error) {
+	e, err := f.Get(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsDirectory {
+		return nil, ErrNotDirectory
+	}
+	return f.Store.List(e.FullPath, startAfter, limit)
+}
+
+//creates the directory and its missing parents, the caller holds the lock
+func (f *Filer) mkdirAll(dir string) error {
+	e, err := f.Get(dir)
+	if err == nil {
+		if !e.IsDirectory {
+			return ErrNotDirectory
+		}
+		return nil
+	}
+	if err != ErrNotFound {
+		return err
+	}
+	parent := &Entry{FullPath: dir}
+	if err = f.mkdirAll(parent.Dir()); err != nil {
+		return err
+	}
+	return f.Store.Put(&Entry{FullPath: dir, IsDirectory: true, Mtime: time.Now()})
+}
+
+// Mkdir creates a directory and its missing parents, like mkdir -p.
+func (f *Filer) Mkdir(dir string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.mkdirAll(CleanPath(dir))
+}
+
+//uploads the content in chunks, deleting the uploaded ones if a later one fails
+func (f *Filer) writeChunks(ctx context.Context, name string, mime string, r io.Reader) (chunks []Chunk, size int64, md5sum string, err error) {
+	hash := md5.New()
+	r = io.TeeReader(r, hash)
+	var buf bytes.Buffer
+	for {
+		buf.Reset()
+		n, readErr := io.CopyN(&buf, r, f.ChunkSize)
+		if readErr != nil && readErr != io.EOF {
+			f.deleteChunks(ctx, chunks)
+			return nil, 0, "", readErr
+		}
+		if n > 0 {
+			var uploaded *operation.UploadedFile
+			uploaded, err = f.Client.Upload(ctx, operation.UploadFile{Name: name, MimeType: mime, Reader: bytes.NewReader(buf.Bytes())}, f.Replication)
+			if err != nil {
+				f.deleteChunks(ctx, chunks)
+				return nil, 0, "", err
+			}
+			chunks = append(chunks, Chunk{Fid: uploaded.Fid, Offset: size, Size: n})
+			size += n
+		}
+		if readErr == io.EOF {
+			return chunks, size, hex.EncodeToString(hash.Sum(nil)), nil
+		}
+	}
+}
+
+//deletes the content of removed or replaced files, failures only leave garbage
+func (f *Filer) deleteChunks(ctx context.Context, chunks []Chunk) {
+	if len(chunks) == 0 {
+		return
+	}
+	fids := make([]string, len(chunks))
+	for i, c := range chunks {
+		fids[i] = c.Fid
+	}
+	for i, err := range f.Client.DeleteBatch(ctx, fids) {
+		if err != nil && err != operation.ErrNotFound {
+			wlog.Warn("failed to delete file chunk", "fid", fids[i], "error", err)
+		}
+	}
+}
+
+//the chunks of old not in the new list, to delete when a file is replaced
+func unusedChunks(old, chunks []Chunk) []Chunk {
+	used := make(map[string]bool)
+	for _, c := range chunks {
+		used[c.Fid] = true
+	}
+	ret := []Chunk{}
+	for _, c := range old {
+		if !used[c.Fid] {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+//puts the entry and its missing parents, and returns the file it replaced if any.
+//a directory entry can replace a directory, to change its attributes.
+//the caller holds the lock.
+func (f *Filer) putEntry(entry *Entry) (replaced *Entry, err error) {
+	old, err := f.Get(entry.FullPath)
+	if err == nil {
+		if old.IsDirectory && !entry.IsDirectory {
+			return nil, ErrIsDirectory
+		}
+		if !old.IsDirectory && entry.IsDirectory {
+			return nil, ErrExists
+		}
+		if !old.IsDirectory {
+			replaced = old
+		}
+	} else if err != ErrNotFound {
+		return nil, err
+	}
+	if err = f.mkdirAll(entry.Dir()); err != nil {
+		return nil, err
+	}
+	return replaced, f.Store.Put(entry)
+}
+
+// WriteFile stores the content of r as the file entry.FullPath, replacing the file
+// there if any. The chunks, size, md5 and mtime of the entry are set from the content,
+// its other attributes are kept. Missing parent directories are created.
+func (f *Filer) WriteFile(ctx context.Context, entry *Entry, r io.Reader) error {
+	entry.FullPath = CleanPath(entry.FullPath)
+	if entry.FullPath == "/" {
+		return ErrIsDirectory
+	}
+	entry.IsDirectory = false
+	if old, err := f.Get(entry.FullPath); err == nil && old.IsDirectory {
+		return ErrIsDirectory
+	}
+	chunks, size, md5sum, err := f.writeChunks(ctx, entry.Name(), entry.Mime, r)
+	if err != nil {
+		return err
+	}
+	entry.Chunks, entry.Size, entry.Md5, entry.Mtime = chunks, size, md5sum, time.Now()
+
+	f.lock.Lock()
+	old, err := f.putEntry(entry)
+	f.lock.Unlock()
+	if err != nil {
+		f.deleteChunks(ctx, chunks)
+		return err
+	}
+	if old != nil {
+		f.deleteChunks(ctx, unusedChunks(old.Chunks, chunks))
+	}
+	return nil
+}
+
+// CreateFile writes the content of r to the path, replacing the file there if any.
+// Missing parent directories are created.
+func (f *Filer) CreateFile(ctx context.Context, fullPath string, mime string, r io.Reader) (*Entry, error) {
+	entry := &Entry{FullPath: fullPath, Mime: mime}
+	if err := f.WriteFile(ctx, entry, r); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// CreateEntry puts a file made of chunks already stored, e.g. the parts of an upload,
+// or a directory with its attributes. A replaced file's chunks that the entry does
+// not use are deleted.
+func (f *Filer) CreateEntry(ctx context.Context, entry *Entry) error {
+	entry.FullPath = CleanPath(entry.FullPath)
+	if entry.FullPath == "/" {
+		return ErrInvalidPath
+	}
+	if entry.Mtime.IsZero() {
+		entry.Mtime = time.Now()
+	}
+	f.lock.Lock()
+	old, err := f.putEntry(entry)
+	f.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		f.deleteChunks(ctx, unusedChunks(old.Chunks, entry.Chunks))
+	}
+	return nil
+}
+
+// Read writes the content of a file to w, chunk after chunk.
+func (f *Filer) Read(ctx context.Context, entry *Entry, w io.Writer) error {
+	return f.ReadRange(ctx, entry, 0, entry.Size, w)
+}
+
+// ReadRange writes size bytes of the content of a file from offset to w. Only the
+// chunks overlapping the range are downloaded.
+func (f *Filer) ReadRange(ctx context.Context, entry *Entry, offset int64, size int64, w io.Writer) error {
+	if entry.IsDirectory {
+		return ErrIsDirectory
+	}
+	end := offset + size
+	for _, c := range entry.Chunks {
+		if c.Offset+c.Size <= offset || c.Offset >= end {
+			continue
+		}
+		file, err := f.Client.Download(ctx, c.Fid)
+		if err != nil {
+			return err
+		}
+		start, stop := c.Offset, c.Offset+c.Size
+		if start < offset {
+			_, err = io.CopyN(ioutil.Discard, file.Body, offset-start)
+			start = offset
+		}
+		if stop > end {
+			stop = end
+		}
+		if err == nil {
+			_, err = io.CopyN(w, file.Body, stop-start)
+		}
+		file.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//the entries below dir, deepest first, so they can be removed in that order
+func (f *Filer) walk(dir string, visit func(e *Entry) error) error {
+	startAfter := ""
+	for {
+		entries, err := f.Store.List(dir, startAfter, 1024)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		for _, e := range entries {
+			if e.IsDirectory {
+				if err = f.walk(e.FullPath, visit); err != nil {
+					return err
+				}
+			}
+			if err = visit(e); err != nil {
+				return err
+			}
+		}
+		startAfter = entries[len(entries)-1].Name()
+	}
+}
+
+// Rename moves a file or a directory with everything in it. The target must not
+// exist; its missing parent directories are created.
+func (f *Filer) Rename(from, to string) error {
+	from, to = CleanPath(from), CleanPath(to)
+	if from == "/" || to == "/" || IsUnder(to, from) {
+		return ErrInvalidPath
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	entry, err := f.Get(from)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Get(to); err == nil {
+		return ErrExists
+	} else if err != ErrNotFound {
+		return err
+	}
+	if err = f.mkdirAll((&Entry{FullPath: to}).Dir()); err != nil {
+		return err
+	}
+	//copy the whole tree first, so a failure leaves the source complete
+	moved := []string{}
+	if entry.IsDirectory {
+		err = f.walk(from, func(e *Entry) error {
+			moved = append(moved, e.FullPath)
+			copied := *e
+			copied.FullPath = to + e.FullPath[len(from):]
+			return f.Store.Put(&copied)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	moved = append(moved, from)
+	entry.FullPath = to
+	if err = f.Store.Put(entry); err != nil {
+		return err
+	}
+	for _, p := range moved {
+		if err = f.Store.Delete(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy copies a file, or a directory with everything in it. The content is stored
+// again, so the copy does not depend on the original. The target must not exist;
+// its missing parent directories are created.
+func (f *Filer) Copy(ctx context.Context, from, to string) error {
+	from, to = CleanPath(from), CleanPath(to)
+	if from == "/" || to == "/" || IsUnder(to, from) {
+		return ErrInvalidPath
+	}
+	entry, err := f.Get(from)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Get(to); err == nil {
+		return ErrExists
+	} else if err != ErrNotFound {
+		return err
+	}
+	return f.copyEntry(ctx, entry, to)
+}
+
+func (f *Filer) copyEntry(ctx context.Context, entry *Entry, to string) error {
+	copied := &Entry{FullPath: to, IsDirectory: entry.IsDirectory, Mime: entry.Mime}
+	if entry.Extended != nil {
+		copied.Extended = make(map[string]string)
+		for k, v := range entry.Extended {
+			copied.Extended[k] = v
+		}
+	}
+	if !entry.IsDirectory {
+		r := f.Open(ctx, entry)
+		defer r.Close()
+		return f.WriteFile(ctx, copied, r)
+	}
+	if err := f.CreateEntry(ctx, copied); err != nil {
+		return err
+	}
+	startAfter := ""
+	for {
+		children, err := f.Store.List(entry.FullPath, startAfter, 1024)
+		if err != nil || len(children) == 0 {
+			return err
+		}
+		for _, child := range children {
+			if err = f.copyEntry(ctx, child, to+"/"+child.Name()); err != nil {
+				return err
+			}
+		}
+		startAfter = children[len(children)-1].Name()
+	}
+}
+
+// Delete removes a file, or a directory if empty or if recursive, and the content
+// of the removed files.
+func (f *Filer) Delete(ctx context.Context, fullPath string, recursive bool) error {
+	fullPath = CleanPath(fullPath)
+	if fullPath == "/" {
+		return ErrInvalidPath
+	}
+	f.lock.Lock()
+	entry, err := f.Get(fullPath)
+	if err != nil {
+		f.lock.Unlock()
+		return err
+	}
+	var chunks []Chunk
+	if entry.IsDirectory {
+		children, err := f.Store.List(fullPath, "", 1)
+		if err == nil && len(children) > 0 && !recursive {
+			err = ErrNotEmpty
+		}
+		if err == nil {
+			err = f.walk(fullPath, func(e *Entry) error {
+				chunks = append(chunks, e.Chunks...)
+				return f.Store.Delete(e.FullPath)
+			})
+		}
+		if err != nil {
+			f.lock.Unlock()
+			return err
+		}
+	}
+	chunks = append(chunks, entry.Chunks...)
+	err = f.Store.Delete(fullPath)
+	f.lock.Unlock()
+	if err == nil {
+		f.deleteChunks(ctx, chunks)
+	}
+	return err
+}
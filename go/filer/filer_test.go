@@ -0,0 +1,156 @@
+package filer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func names(t *testing.T, f *Filer, dir string) string {
+	entries, err := f.List(dir, "", 0)
+	if err != nil {
+		t.Fatalf("list %s: %v", dir, err)
+	}
+	list := []string{}
+	for _, e := range entries {
+		list = append(list, e.Name())
+	}
+	return strings.Join(list, ",")
+}
+
+func TestLogStoreReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := OpenLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFiler(store, nil)
+	f.Mkdir("/a/b")
+	f.CreateFile(context.Background(), "/a/x.txt", "text/plain", strings.NewReader(""))
+	f.CreateFile(context.Background(), "/a/y.txt", "", strings.NewReader(""))
+	if err = f.Delete(context.Background(), "/a/y.txt", false); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	if store, err = OpenLogStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	f = NewFiler(store, nil)
+	if got := names(t, f, "/a"); got != "b,x.txt" {
+		t.Fatalf("replayed /a has %q", got)
+	}
+	if e, err := f.Get("/a/x.txt"); err != nil || e.Mime != "text/plain" || e.IsDirectory {
+		t.Fatalf("replayed /a/x.txt is %+v, %v", e, err)
+	}
+	if entries, _ := f.List("/a", "b", 1); len(entries) != 1 || entries[0].Name() != "x.txt" {
+		t.Fatalf("listing /a after b gave %v", entries)
+	}
+}
+
+func TestLogStoreTornTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := OpenLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFiler(store, nil)
+	f.Mkdir("/a")
+	store.Close()
+	//a crash in the middle of a record
+	log, _ := os.OpenFile(filepath.Join(dir, "filer.log"), os.O_WRONLY|os.O_APPEND, 0644)
+	log.Write([]byte(`{"put":{"FullPath":"/a/torn`))
+	log.Close()
+
+	if store, err = OpenLogStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	f = NewFiler(store, nil)
+	f.Mkdir("/a/b")
+	store.Close()
+
+	if store, err = OpenLogStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	f = NewFiler(store, nil)
+	if got := names(t, f, "/a"); got != "b" {
+		t.Fatalf("replayed /a has %q", got)
+	}
+}
+
+func TestLogStoreLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := OpenLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, err := OpenLogStore(dir); err == nil {
+		second.Close()
+		t.Fatalf("opened the store in %s twice", dir)
+	}
+	store.Close()
+
+	if store, err = OpenLogStore(dir); err != nil {
+		t.Fatalf("store still locked after close: %v", err)
+	}
+	store.Close()
+}
+
+func TestFilerRenameDelete(t *testing.T) {
+	f := NewFiler(NewMemoryStore(), nil)
+	ctx := context.Background()
+	f.CreateFile(ctx, "/photos/2024/a.jpg", "", strings.NewReader(""))
+	f.CreateFile(ctx, "/photos/2024/b.jpg", "", strings.NewReader(""))
+	if _, err := f.CreateFile(ctx, "/photos/2024/a.jpg/c.jpg", "", strings.NewReader("")); err != ErrNotDirectory {
+		t.Fatalf("creating below a file gave %v", err)
+	}
+	if _, err := f.CreateFile(ctx, "/photos", "", strings.NewReader("")); err != ErrIsDirectory {
+		t.Fatalf("replacing a directory gave %v", err)
+	}
+
+	if err := f.Rename("/photos", "/photos/old"); err != ErrInvalidPath {
+		t.Fatalf("renaming into itself gave %v", err)
+	}
+	if err := f.Rename("/photos/2024", "/archive/2024"); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(t, f, "/archive/2024"); got != "a.jpg,b.jpg" {
+		t.Fatalf("renamed directory has %q", got)
+	}
+	if got := names(t, f, "/photos"); got != "" {
+		t.Fatalf("renamed directory left %q", got)
+	}
+	if err := f.Rename("/photos", "/archive/2024/a.jpg"); err != ErrExists {
+		t.Fatalf("renaming over a file gave %v", err)
+	}
+
+	if err := f.Delete(ctx, "/archive", false); err != ErrNotEmpty {
+		t.Fatalf("deleting a full directory gave %v", err)
+	}
+	if err := f.Delete(ctx, "/archive", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Get("/archive/2024/a.jpg"); err != ErrNotFound {
+		t.Fatalf("recursively deleted file gave %v", err)
+	}
+	if got := names(t, f, "/"); got != "photos" {
+		t.Fatalf("root has %q", got)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !freebsd && !linux
+// +build !darwin,!freebsd,!linux
+
+package filer
+
+import (
+	"os"
+)
+
+//files cannot be locked, stores are not guarded against a second writer
+func lockFile(f *os.File) error {
+	return nil
+}
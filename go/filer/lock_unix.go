@@ -0,0 +1,14 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package filer
+
+import (
+	"os"
+	"syscall"
+)
+
+//an exclusive lock, failing at once when held through another open file
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
@@ -0,0 +1,247 @@
+package filer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"code.google.com/p/weed-fs/go/wlog"
+	"sort"
+	"sync"
+)
+
+func init() {
+	RegisterStore("log", OpenLogStore)
+	RegisterStore("memory", func(dir string) (Store, error) {
+		return NewMemoryStore(), nil
+	})
+}
+
+// LogStore is the built-in store. It keeps all entries in memory, and appends each
+// change to a log file replayed on open. The log is rewritten with only the current
+// entries on open when most of it is outdated, the same way volumes are compacted.
+type LogStore struct {
+	lock     sync.RWMutex
+	entries  map[string]*Entry
+	children map[string]map[string]bool //names of the entries in each directory
+	file     *os.File                   //nil for a store in memory only
+	lockFile *os.File                   //holds the lock on the store directory while open
+	records  int                        //records in the log file
+}
+
+type logRecord struct {
+	Put    *Entry `json:"put,omitempty"`
+	Delete string `json:"delete,omitempty"`
+}
+
+//a store losing its entries on exit, for tests and caches
+func NewMemoryStore() *LogStore {
+	return &LogStore{entries: make(map[string]*Entry), children: make(map[string]map[string]bool)}
+}
+
+//opens the store in dir, failing if another store already has it open, in this
+//process or another one, as two writers would interleave their records in the log
+func OpenLogStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	lock, err := lockStoreDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s, err := loadLogStore(filepath.Join(dir, "filer.log"))
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	s.lockFile = lock
+	return s, nil
+}
+
+//the lock is released when the returned file is closed
+func lockStoreDir(dir string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(dir, "filer.lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("filer store in %s is used by another process: %v", dir, err)
+	}
+	return f, nil
+}
+
+func loadLogStore(fileName string) (*LogStore, error) {
+	s := NewMemoryStore()
+	//the end of the last complete line, a torn last line from a crash is cut
+	//there so that the next record does not get appended to it
+	var complete int64
+	torn := false
+	if f, err := os.Open(fileName); err == nil {
+		reader := bufio.NewReaderSize(f, 64*1024)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 && line[len(line)-1] != '\n' {
+				torn = true
+				wlog.Warn("cutting torn filer log record", "file", fileName, "record", s.records, "offset", complete)
+			} else if len(line) > 0 {
+				complete += int64(len(line))
+				var r logRecord
+				if err := json.Unmarshal(line, &r); err != nil {
+					wlog.Warn("skipping invalid filer log record", "file", fileName, "record", s.records, "error", err)
+				} else {
+					s.apply(&r)
+					s.records++
+				}
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if torn {
+		if err := os.Truncate(fileName, complete); err != nil {
+			return nil, err
+		}
+	}
+	if s.records > 2*len(s.entries)+1000 {
+		if err := s.compact(fileName); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.file = f
+	wlog.Info("loaded filer store", "file", fileName, "entries", len(s.entries), "records", s.records)
+	return s, nil
+}
+
+//rewrites the log with one record per entry
+func (s *LogStore) compact(fileName string) error {
+	tmp := fileName + ".cpt"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range s.entries {
+		b, _ := json.Marshal(logRecord{Put: e})
+		w.Write(append(b, '\n'))
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp, fileName)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	wlog.Info("compacted filer log", "file", fileName, "records", s.records, "entries", len(s.entries))
+	s.records = len(s.entries)
+	return nil
+}
+
+func (s *LogStore) apply(r *logRecord) {
+	if r.Put != nil {
+		s.entries[r.Put.FullPath] = r.Put
+		dir := r.Put.Dir()
+		if s.children[dir] == nil {
+			s.children[dir] = make(map[string]bool)
+		}
+		s.children[dir][r.Put.Name()] = true
+	} else if e, ok := s.entries[r.Delete]; ok {
+		delete(s.entries, r.Delete)
+		delete(s.children[e.Dir()], e.Name())
+		if len(s.children[e.Dir()]) == 0 {
+			delete(s.children, e.Dir())
+		}
+	}
+}
+
+//logs the change before applying it, so an entry is only visible once persisted
+func (s *LogStore) write(r *logRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file != nil {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err = s.file.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		s.records++
+	}
+	s.apply(r)
+	return nil
+}
+
+func (s *LogStore) Get(fullPath string) (*Entry, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if e, ok := s.entries[fullPath]; ok {
+		copied := *e
+		return &copied, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *LogStore) Put(entry *Entry) error {
+	copied := *entry
+	return s.write(&logRecord{Put: &copied})
+}
+
+func (s *LogStore) Delete(fullPath string) error {
+	return s.write(&logRecord{Delete: fullPath})
+}
+
+func (s *LogStore) List(dir string, startAfter string, limit int) ([]*Entry, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	names := []string{}
+	for name := range s.children[dir] {
+		if name > startAfter {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if limit > 0 && len(names) > limit {
+		names = names[:limit]
+	}
+	ret := make([]*Entry, 0, len(names))
+	for _, name := range names {
+		copied := *s.entries[CleanPath(dir+"/"+name)]
+		ret = append(ret, &copied)
+	}
+	return ret, nil
+}
+
+func (s *LogStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	if s.lockFile != nil {
+		s.lockFile.Close()
+		s.lockFile = nil
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package filer
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	ErrNotFound     = errors.New("no such file or directory")
+	ErrExists       = errors.New("file exists")
+	ErrNotDirectory = errors.New("not a directory")
+	ErrIsDirectory  = errors.New("is a directory")
+	ErrNotEmpty     = errors.New("directory not empty")
+	ErrInvalidPath  = errors.New("invalid path")
+)
+
+// Store keeps the entries of a filer. Paths are clean and absolute, see CleanPath.
+// The root directory is not stored. Implementations must be safe for concurrent use.
+type Store interface {
+	//ErrNotFound if there is no entry at the path
+	Get(fullPath string) (*Entry, error)
+	//creates or replaces the entry at its path
+	Put(entry *Entry) error
+	//removes the entry only, not the entries below it
+	Delete(fullPath string) error
+	//the entries directly in dir, sorted by name, starting after the name
+	//startAfter, at most limit of them or all if limit <= 0
+	List(dir string, startAfter string, limit int) ([]*Entry, error)
+	Close() error
+}
+
+var stores = make(map[string]func(dir string) (Store, error))
+
+// RegisterStore makes a store implementation available to OpenStore, e.g. from
+// the init function of the package implementing it.
+func RegisterStore(kind string, open func(dir string) (Store, error)) {
+	stores[kind] = open
+}
+
+//opens a store of a registered kind, keeping its data in dir
+func OpenStore(kind string, dir string) (Store, error) {
+	open, ok := stores[kind]
+	if !ok {
+		return nil, errors.New("Unknown filer store:" + kind)
+	}
+	return open(dir)
+}
+
+//the names of the registered stores, sorted
+func StoreKinds() []string {
+	kinds := []string{}
+	for kind := range stores {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HandlerMetrics counts and times the requests of http handlers.
+type HandlerMetrics struct {
+	requests *CounterVec
+	latency  *HistogramVec
+}
+
+//metric names start with weed_<server>_, e.g. weed_volume_http_requests_total
+func NewHandlerMetrics(server string) *HandlerMetrics {
+	return &HandlerMetrics{
+		requests: NewCounterVec("weed_"+server+"_http_requests_total", "Number of http requests by handler, method and status code.", "handler", "method", "code"),
+		latency:  NewHistogramVec("weed_"+server+"_http_request_duration_seconds", "Latency of http requests by handler.", DefaultLatencyBuckets, "handler"),
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (m *HandlerMetrics) Wrap(handler string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		fn(recorder, r)
+		m.latency.Observe(time.Since(start).Seconds(), handler)
+		m.requests.Inc(handler, r.Method, strconv.Itoa(recorder.status))
+	}
+}
@@ -0,0 +1,308 @@
+// Package metrics exposes counters, gauges and histograms in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//metric families are written in the order they are registered
+type Registry struct {
+	collectors []Collector
+	lock       sync.Mutex
+}
+
+type Collector interface {
+	Collect(w *bufio.Writer)
+}
+
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) Register(c Collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+func (r *Registry) Write(w io.Writer) error {
+	r.lock.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.lock.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.Collect(bw)
+	}
+	return bw.Flush()
+}
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	DefaultRegistry.Write(w)
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64 //histograms only
+	count       uint64   //histograms only
+}
+
+//a named metric with label names, and one sample for each seen combination of label values
+type family struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	samples    map[string]*sample
+	lock       sync.Mutex
+}
+
+func newFamily(name, help, metricType string, labelNames []string) family {
+	return family{name: name, help: help, metricType: metricType, labelNames: labelNames, samples: make(map[string]*sample)}
+}
+
+//callers must hold the lock
+func (f *family) sample(labelValues []string) *sample {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s := f.samples[key]
+	if s == nil {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		f.samples[key] = s
+	}
+	return s
+}
+
+func (f *family) sortedSamples() []*sample {
+	keys := make([]string, 0, len(f.samples))
+	for k := range f.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	samples := make([]*sample, len(keys))
+	for i, k := range keys {
+		samples[i] = f.samples[k]
+	}
+	return samples
+}
+
+func writeHeader(w *bufio.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+func writeSample(w *bufio.Writer, name string, labelNames, labelValues []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, l := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabelValue(labelValues[i]))
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+var labelValueEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\"", "\\\"")
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+type CounterVec struct {
+	family
+}
+
+//creates a counter and registers it with the default registry
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{newFamily(name, help, "counter", labelNames)}
+	DefaultRegistry.Register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+//counters only go up, negative deltas are ignored
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sample(labelValues).value += delta
+}
+
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if s, ok := c.samples[strings.Join(labelValues, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+//families without any sample are left out
+func (c *CounterVec) Collect(w *bufio.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.samples) == 0 {
+		return
+	}
+	writeHeader(w, c.name, c.help, c.metricType)
+	for _, s := range c.sortedSamples() {
+		writeSample(w, c.name, c.labelNames, s.labelValues, "", "", s.value)
+	}
+}
+
+type GaugeVec struct {
+	family
+}
+
+//creates a gauge and registers it with the default registry
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{newFamily(name, help, "gauge", labelNames)}
+	DefaultRegistry.Register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.sample(labelValues).value = value
+}
+
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.sample(labelValues).value += delta
+}
+
+func (g *GaugeVec) Collect(w *bufio.Writer) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if len(g.samples) == 0 {
+		return
+	}
+	writeHeader(w, g.name, g.help, g.metricType)
+	for _, s := range g.sortedSamples() {
+		writeSample(w, g.name, g.labelNames, s.labelValues, "", "", s.value)
+	}
+}
+
+// GaugeFunc reads its samples when the metrics are collected, e.g. from the volumes of a store.
+type GaugeFunc struct {
+	name       string
+	help       string
+	labelNames []string
+	collect    func(emit func(value float64, labelValues ...string))
+}
+
+//creates a gauge read by collect and registers it with the default registry
+func NewGaugeFunc(name, help string, collect func(emit func(value float64, labelValues ...string)), labelNames ...string) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, labelNames: labelNames, collect: collect}
+	DefaultRegistry.Register(g)
+	return g
+}
+
+func (g *GaugeFunc) Collect(w *bufio.Writer) {
+	f := newFamily(g.name, g.help, "gauge", g.labelNames)
+	g.collect(func(value float64, labelValues ...string) {
+		f.sample(labelValues).value = value
+	})
+	if len(f.samples) == 0 {
+		return
+	}
+	writeHeader(w, g.name, g.help, "gauge")
+	for _, s := range f.sortedSamples() {
+		writeSample(w, g.name, g.labelNames, s.labelValues, "", "", s.value)
+	}
+}
+
+type HistogramVec struct {
+	family
+	buckets []float64 //upper bounds, increasing
+}
+
+var DefaultLatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+//creates a histogram and registers it with the default registry
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{family: newFamily(name, help, "histogram", labelNames), buckets: buckets}
+	DefaultRegistry.Register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s := h.sample(labelValues)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.value += value
+	s.count++
+}
+
+func (h *HistogramVec) Collect(w *bufio.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.samples) == 0 {
+		return
+	}
+	writeHeader(w, h.name, h.help, h.metricType)
+	for _, s := range h.sortedSamples() {
+		for i, bound := range h.buckets {
+			writeSample(w, h.name+"_bucket", h.labelNames, s.labelValues, "le", formatFloat(bound), float64(s.buckets[i]))
+		}
+		writeSample(w, h.name+"_bucket", h.labelNames, s.labelValues, "le", "+Inf", float64(s.count))
+		writeSample(w, h.name+"_sum", h.labelNames, s.labelValues, "", "", s.value)
+		writeSample(w, h.name+"_count", h.labelNames, s.labelValues, "", "", float64(s.count))
+	}
+}
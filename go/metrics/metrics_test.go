@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	c := &CounterVec{family: newFamily("weed_test_total", "Test counter.", "counter", []string{"code"})}
+	h := &HistogramVec{family: newFamily("weed_test_seconds", "Test histogram.", "histogram", []string{"handler"}), buckets: []float64{.1, 1}}
+	r.Register(c)
+	r.Register(h)
+	c.Inc("200")
+	c.Add(2, "200")
+	c.Inc("a\"b")
+	h.Observe(.05, "get")
+	h.Observe(.5, "get")
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE weed_test_total counter",
+		`weed_test_total{code="200"} 3`,
+		`weed_test_total{code="a\"b"} 1`,
+		"# TYPE weed_test_seconds histogram",
+		`weed_test_seconds_bucket{handler="get",le="0.1"} 1`,
+		`weed_test_seconds_bucket{handler="get",le="1"} 2`,
+		`weed_test_seconds_bucket{handler="get",le="+Inf"} 2`,
+		`weed_test_seconds_count{handler="get"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, out)
+		}
+	}
+}
@@ -11,22 +11,28 @@ import (
 
 type AllocateVolumeResult struct {
 	Error string
+	Tier  string
 }
 
-func AllocateVolume(dn *topology.DataNode, vid storage.VolumeId, repType storage.ReplicationType) error {
+//returns the tier the volume was placed in, which is another one if the tier has no space
+func AllocateVolume(dn *topology.DataNode, vid storage.VolumeId, repType storage.ReplicationType, tier string) (string, error) {
 	values := make(url.Values)
 	values.Add("volume", vid.String())
 	values.Add("replicationType", repType.String())
-	jsonBlob, err := util.Post("http://"+dn.Url()+"/admin/assign_volume", values)
+	values.Add("tier", tier)
+	jsonBlob, err := util.AdminPost(util.NormalizeUrl(dn.Url())+"/admin/assign_volume", values)
 	if err != nil {
-		return err
+		return "", err
 	}
 	var ret AllocateVolumeResult
 	if err := json.Unmarshal(jsonBlob, &ret); err != nil {
-		return err
+		return "", err
 	}
 	if ret.Error != "" {
-		return errors.New(ret.Error)
+		return "", errors.New(ret.Error)
 	}
-	return nil
+	if ret.Tier == "" {
+		return tier, nil
+	}
+	return ret.Tier, nil
 }
@@ -0,0 +1,295 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"code.google.com/p/weed-fs/go/security"
+	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/util"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client reads and writes files of a weed-fs cluster. It fails over between its
+// masters, and between the replica locations of a volume, and caches the
+// locations of the volumes it looked up. Create it with NewClient.
+//
+// Requests carry the request id of the context, see trace.NewContext, so one
+// call can be followed through the master and volume server logs.
+type Client struct {
+	Masters []string
+
+	//default replication type of assigned file ids, empty for the master's default
+	Replication string
+	//time limit of each request, including reading its response, 0 for none
+	Timeout time.Duration
+	//extra tries on the same server before failing over to the next one
+	Retries int
+	//the looked up volume locations, VolumeLocations to share them with the other
+	//clients and lookups of the process
+	Locations *LocationCache
+
+	lock   sync.Mutex
+	master int //the last master which answered
+}
+
+func NewClient(masters ...string) *Client {
+	return &Client{
+		Masters:   masters,
+		Timeout:   30 * time.Second,
+		Retries:   1,
+		Locations: NewLocationCache(time.Minute),
+	}
+}
+
+type AssignResult struct {
+	Fid       string `json:"fid"`
+	Url       string `json:"url"`
+	PublicUrl string `json:"publicUrl"`
+	Count     int    `json:"count"`
+	Auth      string `json:"auth"` //file token for the upload, if the master has a secret
+	Error     string `json:"error"`
+}
+
+//the fid of the i-th file of an assign of several, they share the file key with a _i suffix
+func (a *AssignResult) FileId(i int) string {
+	if i == 0 {
+		return a.Fid
+	}
+	return a.Fid + "_" + strconv.Itoa(i)
+}
+
+//makes sure all requests of one call share a request id
+func withRequestId(ctx context.Context) context.Context {
+	return trace.NewContext(ctx, trace.FromContext(ctx))
+}
+
+func (c *Client) tries() int {
+	if c.Retries < 0 {
+		return 1
+	}
+	return c.Retries + 1
+}
+
+//whether the request may work on a retry or on another server: network errors and 5xx
+func isTemporary(err error) bool {
+	e, ok := err.(*ServerError)
+	return ok && (e.StatusCode == 0 || e.StatusCode >= 500)
+}
+
+// do sends req with the client timeout. The returned cancel must be called once
+// the response body is read.
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, context.CancelFunc, error) {
+	parent, cancel := ctx, context.CancelFunc(func() {})
+	if c.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+	}
+	trace.SetRequestId(req, trace.FromContext(ctx))
+	resp, err := util.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		//a canceled call is not retried, a request timing out is
+		if parent.Err() != nil {
+			return nil, nil, parent.Err()
+		}
+		return nil, nil, &ServerError{Url: req.URL.String(), Message: err.Error()}
+	}
+	return resp, cancel, nil
+}
+
+//sends the request and reads the whole response
+func (c *Client) roundTrip(ctx context.Context, req *http.Request) (int, []byte, error) {
+	resp, cancel, err := c.do(ctx, req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer cancel()
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, &ServerError{Url: req.URL.String(), Message: err.Error()}
+	}
+	return resp.StatusCode, body, nil
+}
+
+//a signed form post, for the tokens and bulk requests only given to trusted clients
+func newFormRequest(url string, values url.Values) (*http.Request, error) {
+	req, err := http.NewRequest("POST", url, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	security.Sign(req, values)
+	return req, nil
+}
+
+//posts the form to the masters, starting with the last one which answered
+func (c *Client) postMaster(ctx context.Context, path string, values url.Values) (body []byte, err error) {
+	if len(c.Masters) == 0 {
+		return nil, ErrNoMaster
+	}
+	c.lock.Lock()
+	start := c.master
+	c.lock.Unlock()
+	for i := range c.Masters {
+		m := (start + i) % len(c.Masters)
+		masterUrl := util.NormalizeUrl(c.Masters[m]) + path
+		for try := 0; try < c.tries(); try++ {
+			var req *http.Request
+			if req, err = newFormRequest(masterUrl, values); err != nil {
+				return nil, err
+			}
+			var status int
+			if status, body, err = c.roundTrip(ctx, req); err == nil {
+				if status >= 300 {
+					var ret struct {
+						Error string `json:"error"`
+					}
+					json.Unmarshal(body, &ret)
+					err = &ServerError{Url: masterUrl, StatusCode: status, Message: ret.Error}
+				} else {
+					c.lock.Lock()
+					c.master = m
+					c.lock.Unlock()
+					return body, nil
+				}
+			}
+			if !isTemporary(err) {
+				return nil, err
+			}
+		}
+	}
+	return nil, err
+}
+
+// Assign reserves count consecutive file ids, see AssignResult.FileId, on a volume
+// of the replication type, or of the client's default one if empty.
+func (c *Client) Assign(ctx context.Context, count int, replication string) (*AssignResult, error) {
+	ctx = withRequestId(ctx)
+	if replication == "" {
+		replication = c.Replication
+	}
+	values := make(url.Values)
+	values.Add("count", strconv.Itoa(count))
+	if replication != "" {
+		values.Add("replication", replication)
+	}
+	body, err := c.postMaster(ctx, "/dir/assign", values)
+	if err != nil {
+		return nil, err
+	}
+	var ret AssignResult
+	if err = json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+	if ret.Count <= 0 {
+		return nil, errors.New("assign failed: " + ret.Error)
+	}
+	return &ret, nil
+}
+
+type fidLookupResult struct {
+	Locations []Location `json:"locations"`
+	Auth      string     `json:"auth"`
+}
+
+//asks the master for the locations of a volume id or a fid, ErrNotFound for unknown volumes
+func (c *Client) lookup(ctx context.Context, volumeIdOrFid string) (*fidLookupResult, error) {
+	values := make(url.Values)
+	values.Add("volumeId", volumeIdOrFid)
+	body, err := c.postMaster(ctx, "/dir/lookup", values)
+	if e, ok := err.(*ServerError); ok && e.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ret fidLookupResult
+	if err = json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+	if len(ret.Locations) == 0 {
+		return nil, ErrNoLocation
+	}
+	return &ret, nil
+}
+
+// Lookup returns the locations of a volume, from the cache if looked up recently.
+func (c *Client) Lookup(ctx context.Context, vid storage.VolumeId) ([]Location, error) {
+	if locations, ok := c.Locations.Get(vid); ok {
+		return locations, nil
+	}
+	ret, err := c.lookup(withRequestId(ctx), vid.String())
+	if err != nil {
+		return nil, err
+	}
+	c.Locations.Set(vid, ret.Locations)
+	return ret.Locations, nil
+}
+
+// LookupBatch returns the locations of the volumes, asking the master for all the
+// ones not cached in one request. Unknown volumes are left out of the result.
+func (c *Client) LookupBatch(ctx context.Context, vids []storage.VolumeId) (map[storage.VolumeId][]Location, error) {
+	ctx = withRequestId(ctx)
+	ret := make(map[storage.VolumeId][]Location)
+	values := make(url.Values)
+	var missing storage.VolumeId
+	for _, vid := range vids {
+		if _, ok := ret[vid]; ok {
+			continue
+		}
+		if locations, ok := c.Locations.Get(vid); ok {
+			ret[vid] = locations
+			continue
+		}
+		ret[vid], missing = nil, vid
+		values.Add("volumeId", vid.String())
+	}
+	switch len(values["volumeId"]) {
+	case 0:
+		return ret, nil
+	case 1: //the master answers a single volume id like a plain lookup
+		locations, err := c.Lookup(ctx, missing)
+		if err != nil && err != ErrNotFound && err != ErrNoLocation {
+			return nil, err
+		}
+		ret[missing] = locations
+	default:
+		body, err := c.postMaster(ctx, "/dir/lookup", values)
+		if err != nil {
+			return nil, err
+		}
+		var results map[string]fidLookupResult
+		if err = json.Unmarshal(body, &results); err != nil {
+			return nil, err
+		}
+		for id, result := range results {
+			if vid, err := storage.NewVolumeId(id); err == nil && len(result.Locations) > 0 {
+				c.Locations.Set(vid, result.Locations)
+				ret[vid] = result.Locations
+			}
+		}
+	}
+	for vid, locations := range ret {
+		if len(locations) == 0 {
+			delete(ret, vid)
+		}
+	}
+	return ret, nil
+}
+
+//the volume id of a fid like 3,01637037d6
+func parseVolumeId(fid string) (storage.VolumeId, error) {
+	commaSep := strings.Index(fid, ",")
+	if commaSep <= 0 {
+		return 0, ErrInvalidFid
+	}
+	return storage.NewVolumeId(fid[:commaSep])
+}
@@ -0,0 +1,403 @@
+package operation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"code.google.com/p/weed-fs/go/security"
+	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/util"
+	"strings"
+	"sync"
+)
+
+//number of files a batch call uploads or deletes at the same time
+const batchConcurrency = 8
+
+// UploadFile is a file to upload, read from Reader once even if the upload is retried.
+type UploadFile struct {
+	Name     string
+	MimeType string //guessed by the volume server from the name if empty
+	Reader   io.Reader
+}
+
+type UploadedFile struct {
+	Fid  string
+	Url  string //where the file can be downloaded
+	Size int
+}
+
+//runs try on each url, with retries, until it works or fails with an error
+//another try would not fix
+func (c *Client) failover(urls []string, try func(url string) error) (err error) {
+	err = ErrNoLocation
+	for _, u := range urls {
+		for i := 0; i < c.tries(); i++ {
+			if err = try(u); err == nil || !isTemporary(err) {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+//the public urls of a volume, the preferred one first if given
+func locationUrls(locations []Location, preferred string) []string {
+	urls := []string{}
+	if preferred != "" {
+		urls = append(urls, util.NormalizeUrl(preferred))
+	}
+	for _, l := range locations {
+		if u := util.NormalizeUrl(l.PublicUrl); preferred == "" || u != urls[0] {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func multipartBody(file UploadFile) (body []byte, contentType string, err error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", `form-data; name="file"; filename="`+quoteEscaper.Replace(path.Base(file.Name))+`"`)
+	if file.MimeType != "" {
+		h.Set("Content-Type", file.MimeType)
+	}
+	part, err := w.CreatePart(h)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err = io.Copy(part, file.Reader); err != nil {
+		return nil, "", err
+	}
+	if err = w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+//uploads the file to the assigned volume server, then to the other replicas of the volume
+func (c *Client) uploadTo(ctx context.Context, a *AssignResult, fid string, file UploadFile) (*UploadedFile, error) {
+	vid, err := parseVolumeId(fid)
+	if err != nil {
+		return nil, err
+	}
+	body, contentType, err := multipartBody(file)
+	if err != nil {
+		return nil, err
+	}
+	ret := &UploadedFile{Fid: fid}
+	upload := func(u string) error {
+		return c.post(ctx, u, fid, a.Auth, body, contentType, ret)
+	}
+	err = c.failover([]string{util.NormalizeUrl(a.PublicUrl)}, upload)
+	if isTemporary(err) {
+		c.Locations.Invalidate(vid)
+		if locations, e := c.Lookup(ctx, vid); e == nil {
+			err = c.failover(locationUrls(locations, a.PublicUrl)[1:], upload)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (c *Client) post(ctx context.Context, serverUrl, fid, jwt string, body []byte, contentType string, ret *UploadedFile) error {
+	req, err := http.NewRequest("POST", serverUrl+"/"+fid, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if jwt != "" {
+		security.SetFileToken(req, jwt)
+	} else {
+		security.Sign(req, nil)
+	}
+	status, respBody, err := c.roundTrip(ctx, req)
+	if err != nil {
+		return err
+	}
+	if err = responseError(req.URL.String(), status, respBody); err != nil {
+		return err
+	}
+	var result UploadResult
+	if err = json.Unmarshal(respBody, &result); err != nil {
+		return &ServerError{Url: req.URL.String(), StatusCode: status, Message: "invalid response " + string(respBody)}
+	}
+	ret.Url, ret.Size = req.URL.String(), result.Size
+	return nil
+}
+
+// Upload assigns a file id and writes the file, with the replication type or the
+// client's default one if empty.
+func (c *Client) Upload(ctx context.Context, file UploadFile, replication string) (*UploadedFile, error) {
+	ctx = withRequestId(ctx)
+	a, err := c.Assign(ctx, 1, replication)
+	if err != nil {
+		return nil, err
+	}
+	return c.uploadTo(ctx, a, a.Fid, file)
+}
+
+// UploadBatch writes the files with one assign, so they get consecutive file ids.
+// The results and errors are in the order of the files; a failed assign fails all.
+func (c *Client) UploadBatch(ctx context.Context, files []UploadFile, replication string) ([]*UploadedFile, []error) {
+	ctx = withRequestId(ctx)
+	results, errs := make([]*UploadedFile, len(files)), make([]error, len(files))
+	if len(files) == 0 {
+		return results, errs
+	}
+	a, err := c.Assign(ctx, len(files), replication)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return results, errs
+	}
+	parallel(len(files), func(i int) {
+		results[i], errs[i] = c.uploadTo(ctx, a, a.FileId(i), files[i])
+	})
+	return results, errs
+}
+
+//runs fn for 0..n-1, batchConcurrency at a time
+func parallel(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	sem := make(chan bool, batchConcurrency)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- true
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// DownloadedFile is a file being downloaded. Its Body must be closed.
+type DownloadedFile struct {
+	Name     string
+	MimeType string
+	Size     int64 //-1 if unknown, e.g. for files stored gzipped and uncompressed on the fly
+	Body     io.ReadCloser
+}
+
+//closes the response and releases its timeout
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
+}
+
+//the file name the volume server sends as filename=name, with \ and " escaped
+func dispositionFileName(disposition string) string {
+	if _, params, err := mime.ParseMediaType(disposition); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	i := strings.Index(disposition, "filename=")
+	if i < 0 {
+		return ""
+	}
+	name := strings.Trim(disposition[i+len("filename="):], `"`)
+	return strings.NewReplacer("\\\\", "\\", "\\\"", `"`).Replace(name)
+}
+
+// Download streams a file from the first replica able to serve it. ErrNotFound
+// and ErrCookieMismatch tell a missing file from a wrong fid.
+func (c *Client) Download(ctx context.Context, fid string) (*DownloadedFile, error) {
+	ctx = withRequestId(ctx)
+	vid, err := parseVolumeId(fid)
+	if err != nil {
+		return nil, err
+	}
+	locations, cached := c.Locations.Get(vid)
+	if !cached {
+		if locations, err = c.Lookup(ctx, vid); err != nil {
+			return nil, err
+		}
+	}
+	ret, err := c.download(ctx, fid, locations)
+	if err == ErrNotFound || isTemporary(err) {
+		c.Locations.Invalidate(vid)
+		//the volume may have moved since its locations were cached
+		if cached {
+			if locations, err = c.Lookup(ctx, vid); err != nil {
+				return nil, err
+			}
+			ret, err = c.download(ctx, fid, locations)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (c *Client) download(ctx context.Context, fid string, locations []Location) (ret *DownloadedFile, err error) {
+	err = c.failover(locationUrls(locations, ""), func(u string) error {
+		req, err := http.NewRequest("GET", u+"/"+fid, nil)
+		if err != nil {
+			return err
+		}
+		resp, cancel, err := c.do(ctx, req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			var body bytes.Buffer
+			io.Copy(&body, resp.Body)
+			resp.Body.Close()
+			cancel()
+			return responseError(req.URL.String(), resp.StatusCode, body.Bytes())
+		}
+		ret = &DownloadedFile{
+			Name:     dispositionFileName(resp.Header.Get("Content-Disposition")),
+			MimeType: resp.Header.Get("Content-Type"),
+			Size:     resp.ContentLength,
+			Body:     &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel},
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// Delete removes a file from all its replicas. The master is asked for the
+// locations on each delete, as it also hands out the token a delete needs when
+// the cluster has a secret.
+func (c *Client) Delete(ctx context.Context, fid string) error {
+	ctx = withRequestId(ctx)
+	vid, err := parseVolumeId(fid)
+	if err != nil {
+		return err
+	}
+	found, err := c.lookup(ctx, fid)
+	if err != nil {
+		return err
+	}
+	c.Locations.Set(vid, found.Locations)
+	return c.failover(locationUrls(found.Locations, ""), func(u string) error {
+		req, err := http.NewRequest("DELETE", u+"/"+fid, nil)
+		if err != nil {
+			return err
+		}
+		if found.Auth != "" {
+			security.SetFileToken(req, found.Auth)
+		} else {
+			security.Sign(req, nil)
+		}
+		status, body, err := c.roundTrip(ctx, req)
+		if err != nil {
+			return err
+		}
+		return responseError(req.URL.String(), status, body)
+	})
+}
+
+// DeleteBatch deletes the files with one request to each volume server holding
+// them, and returns the errors in the order of the fids. The files of a server
+// failing are deleted one by one, failing over to the other replicas.
+func (c *Client) DeleteBatch(ctx context.Context, fids []string) []error {
+	ctx = withRequestId(ctx)
+	errs := make([]error, len(fids))
+	vids, valid := make([]storage.VolumeId, len(fids)), []storage.VolumeId{}
+	for i, fid := range fids {
+		if vids[i], errs[i] = parseVolumeId(fid); errs[i] == nil {
+			valid = append(valid, vids[i])
+		}
+	}
+	locations, err := c.LookupBatch(ctx, valid)
+	servers := make(map[string][]int)
+	for i := range fids {
+		if errs[i] != nil {
+			continue
+		}
+		if err != nil {
+			errs[i] = err
+		} else if l := locations[vids[i]]; len(l) == 0 {
+			errs[i] = ErrNotFound
+		} else {
+			u := util.NormalizeUrl(l[0].PublicUrl)
+			servers[u] = append(servers[u], i)
+		}
+	}
+	urls := make([]string, 0, len(servers))
+	for u := range servers {
+		urls = append(urls, u)
+	}
+	parallel(len(urls), func(j int) {
+		indexes := servers[urls[j]]
+		serverFids := make([]string, len(indexes))
+		for k, i := range indexes {
+			serverFids[k] = fids[i]
+		}
+		results, err := c.deleteFiles(ctx, urls[j], serverFids)
+		for k, i := range indexes {
+			switch {
+			case isTemporary(err):
+				errs[i] = c.Delete(ctx, fids[i])
+			case err != nil:
+				errs[i] = err
+			default:
+				errs[i] = results[k].err(urls[j])
+			}
+		}
+	})
+	return errs
+}
+
+//deletes the files on one volume server, the results are in the order of the fids
+func (c *Client) deleteFiles(ctx context.Context, serverUrl string, fids []string) (results []DeleteResult, err error) {
+	values := make(url.Values)
+	for _, fid := range fids {
+		values.Add("fid", fid)
+	}
+	for try := 0; try < c.tries(); try++ {
+		var req *http.Request
+		if req, err = newFormRequest(serverUrl+"/delete", values); err != nil {
+			return nil, err
+		}
+		var status int
+		var body []byte
+		if status, body, err = c.roundTrip(ctx, req); err == nil {
+			if err = responseError(req.URL.String(), status, body); err == nil {
+				if err = json.Unmarshal(body, &results); err == nil && len(results) != len(fids) {
+					err = &ServerError{Url: req.URL.String(), StatusCode: status, Message: "wrong number of results"}
+				}
+				return results, err
+			}
+		}
+		if !isTemporary(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+//the error of one file of a bulk delete, mapped like the error of a single delete
+func (r DeleteResult) err(serverUrl string) error {
+	switch {
+	case r.Status < 300:
+		return nil
+	case r.Status == http.StatusNotFound && r.Error == cookieMismatchMessage:
+		return ErrCookieMismatch
+	case r.Status == http.StatusNotFound:
+		return ErrNotFound
+	}
+	return &ServerError{Url: serverUrl + "/" + r.Fid, StatusCode: r.Status, Message: r.Error}
+}
@@ -0,0 +1,161 @@
+package operation
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientDownloadFailover(t *testing.T) {
+	volume := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/3,01637037d6":
+			w.Header().Set("Content-Disposition", `filename=a\"b.txt`)
+			w.Write([]byte("hello"))
+		case "/3,01637037d7":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"cookie mismatch"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer volume.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	lookups := 0
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		if r.FormValue("volumeId") != "3" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"volume id not found"}`))
+			return
+		}
+		w.Write([]byte(`{"locations":[{"publicUrl":"` + down.URL + `"},{"publicUrl":"` + volume.URL + `"}]}`))
+	}))
+	defer master.Close()
+
+	c := NewClient(down.URL, master.URL)
+	f, err := c.Download(context.Background(), "3,01637037d6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(f.Body)
+	f.Body.Close()
+	if string(b) != "hello" || f.Name != `a"b.txt` {
+		t.Fatalf("downloaded %q named %q", b, f.Name)
+	}
+	if _, err = c.Download(context.Background(), "3,01637037d7"); err != ErrCookieMismatch {
+		t.Fatal("cookie mismatch:", err)
+	}
+	if lookups != 1 {
+		t.Fatal("volume locations should be cached, lookups:", lookups)
+	}
+	if _, err = c.Download(context.Background(), "3,01637037d8"); err != ErrNotFound {
+		t.Fatal("missing file:", err)
+	}
+	if lookups != 2 {
+		t.Fatal("a missing file should refresh the cached locations, lookups:", lookups)
+	}
+	if _, err = c.Download(context.Background(), "4,01637037d6"); err != ErrNotFound {
+		t.Fatal("missing volume:", err)
+	}
+	if _, err = c.Download(context.Background(), "01637037d6"); err != ErrInvalidFid {
+		t.Fatal("invalid fid:", err)
+	}
+}
+
+func TestClientUploadRetry(t *testing.T) {
+	tries := 0
+	volume := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tries++; tries == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":"disk full"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Error("missing file token", r.Header.Get("Authorization"))
+		}
+		f, h, err := r.FormFile("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(f)
+		if h.Filename != "a.txt" || string(b) != "hello" {
+			t.Errorf("uploaded %q named %q", b, h.Filename)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"size":5}`))
+	}))
+	defer volume.Close()
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"fid":"3,01637037d6","publicUrl":"` + volume.URL + `","count":1,"auth":"token"}`))
+	}))
+	defer master.Close()
+
+	ret, err := NewClient(master.URL).Upload(context.Background(), UploadFile{Name: "a.txt", Reader: strings.NewReader("hello")}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Fid != "3,01637037d6" || ret.Size != 5 || tries != 2 {
+		t.Fatal("upload", ret, tries)
+	}
+}
+
+func TestClientDeleteBatch(t *testing.T) {
+	requests := 0
+	volume := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		r.ParseForm()
+		if r.URL.Path != "/delete" || len(r.Form["fid"]) != 2 {
+			t.Error("bulk delete", r.URL.Path, r.Form)
+		}
+		w.Write([]byte(`[{"fid":"3,01637037d6","status":202,"size":5},{"fid":"4,01637037d6","status":404,"error":"cookie mismatch"}]`))
+	}))
+	defer volume.Close()
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if len(r.Form["volumeId"]) != 3 {
+			t.Error("batch lookup", r.Form)
+		}
+		w.Write([]byte(`{"3":{"locations":[{"publicUrl":"` + volume.URL + `"}]},"4":{"locations":[{"publicUrl":"` + volume.URL + `"}]},"5":{"error":"volume id 5 not found. "}}`))
+	}))
+	defer master.Close()
+
+	errs := NewClient(master.URL).DeleteBatch(context.Background(), []string{"3,01637037d6", "4,01637037d6", "5,01637037d6", "x"})
+	if errs[0] != nil || errs[1] != ErrCookieMismatch || errs[2] != ErrNotFound || errs[3] != ErrInvalidFid {
+		t.Fatal("delete errors", errs)
+	}
+	if requests != 1 {
+		t.Fatal("files on one server should be deleted at once, requests:", requests)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	volume := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/3,01637037d6":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"size":5}`))
+		case "/4,01637037d6":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"size":0}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"unauthorized: expired file token"}`))
+		}
+	}))
+	defer volume.Close()
+	if err := Delete(volume.URL+"/3,01637037d6", "", ""); err != nil {
+		t.Fatal("delete", err)
+	}
+	if err := Delete(volume.URL+"/4,01637037d6", "", ""); err != ErrNotFound {
+		t.Fatal("delete of a missing file", err)
+	}
+	if err := Delete(volume.URL+"/5,01637037d6", "", ""); !IsServerError(err) {
+		t.Fatal("a refused delete should fail", err)
+	}
+}
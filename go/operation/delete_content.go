@@ -1,16 +1,87 @@
 package operation
 
 import (
-	"log"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"code.google.com/p/weed-fs/go/security"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
+	"strings"
 )
 
-func Delete(url string) error {
+//jwt is the file token from the master, without one the delete is signed as server to server traffic,
+//ErrNotFound if the file is not there and a ServerError if the server refused the delete
+func Delete(url string, requestId string, jwt string) error {
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
-		log.Println("failing to delete", url)
+		wlog.Warn("failed to delete", "url", url, "error", err)
 		return err
 	}
-	_, err = http.DefaultClient.Do(req)
-	return err
+	if jwt != "" {
+		security.SetFileToken(req, jwt)
+	} else {
+		security.Sign(req, nil)
+	}
+	trace.SetRequestId(req, requestId)
+	span := trace.StartSpan(requestId, "delete", "url", url)
+	resp, err := util.Do(req)
+	if err != nil {
+		span.End("error", err)
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	span.End("status", resp.StatusCode)
+	if err != nil {
+		return err
+	}
+	return responseError(url, resp.StatusCode, body)
+}
+
+// DeleteResult is the outcome of deleting one file of a bulk delete, with the
+// http status a single delete of the file would have returned.
+type DeleteResult struct {
+	Fid    string `json:"fid"`
+	Status int    `json:"status"`
+	Size   uint32 `json:"size"`
+	Error  string `json:"error,omitempty"`
+}
+
+//deletes the files on one volume server with a single request, signed with the shared secret
+func DeleteFiles(serverUrl string, fids []string, requestId string) ([]DeleteResult, error) {
+	values := make(url.Values)
+	for _, fid := range fids {
+		values.Add("fid", fid)
+	}
+	req, err := http.NewRequest("POST", serverUrl, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	security.Sign(req, values)
+	trace.SetRequestId(req, requestId)
+	span := trace.StartSpan(requestId, "bulk delete", "url", serverUrl, "files", len(fids))
+	resp, err := util.Do(req)
+	if err != nil {
+		span.End("error", err)
+		wlog.Warn("failed to delete files", "url", serverUrl, "error", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	span.End("status", resp.StatusCode)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(serverUrl, resp.StatusCode, body)
+	}
+	var ret []DeleteResult
+	if err = json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
 }
@@ -0,0 +1,31 @@
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/topology"
+	"code.google.com/p/weed-fs/go/util"
+)
+
+type DeleteVolumeResult struct {
+	Error string
+}
+
+func DeleteVolume(dn *topology.DataNode, vid storage.VolumeId) error {
+	values := make(url.Values)
+	values.Add("volume", vid.String())
+	jsonBlob, err := util.AdminPost(util.NormalizeUrl(dn.Url())+"/admin/delete_volume", values)
+	if err != nil {
+		return err
+	}
+	var ret DeleteVolumeResult
+	if err := json.Unmarshal(jsonBlob, &ret); err != nil {
+		return err
+	}
+	if ret.Error != "" {
+		return errors.New(ret.Error)
+	}
+	return nil
+}
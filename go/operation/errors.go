@@ -0,0 +1,62 @@
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+var (
+	ErrNotFound       = errors.New("file not found")
+	ErrCookieMismatch = errors.New("file cookie mismatch")
+	ErrNoMaster       = errors.New("no master server configured")
+	ErrNoLocation     = errors.New("volume has no location")
+	ErrInvalidFid     = errors.New("invalid file id")
+)
+
+// ServerError is a failed request to a master or volume server, either refused
+// with an error status or failed on the network. Other replicas may still work.
+type ServerError struct {
+	Url        string
+	StatusCode int //0 if the server could not be reached
+	Message    string
+}
+
+func (e *ServerError) Error() string {
+	if e.StatusCode == 0 {
+		return e.Url + ": " + e.Message
+	}
+	return e.Url + ": " + strconv.Itoa(e.StatusCode) + " " + e.Message
+}
+
+//whether err comes from a server which could be retried, or another replica tried
+func IsServerError(err error) bool {
+	_, ok := err.(*ServerError)
+	return ok
+}
+
+//the volume servers reply 404 both for missing files and wrong cookies, the
+//error in the body tells which
+const cookieMismatchMessage = "cookie mismatch"
+
+//the error of a response, nil for a success
+func responseError(url string, statusCode int, body []byte) error {
+	if statusCode < 300 {
+		return nil
+	}
+	var ret struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(body, &ret)
+	if statusCode == http.StatusNotFound {
+		if ret.Error == cookieMismatchMessage {
+			return ErrCookieMismatch
+		}
+		return ErrNotFound
+	}
+	if ret.Error == "" {
+		ret.Error = http.StatusText(statusCode)
+	}
+	return &ServerError{Url: url, StatusCode: statusCode, Message: ret.Error}
+}
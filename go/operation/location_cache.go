@@ -0,0 +1,55 @@
+package operation
+
+import (
+	"code.google.com/p/weed-fs/go/storage"
+	"sync"
+	"time"
+)
+
+// LocationCache keeps the locations of volumes for a while, to spare a master
+// lookup on each redirected read or replicated write. Entries are dropped when a
+// location fails, and replaced when the master pushes changed locations.
+type LocationCache struct {
+	ttl     time.Duration
+	lock    sync.RWMutex
+	entries map[storage.VolumeId]cachedLocations
+}
+
+type cachedLocations struct {
+	locations []Location
+	expires   time.Time
+}
+
+//the cache Lookup uses, kept up to date by the master on volume servers
+var VolumeLocations = NewLocationCache(time.Minute)
+
+func NewLocationCache(ttl time.Duration) *LocationCache {
+	return &LocationCache{ttl: ttl, entries: make(map[storage.VolumeId]cachedLocations)}
+}
+
+func (c *LocationCache) Get(vid storage.VolumeId) ([]Location, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	entry, ok := c.entries[vid]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.locations, true
+}
+
+//caches the locations for the ttl, no locations drops the volume
+func (c *LocationCache) Set(vid storage.VolumeId, locations []Location) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(locations) == 0 {
+		delete(c.entries, vid)
+		return
+	}
+	c.entries[vid] = cachedLocations{locations: locations, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *LocationCache) Invalidate(vid storage.VolumeId) {
+	c.lock.Lock()
+	delete(c.entries, vid)
+	c.lock.Unlock()
+}
@@ -0,0 +1,37 @@
+package operation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocationCache(t *testing.T) {
+	c := NewLocationCache(50 * time.Millisecond)
+	if _, ok := c.Get(3); ok {
+		t.Fatal("hit in an empty cache")
+	}
+	c.Set(3, []Location{{Url: "localhost:8080", PublicUrl: "localhost:8080"}})
+	if locations, ok := c.Get(3); !ok || len(locations) != 1 || locations[0].Url != "localhost:8080" {
+		t.Fatal("missed a cached volume:", locations, ok)
+	}
+	if _, ok := c.Get(4); ok {
+		t.Fatal("hit for another volume")
+	}
+
+	c.Invalidate(3)
+	if _, ok := c.Get(3); ok {
+		t.Fatal("hit after invalidation")
+	}
+
+	c.Set(3, []Location{{Url: "localhost:8080"}})
+	c.Set(3, nil)
+	if _, ok := c.Get(3); ok {
+		t.Fatal("hit after setting no locations")
+	}
+
+	c.Set(3, []Location{{Url: "localhost:8080"}})
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := c.Get(3); ok {
+		t.Fatal("hit after the ttl")
+	}
+}
@@ -18,11 +18,14 @@ type LookupResult struct {
 	Error     string     `json:"error"`
 }
 
-//TODO: Add a caching for vid here
-func Lookup(server string, vid storage.VolumeId) (*LookupResult, error) {
+//the locations are cached in VolumeLocations, callers finding them wrong invalidate them
+func Lookup(server string, vid storage.VolumeId, requestId string) (*LookupResult, error) {
+	if locations, ok := VolumeLocations.Get(vid); ok {
+		return &LookupResult{Locations: locations}, nil
+	}
 	values := make(url.Values)
 	values.Add("volumeId", vid.String())
-	jsonBlob, err := util.Post("http://"+server+"/dir/lookup", values)
+	jsonBlob, err := util.PostWithRequestId(util.NormalizeUrl(server)+"/dir/lookup", values, requestId)
 	if err != nil {
 		return nil, err
 	}
@@ -34,5 +37,9 @@ func Lookup(server string, vid storage.VolumeId) (*LookupResult, error) {
 	if ret.Error != "" {
 		return nil, errors.New(ret.Error)
 	}
+	if len(ret.Locations) == 0 {
+		return nil, ErrNoLocation
+	}
+	VolumeLocations.Set(vid, ret.Locations)
 	return &ret, nil
 }
@@ -0,0 +1,35 @@
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/topology"
+	"code.google.com/p/weed-fs/go/util"
+)
+
+type SetVolumeReadOnlyResult struct {
+	Error string
+}
+
+func SetVolumeReadOnly(dn *topology.DataNode, vid storage.VolumeId, readOnly bool) error {
+	values := make(url.Values)
+	values.Add("volume", vid.String())
+	action := "/admin/writable_volume"
+	if readOnly {
+		action = "/admin/readonly_volume"
+	}
+	jsonBlob, err := util.AdminPost(util.NormalizeUrl(dn.Url())+action, values)
+	if err != nil {
+		return err
+	}
+	var ret SetVolumeReadOnlyResult
+	if err := json.Unmarshal(jsonBlob, &ret); err != nil {
+		return err
+	}
+	if ret.Error != "" {
+		return errors.New(ret.Error)
+	}
+	return nil
+}
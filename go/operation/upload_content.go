@@ -7,9 +7,12 @@ import (
 	_ "fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"mime/multipart"
 	"net/http"
+	"code.google.com/p/weed-fs/go/security"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
 )
 
 type UploadResult struct {
@@ -17,27 +20,42 @@ type UploadResult struct {
 	Error string
 }
 
-func Upload(uploadUrl string, filename string, reader io.Reader) (*UploadResult, error) {
+//jwt is the file token from the assign, without one the upload is signed as server to server traffic
+func Upload(uploadUrl string, filename string, reader io.Reader, requestId string, jwt string) (*UploadResult, error) {
 	body_buf := bytes.NewBufferString("")
 	body_writer := multipart.NewWriter(body_buf)
 	file_writer, err := body_writer.CreateFormFile("file", filename)
 	io.Copy(file_writer, reader)
 	content_type := body_writer.FormDataContentType()
 	body_writer.Close()
-	resp, err := http.Post(uploadUrl, content_type, body_buf)
+	req, err := http.NewRequest("POST", uploadUrl, body_buf)
 	if err != nil {
-		log.Println("failing to upload to", uploadUrl)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", content_type)
+	if jwt != "" {
+		security.SetFileToken(req, jwt)
+	} else {
+		security.Sign(req, nil)
+	}
+	trace.SetRequestId(req, requestId)
+	span := trace.StartSpan(requestId, "upload", "url", uploadUrl)
+	resp, err := util.Do(req)
+	if err != nil {
+		span.End("error", err)
+		wlog.Warn("failed to upload", "url", uploadUrl, "error", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 	resp_body, err := ioutil.ReadAll(resp.Body)
+	span.End("status", resp.StatusCode)
 	if err != nil {
 		return nil, err
 	}
 	var ret UploadResult
 	err = json.Unmarshal(resp_body, &ret)
 	if err != nil {
-		log.Println("failing to read upload resonse", uploadUrl, resp_body)
+		wlog.Warn("failed to read upload response", "url", uploadUrl, "response", string(resp_body))
 		return nil, err
 	}
 	if ret.Error != "" {
@@ -2,11 +2,12 @@ package replication
 
 import (
 	"errors"
-	"fmt"
 	"math/rand"
+	"code.google.com/p/weed-fs/go/metrics"
 	"code.google.com/p/weed-fs/go/operation"
 	"code.google.com/p/weed-fs/go/storage"
 	"code.google.com/p/weed-fs/go/topology"
+	"code.google.com/p/weed-fs/go/wlog"
 	"sync"
 )
 
@@ -18,6 +19,8 @@ This package is created to resolve these replica placement issues:
 4. volume allocation for each bucket
 */
 
+var volumeGrowth = metrics.NewCounterVec("weed_master_volume_growth_total", "Number of volumes grown by replication type and result.", "replication", "result")
+
 type VolumeGrowth struct {
 	copy1factor int
 	copy2factor int
@@ -67,14 +70,9 @@ func (vg *VolumeGrowth) GrowByCountAndType(count int, repType storage.Replicatio
 			//randomly pick one server, and then choose from the same rack
 			if ok, server1, vid := topo.RandomlyReserveOneVolume(); ok {
 				rack := server1.Parent()
-				exclusion := make(map[string]topology.Node)
-				exclusion[server1.String()] = server1
-				newNodeList := topology.NewNodeList(rack.Children(), exclusion)
-				if newNodeList.FreeSpace() > 0 {
-					if ok2, server2 := newNodeList.ReserveOneVolume(rand.Intn(newNodeList.FreeSpace()), *vid); ok2 {
-						if err = vg.grow(topo, *vid, repType, server1, server2); err == nil {
-							counter++
-						}
+				if ok2, server2 := reserveOneVolumeApart(rack.Children(), nil, *vid, server1); ok2 {
+					if err = vg.grow(topo, *vid, repType, server1, server2); err == nil {
+						counter++
 					}
 				}
 			}
@@ -87,12 +85,9 @@ func (vg *VolumeGrowth) GrowByCountAndType(count int, repType storage.Replicatio
 				dc := rack.Parent()
 				exclusion := make(map[string]topology.Node)
 				exclusion[rack.String()] = rack
-				newNodeList := topology.NewNodeList(dc.Children(), exclusion)
-				if newNodeList.FreeSpace() > 0 {
-					if ok2, server2 := newNodeList.ReserveOneVolume(rand.Intn(newNodeList.FreeSpace()), *vid); ok2 {
-						if err = vg.grow(topo, *vid, repType, server1, server2); err == nil {
-							counter++
-						}
+				if ok2, server2 := reserveOneVolumeApart(dc.Children(), exclusion, *vid, server1); ok2 {
+					if err = vg.grow(topo, *vid, repType, server1, server2); err == nil {
+						counter++
 					}
 				}
 			}
@@ -105,10 +100,8 @@ func (vg *VolumeGrowth) GrowByCountAndType(count int, repType storage.Replicatio
 			if ret {
 				var servers []*topology.DataNode
 				for _, n := range picked {
-					if n.FreeSpace() > 0 {
-						if ok, server := n.ReserveOneVolume(rand.Intn(n.FreeSpace()), vid); ok {
-							servers = append(servers, server)
-						}
+					if ok, server := reserveOneVolumeApart(singleNode(n), nil, vid, servers...); ok {
+						servers = append(servers, server)
 					}
 				}
 				if len(servers) == 2 {
@@ -129,24 +122,17 @@ func (vg *VolumeGrowth) GrowByCountAndType(count int, repType storage.Replicatio
 				if dc2.FreeSpace() > dc1.FreeSpace() {
 					dc1, dc2 = dc2, dc1
 				}
-				if dc1.FreeSpace() > 0 {
-					if ok, server1 := dc1.ReserveOneVolume(rand.Intn(dc1.FreeSpace()), vid); ok {
-						servers = append(servers, server1)
-						rack := server1.Parent()
-						exclusion := make(map[string]topology.Node)
-						exclusion[rack.String()] = rack
-						newNodeList := topology.NewNodeList(dc1.Children(), exclusion)
-						if newNodeList.FreeSpace() > 0 {
-							if ok2, server2 := newNodeList.ReserveOneVolume(rand.Intn(newNodeList.FreeSpace()), vid); ok2 {
-								servers = append(servers, server2)
-							}
-						}
+				if ok, server1 := reserveOneVolumeApart(singleNode(dc1), nil, vid); ok {
+					servers = append(servers, server1)
+					rack := server1.Parent()
+					exclusion := make(map[string]topology.Node)
+					exclusion[rack.String()] = rack
+					if ok2, server2 := reserveOneVolumeApart(dc1.Children(), exclusion, vid, servers...); ok2 {
+						servers = append(servers, server2)
 					}
 				}
-				if dc2.FreeSpace() > 0 {
-					if ok, server := dc2.ReserveOneVolume(rand.Intn(dc2.FreeSpace()), vid); ok {
-						servers = append(servers, server)
-					}
+				if ok, server := reserveOneVolumeApart(singleNode(dc2), nil, vid, servers...); ok {
+					servers = append(servers, server)
 				}
 				if len(servers) == 3 {
 					if err = vg.grow(topo, vid, repType, servers...); err == nil {
@@ -163,10 +149,8 @@ func (vg *VolumeGrowth) GrowByCountAndType(count int, repType storage.Replicatio
 			if ret {
 				var servers []*topology.DataNode
 				for _, n := range picked {
-					if n.FreeSpace() > 0 {
-						if ok, server := n.ReserveOneVolume(rand.Intn(n.FreeSpace()), vid); ok {
-							servers = append(servers, server)
-						}
+					if ok, server := reserveOneVolumeApart(singleNode(n), nil, vid, servers...); ok {
+						servers = append(servers, server)
 					}
 				}
 				if len(servers) == 3 {
@@ -179,15 +163,72 @@ func (vg *VolumeGrowth) GrowByCountAndType(count int, repType storage.Replicatio
 	}
 	return
 }
-func (vg *VolumeGrowth) grow(topo *topology.Topology, vid storage.VolumeId, repType storage.ReplicationType, servers ...*topology.DataNode) error {
+
+//randomly picks a data node under the nodes, except the excluded ones and the
+//ones sharing a disk with the servers already picked for the volume
+func reserveOneVolumeApart(nodes map[topology.NodeId]topology.Node, exclusion map[string]topology.Node, vid storage.VolumeId, picked ...*topology.DataNode) (bool, *topology.DataNode) {
+	candidates := make(map[topology.NodeId]topology.Node)
+	collectDataNodesApart(nodes, exclusion, picked, candidates)
+	nl := topology.NewNodeList(candidates, nil)
+	if nl.FreeSpace() <= 0 {
+		return false, nil
+	}
+	return nl.ReserveOneVolume(rand.Intn(nl.FreeSpace()), vid)
+}
+
+func collectDataNodesApart(nodes map[topology.NodeId]topology.Node, exclusion map[string]topology.Node, picked []*topology.DataNode, candidates map[topology.NodeId]topology.Node) {
+	for id, n := range nodes {
+		if exclusion[n.String()] != nil || n.FreeSpace() <= 0 {
+			continue
+		}
+		if !n.IsDataNode() {
+			collectDataNodesApart(n.Children(), exclusion, picked, candidates)
+			continue
+		}
+		apart := true
+		for _, p := range picked {
+			if n.(*topology.DataNode).SharesDiskWith(p) {
+				apart = false
+				break
+			}
+		}
+		if apart {
+			candidates[id] = n
+		}
+	}
+}
+
+func singleNode(n topology.Node) map[topology.NodeId]topology.Node {
+	return map[topology.NodeId]topology.Node{n.Id(): n}
+}
+
+func (vg *VolumeGrowth) grow(topo *topology.Topology, vid storage.VolumeId, repType storage.ReplicationType, servers ...*topology.DataNode) (err error) {
+	defer func() {
+		if err != nil {
+			volumeGrowth.Inc(repType.String(), "failure")
+		} else {
+			volumeGrowth.Inc(repType.String(), "success")
+		}
+	}()
+	for i, server := range servers {
+		for _, other := range servers[i+1:] {
+			if server.SharesDiskWith(other) {
+				wlog.Warn("not assigning volume to nodes sharing the same disk", "volume", vid, "node", server.Url(), "other", other.Url())
+				return errors.New("Replicas of " + vid.String() + " would share the same disk")
+			}
+		}
+	}
+	tier := topo.TierPolicy().HotTier()
 	for _, server := range servers {
-		if err := operation.AllocateVolume(server, vid, repType); err == nil {
-			vi := storage.VolumeInfo{Id: vid, Size: 0, RepType: repType, Version: storage.CurrentVersion}
-			server.AddOrUpdateVolume(vi)
-			topo.RegisterVolumeLayout(&vi, server)
-			fmt.Println("Created Volume", vid, "on", server)
+		if allocated, err := operation.AllocateVolume(server, vid, repType, tier); err == nil {
+			vi := storage.VolumeInfo{Id: vid, Size: 0, RepType: repType, Version: storage.CurrentVersion, Tier: allocated}
+			topo.RegisterNewVolume(vi, server)
+			wlog.Info("created volume", "volume", vid, "node", server.Url(), "tier", allocated)
+			if tier != "" && allocated != "" && allocated != tier {
+				wlog.Warn("volume created outside the hot tier, which has no space on the node", "volume", vid, "node", server.Url(), "hotTier", tier, "tier", allocated)
+			}
 		} else {
-			fmt.Println("Failed to assign", vid, "to", servers)
+			wlog.Error("failed to assign volume", "volume", vid, "node", server.Url(), "error", err)
 			return errors.New("Failed to assign " + vid.String())
 		}
 	}
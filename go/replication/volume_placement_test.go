@@ -0,0 +1,33 @@
+package replication
+
+import (
+	"io/ioutil"
+	"os"
+	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/topology"
+	"testing"
+)
+
+func TestReserveOneVolumeApart(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir)
+	topo := topology.NewTopology("test", "", dir, "seq", 1<<20, 5)
+	sharedDisk := []storage.DiskInfo{{Directory: "/data", Device: 7}}
+	topo.RegisterVolumes(true, nil, sharedDisk, "127.0.0.1", 8080, "127.0.0.1:8080", 3)
+	topo.RegisterVolumes(true, nil, sharedDisk, "127.0.0.1", 8081, "127.0.0.1:8081", 3)
+	topo.RegisterVolumes(true, nil, nil, "127.0.0.2", 8080, "127.0.0.2:8080", 3)
+	first, same, other := topo.FindDataNode("127.0.0.1:8080"), topo.FindDataNode("127.0.0.1:8081"), topo.FindDataNode("127.0.0.2:8080")
+
+	for i := 0; i < 20; i++ {
+		ok, dn := reserveOneVolumeApart(topo.Children(), nil, 1, first)
+		if !ok {
+			t.Fatal("failed to reserve a volume")
+		}
+		if dn == first || dn == same {
+			t.Fatalf("picked %s, which shares the disk of %s", dn.Url(), first.Url())
+		}
+	}
+	if ok, dn := reserveOneVolumeApart(topo.Children(), nil, 1, first, other); ok {
+		t.Fatalf("picked %s, with every node excluded", dn.Url())
+	}
+}
@@ -0,0 +1,261 @@
+package s3api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signV4Algorithm   = "AWS4-HMAC-SHA256"
+	iso8601Format     = "20060102T150405Z"
+	yyyymmdd          = "20060102"
+	unsignedPayload   = "UNSIGNED-PAYLOAD"
+	streamingPayload  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	emptySha256       = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	maxClockSkew      = 15 * time.Minute
+	maxPresignExpires = 7 * 24 * time.Hour
+)
+
+// signingContext is what the chunks of a streaming payload are signed with.
+type signingContext struct {
+	key       []byte
+	amzDate   string
+	scope     string
+	signature string //of the request, the seed of the chunk signatures
+}
+
+//the parts of a Signature V4, from the Authorization header or a presigned url
+type signatureV4 struct {
+	accessKey     string
+	date          string //yyyymmdd of the credential scope
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+	amzDate       string
+	payloadHash   string
+}
+
+func (sig *signatureV4) scope() string {
+	return sig.date + "/" + sig.region + "/" + sig.service + "/aws4_request"
+}
+
+//accessKey/yyyymmdd/region/service/aws4_request
+func (sig *signatureV4) parseCredential(credential string) bool {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return false
+	}
+	sig.accessKey, sig.date, sig.region, sig.service = parts[0], parts[1], parts[2], parts[3]
+	return true
+}
+
+//AWS4-HMAC-SHA256 Credential=..., SignedHeaders=a;b, Signature=...
+func parseAuthorization(r *http.Request) (*signatureV4, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, signV4Algorithm+" ") {
+		return nil, errAuthorizationHeader
+	}
+	sig := &signatureV4{}
+	for _, field := range strings.Split(auth[len(signV4Algorithm)+1:], ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return nil, errAuthorizationHeader
+		}
+		switch kv[0] {
+		case "Credential":
+			if !sig.parseCredential(kv[1]) {
+				return nil, errAuthorizationHeader
+			}
+		case "SignedHeaders":
+			sig.signedHeaders = strings.Split(kv[1], ";")
+		case "Signature":
+			sig.signature = kv[1]
+		}
+	}
+	if sig.accessKey == "" || sig.signature == "" || len(sig.signedHeaders) == 0 {
+		return nil, errAuthorizationHeader
+	}
+	sig.amzDate = r.Header.Get("X-Amz-Date")
+	if sig.amzDate == "" {
+		if t, err := http.ParseTime(r.Header.Get("Date")); err == nil {
+			sig.amzDate = t.UTC().Format(iso8601Format)
+		}
+	}
+	sig.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if sig.payloadHash == "" {
+		return nil, errMissingSecurityHeader
+	}
+	return sig, nil
+}
+
+func parsePresigned(r *http.Request, now time.Time) (*signatureV4, error) {
+	q := r.URL.Query()
+	if q.Get("X-Amz-Algorithm") != signV4Algorithm {
+		return nil, errAuthorizationHeader
+	}
+	sig := &signatureV4{
+		signedHeaders: strings.Split(q.Get("X-Amz-SignedHeaders"), ";"),
+		signature:     q.Get("X-Amz-Signature"),
+		amzDate:       q.Get("X-Amz-Date"),
+		payloadHash:   q.Get("X-Amz-Content-Sha256"),
+	}
+	if !sig.parseCredential(q.Get("X-Amz-Credential")) || sig.signature == "" {
+		return nil, errAuthorizationHeader
+	}
+	if sig.payloadHash == "" {
+		sig.payloadHash = unsignedPayload
+	}
+	expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignExpires {
+		return nil, errAuthorizationHeader
+	}
+	t, err := time.Parse(iso8601Format, sig.amzDate)
+	if err != nil {
+		return nil, errAuthorizationHeader
+	}
+	if now.After(t.Add(time.Duration(expires) * time.Second)) {
+		return nil, errExpiredPresignRequest
+	}
+	return sig, nil
+}
+
+//the uri encoding of the Signature V4 spec: all but the unreserved characters, and
+//slashes in paths, are percent encoded
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+		} else {
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := []string{}
+	for k := range query {
+		if k != "X-Amz-Signature" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func headerValue(r *http.Request, name string) string {
+	switch name {
+	case "host":
+		return r.Host
+	case "content-length":
+		if r.Header.Get("Content-Length") == "" && r.ContentLength >= 0 {
+			return strconv.FormatInt(r.ContentLength, 10)
+		}
+	}
+	values := []string{}
+	for _, v := range r.Header[http.CanonicalHeaderKey(name)] {
+		values = append(values, strings.Join(strings.Fields(v), " "))
+	}
+	return strings.Join(values, ",")
+}
+
+func canonicalRequest(r *http.Request, sig *signatureV4) string {
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	headers := ""
+	for _, name := range sig.signedHeaders {
+		headers += name + ":" + headerValue(r, name) + "\n"
+	}
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(path, false),
+		canonicalQuery(r.URL.Query()),
+		headers,
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payloadHash,
+	}, "\n")
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func signingKey(secretKey string, sig *signatureV4) []byte {
+	key := hmacSha256([]byte("AWS4"+secretKey), sig.date)
+	key = hmacSha256(key, sig.region)
+	key = hmacSha256(key, sig.service)
+	return hmacSha256(key, "aws4_request")
+}
+
+// authenticate verifies the Signature V4 of a request, signed in its headers or
+// presigned in its url. It returns what the chunks of a streaming payload are
+// signed with, nil for other requests. All requests pass if there are no keys.
+func (s *Server) authenticate(r *http.Request) (*signingContext, error) {
+	if len(s.Keys) == 0 {
+		return nil, nil
+	}
+	now := s.now()
+	var sig *signatureV4
+	var err error
+	if r.Header.Get("Authorization") != "" {
+		sig, err = parseAuthorization(r)
+	} else if r.URL.Query().Get("X-Amz-Algorithm") != "" {
+		sig, err = parsePresigned(r, now)
+	} else {
+		return nil, errAccessDenied
+	}
+	if err != nil {
+		return nil, err
+	}
+	secretKey, ok := s.Keys[sig.accessKey]
+	if !ok {
+		return nil, errInvalidAccessKeyId
+	}
+	t, err := time.Parse(iso8601Format, sig.amzDate)
+	if err != nil || t.Format(yyyymmdd) != sig.date {
+		return nil, errAuthorizationHeader
+	}
+	if r.Header.Get("Authorization") != "" && (t.Before(now.Add(-maxClockSkew)) || t.After(now.Add(maxClockSkew))) {
+		return nil, errRequestTimeTooSkewed
+	}
+	key := signingKey(secretKey, sig)
+	stringToSign := signV4Algorithm + "\n" + sig.amzDate + "\n" + sig.scope() + "\n" + sha256Hex([]byte(canonicalRequest(r, sig)))
+	expected := hex.EncodeToString(hmacSha256(key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return nil, errSignatureDoesNotMatch
+	}
+	return &signingContext{key: key, amzDate: sig.amzDate, scope: sig.scope(), signature: sig.signature}, nil
+}
+
+//the signature of a chunk of a streaming payload, chained to the previous one
+func (sc *signingContext) chunkSignature(previous string, data []byte) string {
+	stringToSign := signV4Algorithm + "-PAYLOAD\n" + sc.amzDate + "\n" + sc.scope + "\n" + previous + "\n" + emptySha256 + "\n" + sha256Hex(data)
+	return hex.EncodeToString(hmacSha256(sc.key, stringToSign))
+}
@@ -0,0 +1,161 @@
+package s3api
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxPayloadChunk = 16 * 1024 * 1024
+
+// chunkedReader decodes an aws-chunked payload, verifying the signature of each
+// chunk if they are signed. Trailing headers, e.g. checksums, are skipped.
+type chunkedReader struct {
+	r             *bufio.Reader
+	sc            *signingContext //nil if the chunks are not signed
+	previous      string          //signature of the previous chunk
+	data          []byte          //unread data of the current chunk
+	total         int64
+	decodedLength int64 //-1 if unknown
+	err           error
+}
+
+func newChunkedReader(r io.Reader, sc *signingContext, decodedLength int64) *chunkedReader {
+	c := &chunkedReader{r: bufio.NewReader(r), sc: sc, decodedLength: decodedLength}
+	if sc != nil {
+		c.previous = sc.signature
+	}
+	return c
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for len(c.data) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.next()
+	}
+	n := copy(p, c.data)
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			err = errIncompleteBody
+		}
+		return "", err
+	}
+	if len(line) > 4096 {
+		return "", errMalformedChunk
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+//reads the next chunk: hex-size[;chunk-signature=sig]\r\ndata\r\n
+func (c *chunkedReader) next() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	header := strings.SplitN(line, ";", 2)
+	size, err := strconv.ParseInt(strings.TrimSpace(header[0]), 16, 64)
+	if err != nil || size < 0 || size > maxPayloadChunk {
+		return errMalformedChunk
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(c.r, data); err != nil {
+		return errIncompleteBody
+	}
+	if c.sc != nil {
+		if len(header) != 2 || !strings.HasPrefix(header[1], "chunk-signature=") {
+			return errMalformedChunk
+		}
+		signature := strings.TrimPrefix(header[1], "chunk-signature=")
+		if !hmac.Equal([]byte(signature), []byte(c.sc.chunkSignature(c.previous, data))) {
+			return errSignatureDoesNotMatch
+		}
+		c.previous = signature
+	}
+	if size == 0 {
+		//trailing headers until an empty line
+		for {
+			if line, err = c.readLine(); err != nil || line == "" {
+				break
+			}
+		}
+		if c.decodedLength >= 0 && c.total != c.decodedLength {
+			return errIncompleteBody
+		}
+		return io.EOF
+	}
+	if line, err = c.readLine(); err != nil || line != "" {
+		return errMalformedChunk
+	}
+	c.total += size
+	c.data = data
+	return nil
+}
+
+// verifyingReader fails at the end of the content if its digest is not the expected one.
+type verifyingReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected []byte
+	mismatch error
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && !hmac.Equal(v.hash.Sum(nil), v.expected) {
+		return n, v.mismatch
+	}
+	return n, err
+}
+
+// payload is the content of a PUT, decoded and verified against the signature
+// and the Content-MD5 of the request.
+func payload(r *http.Request, sc *signingContext) (io.Reader, error) {
+	var body io.Reader = r.Body
+	switch hash := r.Header.Get("X-Amz-Content-Sha256"); {
+	case strings.HasPrefix(hash, "STREAMING-"):
+		switch hash {
+		case streamingPayload, streamingPayload + "-TRAILER":
+			//sc is nil if the server has no keys to check the chunks with
+		case "STREAMING-UNSIGNED-PAYLOAD-TRAILER":
+			sc = nil
+		default:
+			return nil, errNotImplemented
+		}
+		decodedLength, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil {
+			decodedLength = -1
+		}
+		body = newChunkedReader(body, sc, decodedLength)
+	case hash != "" && hash != unsignedPayload && sc != nil:
+		expected, err := hex.DecodeString(hash)
+		if err != nil || len(expected) != sha256.Size {
+			return nil, errContentSHA256Mismatch
+		}
+		body = &verifyingReader{r: body, hash: sha256.New(), expected: expected, mismatch: errContentSHA256Mismatch}
+	}
+	if contentMd5 := r.Header.Get("Content-Md5"); contentMd5 != "" {
+		expected, err := base64.StdEncoding.DecodeString(contentMd5)
+		if err != nil || len(expected) != md5.Size {
+			return nil, errInvalidDigest
+		}
+		body = &verifyingReader{r: body, hash: md5.New(), expected: expected, mismatch: errBadDigest}
+	}
+	return body, nil
+}
@@ -0,0 +1,95 @@
+package s3api
+
+import (
+	"encoding/xml"
+	"net/http"
+	"code.google.com/p/weed-fs/go/filer"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/wlog"
+)
+
+// apiError is an error as S3 reports it, with its code, message and http status.
+type apiError struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errAccessDenied          = &apiError{"AccessDenied", "Access Denied.", http.StatusForbidden}
+	errBadDigest             = &apiError{"BadDigest", "The Content-MD5 or checksum you specified did not match what we received.", http.StatusBadRequest}
+	errBucketAlreadyOwned    = &apiError{"BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it.", http.StatusConflict}
+	errBucketNotEmpty        = &apiError{"BucketNotEmpty", "The bucket you tried to delete is not empty.", http.StatusConflict}
+	errContentSHA256Mismatch = &apiError{"XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.", http.StatusBadRequest}
+	errIncompleteBody        = &apiError{"IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header.", http.StatusBadRequest}
+	errInternalError         = &apiError{"InternalError", "We encountered an internal error. Please try again.", http.StatusInternalServerError}
+	errInvalidAccessKeyId    = &apiError{"InvalidAccessKeyId", "The access key Id you provided does not exist in our records.", http.StatusForbidden}
+	errInvalidArgument       = &apiError{"InvalidArgument", "Invalid Argument.", http.StatusBadRequest}
+	errInvalidBucketName     = &apiError{"InvalidBucketName", "The specified bucket is not valid.", http.StatusBadRequest}
+	errInvalidDigest         = &apiError{"InvalidDigest", "The Content-MD5 you specified is not valid.", http.StatusBadRequest}
+	errInvalidKey            = &apiError{"InvalidArgument", "Object keys with empty, . or .. path segments are not supported.", http.StatusBadRequest}
+	errInvalidPart           = &apiError{"InvalidPart", "One or more of the specified parts could not be found.", http.StatusBadRequest}
+	errInvalidPartOrder      = &apiError{"InvalidPartOrder", "The list of parts was not in ascending order.", http.StatusBadRequest}
+	errInvalidRange          = &apiError{"InvalidRange", "The requested range is not satisfiable.", http.StatusRequestedRangeNotSatisfiable}
+	errKeyConflict           = &apiError{"InvalidArgument", "An object key can not be the prefix of other keys followed by a slash, and the other way around.", http.StatusConflict}
+	errMalformedXML          = &apiError{"MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema.", http.StatusBadRequest}
+	errMalformedChunk        = &apiError{"InvalidRequest", "The aws-chunked payload is malformed.", http.StatusBadRequest}
+	errMethodNotAllowed      = &apiError{"MethodNotAllowed", "The specified method is not allowed against this resource.", http.StatusMethodNotAllowed}
+	errMissingSecurityHeader = &apiError{"MissingSecurityHeader", "Your request is missing a required header.", http.StatusBadRequest}
+	errNoSuchBucket          = &apiError{"NoSuchBucket", "The specified bucket does not exist.", http.StatusNotFound}
+	errNoSuchKey             = &apiError{"NoSuchKey", "The specified key does not exist.", http.StatusNotFound}
+	errNoSuchUpload          = &apiError{"NoSuchUpload", "The specified multipart upload does not exist.", http.StatusNotFound}
+	errNotImplemented        = &apiError{"NotImplemented", "A header or query you provided implies functionality that is not implemented.", http.StatusNotImplemented}
+	errPreconditionFailed    = &apiError{"PreconditionFailed", "At least one of the preconditions you specified did not hold.", http.StatusPreconditionFailed}
+	errRequestTimeTooSkewed  = &apiError{"RequestTimeTooSkewed", "The difference between the request time and the server's time is too large.", http.StatusForbidden}
+	errSignatureDoesNotMatch = &apiError{"SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.", http.StatusForbidden}
+	errAuthorizationHeader   = &apiError{"AuthorizationHeaderMalformed", "The authorization header is malformed.", http.StatusBadRequest}
+	errExpiredPresignRequest = &apiError{"AccessDenied", "Request has expired.", http.StatusForbidden}
+)
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	Resource  string
+	RequestId string
+}
+
+//maps the filer errors of object requests to their s3 error
+func objectError(err error) error {
+	switch err {
+	case filer.ErrNotFound, filer.ErrNotDirectory, filer.ErrIsDirectory:
+		return errNoSuchKey
+	case filer.ErrExists, filer.ErrInvalidPath:
+		return errInvalidArgument
+	}
+	return err
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		wlog.Error("s3 request failed", "method", r.Method, "path", r.URL.Path, "requestId", trace.RequestId(r), "error", err)
+		e = errInternalError
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(e.Status)
+	if r.Method == "HEAD" {
+		return
+	}
+	writeXmlBody(w, errorResponse{Code: e.Code, Message: e.Message, Resource: r.URL.Path, RequestId: trace.RequestId(r)})
+}
+
+func writeXmlBody(w http.ResponseWriter, v interface{}) {
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeXml(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	writeXmlBody(w, v)
+}
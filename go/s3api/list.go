@@ -0,0 +1,249 @@
+package s3api
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"code.google.com/p/weed-fs/go/filer"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const maxListKeys = 1000
+
+var errListFull = errors.New("listing is full")
+
+// listing collects the objects and common prefixes of a bucket in key order.
+// Directories are walked in the order of their keys, with a trailing slash, so
+// the keys come out sorted as S3 sorts them, e.g. a.txt before a/b.txt.
+type listing struct {
+	prefix    string
+	delimiter string
+	marker    string //only keys after it are listed
+	maxKeys   int
+
+	objects   []*filer.Entry
+	keys      []string
+	prefixes  []string
+	truncated bool
+	last      string //the last key or common prefix listed
+}
+
+func (l *listing) full() error {
+	if len(l.keys)+len(l.prefixes) >= l.maxKeys {
+		l.truncated = true
+		return errListFull
+	}
+	return nil
+}
+
+//adds an object, or the common prefix it is in
+func (l *listing) add(key string, e *filer.Entry) error {
+	if l.delimiter != "" {
+		if i := strings.Index(key[len(l.prefix):], l.delimiter); i >= 0 {
+			commonPrefix := key[:len(l.prefix)+i+len(l.delimiter)]
+			if commonPrefix <= l.marker || len(l.prefixes) > 0 && l.prefixes[len(l.prefixes)-1] == commonPrefix {
+				return nil
+			}
+			if err := l.full(); err != nil {
+				return err
+			}
+			l.prefixes = append(l.prefixes, commonPrefix)
+			l.last = commonPrefix
+			return nil
+		}
+	}
+	if key <= l.marker {
+		return nil
+	}
+	if err := l.full(); err != nil {
+		return err
+	}
+	l.keys = append(l.keys, key)
+	l.objects = append(l.objects, e)
+	l.last = key
+	return nil
+}
+
+//whether all keys in the directory fall in the common prefix of the directory itself
+func (l *listing) grouped(dirKey string) bool {
+	return l.delimiter != "" && strings.Contains(dirKey[len(l.prefix):], l.delimiter)
+}
+
+type keyedEntry struct {
+	key   string
+	entry *filer.Entry
+}
+
+//walks a directory of a bucket, whose keys start with dirKey, and returns how many
+//entries it has
+func (s *Server) walk(dir string, dirKey string, l *listing) (int, error) {
+	entries := []keyedEntry{}
+	startAfter := ""
+	for {
+		page, err := s.Filer.List(dir, startAfter, 1024)
+		if err != nil {
+			return 0, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, e := range page {
+			key := dirKey + e.Name()
+			if e.IsDirectory {
+				key += "/"
+			}
+			entries = append(entries, keyedEntry{key, e})
+		}
+		startAfter = page[len(page)-1].Name()
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	for _, ke := range entries {
+		key, e := ke.key, ke.entry
+		var err error
+		switch {
+		case !e.IsDirectory:
+			if strings.HasPrefix(key, l.prefix) {
+				err = l.add(key, e)
+			}
+		case !strings.HasPrefix(key, l.prefix) && !strings.HasPrefix(l.prefix, key):
+		case key < l.marker && !strings.HasPrefix(l.marker, key):
+			//listed before the marker already
+		case strings.HasPrefix(key, l.prefix) && l.grouped(key):
+			err = l.add(key, e)
+		default:
+			var n int
+			if n, err = s.walk(e.FullPath, key, l); err == nil && n == 0 && strings.HasPrefix(key, l.prefix) {
+				//an empty directory is a folder marker
+				err = l.add(key, e)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(entries), nil
+}
+
+//fills the listing from the directory of the prefix, the keys can not be elsewhere
+func (s *Server) list(bucketDir string, l *listing) error {
+	if l.maxKeys == 0 {
+		return nil
+	}
+	dir, dirKey := bucketDir, l.prefix[:strings.LastIndex(l.prefix, "/")+1]
+	if dirKey != "" {
+		var err error
+		if dir, err = s.objectPath(bucketDir, dirKey); err != nil {
+			return nil
+		}
+		if e, err := s.Filer.Get(dir); err != nil || !e.IsDirectory {
+			return nil
+		}
+	}
+	_, err := s.walk(dir, dirKey, l)
+	if err == errListFull {
+		err = nil
+	}
+	return err
+}
+
+type listedObject struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Marker                *string `xml:",omitempty"`
+	NextMarker            string  `xml:",omitempty"`
+	StartAfter            string  `xml:",omitempty"`
+	Delimiter             string  `xml:",omitempty"`
+	MaxKeys               int
+	KeyCount              *int `xml:",omitempty"`
+	IsTruncated           bool
+	EncodingType          string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	Contents              []listedObject
+	CommonPrefixes        []commonPrefix
+}
+
+// listObjects serves ListObjectsV2, and the original ListObjects without list-type=2.
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string, bucketDir string) error {
+	q := r.URL.Query()
+	l := &listing{prefix: q.Get("prefix"), delimiter: q.Get("delimiter"), maxKeys: maxListKeys}
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errInvalidArgument
+		}
+		if n < l.maxKeys {
+			l.maxKeys = n
+		}
+	}
+	v2 := q.Get("list-type") == "2"
+	if v2 {
+		l.marker = q.Get("start-after")
+		if token := q.Get("continuation-token"); token != "" {
+			marker, err := base64.StdEncoding.DecodeString(token)
+			if err != nil {
+				return errInvalidArgument
+			}
+			l.marker = string(marker)
+		}
+	} else {
+		l.marker = q.Get("marker")
+	}
+	if err := s.list(bucketDir, l); err != nil {
+		return err
+	}
+
+	encode := func(s string) string { return s }
+	if q.Get("encoding-type") == "url" {
+		encode = url.QueryEscape
+	}
+	result := listBucketResult{
+		Name:         bucket,
+		Prefix:       encode(l.prefix),
+		Delimiter:    encode(l.delimiter),
+		MaxKeys:      l.maxKeys,
+		IsTruncated:  l.truncated,
+		EncodingType: q.Get("encoding-type"),
+	}
+	for i, key := range l.keys {
+		e := l.objects[i]
+		result.Contents = append(result.Contents, listedObject{Key: encode(key), LastModified: e.Mtime.UTC().Format(timeFormat), ETag: etag(e), Size: e.Size, StorageClass: "STANDARD"})
+	}
+	for _, p := range l.prefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: encode(p)})
+	}
+	if v2 {
+		keyCount := len(l.keys) + len(l.prefixes)
+		result.KeyCount = &keyCount
+		result.StartAfter = encode(q.Get("start-after"))
+		result.ContinuationToken = q.Get("continuation-token")
+		if l.truncated {
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(l.last))
+		}
+	} else {
+		marker := encode(l.marker)
+		result.Marker = &marker
+		if l.truncated && l.delimiter != "" {
+			result.NextMarker = encode(l.last)
+		}
+	}
+	writeXml(w, result)
+	return nil
+}
@@ -0,0 +1,194 @@
+package s3api
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"path"
+	"code.google.com/p/weed-fs/go/filer"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/wlog"
+	"strconv"
+	"strings"
+)
+
+// A multipart upload is a directory in BucketsDir/.uploads, named after the upload
+// id, with a file for each part. Completing the upload creates the object from the
+// chunks of the parts, without copying them.
+
+const maxPartNumber = 10000
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+func (s *Server) uploadDir(uploadId string) string {
+	return path.Join(s.BucketsDir, uploadsName, uploadId)
+}
+
+func (s *Server) partPath(uploadId string, partNumber int) string {
+	return s.uploadDir(uploadId) + "/" + fmt.Sprintf("%05d", partNumber)
+}
+
+//the directory of an upload of the object
+func (s *Server) upload(bucket, key, uploadId string) (*filer.Entry, error) {
+	if uploadId == "" || strings.ContainsAny(uploadId, "/.") {
+		return nil, errNoSuchUpload
+	}
+	e, err := s.Filer.Get(s.uploadDir(uploadId))
+	if err == filer.ErrNotFound || err == nil && (!e.IsDirectory || e.Extended["bucket"] != bucket || e.Extended["key"] != key) {
+		return nil, errNoSuchUpload
+	}
+	return e, err
+}
+
+func (s *Server) createMultipartUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	dir, err := s.bucketDir(bucket)
+	if err != nil {
+		return err
+	}
+	if _, err = s.objectPath(dir, key); err != nil || strings.HasSuffix(key, "/") {
+		return errInvalidKey
+	}
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return err
+	}
+	uploadId := hex.EncodeToString(id)
+	//the attributes of the object, kept until it is created
+	ext := objectAttributes(r)
+	ext["bucket"], ext["key"], ext["mime"] = bucket, key, r.Header.Get("Content-Type")
+	if err = s.Filer.CreateEntry(ctx, &filer.Entry{FullPath: s.uploadDir(uploadId), IsDirectory: true, Extended: ext}); err != nil {
+		return err
+	}
+	writeXml(w, initiateMultipartUploadResult{Bucket: bucket, Key: key, UploadId: uploadId})
+	return nil
+}
+
+func (s *Server) uploadPart(ctx context.Context, w http.ResponseWriter, r *http.Request, sc *signingContext, bucket, key string) error {
+	q := r.URL.Query()
+	partNumber, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		return errInvalidArgument
+	}
+	if _, err = s.upload(bucket, key, q.Get("uploadId")); err != nil {
+		return err
+	}
+	body, err := payload(r, sc)
+	if err != nil {
+		return err
+	}
+	part := &filer.Entry{FullPath: s.partPath(q.Get("uploadId"), partNumber)}
+	if err = s.Filer.WriteFile(ctx, part, body); err != nil {
+		return writeObjectError(err)
+	}
+	w.Header().Set("ETag", etag(part))
+	return nil
+}
+
+func (s *Server) completeMultipartUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, sc *signingContext, bucket, key string) error {
+	uploadId := r.URL.Query().Get("uploadId")
+	upload, err := s.upload(bucket, key, uploadId)
+	if err != nil {
+		return err
+	}
+	dir, err := s.bucketDir(bucket)
+	if err != nil {
+		return err
+	}
+	fullPath, err := s.objectPath(dir, key)
+	if err != nil {
+		return err
+	}
+	var req completeMultipartUpload
+	if err = readXml(r, sc, &req); err != nil {
+		return err
+	}
+	if len(req.Parts) == 0 {
+		return errMalformedXML
+	}
+
+	entry := &filer.Entry{FullPath: fullPath, Mime: upload.Extended["mime"], Extended: make(map[string]string)}
+	for name, value := range upload.Extended {
+		if name != "bucket" && name != "key" && name != "mime" {
+			entry.Extended[name] = value
+		}
+	}
+	//the etag of a multipart object is the md5 of the md5 of its parts, and their number
+	md5s := md5.New()
+	parts := []string{}
+	last := 0
+	for _, p := range req.Parts {
+		if p.PartNumber <= last {
+			return errInvalidPartOrder
+		}
+		last = p.PartNumber
+		part, err := s.Filer.Get(s.partPath(uploadId, p.PartNumber))
+		if err == filer.ErrNotFound {
+			return errInvalidPart
+		} else if err != nil {
+			return err
+		}
+		if etag(part) != `"`+strings.Trim(p.ETag, `"`)+`"` {
+			return errInvalidPart
+		}
+		sum, _ := hex.DecodeString(part.Md5)
+		md5s.Write(sum)
+		for _, c := range part.Chunks {
+			c.Offset += entry.Size
+			entry.Chunks = append(entry.Chunks, c)
+		}
+		entry.Size += part.Size
+		parts = append(parts, part.FullPath)
+	}
+	entry.Extended[etagKey] = hex.EncodeToString(md5s.Sum(nil)) + "-" + strconv.Itoa(len(parts))
+	if err = s.Filer.CreateEntry(ctx, entry); err != nil {
+		return writeObjectError(err)
+	}
+
+	//the chunks of the completed parts are the object's now, so only their entries
+	//are removed; the parts left out are deleted with the upload
+	for _, p := range parts {
+		s.Filer.Store.Delete(p)
+	}
+	if err = s.Filer.Delete(ctx, s.uploadDir(uploadId), true); err != nil {
+		wlog.Warn("failed to remove completed upload", "uploadId", uploadId, "requestId", trace.FromContext(ctx), "error", err)
+	}
+	writeXml(w, completeMultipartUploadResult{Location: "/" + bucket + "/" + key, Bucket: bucket, Key: key, ETag: etag(entry)})
+	return nil
+}
+
+func (s *Server) abortMultipartUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	uploadId := r.URL.Query().Get("uploadId")
+	if _, err := s.upload(bucket, key, uploadId); err != nil {
+		return err
+	}
+	if err := s.Filer.Delete(ctx, s.uploadDir(uploadId), true); err != nil && err != filer.ErrNotFound {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,358 @@
+package s3api
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"code.google.com/p/weed-fs/go/filer"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/wlog"
+	"strconv"
+	"strings"
+)
+
+const (
+	emptyMd5   = "d41d8cd98f00b204e9800998ecf8427e"
+	etagKey    = "ETag" //in Entry.Extended, the etag of objects not written in one piece
+	maxXmlBody = 1024 * 1024
+)
+
+//request headers kept with an object and returned when it is read, besides the
+//x-amz-meta-* ones
+var storedHeaders = []string{"Cache-Control", "Content-Disposition", "Content-Encoding", "Content-Language", "Expires"}
+
+//the attributes of an object from the headers of its PUT
+func objectAttributes(r *http.Request) map[string]string {
+	ext := make(map[string]string)
+	for _, name := range storedHeaders {
+		if v := r.Header.Get(name); v != "" {
+			ext[name] = v
+		}
+	}
+	//aws-chunked is the encoding of the request only
+	encodings := []string{}
+	for _, e := range strings.Split(ext["Content-Encoding"], ",") {
+		if e = strings.TrimSpace(e); e != "" && e != "aws-chunked" {
+			encodings = append(encodings, e)
+		}
+	}
+	if len(encodings) > 0 {
+		ext["Content-Encoding"] = strings.Join(encodings, ",")
+	} else {
+		delete(ext, "Content-Encoding")
+	}
+	for name, values := range r.Header {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			ext[name] = values[0]
+		}
+	}
+	return ext
+}
+
+func etag(e *filer.Entry) string {
+	if tag := e.Extended[etagKey]; tag != "" {
+		return `"` + tag + `"`
+	}
+	if e.Md5 == "" {
+		return `"` + emptyMd5 + `"`
+	}
+	return `"` + e.Md5 + `"`
+}
+
+//maps the filer errors of writing an object to their s3 error
+func writeObjectError(err error) error {
+	switch err {
+	case filer.ErrIsDirectory, filer.ErrNotDirectory, filer.ErrExists:
+		return errKeyConflict
+	case io.ErrUnexpectedEOF:
+		return errIncompleteBody
+	}
+	return err
+}
+
+func (s *Server) putObject(ctx context.Context, w http.ResponseWriter, r *http.Request, sc *signingContext, bucket, key string) error {
+	dir, err := s.bucketDir(bucket)
+	if err != nil {
+		return err
+	}
+	fullPath, err := s.objectPath(dir, key)
+	if err != nil {
+		return err
+	}
+	body, err := payload(r, sc)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(key, "/") {
+		//a folder marker, kept as a directory
+		n, err := io.Copy(ioutil.Discard, body)
+		if err != nil {
+			return writeObjectError(err)
+		}
+		if n > 0 {
+			return errInvalidKey
+		}
+		if err = s.Filer.Mkdir(fullPath); err != nil {
+			return writeObjectError(err)
+		}
+		w.Header().Set("ETag", `"`+emptyMd5+`"`)
+		return nil
+	}
+	entry := &filer.Entry{FullPath: fullPath, Mime: r.Header.Get("Content-Type"), Extended: objectAttributes(r)}
+	if err = s.Filer.WriteFile(ctx, entry, body); err != nil {
+		return writeObjectError(err)
+	}
+	w.Header().Set("ETag", etag(entry))
+	return nil
+}
+
+//the object of a key, a folder marker if the key ends with a slash
+func (s *Server) object(bucket, key string) (*filer.Entry, error) {
+	dir, err := s.bucketDir(bucket)
+	if err != nil {
+		return nil, err
+	}
+	fullPath, err := s.objectPath(dir, key)
+	if err != nil {
+		return nil, errNoSuchKey
+	}
+	entry, err := s.Filer.Get(fullPath)
+	if err != nil {
+		return nil, objectError(err)
+	}
+	if entry.IsDirectory != strings.HasSuffix(key, "/") {
+		return nil, errNoSuchKey
+	}
+	return entry, nil
+}
+
+//the offset and size of a range header; ranges this does not support, e.g. several
+//ones, are ignored as S3 does
+func parseRange(spec string, size int64) (offset int64, length int64, ok bool, err error) {
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(spec, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, size, false, nil
+	}
+	if bounds[0] == "" {
+		n, e := strconv.ParseInt(bounds[1], 10, 64)
+		if e != nil {
+			return 0, size, false, nil
+		}
+		if n <= 0 || size == 0 {
+			return 0, 0, false, errInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+	start, e := strconv.ParseInt(bounds[0], 10, 64)
+	if e != nil || start < 0 {
+		return 0, size, false, nil
+	}
+	end := size - 1
+	if bounds[1] != "" {
+		last, e := strconv.ParseInt(bounds[1], 10, 64)
+		if e != nil || last < start {
+			return 0, size, false, nil
+		}
+		if last < end {
+			end = last
+		}
+	}
+	if start >= size {
+		return 0, 0, false, errInvalidRange
+	}
+	return start, end - start + 1, true, nil
+}
+
+//the response-* query parameters of a GET override the headers of the object
+var responseOverrides = map[string]string{
+	"response-cache-control":       "Cache-Control",
+	"response-content-disposition": "Content-Disposition",
+	"response-content-encoding":    "Content-Encoding",
+	"response-content-language":    "Content-Language",
+	"response-content-type":        "Content-Type",
+	"response-expires":             "Expires",
+}
+
+func etagMatches(condition string, tag string) bool {
+	for _, c := range strings.Split(condition, ",") {
+		if c = strings.TrimSpace(c); c == "*" || c == tag || `"`+c+`"` == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) getObject(ctx context.Context, w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	entry, err := s.object(bucket, key)
+	if err != nil {
+		return err
+	}
+	tag := etag(entry)
+	if condition := r.Header.Get("If-Match"); condition != "" && !etagMatches(condition, tag) {
+		return errPreconditionFailed
+	}
+	h := w.Header()
+	h.Set("ETag", tag)
+	h.Set("Last-Modified", entry.Mtime.UTC().Format(http.TimeFormat))
+	if condition := r.Header.Get("If-None-Match"); condition != "" && etagMatches(condition, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Content-Type", "binary/octet-stream")
+	if entry.Mime != "" {
+		h.Set("Content-Type", entry.Mime)
+	}
+	for name, value := range entry.Extended {
+		if name != etagKey {
+			h.Set(name, value)
+		}
+	}
+	q := r.URL.Query()
+	for param, name := range responseOverrides {
+		if v := q.Get(param); v != "" {
+			h.Set(name, v)
+		}
+	}
+	offset, size, ranged, err := parseRange(r.Header.Get("Range"), entry.Size)
+	if err != nil {
+		h.Set("Content-Range", "bytes */"+strconv.FormatInt(entry.Size, 10))
+		return err
+	}
+	h.Set("Content-Length", strconv.FormatInt(size, 10))
+	if ranged {
+		h.Set("Content-Range", "bytes "+strconv.FormatInt(offset, 10)+"-"+strconv.FormatInt(offset+size-1, 10)+"/"+strconv.FormatInt(entry.Size, 10))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	if r.Method == "HEAD" || entry.IsDirectory {
+		return nil
+	}
+	if err = s.Filer.ReadRange(ctx, entry, offset, size, w); err != nil {
+		//the status is sent already, the client sees a short body
+		wlog.Error("failed to read object", "path", entry.FullPath, "requestId", trace.RequestId(r), "error", err)
+	}
+	return nil
+}
+
+//deletes an object if it exists, and the directories it leaves empty, as S3 has none
+func (s *Server) removeObject(ctx context.Context, bucketDir string, key string) error {
+	fullPath, err := s.objectPath(bucketDir, key)
+	if err != nil {
+		return err
+	}
+	entry, err := s.Filer.Get(fullPath)
+	if err == filer.ErrNotFound || err == filer.ErrNotDirectory {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if entry.IsDirectory != strings.HasSuffix(key, "/") {
+		return nil
+	}
+	err = s.Filer.Delete(ctx, fullPath, false)
+	if err == filer.ErrNotEmpty || err == filer.ErrNotFound {
+		//a folder marker of other objects stays, they need the directory
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for dir := path.Dir(fullPath); strings.HasPrefix(dir, bucketDir+"/"); dir = path.Dir(dir) {
+		if s.Filer.Delete(ctx, dir, false) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *Server) deleteObject(ctx context.Context, w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	dir, err := s.bucketDir(bucket)
+	if err != nil {
+		return err
+	}
+	if err = s.removeObject(ctx, dir, key); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type deleteRequest struct {
+	XMLName xml.Name `xml:"Delete"`
+	Quiet   bool
+	Objects []struct {
+		Key string
+	} `xml:"Object"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+type deletedObject struct {
+	Key string
+}
+
+type deleteError struct {
+	Key     string
+	Code    string
+	Message string
+}
+
+//reads and decodes the xml body of a request
+func readXml(r *http.Request, sc *signingContext, v interface{}) error {
+	body, err := payload(r, sc)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxXmlBody))
+	if err != nil {
+		return writeObjectError(err)
+	}
+	if xml.Unmarshal(data, v) != nil {
+		return errMalformedXML
+	}
+	return nil
+}
+
+func (s *Server) deleteObjects(ctx context.Context, w http.ResponseWriter, r *http.Request, sc *signingContext, bucket string) error {
+	dir, err := s.bucketDir(bucket)
+	if err != nil {
+		return err
+	}
+	var req deleteRequest
+	if err = readXml(r, sc, &req); err != nil {
+		return err
+	}
+	if len(req.Objects) == 0 || len(req.Objects) > 1000 {
+		return errMalformedXML
+	}
+	result := deleteResult{}
+	for _, o := range req.Objects {
+		err := s.removeObject(ctx, dir, o.Key)
+		if err == nil {
+			if !req.Quiet {
+				result.Deleted = append(result.Deleted, deletedObject{Key: o.Key})
+			}
+			continue
+		}
+		e, ok := err.(*apiError)
+		if !ok {
+			wlog.Error("failed to delete object", "bucket", bucket, "key", o.Key, "requestId", trace.FromContext(ctx), "error", err)
+			e = errInternalError
+		}
+		result.Errors = append(result.Errors, deleteError{Key: o.Key, Code: e.Code, Message: e.Message})
+	}
+	writeXml(w, result)
+	return nil
+}
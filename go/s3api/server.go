@@ -0,0 +1,239 @@
+package s3api
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"path"
+	"code.google.com/p/weed-fs/go/filer"
+	"code.google.com/p/weed-fs/go/trace"
+	"strings"
+	"time"
+)
+
+const (
+	timeFormat  = "2006-01-02T15:04:05.000Z"
+	uploadsName = ".uploads" //not a valid bucket name, so it can not clash with one
+)
+
+// Server serves a subset of the S3 REST API with path style urls, e.g.
+// http://localhost:8333/bucket/key. Buckets are the directories in BucketsDir
+// of the filer, and objects the files below them, so a key a/b/c.txt is the file
+// c.txt in the directory a/b of the bucket.
+type Server struct {
+	Filer      *filer.Filer
+	BucketsDir string
+	Region     string
+	Keys       map[string]string //secret keys by access key id, no authentication if empty
+
+	now func() time.Time
+}
+
+func NewServer(f *filer.Filer, keys map[string]string) *Server {
+	return &Server{Filer: f, BucketsDir: "/buckets", Region: "us-east-1", Keys: keys, now: time.Now}
+}
+
+//the query parameters selecting features of buckets and objects that are not supported
+var unsupported = []string{"acl", "accelerate", "analytics", "attributes", "cors", "encryption", "intelligent-tiering",
+	"inventory", "legal-hold", "lifecycle", "logging", "metrics", "notification", "object-lock", "ownershipControls",
+	"policy", "policyStatus", "publicAccessBlock", "replication", "requestPayment", "restore", "retention", "select",
+	"tagging", "torrent", "website"}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sc, err := s.authenticate(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	ctx := trace.NewContext(r.Context(), trace.RequestId(r))
+	q := r.URL.Query()
+	for _, name := range unsupported {
+		if _, ok := q[name]; ok {
+			writeError(w, r, errNotImplemented)
+			return
+		}
+	}
+	bucket := strings.TrimPrefix(r.URL.Path, "/")
+	key := ""
+	if i := strings.Index(bucket, "/"); i >= 0 {
+		bucket, key = bucket[:i], bucket[i+1:]
+	}
+	uploadId := has(q, "uploadId")
+	switch {
+	case bucket == "" && r.Method == "GET":
+		err = s.listBuckets(w, r)
+	case bucket == "":
+		err = errMethodNotAllowed
+	case key == "":
+		err = s.serveBucket(ctx, w, r, sc, bucket)
+	case r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "":
+		err = errNotImplemented
+	case r.Method == "PUT" && uploadId:
+		err = s.uploadPart(ctx, w, r, sc, bucket, key)
+	case r.Method == "PUT":
+		err = s.putObject(ctx, w, r, sc, bucket, key)
+	case (r.Method == "GET" || r.Method == "HEAD") && !uploadId:
+		err = s.getObject(ctx, w, r, bucket, key)
+	case r.Method == "DELETE" && uploadId:
+		err = s.abortMultipartUpload(ctx, w, r, bucket, key)
+	case r.Method == "DELETE":
+		err = s.deleteObject(ctx, w, r, bucket, key)
+	case r.Method == "POST" && has(q, "uploads"):
+		err = s.createMultipartUpload(ctx, w, r, bucket, key)
+	case r.Method == "POST" && uploadId:
+		err = s.completeMultipartUpload(ctx, w, r, sc, bucket, key)
+	default:
+		err = errNotImplemented
+	}
+	if err != nil {
+		writeError(w, r, err)
+	}
+}
+
+func has(q url.Values, name string) bool {
+	_, ok := q[name]
+	return ok
+}
+
+func (s *Server) serveBucket(ctx context.Context, w http.ResponseWriter, r *http.Request, sc *signingContext, bucket string) error {
+	q := r.URL.Query()
+	switch r.Method {
+	case "PUT":
+		return s.createBucket(w, r, bucket)
+	case "HEAD":
+		_, err := s.bucketDir(bucket)
+		return err
+	case "DELETE":
+		return s.deleteBucket(ctx, w, bucket)
+	case "POST":
+		if has(q, "delete") {
+			return s.deleteObjects(ctx, w, r, sc, bucket)
+		}
+	case "GET":
+		dir, err := s.bucketDir(bucket)
+		if err != nil {
+			return err
+		}
+		switch {
+		case has(q, "location"):
+			writeXml(w, locationConstraint{Location: s.Region})
+			return nil
+		case has(q, "versioning"):
+			writeXml(w, versioningConfiguration{})
+			return nil
+		case has(q, "uploads"), has(q, "versions"):
+			return errNotImplemented
+		}
+		return s.listObjects(w, r, bucket, dir)
+	}
+	return errNotImplemented
+}
+
+type locationConstraint struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+	Location string   `xml:",chardata"`
+}
+
+type versioningConfiguration struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ VersioningConfiguration"`
+}
+
+//bucket names follow the S3 rules: 3 to 63 lower case letters, digits, dots and
+//dashes, starting and ending with a letter or a digit
+func validBucketName(name string) bool {
+	if len(name) < 3 || len(name) > 63 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		alnum := 'a' <= c && c <= 'z' || '0' <= c && c <= '9'
+		if !alnum && (c != '.' && c != '-' || i == 0 || i == len(name)-1) {
+			return false
+		}
+	}
+	return !strings.Contains(name, "..")
+}
+
+//the directory of an existing bucket
+func (s *Server) bucketDir(bucket string) (string, error) {
+	if !validBucketName(bucket) {
+		return "", errNoSuchBucket
+	}
+	dir := path.Join(s.BucketsDir, bucket)
+	if e, err := s.Filer.Get(dir); err != nil || !e.IsDirectory {
+		if err != nil && err != filer.ErrNotFound {
+			return "", err
+		}
+		return "", errNoSuchBucket
+	}
+	return dir, nil
+}
+
+//the path of an object, keys the filer would change when cleaning the path are refused
+func (s *Server) objectPath(bucketDir string, key string) (string, error) {
+	fullPath := bucketDir + "/" + strings.TrimSuffix(key, "/")
+	if len(key) > 1024 || filer.CleanPath(fullPath) != fullPath || fullPath == bucketDir {
+		return "", errInvalidKey
+	}
+	return fullPath, nil
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   owner
+	Buckets []bucketInfo `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string
+	DisplayName string
+}
+
+type bucketInfo struct {
+	Name         string
+	CreationDate string
+}
+
+func (s *Server) listBuckets(w http.ResponseWriter, r *http.Request) error {
+	entries, err := s.Filer.List(s.BucketsDir, "", 0)
+	if err != nil && err != filer.ErrNotFound {
+		return err
+	}
+	result := listAllMyBucketsResult{Owner: owner{ID: "weed", DisplayName: "weed"}, Buckets: []bucketInfo{}}
+	for _, e := range entries {
+		if e.IsDirectory && validBucketName(e.Name()) {
+			result.Buckets = append(result.Buckets, bucketInfo{Name: e.Name(), CreationDate: e.Mtime.UTC().Format(timeFormat)})
+		}
+	}
+	writeXml(w, result)
+	return nil
+}
+
+func (s *Server) createBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	if !validBucketName(bucket) {
+		return errInvalidBucketName
+	}
+	if _, err := s.bucketDir(bucket); err == nil {
+		return errBucketAlreadyOwned
+	}
+	if err := s.Filer.Mkdir(path.Join(s.BucketsDir, bucket)); err != nil {
+		return err
+	}
+	w.Header().Set("Location", "/"+bucket)
+	return nil
+}
+
+func (s *Server) deleteBucket(ctx context.Context, w http.ResponseWriter, bucket string) error {
+	dir, err := s.bucketDir(bucket)
+	if err != nil {
+		return err
+	}
+	if err = s.Filer.Delete(ctx, dir, false); err == filer.ErrNotEmpty {
+		return errBucketNotEmpty
+	} else if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,110 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingToken = errors.New("missing file token")
+	ErrBadToken     = errors.New("bad file token")
+	ErrExpiredToken = errors.New("file token expired")
+	ErrTokenFid     = errors.New("file token is for another fid")
+)
+
+//the fixed JWT header, only HMAC SHA256 tokens are issued and accepted
+var tokenHeader = encodeSegment([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type fileClaims struct {
+	Fid string `json:"fid"`
+	Exp int64  `json:"exp"`
+}
+
+func HasSecret() bool {
+	return len(getSecret()) > 0
+}
+
+func encodeSegment(b []byte) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(b), "=")
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	if l := len(s) % 4; l > 0 {
+		s += strings.Repeat("=", 4-l)
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
+
+func signSegments(key []byte, s string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return encodeSegment(mac.Sum(nil))
+}
+
+// GenerateFileToken issues a JWT allowing to write or delete the fid, and the fids
+// with a _N suffix assigned along with it, until the ttl expires.
+// Without a secret no token is needed, and none is generated.
+func GenerateFileToken(fid string, ttl time.Duration) string {
+	key := getSecret()
+	if len(key) == 0 {
+		return ""
+	}
+	claims, _ := json.Marshal(fileClaims{Fid: fid, Exp: time.Now().Add(ttl).Unix()})
+	s := tokenHeader + "." + encodeSegment(claims)
+	return s + "." + signSegments(key, s)
+}
+
+func VerifyFileToken(token string, fid string) error {
+	key := getSecret()
+	if len(key) == 0 {
+		return nil
+	}
+	if token == "" {
+		return ErrMissingToken
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return ErrBadToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(signSegments(key, parts[0]+"."+parts[1]))) {
+		return ErrBadToken
+	}
+	b, err := decodeSegment(parts[1])
+	if err != nil {
+		return ErrBadToken
+	}
+	var claims fileClaims
+	if err = json.Unmarshal(b, &claims); err != nil {
+		return ErrBadToken
+	}
+	if time.Now().Unix() > claims.Exp {
+		return ErrExpiredToken
+	}
+	if i := strings.LastIndex(fid, "_"); i > 0 {
+		fid = fid[:i]
+	}
+	if claims.Fid != fid {
+		return ErrTokenFid
+	}
+	return nil
+}
+
+//the token of a request, from the Authorization: Bearer header or the jwt query parameter
+func FileToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("jwt")
+}
+
+func SetFileToken(r *http.Request, token string) {
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
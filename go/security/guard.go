@@ -0,0 +1,217 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"code.google.com/p/weed-fs/go/wlog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuthorizationScheme = "WEED-HMAC"
+	//signed requests older or newer than this are refused, to limit replays
+	MaxClockSkew = 5 * time.Minute
+)
+
+var (
+	ErrMissingSignature  = errors.New("missing signature")
+	ErrBadSignature      = errors.New("bad signature")
+	ErrExpiredSignature  = errors.New("signature expired")
+	ErrReplayedSignature = errors.New("signature already used")
+)
+
+//the shared secret this process signs its admin requests with
+var (
+	secret     []byte
+	secretLock sync.RWMutex
+)
+
+func SetSecret(s string) {
+	secretLock.Lock()
+	secret = []byte(s)
+	secretLock.Unlock()
+}
+
+func getSecret() []byte {
+	secretLock.RLock()
+	defer secretLock.RUnlock()
+	return secret
+}
+
+//the signature covers the method, the path, the sorted form values, the time and a nonce,
+//so a captured token can not be used for another volume or another action, nor twice
+func signature(key []byte, method, path string, form url.Values, timestamp int64, nonce string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(method + "\n" + path + "\n" + form.Encode() + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newNonce() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Sign adds an Authorization header to an outgoing request, if a secret is configured.
+// form holds the values sent in the body; values in the url query are added to them.
+func Sign(r *http.Request, form url.Values) {
+	key := getSecret()
+	if len(key) == 0 {
+		return
+	}
+	all := make(url.Values)
+	for k, v := range form {
+		all[k] = append(all[k], v...)
+	}
+	for k, v := range r.URL.Query() {
+		all[k] = append(all[k], v...)
+	}
+	now, nonce := time.Now().Unix(), newNonce()
+	r.Header.Set("Authorization", AuthorizationScheme+" "+strconv.FormatInt(now, 10)+":"+nonce+":"+signature(key, r.Method, r.URL.Path, all, now, nonce))
+}
+
+// Guard lets a request through when it comes from a white listed address,
+// or carries a valid signature made with the shared secret. Without a secret
+// and a white list, all requests are let through. Each signature is accepted
+// once: the nonces seen within MaxClockSkew are kept to refuse replays.
+type Guard struct {
+	secret    []byte
+	whiteList []*net.IPNet
+
+	nonces    map[string]int64 //nonce to the time it was signed at
+	lastPrune time.Time
+	nonceLock sync.Mutex
+}
+
+//whiteList entries are ip addresses or CIDR blocks, e.g. 10.0.0.1 or 10.0.0.0/8
+func NewGuard(secret string, whiteList []string) (*Guard, error) {
+	g := &Guard{secret: []byte(secret), nonces: make(map[string]int64)}
+	for _, entry := range whiteList {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.New("invalid white list entry " + entry)
+		}
+		g.whiteList = append(g.whiteList, ipNet)
+	}
+	return g, nil
+}
+
+func (g *Guard) IsActive() bool {
+	return len(g.secret) > 0 || len(g.whiteList) > 0
+}
+
+func (g *Guard) isWhiteListed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range g.whiteList {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Guard) checkSignature(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, AuthorizationScheme+" ") {
+		return ErrMissingSignature
+	}
+	parts := strings.SplitN(strings.TrimPrefix(auth, AuthorizationScheme+" "), ":", 3)
+	if len(parts) != 3 || parts[1] == "" {
+		return ErrBadSignature
+	}
+	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if skew := time.Since(time.Unix(timestamp, 0)); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return ErrExpiredSignature
+	}
+	r.ParseForm()
+	expected := signature(g.secret, r.Method, r.URL.Path, r.Form, timestamp, parts[1])
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return ErrBadSignature
+	}
+	if !g.useNonce(parts[1], timestamp) {
+		return ErrReplayedSignature
+	}
+	return nil
+}
+
+//false if the nonce was used already. nonces are forgotten once their
+//signatures expire, so the cache holds at most the requests of 2*MaxClockSkew.
+func (g *Guard) useNonce(nonce string, timestamp int64) bool {
+	g.nonceLock.Lock()
+	defer g.nonceLock.Unlock()
+	now := time.Now()
+	if now.Sub(g.lastPrune) > time.Minute {
+		oldest := now.Add(-MaxClockSkew).Unix()
+		for n, t := range g.nonces {
+			if t < oldest {
+				delete(g.nonces, n)
+			}
+		}
+		g.lastPrune = now
+	}
+	if _, used := g.nonces[nonce]; used {
+		return false
+	}
+	g.nonces[nonce] = timestamp
+	return true
+}
+
+//checks the request, the error says why it is refused
+func (g *Guard) Check(r *http.Request) error {
+	if !g.IsActive() || g.isWhiteListed(r.RemoteAddr) {
+		return nil
+	}
+	if len(g.secret) == 0 {
+		return errors.New("not in the white list")
+	}
+	return g.checkSignature(r)
+}
+
+// Secure refuses requests failing the check with 401 Unauthorized.
+func (g *Guard) Secure(fn http.HandlerFunc) http.HandlerFunc {
+	if !g.IsActive() {
+		return fn
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := g.Check(r); err != nil {
+			wlog.Warn("unauthorized request", "remote", r.RemoteAddr, "path", r.URL.Path, "error", err)
+			bytes, _ := json.Marshal(map[string]string{"error": "unauthorized: " + err.Error()})
+			w.Header().Set("Content-Type", "application/javascript")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(bytes)
+			return
+		}
+		fn(w, r)
+	}
+}
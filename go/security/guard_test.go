@@ -0,0 +1,94 @@
+package security
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newAdminRequest(action string, values url.Values) *http.Request {
+	r, _ := http.NewRequest("POST", "http://localhost:8080"+action, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "192.168.1.5:34567"
+	return r
+}
+
+func TestGuardSignature(t *testing.T) {
+	g, _ := NewGuard("secret", nil)
+	values := url.Values{"volume": {"3"}}
+
+	if err := g.Check(newAdminRequest("/admin/delete_volume", values)); err != ErrMissingSignature {
+		t.Fatal("unsigned request:", err)
+	}
+
+	SetSecret("secret")
+	defer SetSecret("")
+	r := newAdminRequest("/admin/delete_volume", values)
+	Sign(r, values)
+	if err := g.Check(r); err != nil {
+		t.Fatal("signed request:", err)
+	}
+	if err := g.Check(r); err != ErrReplayedSignature {
+		t.Fatal("the same request again:", err)
+	}
+
+	//the same signature for another volume
+	auth := r.Header.Get("Authorization")
+	r = newAdminRequest("/admin/delete_volume", url.Values{"volume": {"4"}})
+	r.Header.Set("Authorization", auth)
+	if err := g.Check(r); err != ErrBadSignature {
+		t.Fatal("replayed signature:", err)
+	}
+
+	SetSecret("other")
+	r = newAdminRequest("/admin/delete_volume", values)
+	Sign(r, values)
+	if err := g.Check(r); err != ErrBadSignature {
+		t.Fatal("wrong secret:", err)
+	}
+}
+
+func TestGuardWhiteList(t *testing.T) {
+	if _, err := NewGuard("", []string{"10.0.0.0/33"}); err == nil {
+		t.Fatal("invalid white list entry accepted")
+	}
+	g, _ := NewGuard("", []string{"10.0.0.1", "192.168.1.0/24"})
+	if err := g.Check(newAdminRequest("/admin/assign_volume", nil)); err != nil {
+		t.Fatal("white listed address:", err)
+	}
+	r := newAdminRequest("/admin/assign_volume", nil)
+	r.RemoteAddr = "10.0.0.2:34567"
+	if err := g.Check(r); err == nil {
+		t.Fatal("address outside the white list")
+	}
+}
+
+func TestFileToken(t *testing.T) {
+	if err := VerifyFileToken("", "3,01637037d6"); err != nil {
+		t.Fatal("tokens are not needed without a secret:", err)
+	}
+	SetSecret("secret")
+	defer SetSecret("")
+	token := GenerateFileToken("3,01637037d6", time.Minute)
+	if err := VerifyFileToken(token, "3,01637037d6"); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFileToken(token, "3,01637037d6_2"); err != nil {
+		t.Fatal("fid assigned along:", err)
+	}
+	if err := VerifyFileToken(token, "3,01637037d7"); err != ErrTokenFid {
+		t.Fatal("another fid:", err)
+	}
+	if err := VerifyFileToken("", "3,01637037d6"); err != ErrMissingToken {
+		t.Fatal("missing token:", err)
+	}
+	if err := VerifyFileToken(GenerateFileToken("3,01637037d6", -time.Minute), "3,01637037d6"); err != ErrExpiredToken {
+		t.Fatal("expired token:", err)
+	}
+	SetSecret("other")
+	if err := VerifyFileToken(token, "3,01637037d6"); err != ErrBadToken {
+		t.Fatal("token signed with another secret:", err)
+	}
+}
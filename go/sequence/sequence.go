@@ -2,9 +2,9 @@ package sequence
 
 import (
 	"encoding/gob"
-	"log"
 	"os"
 	"path"
+	"code.google.com/p/weed-fs/go/wlog"
 	"sync"
 )
 
@@ -32,12 +32,12 @@ func NewSequencer(dirname string, filename string) (m *SequencerImpl) {
 	seqFile, se := os.OpenFile(path.Join(m.dir, m.fileName+".seq"), os.O_RDONLY, 0644)
 	if se != nil {
 		m.FileIdSequence = FileIdSaveInterval
-		log.Println("Setting file id sequence", m.FileIdSequence)
+		wlog.Info("setting file id sequence", "sequence", m.FileIdSequence)
 	} else {
 		decoder := gob.NewDecoder(seqFile)
 		defer seqFile.Close()
 		decoder.Decode(&m.FileIdSequence)
-		log.Println("Loading file id sequence", m.FileIdSequence, "=>", m.FileIdSequence+FileIdSaveInterval)
+		wlog.Info("loading file id sequence", "sequence", m.FileIdSequence, "next", m.FileIdSequence+FileIdSaveInterval)
 		//in case the server stops between intervals
 		m.FileIdSequence += FileIdSaveInterval
 	}
@@ -60,10 +60,10 @@ func (m *SequencerImpl) NextFileId(count int) (uint64, int) {
 	return m.FileIdSequence - m.fileIdCounter, count
 }
 func (m *SequencerImpl) saveSequence() {
-	log.Println("Saving file id sequence", m.FileIdSequence, "to", path.Join(m.dir, m.fileName+".seq"))
+	wlog.Debug("saving file id sequence", "sequence", m.FileIdSequence, "file", path.Join(m.dir, m.fileName+".seq"))
 	seqFile, e := os.OpenFile(path.Join(m.dir, m.fileName+".seq"), os.O_CREATE|os.O_WRONLY, 0644)
 	if e != nil {
-		log.Fatalf("Sequence File Save [ERROR] %s\n", e)
+		wlog.Fatal("failed to save sequence file", "error", e)
 	}
 	defer seqFile.Close()
 	encoder := gob.NewEncoder(seqFile)
@@ -115,7 +115,11 @@ func NewCompactMap() CompactMap {
 
 func (cm *CompactMap) Set(key Key, offset uint32, size uint32) uint32 {
 	x := cm.binarySearchCompactSection(key)
-	if x < 0 {
+	if x == -3 {
+		//key is smaller than all sections, e.g., when replaying index rows after a checkpoint
+		cm.list = append([]CompactSection{NewCompactSection(key)}, cm.list...)
+		x = 0
+	} else if x < 0 {
 		//println(x, "creating", len(cm.list), "section1, starting", key)
 		cm.list = append(cm.list, NewCompactSection(key))
 		x = len(cm.list) - 1
@@ -5,6 +5,7 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"io/ioutil"
+	"code.google.com/p/weed-fs/go/wlog"
 	"strings"
 )
 
@@ -36,11 +37,11 @@ func GzipData(input []byte) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	w, _ := gzip.NewWriterLevel(buf, flate.BestCompression)
 	if _, err := w.Write(input); err != nil {
-		println("error compressing data:", err)
+		wlog.Error("failed to compress data", "error", err)
 		return nil, err
 	}
 	if err := w.Close(); err != nil {
-		println("error closing compressed data:", err)
+		wlog.Error("failed to close compressed data", "error", err)
 		return nil, err
 	}
 	return buf.Bytes(), nil
@@ -51,7 +52,7 @@ func UnGzipData(input []byte) ([]byte, error) {
 	defer r.Close()
 	output, err := ioutil.ReadAll(r)
 	if err != nil {
-		println("error uncompressing data:", err)
+		wlog.Error("failed to uncompress data", "error", err)
 	}
 	return output, err
 }
@@ -1,11 +1,14 @@
 package storage
 
 import (
+	"errors"
 	"hash/crc32"
 )
 
 var table = crc32.MakeTable(crc32.Castagnoli)
 
+var ErrCrcMismatch = errors.New("CRC error! Data On Disk Corrupted!")
+
 type CRC uint32
 
 func NewCRC(b []byte) CRC {
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	DefaultTier = "hot"
+)
+
+type DiskLocation struct {
+	Directory      string
+	MaxVolumeCount int    //0 means only limited by the disk space
+	Tier           string //label of the storage class, e.g. hot, warm or cold
+
+	lowDiskSpace int32 //1 if free space is below the store's reserve as of the last check, accessed atomically
+}
+
+func (l *DiskLocation) isLowOnDiskSpace() bool {
+	return atomic.LoadInt32(&l.lowDiskSpace) == 1
+}
+
+//returns whether the flag changed
+func (l *DiskLocation) setLowDiskSpace(low bool) bool {
+	var value int32
+	if low {
+		value = 1
+	}
+	return atomic.SwapInt32(&l.lowDiskSpace, value) != value
+}
+
+//reported to the master on join
+type DiskInfo struct {
+	Directory      string
+	Tier           string
+	Device         uint64 //0 if unknown
+	MaxVolumeCount int
+	VolumeCount    int
+	FreeBytes      uint64
+	TotalBytes     uint64
+	ReservedBytes  uint64 //not to be used by volumes
+}
+
+//dirs, maxCounts and tiers are comma separated lists, a single max count or tier applies to all directories
+func NewDiskLocations(dirs string, maxCounts string, tiers string) ([]*DiskLocation, error) {
+	folders := strings.Split(dirs, ",")
+	counts := strings.Split(maxCounts, ",")
+	if len(counts) != 1 && len(counts) != len(folders) {
+		return nil, errors.New("expecting 1 or " + strconv.Itoa(len(folders)) + " max volume counts, but got " + maxCounts)
+	}
+	labels := strings.Split(tiers, ",")
+	if len(labels) != 1 && len(labels) != len(folders) {
+		return nil, errors.New("expecting 1 or " + strconv.Itoa(len(folders)) + " tiers, but got " + tiers)
+	}
+	var locations []*DiskLocation
+	for i, folder := range folders {
+		count, tier := counts[0], labels[0]
+		if len(counts) > 1 {
+			count = counts[i]
+		}
+		if len(labels) > 1 {
+			tier = labels[i]
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil {
+			return nil, errors.New("max volume count " + count + " is not a valid integer!")
+		}
+		if tier = strings.TrimSpace(tier); tier == "" {
+			tier = DefaultTier
+		}
+		locations = append(locations, &DiskLocation{Directory: strings.TrimSpace(folder), MaxVolumeCount: max, Tier: tier})
+	}
+	return locations, nil
+}
@@ -0,0 +1,21 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package storage
+
+import (
+	"syscall"
+)
+
+//the device id tells which physical disk the directory lives on
+func diskStatus(dir string) (device uint64, free uint64, total uint64, err error) {
+	var st syscall.Stat_t
+	if err = syscall.Stat(dir, &st); err != nil {
+		return
+	}
+	var fs syscall.Statfs_t
+	if err = syscall.Statfs(dir, &fs); err != nil {
+		return
+	}
+	return uint64(st.Dev), uint64(fs.Bavail) * uint64(fs.Bsize), uint64(fs.Blocks) * uint64(fs.Bsize), nil
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !freebsd && !linux
+// +build !darwin,!freebsd,!linux
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+//no mmap available, just read the whole file into memory
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return ioutil.ReadAll(f)
+}
+
+func munmapFile(b []byte) error {
+	return nil
+}
@@ -2,12 +2,12 @@ package storage
 
 import (
 	"encoding/hex"
-	"fmt"
 	"io/ioutil"
 	"mime"
 	"net/http"
 	"path"
 	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
 	"strconv"
 	"strings"
 )
@@ -40,13 +40,13 @@ func NewNeedle(r *http.Request) (n *Needle, fname string, e error) {
 	n = new(Needle)
 	form, fe := r.MultipartReader()
 	if fe != nil {
-		fmt.Println("MultipartReader [ERROR]", fe)
+		wlog.Debug("failed to read multipart form", "error", fe)
 		e = fe
 		return
 	}
 	part, fe := form.NextPart()
 	if fe != nil {
-		fmt.Println("Reading Multi part [ERROR]", fe)
+		wlog.Debug("failed to read multipart part", "error", fe)
 		e = fe
 		return
 	}
@@ -101,7 +101,7 @@ func (n *Needle) ParsePath(fid string) {
 	length := len(fid)
 	if length <= 8 {
 		if length > 0 {
-			println("Invalid fid", fid, "length", length)
+			wlog.Debug("invalid fid", "fid", fid, "length", length)
 		}
 		return
 	}
@@ -123,7 +123,7 @@ func ParseKeyHash(key_hash_string string) (uint64, uint32) {
 	key_hash_bytes, khe := hex.DecodeString(key_hash_string)
 	key_hash_len := len(key_hash_bytes)
 	if khe != nil || key_hash_len <= 4 {
-		println("Invalid key_hash", key_hash_string, "length:", key_hash_len, "error", khe)
+		wlog.Debug("invalid key hash", "keyHash", key_hash_string, "length", key_hash_len, "error", khe)
 		return 0, 0
 	}
 	key := util.BytesToUint64(key_hash_bytes[0 : key_hash_len-4])
@@ -1,11 +1,66 @@
 package storage
 
 import (
+	"errors"
 	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
 	"fmt"
+	"io"
 	"os"
 )
 
+type NeedleMapper interface {
+	Put(key uint64, offset uint32, size uint32) (int, error)
+	Get(key uint64) (element *NeedleValue, ok bool)
+	Delete(key uint64) error
+	Checkpoint() error
+	Close()
+	ContentSize() uint64
+	DeletedSize() uint64
+	FileCount() int
+	DeletedCount() int
+	Visit(visit func(NeedleValue) error) error
+}
+
+type NeedleMapType int
+
+const (
+	NeedleMapInMemory NeedleMapType = iota // whole index in a CompactMap
+	NeedleMapSorted                        // memory mapped sorted index, read only
+	NeedleMapHybrid                        // memory mapped sorted index, plus recent writes in memory
+)
+
+func NewNeedleMapType(t string) (NeedleMapType, error) {
+	switch t {
+	case "memory", "":
+		return NeedleMapInMemory, nil
+	case "sorted":
+		return NeedleMapSorted, nil
+	case "hybrid":
+		return NeedleMapHybrid, nil
+	}
+	return NeedleMapInMemory, errors.New("Unknown Needle Map Type:" + t)
+}
+func (t NeedleMapType) String() string {
+	switch t {
+	case NeedleMapSorted:
+		return "sorted"
+	case NeedleMapHybrid:
+		return "hybrid"
+	}
+	return "memory"
+}
+
+func LoadNeedleMapper(t NeedleMapType, indexFile *os.File) (NeedleMapper, error) {
+	switch t {
+	case NeedleMapSorted:
+		return LoadSortedNeedleMap(indexFile)
+	case NeedleMapHybrid:
+		return LoadHybridNeedleMap(indexFile)
+	}
+	return LoadNeedleMap(indexFile)
+}
+
 type NeedleMap struct {
 	indexFile *os.File
 	m         CompactMap
@@ -17,6 +72,8 @@ type NeedleMap struct {
 	fileCounter         int
 	deletionByteCounter uint64
 	fileByteCounter     uint64
+
+	checkpointed int64 //index file size covered by the last checkpoint
 }
 
 func NewNeedleMap(file *os.File) *NeedleMap {
@@ -32,35 +89,81 @@ const (
 	RowsToRead = 1024
 )
 
+//loads the last checkpoint if there is one, and replays the index file rows written after it
 func LoadNeedleMap(file *os.File) (*NeedleMap, error) {
 	nm := NewNeedleMap(file)
+	if e := nm.loadCheckpoint(); e != nil {
+		return nm, e
+	}
+	e := walkIndexFile(file, func(key uint64, offset, size uint32) {
+		nm.fileCounter++
+		nm.fileByteCounter = nm.fileByteCounter + uint64(size)
+		if offset > 0 {
+			oldSize := nm.m.Set(Key(key), offset, size)
+			//log.Println("reading key", key, "offset", offset, "size", size, "oldSize", oldSize)
+			if oldSize > 0 {
+				nm.deletionCounter++
+				nm.deletionByteCounter = nm.deletionByteCounter + uint64(oldSize)
+			}
+		} else {
+			oldSize := nm.m.Delete(Key(key))
+			//log.Println("removing key", key, "offset", offset, "size", size, "oldSize", oldSize)
+			nm.deletionCounter++
+			nm.deletionByteCounter = nm.deletionByteCounter + uint64(oldSize)
+		}
+	})
+	return nm, e
+}
+
+func (nm *NeedleMap) loadCheckpoint() error {
+	start, e := nm.indexFile.Seek(0, 1)
+	if e != nil || start != 0 {
+		return e
+	}
+	stat, e := nm.indexFile.Stat()
+	if e != nil {
+		return e
+	}
+	checkpointFileName := sortedIndexFileName(nm.indexFile.Name())
+	m := NewCompactMap()
+	covered, fileCounter, deletionCounter, fileByteCounter, deletionByteCounter, e := readSortedIndex(checkpointFileName, func(key uint64, offset, size uint32) {
+		m.Set(Key(key), offset, size)
+	})
+	if e != nil {
+		if !os.IsNotExist(e) {
+			wlog.Warn("ignoring checkpoint", "file", checkpointFileName, "error", e)
+		}
+		return nil
+	}
+	if covered > stat.Size() {
+		wlog.Warn("ignoring checkpoint covering more than the index file", "file", checkpointFileName, "covered", covered, "indexSize", stat.Size())
+		return nil
+	}
+	if _, e = nm.indexFile.Seek(covered, 0); e != nil {
+		return e
+	}
+	nm.m, nm.checkpointed = m, covered
+	nm.fileCounter, nm.deletionCounter, nm.fileByteCounter, nm.deletionByteCounter = fileCounter, deletionCounter, fileByteCounter, deletionByteCounter
+	return nil
+}
+
+//walk the index file from its current position to the end
+func walkIndexFile(file *os.File, fn func(key uint64, offset, size uint32)) error {
 	bytes := make([]byte, 16*RowsToRead)
-	count, e := nm.indexFile.Read(bytes)
+	count, e := file.Read(bytes)
 	for count > 0 && e == nil {
-		for i := 0; i < count; i += 16 {
+		for i := 0; i+16 <= count; i += 16 {
 			key := util.BytesToUint64(bytes[i : i+8])
 			offset := util.BytesToUint32(bytes[i+8 : i+12])
 			size := util.BytesToUint32(bytes[i+12 : i+16])
-			nm.fileCounter++
-			nm.fileByteCounter = nm.fileByteCounter + uint64(size)
-			if offset > 0 {
-				oldSize := nm.m.Set(Key(key), offset, size)
-				//log.Println("reading key", key, "offset", offset, "size", size, "oldSize", oldSize)
-				if oldSize > 0 {
-					nm.deletionCounter++
-					nm.deletionByteCounter = nm.deletionByteCounter + uint64(oldSize)
-				}
-			} else {
-				oldSize := nm.m.Delete(Key(key))
-				//log.Println("removing key", key, "offset", offset, "size", size, "oldSize", oldSize)
-				nm.deletionCounter++
-				nm.deletionByteCounter = nm.deletionByteCounter + uint64(oldSize)
-			}
+			fn(key, offset, size)
 		}
-
-		count, e = nm.indexFile.Read(bytes)
+		count, e = file.Read(bytes)
 	}
-	return nm, e
+	if e == io.EOF {
+		return nil
+	}
+	return e
 }
 
 func (nm *NeedleMap) Put(key uint64, offset uint32, size uint32) (int, error) {
@@ -96,12 +199,44 @@ func (nm *NeedleMap) Delete(key uint64) error {
 	nm.deletionCounter++
 	return nil
 }
+
+//writes the live entries and counters, sorted by key, together with the index file size they cover
+func (nm *NeedleMap) Checkpoint() error {
+	covered, err := nm.indexFile.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+	if covered == nm.checkpointed {
+		return nil
+	}
+	var values []NeedleValue
+	nm.m.Visit(func(nv NeedleValue) error {
+		if nv.Offset > 0 && nv.Size > 0 {
+			values = append(values, nv)
+		}
+		return nil
+	})
+	if err = writeSortedIndex(sortedIndexFileName(nm.indexFile.Name()), covered, nm.fileCounter, nm.deletionCounter, nm.fileByteCounter, nm.deletionByteCounter, values); err != nil {
+		return err
+	}
+	nm.checkpointed = covered
+	return nil
+}
 func (nm *NeedleMap) Close() {
 	nm.indexFile.Close()
 }
 func (nm *NeedleMap) ContentSize() uint64 {
 	return nm.fileByteCounter
 }
+func (nm *NeedleMap) DeletedSize() uint64 {
+	return nm.deletionByteCounter
+}
+func (nm *NeedleMap) FileCount() int {
+	return nm.fileCounter
+}
+func (nm *NeedleMap) DeletedCount() int {
+	return nm.deletionCounter
+}
 func (nm *NeedleMap) Visit(visit func(NeedleValue) error) (err error) {
 	return nm.m.Visit(visit)
 }
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"code.google.com/p/weed-fs/go/util"
+	"fmt"
+	"os"
+)
+
+// HybridNeedleMap keeps the entries covered by the sorted index on disk,
+// and only the entries written after it in memory.
+type HybridNeedleMap struct {
+	base *SortedNeedleMap
+	m    map[Key]NeedleValue //recent writes, in any key order
+
+	//transient
+	bytes []byte
+
+	deletionCounter     int
+	fileCounter         int
+	deletionByteCounter uint64
+	fileByteCounter     uint64
+}
+
+func LoadHybridNeedleMap(indexFile *os.File) (*HybridNeedleMap, error) {
+	base := &SortedNeedleMap{indexFile: indexFile, sortedFileName: sortedIndexFileName(indexFile.Name())}
+	if e := base.load(false); e != nil {
+		return nil, e
+	}
+	nm := &HybridNeedleMap{
+		base:                base,
+		m:                   make(map[Key]NeedleValue),
+		bytes:               make([]byte, 16),
+		deletionCounter:     base.deletionCounter,
+		fileCounter:         base.fileCounter,
+		deletionByteCounter: base.deletionByteCounter,
+		fileByteCounter:     base.fileByteCounter,
+	}
+	if _, e := indexFile.Seek(base.coveredIndexSize, 0); e != nil {
+		base.Close()
+		return nil, e
+	}
+	e := walkIndexFile(indexFile, func(key uint64, offset, size uint32) {
+		nm.fileCounter++
+		nm.fileByteCounter = nm.fileByteCounter + uint64(size)
+		oldSize := nm.set(key, offset, size)
+		if offset == 0 || oldSize > 0 {
+			nm.deletionCounter++
+			nm.deletionByteCounter = nm.deletionByteCounter + uint64(oldSize)
+		}
+	})
+	if e != nil {
+		base.Close()
+		return nil, e
+	}
+	return nm, nil
+}
+
+//deletions are kept in memory as entries with zero offset and size
+func (nm *HybridNeedleMap) set(key uint64, offset uint32, size uint32) uint32 {
+	oldSize := uint32(0)
+	if nv, ok := nm.Get(key); ok {
+		oldSize = nv.Size
+	}
+	nm.m[Key(key)] = NeedleValue{Key: Key(key), Offset: offset, Size: size}
+	return oldSize
+}
+
+func (nm *HybridNeedleMap) Put(key uint64, offset uint32, size uint32) (int, error) {
+	oldSize := nm.set(key, offset, size)
+	util.Uint64toBytes(nm.bytes[0:8], key)
+	util.Uint32toBytes(nm.bytes[8:12], offset)
+	util.Uint32toBytes(nm.bytes[12:16], size)
+	nm.fileCounter++
+	nm.fileByteCounter = nm.fileByteCounter + uint64(size)
+	if oldSize > 0 {
+		nm.deletionCounter++
+		nm.deletionByteCounter = nm.deletionByteCounter + uint64(oldSize)
+	}
+	return nm.base.indexFile.Write(nm.bytes)
+}
+func (nm *HybridNeedleMap) Get(key uint64) (element *NeedleValue, ok bool) {
+	if nv, found := nm.m[Key(key)]; found {
+		if nv.Offset == 0 && nv.Size == 0 {
+			return nil, false
+		}
+		return &nv, true
+	}
+	return nm.base.Get(key)
+}
+func (nm *HybridNeedleMap) Delete(key uint64) error {
+	indexFile := nm.base.indexFile
+	offset, err := indexFile.Seek(0, 1)
+	if err != nil {
+		return fmt.Errorf("cannot get position of indexfile: %s", err)
+	}
+	util.Uint64toBytes(nm.bytes[0:8], key)
+	util.Uint32toBytes(nm.bytes[8:12], 0)
+	util.Uint32toBytes(nm.bytes[12:16], 0)
+	if _, err = indexFile.Write(nm.bytes); err != nil {
+		indexFile.Truncate(offset)
+		return fmt.Errorf("error writing to indexfile %s: %s", indexFile.Name(), err)
+	}
+	nm.deletionByteCounter = nm.deletionByteCounter + uint64(nm.set(key, 0, 0))
+	nm.deletionCounter++
+	return nil
+}
+
+//merges the recent writes into a new sorted index, and drops them from memory
+func (nm *HybridNeedleMap) Checkpoint() error {
+	if len(nm.m) == 0 {
+		return nil
+	}
+	covered, err := nm.base.indexFile.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+	var values []NeedleValue
+	nm.Visit(func(nv NeedleValue) error {
+		if nv.Offset > 0 && nv.Size > 0 {
+			values = append(values, nv)
+		}
+		return nil
+	})
+	if err = writeSortedIndex(nm.base.sortedFileName, covered, nm.fileCounter, nm.deletionCounter, nm.fileByteCounter, nm.deletionByteCounter, values); err != nil {
+		return err
+	}
+	base := &SortedNeedleMap{indexFile: nm.base.indexFile, sortedFileName: nm.base.sortedFileName}
+	if err = base.mmap(); err != nil {
+		return err
+	}
+	nm.base.munmap()
+	nm.base, nm.m = base, make(map[Key]NeedleValue)
+	return nil
+}
+func (nm *HybridNeedleMap) Close() {
+	nm.base.Close()
+}
+func (nm *HybridNeedleMap) ContentSize() uint64 {
+	return nm.fileByteCounter
+}
+func (nm *HybridNeedleMap) DeletedSize() uint64 {
+	return nm.deletionByteCounter
+}
+func (nm *HybridNeedleMap) FileCount() int {
+	return nm.fileCounter
+}
+func (nm *HybridNeedleMap) DeletedCount() int {
+	return nm.deletionCounter
+}
+func (nm *HybridNeedleMap) Visit(visit func(NeedleValue) error) error {
+	for _, nv := range nm.m {
+		if nv.Offset == 0 && nv.Size == 0 {
+			continue
+		}
+		if err := visit(nv); err != nil {
+			return err
+		}
+	}
+	return nm.base.Visit(func(nv NeedleValue) error {
+		if _, found := nm.m[nv.Key]; found {
+			return nil
+		}
+		return visit(nv)
+	})
+}
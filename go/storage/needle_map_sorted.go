@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	//covered index size, fileCounter, deletionCounter, fileByteCounter, deletionByteCounter, entry count
+	SortedIndexHeaderSize = 48
+)
+
+var ErrReadOnlyNeedleMap = errors.New("needle map is read only")
+
+// SortedNeedleMap serves lookups from a memory mapped .sdx file, which holds the
+// live entries of the .idx file sorted by key. It is meant for read only volumes.
+type SortedNeedleMap struct {
+	indexFile      *os.File
+	sortedFileName string
+	data           []byte
+	entries        int
+
+	coveredIndexSize    int64
+	deletionCounter     int
+	fileCounter         int
+	deletionByteCounter uint64
+	fileByteCounter     uint64
+}
+
+func sortedIndexFileName(indexFileName string) string {
+	return strings.TrimSuffix(indexFileName, ".idx") + ".sdx"
+}
+
+func LoadSortedNeedleMap(indexFile *os.File) (*SortedNeedleMap, error) {
+	nm := &SortedNeedleMap{indexFile: indexFile, sortedFileName: sortedIndexFileName(indexFile.Name())}
+	if e := nm.load(true); e != nil {
+		return nil, e
+	}
+	return nm, nil
+}
+
+//with exact, the sorted index is regenerated unless it covers the whole .idx file,
+//otherwise only when it covers more than the .idx file has.
+func (nm *SortedNeedleMap) load(exact bool) error {
+	stat, e := nm.indexFile.Stat()
+	if e != nil {
+		return e
+	}
+	if e = nm.mmap(); e == nil {
+		if nm.coveredIndexSize == stat.Size() || !exact && nm.coveredIndexSize < stat.Size() {
+			return nil
+		}
+		nm.munmap()
+	}
+	if e = nm.generate(); e != nil {
+		return e
+	}
+	return nm.mmap()
+}
+
+func (nm *SortedNeedleMap) mmap() error {
+	f, e := os.Open(nm.sortedFileName)
+	if e != nil {
+		return e
+	}
+	defer f.Close()
+	stat, e := f.Stat()
+	if e != nil {
+		return e
+	}
+	if stat.Size() < SortedIndexHeaderSize || (stat.Size()-SortedIndexHeaderSize)%16 != 0 {
+		return errors.New("corrupted sorted index file " + nm.sortedFileName)
+	}
+	if nm.data, e = mmapFile(f, int(stat.Size())); e != nil {
+		return e
+	}
+	nm.coveredIndexSize = int64(util.BytesToUint64(nm.data[0:8]))
+	nm.fileCounter = int(util.BytesToUint64(nm.data[8:16]))
+	nm.deletionCounter = int(util.BytesToUint64(nm.data[16:24]))
+	nm.fileByteCounter = util.BytesToUint64(nm.data[24:32])
+	nm.deletionByteCounter = util.BytesToUint64(nm.data[32:40])
+	nm.entries = int(util.BytesToUint64(nm.data[40:48]))
+	if nm.entries != (len(nm.data)-SortedIndexHeaderSize)/16 {
+		nm.munmap()
+		return errors.New("corrupted sorted index file " + nm.sortedFileName)
+	}
+	return nil
+}
+
+func (nm *SortedNeedleMap) munmap() {
+	if nm.data != nil {
+		munmapFile(nm.data)
+		nm.data, nm.entries = nil, 0
+	}
+}
+
+//replay the .idx file and write out the live entries sorted by key
+func (nm *SortedNeedleMap) generate() error {
+	wlog.Info("generating sorted index", "file", nm.sortedFileName)
+	if _, e := nm.indexFile.Seek(0, 0); e != nil {
+		return e
+	}
+	m, e := LoadNeedleMap(nm.indexFile)
+	if e != nil {
+		return e
+	}
+	return m.Checkpoint()
+}
+
+func writeSortedIndex(fileName string, covered int64, fileCounter, deletionCounter int, fileByteCounter, deletionByteCounter uint64, values []NeedleValue) error {
+	sort.Sort(byNeedleKey(values))
+	tmpName := fileName + ".tmp"
+	f, e := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if e != nil {
+		return e
+	}
+	w := bufio.NewWriter(f)
+	header := make([]byte, SortedIndexHeaderSize)
+	util.Uint64toBytes(header[0:8], uint64(covered))
+	util.Uint64toBytes(header[8:16], uint64(fileCounter))
+	util.Uint64toBytes(header[16:24], uint64(deletionCounter))
+	util.Uint64toBytes(header[24:32], fileByteCounter)
+	util.Uint64toBytes(header[32:40], deletionByteCounter)
+	util.Uint64toBytes(header[40:48], uint64(len(values)))
+	w.Write(header)
+	row := make([]byte, 16)
+	for _, nv := range values {
+		util.Uint64toBytes(row[0:8], uint64(nv.Key))
+		util.Uint32toBytes(row[8:12], nv.Offset)
+		util.Uint32toBytes(row[12:16], nv.Size)
+		w.Write(row)
+	}
+	if e = w.Flush(); e != nil {
+		f.Close()
+		return e
+	}
+	if e = f.Sync(); e != nil {
+		f.Close()
+		return e
+	}
+	if e = f.Close(); e != nil {
+		return e
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+//reads the header and rows of a sorted index file without memory mapping it
+func readSortedIndex(fileName string, fn func(key uint64, offset, size uint32)) (covered int64, fileCounter, deletionCounter int, fileByteCounter, deletionByteCounter uint64, err error) {
+	f, e := os.Open(fileName)
+	if e != nil {
+		err = e
+		return
+	}
+	defer f.Close()
+	header := make([]byte, SortedIndexHeaderSize)
+	if _, err = io.ReadFull(f, header); err != nil {
+		return
+	}
+	covered = int64(util.BytesToUint64(header[0:8]))
+	fileCounter = int(util.BytesToUint64(header[8:16]))
+	deletionCounter = int(util.BytesToUint64(header[16:24]))
+	fileByteCounter = util.BytesToUint64(header[24:32])
+	deletionByteCounter = util.BytesToUint64(header[32:40])
+	entries := util.BytesToUint64(header[40:48])
+	r := bufio.NewReader(f)
+	row := make([]byte, 16)
+	for i := uint64(0); i < entries; i++ {
+		if _, err = io.ReadFull(r, row); err != nil {
+			err = errors.New("corrupted sorted index file " + fileName)
+			return
+		}
+		fn(util.BytesToUint64(row[0:8]), util.BytesToUint32(row[8:12]), util.BytesToUint32(row[12:16]))
+	}
+	return
+}
+
+func (nm *SortedNeedleMap) row(i int) (key uint64, offset uint32, size uint32) {
+	b := nm.data[SortedIndexHeaderSize+16*i : SortedIndexHeaderSize+16*i+16]
+	return util.BytesToUint64(b[0:8]), util.BytesToUint32(b[8:12]), util.BytesToUint32(b[12:16])
+}
+
+func (nm *SortedNeedleMap) Get(key uint64) (element *NeedleValue, ok bool) {
+	l, h := 0, nm.entries-1
+	for l <= h {
+		m := (l + h) / 2
+		k, offset, size := nm.row(m)
+		if k < key {
+			l = m + 1
+		} else if key < k {
+			h = m - 1
+		} else {
+			return &NeedleValue{Key: Key(k), Offset: offset, Size: size}, true
+		}
+	}
+	return nil, false
+}
+func (nm *SortedNeedleMap) Put(key uint64, offset uint32, size uint32) (int, error) {
+	return 0, ErrReadOnlyNeedleMap
+}
+func (nm *SortedNeedleMap) Delete(key uint64) error {
+	return ErrReadOnlyNeedleMap
+}
+func (nm *SortedNeedleMap) Checkpoint() error {
+	return nil
+}
+func (nm *SortedNeedleMap) Close() {
+	nm.munmap()
+	nm.indexFile.Close()
+}
+func (nm *SortedNeedleMap) ContentSize() uint64 {
+	return nm.fileByteCounter
+}
+func (nm *SortedNeedleMap) DeletedSize() uint64 {
+	return nm.deletionByteCounter
+}
+func (nm *SortedNeedleMap) FileCount() int {
+	return nm.fileCounter
+}
+func (nm *SortedNeedleMap) DeletedCount() int {
+	return nm.deletionCounter
+}
+func (nm *SortedNeedleMap) Visit(visit func(NeedleValue) error) error {
+	for i := 0; i < nm.entries; i++ {
+		k, offset, size := nm.row(i)
+		if err := visit(NeedleValue{Key: Key(k), Offset: offset, Size: size}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type byNeedleKey []NeedleValue
+
+func (s byNeedleKey) Len() int           { return len(s) }
+func (s byNeedleKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byNeedleKey) Less(i, j int) bool { return s[i].Key < s[j].Key }
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func writeTestIndex(t *testing.T, dir string) *os.File {
+	indexFile, err := os.OpenFile(path.Join(dir, "1.idx"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm := NewNeedleMap(indexFile)
+	for i := uint64(1); i <= 100; i++ {
+		nm.Put(i-1, uint32(101-i), uint32(1010-i*10))
+	}
+	nm.Delete(50)
+	nm.Put(60, 1000, 7)
+	return indexFile
+}
+
+func TestSortedNeedleMap(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir)
+	indexFile := writeTestIndex(t, dir)
+	nm, err := LoadSortedNeedleMap(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nm.Close()
+
+	if v, ok := nm.Get(99); !ok || v.Offset != 1 || v.Size != 10 {
+		t.Fatal("key 99", v, ok)
+	}
+	if v, ok := nm.Get(60); !ok || v.Offset != 1000 || v.Size != 7 {
+		t.Fatal("key 60", v, ok)
+	}
+	if _, ok := nm.Get(50); ok {
+		t.Fatal("key 50 should have been deleted")
+	}
+	if _, ok := nm.Get(100); ok {
+		t.Fatal("key 100 should not exist")
+	}
+	if nm.FileCount() != 102 || nm.DeletedCount() != 2 {
+		t.Fatal("counters", nm.FileCount(), nm.DeletedCount())
+	}
+	if _, err := nm.Put(200, 1, 1); err != ErrReadOnlyNeedleMap {
+		t.Fatal("sorted needle map should be read only")
+	}
+}
+
+func TestHybridNeedleMap(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir)
+	indexFile := writeTestIndex(t, dir)
+	nm, err := LoadHybridNeedleMap(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.Put(200, 2000, 20)
+	nm.Delete(99)
+	nm.Put(98, 3000, 30)
+	nm.Close()
+
+	indexFile, err = os.OpenFile(path.Join(dir, "1.idx"), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm, err = LoadHybridNeedleMap(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nm.Close()
+	if v, ok := nm.Get(200); !ok || v.Offset != 2000 {
+		t.Fatal("key 200", v, ok)
+	}
+	if _, ok := nm.Get(99); ok {
+		t.Fatal("key 99 should have been deleted")
+	}
+	if v, ok := nm.Get(98); !ok || v.Offset != 3000 {
+		t.Fatal("key 98", v, ok)
+	}
+	if v, ok := nm.Get(1); !ok || v.Offset != 99 {
+		t.Fatal("key 1", v, ok)
+	}
+	count := 0
+	nm.Visit(func(nv NeedleValue) error {
+		count++
+		return nil
+	})
+	if count != 99 {
+		t.Fatal("visited", count, "entries")
+	}
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestNeedleMapCheckpoint(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir)
+	indexFile := writeTestIndex(t, dir)
+	indexFile.Seek(0, 0)
+	nm, err := LoadNeedleMap(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = nm.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	nm.Close()
+
+	indexFile, _ = os.OpenFile(path.Join(dir, "1.idx"), os.O_RDWR, 0644)
+	if nm, err = LoadNeedleMap(indexFile); err != nil {
+		t.Fatal(err)
+	}
+	if nm.checkpointed == 0 {
+		t.Fatal("checkpoint is not loaded")
+	}
+	nm.Put(200, 2000, 20)
+	nm.Delete(1)
+	nm.Close()
+
+	indexFile, _ = os.OpenFile(path.Join(dir, "1.idx"), os.O_RDWR, 0644)
+	nm, err = LoadNeedleMap(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nm.Close()
+	if v, ok := nm.Get(200); !ok || v.Offset != 2000 {
+		t.Fatal("key 200", v, ok)
+	}
+	if v, ok := nm.Get(1); ok && v.Size > 0 {
+		t.Fatal("key 1 should have been deleted", v)
+	}
+	if v, ok := nm.Get(60); !ok || v.Offset != 1000 {
+		t.Fatal("key 60", v, ok)
+	}
+	if nm.FileCount() != 104 || nm.DeletedCount() != 3 {
+		t.Fatal("counters", nm.FileCount(), nm.DeletedCount())
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
 )
 
 const (
@@ -24,7 +25,7 @@ func (n *Needle) Append(w io.Writer, version Version) (size uint32, err error) {
 			defer func(s io.Seeker, off int64) {
 				if err != nil {
 					if _, e = s.Seek(off, 0); e != nil {
-						fmt.Printf("Failed to seek %s back to %d with error: %s\n", w, off, e)
+						wlog.Error("failed to seek back after a failed append", "offset", off, "error", e)
 					}
 				}
 			}(s, end)
@@ -120,7 +121,7 @@ func (n *Needle) Read(r io.Reader, size uint32, version Version) (ret int, err e
 		n.Data = bytes[NeedleHeaderSize : NeedleHeaderSize+size]
 		checksum := util.BytesToUint32(bytes[NeedleHeaderSize+size : NeedleHeaderSize+size+NeedleChecksumSize])
 		if checksum != NewCRC(n.Data).Value() {
-			return 0, errors.New("CRC error! Data On Disk Corrupted!")
+			return 0, ErrCrcMismatch
 		}
 		return
 	case Version2:
@@ -141,7 +142,7 @@ func (n *Needle) Read(r io.Reader, size uint32, version Version) (ret int, err e
 		n.readNeedleDataVersion2(bytes[NeedleHeaderSize : NeedleHeaderSize+int(n.Size)])
 		checksum := util.BytesToUint32(bytes[NeedleHeaderSize+n.Size : NeedleHeaderSize+n.Size+NeedleChecksumSize])
 		if checksum != NewCRC(n.Data).Value() {
-			return 0, errors.New("CRC error! Data On Disk Corrupted!")
+			return 0, ErrCrcMismatch
 		}
 		return
 	}
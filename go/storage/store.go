@@ -3,21 +3,28 @@ package storage
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"net/url"
 	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Store struct {
 	volumes        map[VolumeId]*Volume
-	dir            string
+	volumesLock    sync.RWMutex //guards the map only, each volume has its own lock
+	locations      []*DiskLocation
 	Port           int
 	Ip             string
 	PublicUrl      string
 	MaxVolumeCount int
+	MinFreeSpace   uint64 //bytes kept free on each disk, writes are refused below it
+
+	needleMapKind NeedleMapType
 
 	masterNode      string
 	connected       bool
@@ -25,15 +32,20 @@ type Store struct {
 
 }
 
-func NewStore(port int, ip, publicUrl, dirname string, maxVolumeCount int) (s *Store) {
-	s = &Store{Port: port, Ip: ip, PublicUrl: publicUrl, dir: dirname, MaxVolumeCount: maxVolumeCount}
+func NewStore(port int, ip, publicUrl string, locations []*DiskLocation, needleMapKind NeedleMapType) (s *Store) {
+	s = &Store{Port: port, Ip: ip, PublicUrl: publicUrl, locations: locations, needleMapKind: needleMapKind}
+	for _, location := range locations {
+		s.MaxVolumeCount += location.MaxVolumeCount
+	}
 	s.volumes = make(map[VolumeId]*Volume)
 	s.loadExistingVolumes()
 
-	log.Println("Store started on dir:", dirname, "with", len(s.volumes), "volumes")
+	wlog.Info("store started", "dirs", len(locations), "volumes", len(s.volumes))
 	return
 }
-func (s *Store) AddVolume(volumeListString string, replicationType string) error {
+
+//new volumes go to a directory of the tier if possible, otherwise to any directory
+func (s *Store) AddVolume(volumeListString string, replicationType string, tier string) error {
 	rt, e := NewReplicationTypeFromString(replicationType)
 	if e != nil {
 		return e
@@ -45,7 +57,7 @@ func (s *Store) AddVolume(volumeListString string, replicationType string) error
 			if err != nil {
 				return errors.New("Volume Id " + id_string + " is not a valid unsigned integer!")
 			}
-			e = s.addVolume(VolumeId(id), rt)
+			e = s.addVolume(VolumeId(id), rt, tier)
 		} else {
 			pair := strings.Split(range_string, "-")
 			start, start_err := strconv.ParseUint(pair[0], 10, 64)
@@ -57,7 +69,7 @@ func (s *Store) AddVolume(volumeListString string, replicationType string) error
 				return errors.New("Volume End Id" + pair[1] + " is not a valid unsigned integer!")
 			}
 			for id := start; id <= end; id++ {
-				if err := s.addVolume(VolumeId(id), rt); err != nil {
+				if err := s.addVolume(VolumeId(id), rt, tier); err != nil {
 					e = err
 				}
 			}
@@ -65,15 +77,101 @@ func (s *Store) AddVolume(volumeListString string, replicationType string) error
 	}
 	return e
 }
-func (s *Store) addVolume(vid VolumeId, replicationType ReplicationType) (err error) {
-	if s.volumes[vid] != nil {
+func (s *Store) addVolume(vid VolumeId, replicationType ReplicationType, tier string) (err error) {
+	if s.HasVolume(vid) {
 		return errors.New("Volume Id " + vid.String() + " already exists!")
 	}
-	log.Println("In dir", s.dir, "adds volume =", vid, ", replicationType =", replicationType)
-	s.volumes[vid], err = NewVolume(s.dir, vid, replicationType)
-	return err
+	location := s.pickLocation(tier, "")
+	if location == nil && tier != "" {
+		if location = s.pickLocation("", ""); location != nil {
+			wlog.Warn("no directory of the tier has space, adding volume to another tier", "volume", vid, "tier", tier, "dir", location.Directory, "dirTier", location.Tier)
+		}
+	}
+	if location == nil {
+		return errors.New("No more free space left for volume " + vid.String())
+	}
+	wlog.Info("adding volume", "dir", location.Directory, "volume", vid, "replication", replicationType)
+	v, err := NewVolume(location.Directory, vid, replicationType, s.needleMapKind)
+	if err != nil {
+		return err
+	}
+	if !s.putVolume(v) {
+		v.Close()
+		return errors.New("Volume Id " + vid.String() + " already exists!")
+	}
+	return nil
+}
+
+//picks the directory with free volume slots and the most free disk space,
+//only of the tier if it is not empty, and never the except directory
+func (s *Store) pickLocation(tier string, except string) (picked *DiskLocation) {
+	var pickedFree uint64
+	pickedCount := 0
+	for _, location := range s.locations {
+		if tier != "" && location.Tier != tier || location.Directory == except {
+			continue
+		}
+		count := s.volumeCount(location)
+		if location.MaxVolumeCount > 0 && count >= location.MaxVolumeCount {
+			continue
+		}
+		_, free, total, err := diskStatus(location.Directory)
+		if err != nil {
+			wlog.Warn("failed to check disk space", "dir", location.Directory, "error", err)
+			continue
+		}
+		if total > 0 && free < s.MinFreeSpace {
+			continue
+		}
+		if picked == nil || free > pickedFree || free == pickedFree && count < pickedCount {
+			picked, pickedFree, pickedCount = location, free, count
+		}
+	}
+	return
+}
+
+func (s *Store) volumeCount(location *DiskLocation) (count int) {
+	for _, v := range s.allVolumes() {
+		if v.dir == location.Directory {
+			count++
+		}
+	}
+	return
 }
 
+func (s *Store) SetVolumeReadOnly(volumeIdString string, readOnly bool) error {
+	vid, err := NewVolumeId(volumeIdString)
+	if err != nil {
+		return errors.New("Volume Id " + volumeIdString + " is not a valid unsigned integer!")
+	}
+	v := s.GetVolume(vid)
+	if v == nil {
+		return errors.New("Volume Id " + volumeIdString + " is not found!")
+	}
+	if err = v.SetReadOnly(readOnly); err != nil {
+		return err
+	}
+	wlog.Info("volume read only changed", "volume", vid, "readOnly", readOnly)
+	if s.masterNode != "" {
+		s.Join()
+	}
+	return nil
+}
+func (s *Store) DeleteVolume(volumeIdString string) error {
+	vid, err := NewVolumeId(volumeIdString)
+	if err != nil {
+		return errors.New("Volume Id " + volumeIdString + " is not a valid unsigned integer!")
+	}
+	v := s.removeVolume(vid)
+	if v == nil {
+		return errors.New("Volume Id " + volumeIdString + " is not found!")
+	}
+	wlog.Info("deleting volume", "dir", v.dir, "volume", vid)
+	if err = v.Destroy(); err != nil {
+		return fmt.Errorf("failed to remove files of volume %s: %s", vid.String(), err)
+	}
+	return nil
+}
 func (s *Store) CheckCompactVolume(volumeIdString string, garbageThresholdString string) (error, bool) {
 	vid, err := NewVolumeId(volumeIdString)
 	if err != nil {
@@ -83,46 +181,114 @@ func (s *Store) CheckCompactVolume(volumeIdString string, garbageThresholdString
 	if e != nil {
 		return errors.New("garbageThreshold " + garbageThresholdString + " is not a valid float number!"), false
 	}
-	return nil, garbageThreshold < s.volumes[vid].garbageLevel()
+	v := s.GetVolume(vid)
+	if v == nil {
+		return errors.New("Volume Id " + volumeIdString + " is not found!"), false
+	}
+	return nil, garbageThreshold < v.garbageLevel()
 }
 func (s *Store) CompactVolume(volumeIdString string) error {
 	vid, err := NewVolumeId(volumeIdString)
 	if err != nil {
 		return errors.New("Volume Id " + volumeIdString + " is not a valid unsigned integer!")
 	}
-	return s.volumes[vid].compact()
+	v := s.GetVolume(vid)
+	if v == nil {
+		return errors.New("Volume Id " + volumeIdString + " is not found!")
+	}
+	return v.compact()
 }
 func (s *Store) CommitCompactVolume(volumeIdString string) error {
 	vid, err := NewVolumeId(volumeIdString)
 	if err != nil {
 		return errors.New("Volume Id " + volumeIdString + " is not a valid unsigned integer!")
 	}
-	return s.volumes[vid].commitCompact()
+	v := s.GetVolume(vid)
+	if v == nil {
+		return errors.New("Volume Id " + volumeIdString + " is not found!")
+	}
+	return v.commitCompact()
 }
 func (s *Store) loadExistingVolumes() {
-	if dirs, err := ioutil.ReadDir(s.dir); err == nil {
+	for _, location := range s.locations {
+		dirs, err := ioutil.ReadDir(location.Directory)
+		if err != nil {
+			wlog.Error("failed to read dir", "dir", location.Directory, "error", err)
+			continue
+		}
 		for _, dir := range dirs {
 			name := dir.Name()
 			if !dir.IsDir() && strings.HasSuffix(name, ".dat") {
 				base := name[:len(name)-len(".dat")]
 				if vid, err := NewVolumeId(base); err == nil {
-					if s.volumes[vid] == nil {
-						if v, e := NewVolume(s.dir, vid, CopyNil); e == nil {
-							s.volumes[vid] = v
-							log.Println("In dir", s.dir, "read volume =", vid, "replicationType =", v.ReplicaType, "version =", v.Version(), "size =", v.Size())
-						}
+					if v := s.volumes[vid]; v != nil {
+						wlog.Warn("skipping volume already loaded from another dir", "dir", location.Directory, "volume", vid, "loadedFrom", v.dir)
+						continue
+					}
+					if v, e := NewVolume(location.Directory, vid, CopyNil, s.needleMapKind); e == nil {
+						s.volumes[vid] = v
+						wlog.Info("loaded volume", "dir", location.Directory, "volume", vid, "replication", v.ReplicaType, "version", v.Version(), "size", v.Size())
 					}
 				}
 			}
 		}
 	}
 }
+
+//measures the disks, and refreshes which ones are low on free space
+func (s *Store) DiskStatus() []*DiskInfo {
+	var disks []*DiskInfo
+	for _, location := range s.locations {
+		d := &DiskInfo{Directory: location.Directory, Tier: location.Tier, MaxVolumeCount: location.MaxVolumeCount, VolumeCount: s.volumeCount(location), ReservedBytes: s.MinFreeSpace}
+		var err error
+		if d.Device, d.FreeBytes, d.TotalBytes, err = diskStatus(location.Directory); err != nil {
+			wlog.Warn("failed to check disk space", "dir", location.Directory, "error", err)
+		}
+		lowDiskSpace := d.TotalBytes > 0 && d.FreeBytes < s.MinFreeSpace
+		if location.setLowDiskSpace(lowDiskSpace) {
+			wlog.Warn("disk space changed", "dir", location.Directory, "free", d.FreeBytes, "reserve", s.MinFreeSpace, "lowDiskSpace", lowDiskSpace)
+		}
+		disks = append(disks, d)
+	}
+	return disks
+}
+func (s *Store) findLocation(v *Volume) *DiskLocation {
+	for _, location := range s.locations {
+		if location.Directory == v.dir {
+			return location
+		}
+	}
+	return nil
+}
+func (s *Store) isLowOnDiskSpace(v *Volume) bool {
+	if location := s.findLocation(v); location != nil {
+		return location.isLowOnDiskSpace()
+	}
+	return false
+}
+func (s *Store) volumeTier(v *Volume) string {
+	if location := s.findLocation(v); location != nil {
+		return location.Tier
+	}
+	return ""
+}
+
+// VolumeTier is the tier of the directory holding the volume, empty if unknown.
+func (s *Store) VolumeTier(vid VolumeId) string {
+	if v := s.GetVolume(vid); v != nil {
+		return s.volumeTier(v)
+	}
+	return ""
+}
 func (s *Store) Status() []*VolumeInfo {
 	var stats []*VolumeInfo
-	for k, v := range s.volumes {
+	for _, v := range s.allVolumes() {
+		lowDiskSpace := s.isLowOnDiskSpace(v)
 		s := new(VolumeInfo)
 		s.Id, s.Size, s.RepType, s.Version, s.FileCount, s.DeleteCount, s.DeletedByteCount =
-			VolumeId(k), v.ContentSize(), v.ReplicaType, v.Version(), v.nm.fileCounter, v.nm.deletionCounter, v.nm.deletionByteCounter
+			v.Id, v.ContentSize(), v.ReplicaType, v.Version(), v.nm.FileCount(), v.nm.DeletedCount(), v.nm.DeletedSize()
+		s.ReadOnly, s.LowDiskSpace = v.IsReadOnly(), lowDiskSpace
+		s.Stats = v.Stats()
 		stats = append(stats, s)
 	}
 	return stats
@@ -136,12 +302,16 @@ func (s *Store) SetMaster(mserver string) {
 	s.masterNode = mserver
 }
 func (s *Store) Join() error {
+	disks, _ := json.Marshal(s.DiskStatus())
 	stats := new([]*VolumeInfo)
-	for k, v := range s.volumes {
-		s := new(VolumeInfo)
-		s.Id, s.Size, s.RepType, s.Version, s.FileCount, s.DeleteCount, s.DeletedByteCount =
-			VolumeId(k), uint64(v.Size()), v.ReplicaType, v.Version(), v.nm.fileCounter, v.nm.deletionCounter, v.nm.deletionByteCounter
-		*stats = append(*stats, s)
+	for _, v := range s.allVolumes() {
+		vi := new(VolumeInfo)
+		vi.Id, vi.Size, vi.RepType, vi.Version, vi.FileCount, vi.DeleteCount, vi.DeletedByteCount =
+			v.Id, uint64(v.Size()), v.ReplicaType, v.Version(), v.nm.FileCount(), v.nm.DeletedCount(), v.nm.DeletedSize()
+		vi.ModifiedAt = v.LastModified()
+		vi.ReadOnly, vi.LowDiskSpace = v.IsReadOnly(), s.isLowOnDiskSpace(v)
+		vi.Tier, vi.Stats = s.volumeTier(v), v.Stats()
+		*stats = append(*stats, vi)
 	}
 	bytes, _ := json.Marshal(stats)
 	values := make(url.Values)
@@ -153,7 +323,8 @@ func (s *Store) Join() error {
 	values.Add("publicUrl", s.PublicUrl)
 	values.Add("volumes", string(bytes))
 	values.Add("maxVolumeCount", strconv.Itoa(s.MaxVolumeCount))
-	jsonBlob, err := util.Post("http://"+s.masterNode+"/dir/join", values)
+	values.Add("disks", string(disks))
+	jsonBlob, err := util.AdminPost(util.NormalizeUrl(s.masterNode)+"/dir/join", values)
 	if err != nil {
 		return err
 	}
@@ -165,40 +336,98 @@ func (s *Store) Join() error {
 	s.connected = true
 	return nil
 }
+func (s *Store) Checkpoint() {
+	for _, v := range s.allVolumes() {
+		if err := v.Checkpoint(); err != nil {
+			wlog.Error("failed to checkpoint volume", "volume", v.Id, "error", err)
+		}
+	}
+}
 func (s *Store) Close() {
-	for _, v := range s.volumes {
+	for _, v := range s.allVolumes() {
 		v.Close()
 	}
 }
 func (s *Store) Write(i VolumeId, n *Needle) (size uint32, err error) {
-	if v := s.volumes[i]; v != nil {
+	if v := s.GetVolume(i); v != nil {
+		if v.IsReadOnly() {
+			err = fmt.Errorf("Volume %s is read only!", i.String())
+			return
+		}
+		if s.isLowOnDiskSpace(v) {
+			err = fmt.Errorf("Volume %s is on %s, which is low on disk space!", i.String(), v.dir)
+			return
+		}
+		start := time.Now()
 		size, err = v.write(n)
+		v.recordStats(writeStats, uint64(size), start, err)
 		if err != nil && s.volumeSizeLimit < v.ContentSize()+uint64(size) && s.volumeSizeLimit >= v.ContentSize() {
-			log.Println("volume", i, "size is", v.ContentSize(), "close to", s.volumeSizeLimit)
+			wlog.Info("volume is close to the size limit", "volume", i, "size", v.ContentSize(), "limit", s.volumeSizeLimit)
 			s.Join()
 		}
 		return
 	}
-	log.Println("volume", i, "not found!")
+	wlog.Warn("volume not found", "volume", i)
 	return
 }
 func (s *Store) Delete(i VolumeId, n *Needle) (uint32, error) {
-	if v := s.volumes[i]; v != nil {
-		return v.delete(n)
+	if v := s.GetVolume(i); v != nil {
+		if v.IsReadOnly() {
+			return 0, fmt.Errorf("Volume %s is read only!", i.String())
+		}
+		start := time.Now()
+		size, err := v.delete(n)
+		v.recordStats(deleteStats, uint64(size), start, err)
+		return size, err
 	}
 	return 0, nil
 }
 func (s *Store) Read(i VolumeId, n *Needle) (int, error) {
-	if v := s.volumes[i]; v != nil {
-		return v.read(n)
+	if v := s.GetVolume(i); v != nil {
+		start := time.Now()
+		count, err := v.read(n)
+		v.recordStats(readStats, uint64(len(n.Data)), start, err)
+		return count, err
 	}
 	return 0, errors.New("Not Found")
 }
 func (s *Store) GetVolume(i VolumeId) *Volume {
+	s.volumesLock.RLock()
+	defer s.volumesLock.RUnlock()
 	return s.volumes[i]
 }
 
 func (s *Store) HasVolume(i VolumeId) bool {
-	_, ok := s.volumes[i]
-	return ok
+	return s.GetVolume(i) != nil
+}
+
+//adds the volume, false if one with the same id exists
+func (s *Store) putVolume(v *Volume) bool {
+	s.volumesLock.Lock()
+	defer s.volumesLock.Unlock()
+	if s.volumes[v.Id] != nil {
+		return false
+	}
+	s.volumes[v.Id] = v
+	return true
+}
+
+//takes the volume out of the store, nil if it is unknown
+func (s *Store) removeVolume(i VolumeId) *Volume {
+	s.volumesLock.Lock()
+	defer s.volumesLock.Unlock()
+	v := s.volumes[i]
+	delete(s.volumes, i)
+	return v
+}
+
+//the volumes at the moment, to range over without holding the lock
+func (s *Store) allVolumes() []*Volume {
+	s.volumesLock.RLock()
+	defer s.volumesLock.RUnlock()
+	list := make([]*Volume, 0, len(s.volumes))
+	for _, v := range s.volumes {
+		list = append(list, v)
+	}
+	return list
 }
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestNewDiskLocations(t *testing.T) {
+	locations, err := NewDiskLocations("/data1, /data2", "3,4", "hot,cold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locations) != 2 || locations[1].Directory != "/data2" || locations[1].MaxVolumeCount != 4 || locations[1].Tier != "cold" {
+		t.Fatal("unexpected locations", locations[0], locations[1])
+	}
+	if locations, err = NewDiskLocations("/data1,/data2", "7", ""); err != nil || locations[0].MaxVolumeCount != 7 || locations[1].MaxVolumeCount != 7 || locations[1].Tier != DefaultTier {
+		t.Fatal("one max count and tier should apply to all directories", err)
+	}
+	if _, err = NewDiskLocations("/data1,/data2,/data3", "1,2", "hot"); err == nil {
+		t.Fatal("mismatched max counts should fail")
+	}
+}
+
+func TestStoreMultipleDirectories(t *testing.T) {
+	dir1, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir1)
+	dir2, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir2)
+	locations := []*DiskLocation{{Directory: dir1, MaxVolumeCount: 1, Tier: "hot"}, {Directory: dir2, MaxVolumeCount: 1, Tier: "cold"}}
+
+	s := NewStore(8080, "localhost", "localhost:8080", locations, NeedleMapInMemory)
+	if s.MaxVolumeCount != 2 {
+		t.Fatal("max volume count", s.MaxVolumeCount)
+	}
+	if err := s.AddVolume("1,2", "000", "hot"); err != nil {
+		t.Fatal(err)
+	}
+	if s.volumes[1].dir == s.volumes[2].dir {
+		t.Fatal("both volumes are in", s.volumes[1].dir)
+	}
+	if s.volumes[1].dir != dir1 {
+		t.Fatal("volume 1 should be on the hot tier")
+	}
+	if err := s.AddVolume("3", "000", ""); err == nil {
+		t.Fatal("all directories should be full")
+	}
+	s.Close()
+
+	s = NewStore(8080, "localhost", "localhost:8080", locations, NeedleMapInMemory)
+	defer s.Close()
+	if !s.HasVolume(1) || !s.HasVolume(2) {
+		t.Fatal("volumes should be loaded from both directories")
+	}
+	for _, d := range s.DiskStatus() {
+		if d.VolumeCount != 1 {
+			t.Fatal("disk", d.Directory, "has", d.VolumeCount, "volumes")
+		}
+	}
+}
+
+func TestStoreMoveVolume(t *testing.T) {
+	dir1, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir1)
+	dir2, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir2)
+	locations := []*DiskLocation{{Directory: dir1, MaxVolumeCount: 1, Tier: "hot"}, {Directory: dir2, MaxVolumeCount: 1, Tier: "cold"}}
+	s := NewStore(8080, "localhost", "localhost:8080", locations, NeedleMapInMemory)
+	defer s.Close()
+	if err := s.AddVolume("1", "000", "hot"); err != nil {
+		t.Fatal(err)
+	}
+	n := &Needle{Id: 7, Cookie: 1, Data: []byte("hello")}
+	n.Checksum = NewCRC(n.Data)
+	if _, err := s.Write(1, n); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MoveVolume("1", "cold"); err != nil {
+		t.Fatal(err)
+	}
+	if s.volumes[1].dir != dir2 || s.VolumeTier(1) != "cold" {
+		t.Fatal("volume 1 should have moved to", dir2)
+	}
+	if _, err := os.Stat(path.Join(dir1, "1.dat")); !os.IsNotExist(err) {
+		t.Fatal("old volume file should be removed", err)
+	}
+	read := &Needle{Id: 7, Cookie: 1}
+	if _, err := s.Read(1, read); err != nil || string(read.Data) != "hello" {
+		t.Fatal("read after move", err, string(read.Data))
+	}
+	//the hot directory is free again, but the cold one is full
+	if err := s.AddVolume("2", "000", "cold"); err != nil {
+		t.Fatal(err)
+	}
+	if tier := s.VolumeTier(2); tier != "hot" {
+		t.Fatal("volume 2 should fall back to the hot tier, got", tier)
+	}
+}
+
+func TestStoreSortedIndex(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir)
+	locations := []*DiskLocation{{Directory: dir, MaxVolumeCount: 1, Tier: "hot"}}
+	s := NewStore(8080, "localhost", "localhost:8080", locations, NeedleMapSorted)
+	if err := s.AddVolume("1", "000", "hot"); err != nil {
+		t.Fatal(err)
+	}
+	n := &Needle{Id: 7, Cookie: 1, Data: []byte("hello")}
+	n.Checksum = NewCRC(n.Data)
+	if _, err := s.Write(1, n); err != nil {
+		t.Fatal("writing to a new volume:", err)
+	}
+	if status := s.Status(); status[0].ReadOnly {
+		t.Fatal("a new volume should be writable")
+	}
+	if err := s.volumes[1].SetReadOnly(true); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = NewStore(8080, "localhost", "localhost:8080", locations, NeedleMapSorted)
+	defer s.Close()
+	if _, sorted := s.volumes[1].nm.(*SortedNeedleMap); !sorted || !s.Status()[0].ReadOnly {
+		t.Fatal("a read only volume should load the sorted needle map")
+	}
+	if err := s.volumes[1].SetReadOnly(false); err != nil {
+		t.Fatal(err)
+	}
+	n = &Needle{Id: 8, Cookie: 1, Data: []byte("world")}
+	n.Checksum = NewCRC(n.Data)
+	if _, err := s.Write(1, n); err != nil {
+		t.Fatal("writing to a volume made writable again:", err)
+	}
+	read := &Needle{Id: 7, Cookie: 1}
+	if _, err := s.Read(1, read); err != nil || string(read.Data) != "hello" {
+		t.Fatal("reading after reloading the needle map:", err)
+	}
+}
+
+func TestStoreSortedIndexFullVolume(t *testing.T) {
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"VolumeSizeLimit":1}`))
+	}))
+	defer master.Close()
+	dir, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir)
+	locations := []*DiskLocation{{Directory: dir, MaxVolumeCount: 1, Tier: "hot"}}
+	s := NewStore(8080, "localhost", "localhost:8080", locations, NeedleMapSorted)
+	defer s.Close()
+	s.SetMaster(master.URL)
+	if err := s.AddVolume("1", "000", "hot"); err != nil {
+		t.Fatal(err)
+	}
+	n := &Needle{Id: 7, Cookie: 1, Data: []byte("hello")}
+	n.Checksum = NewCRC(n.Data)
+	if _, err := s.Write(1, n); err != nil {
+		t.Fatal(err)
+	}
+	//the volume is over the size limit of the master now
+	if err := s.Join(); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := s.Delete(1, &Needle{Id: 7, Cookie: 1}); err != nil || size == 0 {
+		t.Fatal("deleting from a full volume:", size, err)
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir)
+	locations := []*DiskLocation{{Directory: dir, MaxVolumeCount: 1, Tier: "hot"}}
+	s := NewStore(8080, "localhost", "localhost:8080", locations, NeedleMapInMemory)
+	defer s.Close()
+	if err := s.AddVolume("1", "000", "hot"); err != nil {
+		t.Fatal(err)
+	}
+	n := &Needle{Id: 7, Cookie: 1, Data: []byte("hello")}
+	n.Checksum = NewCRC(n.Data)
+	if _, err := s.Write(1, n); err != nil {
+		t.Fatal(err)
+	}
+	nv, _ := s.volumes[1].nm.Get(7)
+	stored := nv.Size
+	size, err := s.Delete(1, &Needle{Id: 7, Cookie: 1})
+	if err != nil || size == 0 || size != stored {
+		t.Fatal("deleted size", size, "of a needle stored with", stored, err)
+	}
+	if size, err = s.Delete(1, &Needle{Id: 7, Cookie: 1}); err != nil || size != 0 {
+		t.Fatal("deleted size of a deleted needle", size, err)
+	}
+}
+
+func TestStoreCompactVolume(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir)
+	locations := []*DiskLocation{{Directory: dir, MaxVolumeCount: 2, Tier: "hot"}}
+	s := NewStore(8080, "localhost", "localhost:8080", locations, NeedleMapInMemory)
+	defer s.Close()
+	if err := s.AddVolume("1", "000", "hot"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint64{7, 8} {
+		n := &Needle{Id: id, Cookie: 1, Data: []byte("hello")}
+		n.Checksum = NewCRC(n.Data)
+		if _, err := s.Write(1, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//volumes come and go while the volume is compacted
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 10; i++ {
+			s.AddVolume("2", "000", "hot")
+			s.DeleteVolume("2")
+		}
+		done <- true
+	}()
+	if err := s.CompactVolume("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CommitCompactVolume("1"); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	for _, id := range []uint64{7, 8} {
+		read := &Needle{Id: id, Cookie: 1}
+		if _, err := s.Read(1, read); err != nil || string(read.Data) != "hello" {
+			t.Fatal("read after compaction", id, err)
+		}
+	}
+	if err := s.CompactVolume("3"); err == nil {
+		t.Fatal("compacting an unknown volume should fail")
+	}
+}
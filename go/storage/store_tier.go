@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
+)
+
+//moves a volume to another directory of the tier on this server
+func (s *Store) MoveVolume(volumeIdString string, tier string) error {
+	vid, err := NewVolumeId(volumeIdString)
+	if err != nil {
+		return errors.New("Volume Id " + volumeIdString + " is not a valid unsigned integer!")
+	}
+	v := s.GetVolume(vid)
+	if v == nil {
+		return errors.New("Volume Id " + volumeIdString + " is not found!")
+	}
+	location := s.pickLocation(tier, v.dir)
+	if location == nil {
+		return errors.New("No directory of tier " + tier + " has space for volume " + volumeIdString)
+	}
+	wlog.Info("moving volume", "volume", vid, "from", v.dir, "to", location.Directory)
+	//out of service during the copy, reads and writes fail as for an unknown volume
+	if removed := s.removeVolume(vid); removed != v {
+		if removed != nil {
+			s.putVolume(removed)
+		}
+		return errors.New("Volume Id " + volumeIdString + " changed during the move!")
+	}
+	v.Close()
+	src, dst := v.FileName(), path.Join(location.Directory, vid.String())
+	for _, ext := range []string{".dat", ".idx"} {
+		if err = copyFile(src+ext, dst+ext); err != nil {
+			break
+		}
+	}
+	var moved *Volume
+	if err == nil {
+		moved, err = NewVolume(location.Directory, vid, CopyNil, s.needleMapKind)
+	}
+	if err != nil {
+		removeVolumeFiles(dst)
+		if reopened, e := NewVolume(v.dir, vid, CopyNil, s.needleMapKind); e == nil {
+			s.putVolume(reopened)
+		} else {
+			wlog.Error("failed to reopen volume after a failed move", "volume", vid, "dir", v.dir, "error", e)
+		}
+		return err
+	}
+	s.putVolume(moved)
+	removeVolumeFiles(src)
+	return nil
+}
+
+//copies a volume from another volume server into a directory of the tier
+func (s *Store) CopyVolume(volumeIdString string, source string, tier string) error {
+	vid, err := NewVolumeId(volumeIdString)
+	if err != nil {
+		return errors.New("Volume Id " + volumeIdString + " is not a valid unsigned integer!")
+	}
+	if s.HasVolume(vid) {
+		return errors.New("Volume Id " + volumeIdString + " already exists!")
+	}
+	location := s.pickLocation(tier, "")
+	if location == nil {
+		return errors.New("No directory of tier " + tier + " has space for volume " + volumeIdString)
+	}
+	wlog.Info("copying volume", "volume", vid, "source", source, "to", location.Directory)
+	dst := path.Join(location.Directory, vid.String())
+	for _, ext := range []string{".dat", ".idx"} {
+		values := make(url.Values)
+		values.Add("volume", vid.String())
+		values.Add("ext", ext)
+		if err = util.GetToFile(util.NormalizeUrl(source)+"/admin/volume_file?"+values.Encode(), dst+ext); err != nil {
+			removeVolumeFiles(dst)
+			return err
+		}
+	}
+	v, err := NewVolume(location.Directory, vid, CopyNil, s.needleMapKind)
+	if err != nil {
+		removeVolumeFiles(dst)
+		return err
+	}
+	if !s.putVolume(v) {
+		v.Close()
+		return errors.New("Volume Id " + volumeIdString + " already exists!")
+	}
+	return nil
+}
+
+func removeVolumeFiles(fileName string) {
+	for _, ext := range []string{".dat", ".idx", ".sdx", ".cpd", ".cpx"} {
+		os.Remove(fileName + ext)
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	return err
+}
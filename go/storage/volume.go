@@ -6,22 +6,30 @@ import (
 	"io"
 	"os"
 	"path"
+	"code.google.com/p/weed-fs/go/wlog"
 	"sync"
+	"time"
 )
 
 const (
 	SuperBlockSize = 8
+
+	SuperBlockFlagReadOnly = 0x01
 )
 
 type SuperBlock struct {
 	Version     Version
 	ReplicaType ReplicationType
+	ReadOnly    bool
 }
 
 func (s *SuperBlock) Bytes() []byte {
 	header := make([]byte, SuperBlockSize)
 	header[0] = byte(s.Version)
 	header[1] = s.ReplicaType.Byte()
+	if s.ReadOnly {
+		header[2] = header[2] | SuperBlockFlagReadOnly
+	}
 	return header
 }
 
@@ -29,15 +37,21 @@ type Volume struct {
 	Id       VolumeId
 	dir      string
 	dataFile *os.File
-	nm       *NeedleMap
+	nm       NeedleMapper
+
+	needleMapKind NeedleMapType
 
 	SuperBlock
 
-	accessLock sync.Mutex
+	accessLock   sync.Mutex
+	lastModified int64 //unix time in seconds
+
+	stats     VolumeStats
+	statsLock sync.Mutex
 }
 
-func NewVolume(dirname string, id VolumeId, replicationType ReplicationType) (v *Volume, e error) {
-	v = &Volume{dir: dirname, Id: id}
+func NewVolume(dirname string, id VolumeId, replicationType ReplicationType, needleMapKind NeedleMapType) (v *Volume, e error) {
+	v = &Volume{dir: dirname, Id: id, needleMapKind: needleMapKind}
 	v.SuperBlock = SuperBlock{ReplicaType: replicationType}
 	e = v.load(true)
 	return
@@ -55,6 +69,9 @@ func (v *Volume) load(alsoLoadIndex bool) error {
 	if e != nil {
 		return fmt.Errorf("cannot create Volume Data %s.dat: %s", fileName, e)
 	}
+	if stat, se := v.dataFile.Stat(); se == nil {
+		v.lastModified = stat.ModTime().Unix()
+	}
 	if v.ReplicaType == CopyNil {
 		e = v.readSuperBlock()
 	} else {
@@ -65,10 +82,35 @@ func (v *Volume) load(alsoLoadIndex bool) error {
 		if ie != nil {
 			return fmt.Errorf("cannot create Volume Data %s.dat: %s", fileName, e)
 		}
-		v.nm, e = LoadNeedleMap(indexFile)
+		v.nm, e = LoadNeedleMapper(v.loadedNeedleMapKind(), indexFile)
 	}
 	return e
 }
+
+//the sorted needle map takes no writes, so writable volumes load the index in memory
+func (v *Volume) loadedNeedleMapKind() NeedleMapType {
+	if v.needleMapKind == NeedleMapSorted && !v.SuperBlock.ReadOnly {
+		return NeedleMapInMemory
+	}
+	return v.needleMapKind
+}
+
+//replaces the needle map with one of the kind, loaded from the index file again
+func (v *Volume) reloadNeedleMap(kind NeedleMapType) error {
+	indexFile, e := os.OpenFile(path.Join(v.dir, v.Id.String())+".idx", os.O_RDWR|os.O_CREATE, 0644)
+	if e != nil {
+		return e
+	}
+	nm, e := LoadNeedleMapper(kind, indexFile)
+	if e != nil {
+		indexFile.Close()
+		return e
+	}
+	v.nm.Close()
+	v.nm = nm
+	return nil
+}
+
 func (v *Volume) Version() Version {
 	return v.SuperBlock.Version
 }
@@ -79,7 +121,7 @@ func (v *Volume) Size() int64 {
 	if e == nil {
 		return stat.Size()
 	}
-	fmt.Printf("Failed to read file size %s %s\n", v.dataFile.Name(), e.Error())
+	wlog.Error("failed to read file size", "file", v.dataFile.Name(), "error", e)
 	return -1
 }
 func (v *Volume) Close() {
@@ -88,10 +130,22 @@ func (v *Volume) Close() {
 	v.nm.Close()
 	v.dataFile.Close()
 }
+
+//closes the volume and removes all its files, including unfinished compactions
+func (v *Volume) Destroy() (err error) {
+	v.Close()
+	fileName := path.Join(v.dir, v.Id.String())
+	for _, ext := range []string{".dat", ".idx", ".sdx", ".cpd", ".cpx"} {
+		if e := os.Remove(fileName + ext); e != nil && !os.IsNotExist(e) {
+			err = e
+		}
+	}
+	return
+}
 func (v *Volume) maybeWriteSuperBlock() error {
 	stat, e := v.dataFile.Stat()
 	if e != nil {
-		fmt.Printf("failed to stat datafile %s: %s", v.dataFile, e)
+		wlog.Error("failed to stat data file", "file", v.dataFile.Name(), "error", e)
 		return e
 	}
 	if stat.Size() == 0 {
@@ -111,11 +165,48 @@ func (v *Volume) readSuperBlock() (err error) {
 }
 func ParseSuperBlock(header []byte) (superBlock SuperBlock, err error) {
 	superBlock.Version = Version(header[0])
+	superBlock.ReadOnly = header[2]&SuperBlockFlagReadOnly > 0
 	if superBlock.ReplicaType, err = NewReplicationTypeFromByte(header[1]); err != nil {
 		err = fmt.Errorf("cannot read replica type: %s", err)
 	}
 	return
 }
+func (v *Volume) IsReadOnly() bool {
+	v.accessLock.Lock()
+	defer v.accessLock.Unlock()
+	return v.SuperBlock.ReadOnly
+}
+
+//persists the read only flag in the super block
+func (v *Volume) SetReadOnly(readOnly bool) error {
+	v.accessLock.Lock()
+	defer v.accessLock.Unlock()
+	if v.SuperBlock.ReadOnly == readOnly {
+		return nil
+	}
+	if _, sorted := v.nm.(*SortedNeedleMap); sorted && !readOnly {
+		if e := v.reloadNeedleMap(NeedleMapInMemory); e != nil {
+			return fmt.Errorf("cannot load needle map of volume %d for writes: %s", v.Id, e)
+		}
+	}
+	v.SuperBlock.ReadOnly = readOnly
+	if _, e := v.dataFile.WriteAt(v.SuperBlock.Bytes(), 0); e != nil {
+		v.SuperBlock.ReadOnly = !readOnly
+		return fmt.Errorf("cannot write superblock of volume %d: %s", v.Id, e)
+	}
+	if readOnly && v.needleMapKind == NeedleMapSorted {
+		if e := v.reloadNeedleMap(NeedleMapSorted); e != nil {
+			wlog.Warn("failed to load sorted needle map of read only volume", "volume", v.Id, "error", e)
+		}
+	}
+	return nil
+}
+
+func (v *Volume) Checkpoint() error {
+	v.accessLock.Lock()
+	defer v.accessLock.Unlock()
+	return v.nm.Checkpoint()
+}
 func (v *Volume) NeedToReplicate() bool {
 	return v.ReplicaType.GetCopyCount() > 1
 }
@@ -131,9 +222,12 @@ func (v *Volume) write(n *Needle) (size uint32, err error) {
 		v.dataFile.Truncate(offset)
 		return
 	}
+	v.lastModified = time.Now().Unix()
 	nv, ok := v.nm.Get(n.Id)
 	if !ok || int64(nv.Offset)*NeedlePaddingSize < offset {
-		_, err = v.nm.Put(n.Id, uint32(offset/NeedlePaddingSize), n.Size)
+		if _, err = v.nm.Put(n.Id, uint32(offset/NeedlePaddingSize), n.Size); err != nil {
+			v.dataFile.Truncate(offset)
+		}
 	}
 	return
 }
@@ -143,6 +237,7 @@ func (v *Volume) delete(n *Needle) (uint32, error) {
 	nv, ok := v.nm.Get(n.Id)
 	//fmt.Println("key", n.Id, "volume offset", nv.Offset, "data_size", n.Size, "cached size", nv.Size)
 	if ok {
+		size := nv.Size //nv points into the map, which the delete zeroes
 		if err := v.nm.Delete(n.Id); err != nil {
 			return 0, err
 		}
@@ -154,7 +249,8 @@ func (v *Volume) delete(n *Needle) (uint32, error) {
 			v.dataFile.Truncate(offset)
 			return 0, err
 		}
-		return nv.Size, err
+		v.lastModified = time.Now().Unix()
+		return size, err
 	}
 	return 0, nil
 }
@@ -171,7 +267,7 @@ func (v *Volume) read(n *Needle) (int, error) {
 }
 
 func (v *Volume) garbageLevel() float64 {
-	return float64(v.nm.deletionByteCounter) / float64(v.ContentSize())
+	return float64(v.nm.DeletedSize()) / float64(v.ContentSize())
 }
 
 func (v *Volume) compact() error {
@@ -185,7 +281,12 @@ func (v *Volume) commitCompact() error {
 	v.accessLock.Lock()
 	defer v.accessLock.Unlock()
 	v.dataFile.Close()
+	v.nm.Close()
 	var e error
+	//the sorted index and checkpoint only match the old index file
+	if e = os.Remove(path.Join(v.dir, v.Id.String()+".sdx")); e != nil && !os.IsNotExist(e) {
+		return e
+	}
 	if e = os.Rename(path.Join(v.dir, v.Id.String()+".cpd"), path.Join(v.dir, v.Id.String()+".dat")); e != nil {
 		return e
 	}
@@ -277,6 +378,14 @@ func (v *Volume) copyDataAndGenerateIndexFile(dstName, idxName string) (err erro
 
 	return
 }
+func (v *Volume) FileName() string {
+	return path.Join(v.dir, v.Id.String())
+}
+func (v *Volume) LastModified() int64 {
+	v.accessLock.Lock()
+	defer v.accessLock.Unlock()
+	return v.lastModified
+}
 func (v *Volume) ContentSize() uint64 {
-	return v.nm.fileByteCounter
+	return v.nm.ContentSize()
 }
@@ -10,4 +10,9 @@ type VolumeInfo struct {
 	FileCount        int
 	DeleteCount      int
 	DeletedByteCount uint64
+	ModifiedAt       int64 //unix time in seconds of the last write or delete
+	ReadOnly         bool
+	LowDiskSpace     bool   //on a disk below the reserve of free space, takes no writes
+	Tier             string //tier of the data directory holding the volume
+	Stats            VolumeStats
 }
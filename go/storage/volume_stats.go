@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"time"
+)
+
+//upper bounds of the latency histogram buckets, the last bucket counts anything slower
+var LatencyBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond, time.Second,
+}
+
+type OperationStats struct {
+	Count     uint64
+	Errors    uint64
+	Bytes     uint64
+	Latencies []uint64 //counts for each of the LatencyBuckets, plus one for slower operations
+}
+
+func (o *OperationStats) record(bytes uint64, latency time.Duration, err error) {
+	o.Count++
+	if err != nil {
+		o.Errors++
+		return
+	}
+	o.Bytes += bytes
+	if o.Latencies == nil {
+		o.Latencies = make([]uint64, len(LatencyBuckets)+1)
+	}
+	i := 0
+	for i < len(LatencyBuckets) && latency > LatencyBuckets[i] {
+		i++
+	}
+	o.Latencies[i]++
+}
+
+func (o *OperationStats) add(other OperationStats) {
+	o.Count += other.Count
+	o.Errors += other.Errors
+	o.Bytes += other.Bytes
+	if other.Latencies != nil && o.Latencies == nil {
+		o.Latencies = make([]uint64, len(LatencyBuckets)+1)
+	}
+	for i, c := range other.Latencies {
+		if i < len(o.Latencies) {
+			o.Latencies[i] += c
+		}
+	}
+}
+
+// VolumeStats counts the requests served by a volume since the volume server started.
+type VolumeStats struct {
+	Read   OperationStats
+	Write  OperationStats
+	Delete OperationStats
+}
+
+func (s *VolumeStats) Requests() uint64 {
+	return s.Read.Count + s.Write.Count + s.Delete.Count
+}
+
+func (s *VolumeStats) Add(other VolumeStats) {
+	s.Read.add(other.Read)
+	s.Write.add(other.Write)
+	s.Delete.add(other.Delete)
+}
+
+func (s VolumeStats) copy() VolumeStats {
+	for _, o := range []*OperationStats{&s.Read, &s.Write, &s.Delete} {
+		o.Latencies = append([]uint64(nil), o.Latencies...)
+	}
+	return s
+}
+
+func (v *Volume) recordStats(o func(*VolumeStats) *OperationStats, bytes uint64, start time.Time, err error) {
+	v.statsLock.Lock()
+	defer v.statsLock.Unlock()
+	o(&v.stats).record(bytes, time.Since(start), err)
+}
+
+func (v *Volume) Stats() VolumeStats {
+	v.statsLock.Lock()
+	defer v.statsLock.Unlock()
+	return v.stats.copy()
+}
+
+func readStats(s *VolumeStats) *OperationStats   { return &s.Read }
+func writeStats(s *VolumeStats) *OperationStats  { return &s.Write }
+func deleteStats(s *VolumeStats) *OperationStats { return &s.Delete }
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVolumeStats(t *testing.T) {
+	var s VolumeStats
+	s.Read.record(100, 500*time.Microsecond, nil)
+	s.Read.record(200, 3*time.Millisecond, nil)
+	s.Read.record(0, time.Millisecond, errors.New("Not Found"))
+	s.Write.record(300, 2*time.Second, nil)
+
+	if s.Read.Count != 3 || s.Read.Errors != 1 || s.Read.Bytes != 300 {
+		t.Fatal("read stats", s.Read)
+	}
+	if s.Read.Latencies[0] != 1 || s.Read.Latencies[2] != 1 {
+		t.Fatal("read latencies", s.Read.Latencies)
+	}
+	if s.Write.Latencies[len(LatencyBuckets)] != 1 {
+		t.Fatal("slow writes should go to the last bucket", s.Write.Latencies)
+	}
+
+	var total VolumeStats
+	total.Add(s)
+	total.Add(s)
+	if total.Requests() != 8 || total.Read.Latencies[2] != 2 || total.Delete.Latencies != nil {
+		t.Fatal("total stats", total)
+	}
+}
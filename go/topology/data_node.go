@@ -4,16 +4,22 @@ import (
 	_ "fmt"
 	"code.google.com/p/weed-fs/go/storage"
 	"strconv"
+	"time"
 )
 
 type DataNode struct {
 	NodeImpl
 	volumes   map[storage.VolumeId]storage.VolumeInfo
+	disks     []storage.DiskInfo
 	Ip        string
 	Port      int
 	PublicUrl string
 	LastSeen  int64 // unix time in seconds
 	Dead      bool
+
+	load          float64 //requests per second between the last two heartbeats
+	requests      uint64
+	loadSampledAt time.Time
 }
 
 func NewDataNode(id string) *DataNode {
@@ -34,6 +40,133 @@ func (dn *DataNode) AddOrUpdateVolume(v storage.VolumeInfo) {
 		dn.volumes[v.Id] = v
 	}
 }
+
+//the max volume id is left alone, so a deleted volume id is not handed out again
+func (dn *DataNode) RemoveVolume(vid storage.VolumeId) bool {
+	if _, ok := dn.volumes[vid]; !ok {
+		return false
+	}
+	delete(dn.volumes, vid)
+	dn.UpAdjustVolumeCountDelta(-1)
+	return true
+}
+func (dn *DataNode) GetVolume(vid storage.VolumeId) (v storage.VolumeInfo, ok bool) {
+	v, ok = dn.volumes[vid]
+	return
+}
+
+//with disk usage reported, the max volume count follows the real disk headroom
+func (dn *DataNode) UpdateDisks(disks []storage.DiskInfo, maxVolumeCount int, volumeSizeLimit uint64) {
+	dn.disks = disks
+	if max := dn.diskMaxVolumeCount(volumeSizeLimit); max >= 0 {
+		maxVolumeCount = max
+	}
+	if maxVolumeCount != dn.maxVolumeCount {
+		dn.UpAdjustMaxVolumeCountDelta(maxVolumeCount - dn.maxVolumeCount)
+	}
+}
+
+//existing volumes plus the new volumes of volumeSizeLimit fitting in the free space above the reserve,
+//but no more than the directories' max volume counts. returns -1 if disk usage is unknown.
+//directories on one device share its free space, and writable volumes still grow into it.
+func (dn *DataNode) diskMaxVolumeCount(volumeSizeLimit uint64) int {
+	if volumeSizeLimit == 0 {
+		return -1
+	}
+	known, countLimited := false, true
+	countSlots := 0
+	var available uint64
+	counted := make(map[uint64]bool)
+	for _, d := range dn.disks {
+		if d.TotalBytes == 0 {
+			continue
+		}
+		known = true
+		if d.Device == 0 || !counted[d.Device] {
+			counted[d.Device] = true
+			if d.FreeBytes > d.ReservedBytes {
+				available += d.FreeBytes - d.ReservedBytes
+			}
+		}
+		if d.MaxVolumeCount <= 0 {
+			countLimited = false
+		} else if d.MaxVolumeCount > d.VolumeCount {
+			countSlots += d.MaxVolumeCount - d.VolumeCount
+		}
+	}
+	if !known {
+		return -1
+	}
+	growth := dn.remainingGrowth(volumeSizeLimit)
+	slots := 0
+	if available > growth {
+		slots = int((available - growth) / volumeSizeLimit)
+	}
+	if countLimited && countSlots < slots {
+		slots = countSlots
+	}
+	return dn.GetVolumeCount() + slots
+}
+
+//the bytes the writable volumes can still take before reaching the size limit
+func (dn *DataNode) remainingGrowth(volumeSizeLimit uint64) (growth uint64) {
+	for _, v := range dn.volumes {
+		if !v.ReadOnly && v.Size < volumeSizeLimit {
+			growth += volumeSizeLimit - v.Size
+		}
+	}
+	return
+}
+
+func (dn *DataNode) HasTier(tier string) bool {
+	for _, d := range dn.disks {
+		if d.Tier == tier {
+			return true
+		}
+	}
+	return false
+}
+
+//whether both data nodes store volumes on the same physical disk of one host
+func (dn *DataNode) SharesDiskWith(other *DataNode) bool {
+	if dn == other {
+		return true
+	}
+	if dn.Ip != other.Ip {
+		return false
+	}
+	for _, d := range dn.disks {
+		for _, o := range other.disks {
+			if d.Device != 0 && d.Device == o.Device {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//sums the request counters of all volumes, which only grow between volume server restarts
+func (dn *DataNode) updateLoad(now time.Time) {
+	var requests uint64
+	for _, v := range dn.volumes {
+		requests += v.Stats.Requests()
+	}
+	if !dn.loadSampledAt.IsZero() && requests >= dn.requests {
+		if seconds := now.Sub(dn.loadSampledAt).Seconds(); seconds > 0 {
+			dn.load = float64(requests-dn.requests) / seconds
+		}
+	}
+	dn.requests, dn.loadSampledAt = requests, now
+}
+func (dn *DataNode) Load() float64 {
+	return dn.load
+}
+func (dn *DataNode) Stats() (stats storage.VolumeStats) {
+	for _, v := range dn.volumes {
+		stats.Add(v.Stats)
+	}
+	return
+}
 func (dn *DataNode) GetTopology() *Topology {
 	p := dn.parent
 	for p.Parent() != nil {
@@ -56,5 +189,7 @@ func (dn *DataNode) ToMap() interface{} {
 	ret["Max"] = dn.GetMaxVolumeCount()
 	ret["Free"] = dn.FreeSpace()
 	ret["PublicUrl"] = dn.PublicUrl
+	ret["Disks"] = dn.disks
+	ret["Load"] = dn.load
 	return ret
 }
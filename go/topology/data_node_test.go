@@ -0,0 +1,42 @@
+package topology
+
+import (
+	"code.google.com/p/weed-fs/go/storage"
+	"testing"
+)
+
+func TestDiskMaxVolumeCount(t *testing.T) {
+	const limit = 100
+	for _, test := range []struct {
+		name    string
+		disks   []storage.DiskInfo
+		volumes []storage.VolumeInfo
+		want    int
+	}{
+		{"unknown usage", []storage.DiskInfo{{Directory: "/a"}}, nil, -1},
+		{"one disk", []storage.DiskInfo{{Directory: "/a", Device: 1, TotalBytes: 1000, FreeBytes: 500, ReservedBytes: 100}}, nil, 4},
+		{"two directories on one device", []storage.DiskInfo{
+			{Directory: "/a", Device: 1, TotalBytes: 1000, FreeBytes: 500},
+			{Directory: "/b", Device: 1, TotalBytes: 1000, FreeBytes: 500},
+		}, nil, 5},
+		{"two devices", []storage.DiskInfo{
+			{Directory: "/a", Device: 1, TotalBytes: 1000, FreeBytes: 500},
+			{Directory: "/b", Device: 2, TotalBytes: 1000, FreeBytes: 500},
+		}, nil, 10},
+		{"writable volumes still growing", []storage.DiskInfo{{Directory: "/a", Device: 1, TotalBytes: 1000, FreeBytes: 500}}, []storage.VolumeInfo{
+			{Id: 1, Size: 50},
+			{Id: 2, Size: 100},
+			{Id: 3, Size: 10, ReadOnly: true},
+		}, 3 + 4},
+		{"max volume count", []storage.DiskInfo{{Directory: "/a", Device: 1, TotalBytes: 1000, FreeBytes: 500, MaxVolumeCount: 2}}, nil, 2},
+	} {
+		dn := NewDataNode("test")
+		for _, v := range test.volumes {
+			dn.AddOrUpdateVolume(v)
+		}
+		dn.disks = test.disks
+		if got := dn.diskMaxVolumeCount(limit); got != test.want {
+			t.Errorf("%s: max volume count %d, want %d", test.name, got, test.want)
+		}
+	}
+}
@@ -1,8 +1,8 @@
 package topology
 
 import (
-	"fmt"
 	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/wlog"
 )
 
 type NodeId string
@@ -150,7 +150,7 @@ func (n *NodeImpl) LinkChildNode(node Node) {
 		n.UpAdjustVolumeCountDelta(node.GetVolumeCount())
 		n.UpAdjustActiveVolumeCountDelta(node.GetActiveVolumeCount())
 		node.SetParent(n)
-		fmt.Println(n, "adds child", node.Id())
+		wlog.Debug("adding child", "node", n.Id(), "child", node.Id())
 	}
 }
 
@@ -162,7 +162,7 @@ func (n *NodeImpl) UnlinkChildNode(nodeId NodeId) {
 		n.UpAdjustVolumeCountDelta(-node.GetVolumeCount())
 		n.UpAdjustActiveVolumeCountDelta(-node.GetActiveVolumeCount())
 		n.UpAdjustMaxVolumeCountDelta(-node.GetMaxVolumeCount())
-		fmt.Println(n, "removes", node, "volumeCount =", n.activeVolumeCount)
+		wlog.Debug("removing child", "node", n.Id(), "child", node.Id(), "activeVolumeCount", n.activeVolumeCount)
 	}
 }
 
@@ -1,9 +1,9 @@
 package topology
 
 import (
-	"fmt"
 	"math/rand"
 	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/wlog"
 )
 
 type NodeList struct {
@@ -56,7 +56,7 @@ func (nl *NodeList) ReserveOneVolume(randomVolumeIndex int, vid storage.VolumeId
 			randomVolumeIndex -= freeSpace
 		} else {
 			if node.IsDataNode() && node.FreeSpace() > 0 {
-				fmt.Println("vid =", vid, " assigned to node =", node, ", freeSpace =", node.FreeSpace())
+				wlog.Debug("volume assigned to node", "volume", vid, "node", node.Id(), "freeSpace", node.FreeSpace())
 				return true, node.(*DataNode)
 			}
 			children := node.Children()
@@ -16,22 +16,22 @@ func TestXYZ(t *testing.T) {
 	}
 	nl := NewNodeList(topo.Children(), nil)
 
-	picked, ret := nl.RandomlyPickN(1)
+	picked, ret := nl.RandomlyPickN(1, 1)
 	if !ret || len(picked) != 1 {
 		t.Error("need to randomly pick 1 node")
 	}
 
-	picked, ret = nl.RandomlyPickN(4)
+	picked, ret = nl.RandomlyPickN(4, 1)
 	if !ret || len(picked) != 4 {
 		t.Error("need to randomly pick 4 nodes")
 	}
 
-	picked, ret = nl.RandomlyPickN(5)
+	picked, ret = nl.RandomlyPickN(5, 1)
 	if !ret || len(picked) != 5 {
 		t.Error("need to randomly pick 5 nodes")
 	}
 
-	picked, ret = nl.RandomlyPickN(6)
+	picked, ret = nl.RandomlyPickN(6, 1)
 	if ret || len(picked) != 0 {
 		t.Error("can not randomly pick 6 nodes:", ret, picked)
 	}
@@ -1,12 +1,19 @@
 package topology
 
 import (
+	"encoding/gob"
 	"errors"
 	"io/ioutil"
 	"math/rand"
+	"os"
+	"path"
 	"code.google.com/p/weed-fs/go/directory"
 	"code.google.com/p/weed-fs/go/sequence"
 	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/wlog"
+	"sort"
+	"sync"
+	"time"
 )
 
 type Topology struct {
@@ -20,12 +27,20 @@ type Topology struct {
 	volumeSizeLimit uint64
 
 	sequence sequence.Sequencer
+	//keeps the ids of deleted volumes from being handed out again after a restart
+	maxVolumeIdFile string
 
 	chanDeadDataNodes      chan *DataNode
 	chanRecoveredDataNodes chan *DataNode
 	chanFullVolumes        chan storage.VolumeInfo
 
 	configuration *Configuration
+
+	vacuumScheduler  *VacuumScheduler
+	tierPolicy       *TierPolicy
+	locationNotifier *LocationNotifier
+
+	accessLock sync.RWMutex //guards the nodes and volume layouts, changed by heartbeats
 }
 
 func NewTopology(id string, confFile string, dirname string, sequenceFilename string, volumeSizeLimit uint64, pulse int) *Topology {
@@ -39,11 +54,17 @@ func NewTopology(id string, confFile string, dirname string, sequenceFilename st
 	t.volumeSizeLimit = volumeSizeLimit
 
 	t.sequence = sequence.NewSequencer(dirname, sequenceFilename)
+	t.maxVolumeIdFile = path.Join(dirname, sequenceFilename+".vid")
+	t.loadMaxVolumeId()
 
 	t.chanDeadDataNodes = make(chan *DataNode)
 	t.chanRecoveredDataNodes = make(chan *DataNode)
 	t.chanFullVolumes = make(chan storage.VolumeInfo)
 
+	t.vacuumScheduler = NewVacuumScheduler(t)
+	t.tierPolicy = NewTierPolicy(t)
+	t.locationNotifier = NewLocationNotifier(t)
+
 	t.loadConfiguration(confFile)
 
 	return t
@@ -57,7 +78,62 @@ func (t *Topology) loadConfiguration(configurationFile string) error {
 	return e
 }
 
+func (t *Topology) loadMaxVolumeId() {
+	f, e := os.Open(t.maxVolumeIdFile)
+	if e != nil {
+		return
+	}
+	defer f.Close()
+	var vid storage.VolumeId
+	if e = gob.NewDecoder(f).Decode(&vid); e != nil {
+		wlog.Warn("failed to load max volume id", "file", t.maxVolumeIdFile, "error", e)
+		return
+	}
+	wlog.Info("loading max volume id", "volume", vid)
+	t.UpAdjustMaxVolumeId(vid)
+}
+
+func (t *Topology) saveMaxVolumeId() {
+	f, e := os.OpenFile(t.maxVolumeIdFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if e != nil {
+		wlog.Error("failed to save max volume id", "file", t.maxVolumeIdFile, "error", e)
+		return
+	}
+	defer f.Close()
+	if e = gob.NewEncoder(f).Encode(t.GetMaxVolumeId()); e != nil {
+		wlog.Error("failed to save max volume id", "file", t.maxVolumeIdFile, "error", e)
+	}
+}
+
+func (t *Topology) VacuumScheduler() *VacuumScheduler {
+	return t.vacuumScheduler
+}
+
+func (t *Topology) TierPolicy() *TierPolicy {
+	return t.tierPolicy
+}
+
+func (t *Topology) LocationNotifier() *LocationNotifier {
+	return t.locationNotifier
+}
+
+func (t *Topology) VolumeLayouts() (layouts []*VolumeLayout) {
+	for _, vl := range t.replicaType2VolumeLayout {
+		if vl != nil {
+			layouts = append(layouts, vl)
+		}
+	}
+	return
+}
+
+//the replicas of a volume, a copy taken under the lock
 func (t *Topology) Lookup(vid storage.VolumeId) []*DataNode {
+	t.accessLock.RLock()
+	defer t.accessLock.RUnlock()
+	return append([]*DataNode(nil), t.lookup(vid)...)
+}
+
+func (t *Topology) lookup(vid storage.VolumeId) []*DataNode {
 	for _, vl := range t.replicaType2VolumeLayout {
 		if vl != nil {
 			if list := vl.Lookup(vid); list != nil {
@@ -68,6 +144,41 @@ func (t *Topology) Lookup(vid storage.VolumeId) []*DataNode {
 	return nil
 }
 
+//the data node with the url or public url, nil if unknown
+func (t *Topology) FindDataNode(url string) *DataNode {
+	t.accessLock.RLock()
+	defer t.accessLock.RUnlock()
+	for _, dc := range t.Children() {
+		for _, rack := range dc.Children() {
+			for _, c := range rack.Children() {
+				if dn := c.(*DataNode); dn.Url() == url || dn.PublicUrl == url {
+					return dn
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//the replicas of a volume, the least busy first
+func (t *Topology) LookupByLoad(vid storage.VolumeId) []*DataNode {
+	t.accessLock.RLock()
+	defer t.accessLock.RUnlock()
+	list := t.lookup(vid)
+	if list == nil {
+		return nil
+	}
+	sorted := append([]*DataNode(nil), list...)
+	sort.Sort(byLoad(sorted))
+	return sorted
+}
+
+type byLoad []*DataNode
+
+func (s byLoad) Len() int           { return len(s) }
+func (s byLoad) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byLoad) Less(i, j int) bool { return s[i].load < s[j].load }
+
 func (t *Topology) RandomlyReserveOneVolume() (bool, *DataNode, *storage.VolumeId) {
 	if t.FreeSpace() <= 0 {
 		return false, nil, nil
@@ -96,11 +207,7 @@ func (t *Topology) NextVolumeId() storage.VolumeId {
 }
 
 func (t *Topology) PickForWrite(repType storage.ReplicationType, count int) (string, int, *DataNode, error) {
-	replicationTypeIndex := repType.GetReplicationLevelIndex()
-	if t.replicaType2VolumeLayout[replicationTypeIndex] == nil {
-		t.replicaType2VolumeLayout[replicationTypeIndex] = NewVolumeLayout(repType, t.volumeSizeLimit, t.pulse)
-	}
-	vid, count, datanodes, err := t.replicaType2VolumeLayout[replicationTypeIndex].PickForWrite(count)
+	vid, count, datanodes, err := t.GetVolumeLayout(repType).PickForWrite(count)
 	if err != nil {
 		return "", 0, nil, errors.New("No writable volumes avalable!")
 	}
@@ -111,7 +218,9 @@ func (t *Topology) PickForWrite(repType storage.ReplicationType, count int) (str
 func (t *Topology) GetVolumeLayout(repType storage.ReplicationType) *VolumeLayout {
 	replicationTypeIndex := repType.GetReplicationLevelIndex()
 	if t.replicaType2VolumeLayout[replicationTypeIndex] == nil {
-		t.replicaType2VolumeLayout[replicationTypeIndex] = NewVolumeLayout(repType, t.volumeSizeLimit, t.pulse)
+		vl := NewVolumeLayout(repType, t.volumeSizeLimit, t.pulse)
+		vl.locationsChanged = t.locationNotifier.Changed
+		t.replicaType2VolumeLayout[replicationTypeIndex] = vl
 	}
 	return t.replicaType2VolumeLayout[replicationTypeIndex]
 }
@@ -120,7 +229,17 @@ func (t *Topology) RegisterVolumeLayout(v *storage.VolumeInfo, dn *DataNode) {
 	t.GetVolumeLayout(v.RepType).RegisterVolume(v, dn)
 }
 
-func (t *Topology) RegisterVolumes(init bool, volumeInfos []storage.VolumeInfo, ip string, port int, publicUrl string, maxVolumeCount int) {
+//adds a volume just created on the data node, before its heartbeat reports it
+func (t *Topology) RegisterNewVolume(v storage.VolumeInfo, dn *DataNode) {
+	t.accessLock.Lock()
+	defer t.accessLock.Unlock()
+	dn.AddOrUpdateVolume(v)
+	t.RegisterVolumeLayout(&v, dn)
+}
+
+func (t *Topology) RegisterVolumes(init bool, volumeInfos []storage.VolumeInfo, disks []storage.DiskInfo, ip string, port int, publicUrl string, maxVolumeCount int) {
+	t.accessLock.Lock()
+	defer t.accessLock.Unlock()
 	dcName, rackName := t.configuration.Locate(ip)
 	dc := t.GetOrCreateDataCenter(dcName)
 	rack := dc.GetOrCreateRack(rackName)
@@ -133,6 +252,58 @@ func (t *Topology) RegisterVolumes(init bool, volumeInfos []storage.VolumeInfo,
 		dn.AddOrUpdateVolume(v)
 		t.RegisterVolumeLayout(&v, dn)
 	}
+	dn.UpdateDisks(disks, maxVolumeCount, t.volumeSizeLimit)
+	dn.updateLoad(time.Now())
+}
+
+//marks all replicas of a volume, returns false if the volume is unknown
+func (t *Topology) SetVolumeReadOnly(vid storage.VolumeId, readOnly bool) bool {
+	t.accessLock.Lock()
+	defer t.accessLock.Unlock()
+	found := false
+	for _, vl := range t.replicaType2VolumeLayout {
+		if vl == nil || vl.vid2location[vid] == nil {
+			continue
+		}
+		found = true
+		var info storage.VolumeInfo
+		for _, dn := range vl.vid2location[vid].list {
+			if v, ok := dn.volumes[vid]; ok {
+				v.ReadOnly = readOnly
+				dn.volumes[vid] = v
+				info = v
+			}
+		}
+		vl.updateReadOnly(&info)
+	}
+	return found
+}
+
+//forgets one replica of a deleted volume, the rest of the replicas stop taking writes
+func (t *Topology) UnRegisterVolume(vid storage.VolumeId, dn *DataNode) bool {
+	t.accessLock.Lock()
+	defer t.accessLock.Unlock()
+	v, ok := dn.GetVolume(vid)
+	if !ok {
+		return false
+	}
+	vl := t.GetVolumeLayout(v.RepType)
+	if vl.removeFromWritable(vid) {
+		for _, node := range vl.Lookup(vid) {
+			node.UpAdjustActiveVolumeCountDelta(-1)
+		}
+	}
+	vl.UnRegisterVolume(vid, dn)
+	dn.RemoveVolume(vid)
+	if vl.vid2location[vid] == nil {
+		t.vacuumScheduler.SetVolumeThreshold(vid, 0)
+	}
+	//no heartbeat reports the volume any more, so its id only survives a restart on disk
+	t.UpAdjustMaxVolumeId(vid)
+	if t.maxVolumeIdFile != "" {
+		t.saveMaxVolumeId()
+	}
+	return true
 }
 
 func (t *Topology) GetOrCreateDataCenter(dcName string) *DataCenter {
@@ -7,104 +7,103 @@ import (
 	"net/url"
 	"code.google.com/p/weed-fs/go/storage"
 	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
+	"strconv"
 	"time"
 )
 
-func batchVacuumVolumeCheck(vl *VolumeLayout, vid storage.VolumeId, locationlist *VolumeLocationList, garbageThreshold string) bool {
-	ch := make(chan bool, locationlist.Length())
-	for index, dn := range locationlist.list {
-		go func(index int, url string, vid storage.VolumeId) {
-			//fmt.Println(index, "Check vacuuming", vid, "on", dn.Url())
-			if e, ret := vacuumVolume_Check(url, vid, garbageThreshold); e != nil {
-				//fmt.Println(index, "Error when checking vacuuming", vid, "on", url, e)
-				ch <- false
-			} else {
-				//fmt.Println(index, "Checked vacuuming", vid, "on", url, "needVacuum", ret)
-				ch <- ret
-			}
-		}(index, dn.Url(), vid)
+//asks the replicas whether the volume needs vacuuming, with an error if one fails
+//to answer or does not answer within the timeout
+func batchVacuumVolumeCheck(vl *VolumeLayout, vid storage.VolumeId, locationlist *VolumeLocationList, garbageThreshold float64, timeout time.Duration) (bool, error) {
+	type checkResult struct {
+		url    string
+		needed bool
+		err    error
+	}
+	ch := make(chan checkResult, locationlist.Length())
+	for _, dn := range locationlist.list {
+		go func(url string) {
+			e, ret := vacuumVolume_Check(url, vid, garbageThreshold)
+			ch <- checkResult{url, ret, e}
+		}(dn.Url())
 	}
-	isCheckSuccess := true
+	needed := true
+	deadline := time.After(timeout)
 	for _ = range locationlist.list {
 		select {
-		case canVacuum := <-ch:
-			isCheckSuccess = isCheckSuccess && canVacuum
-		case <-time.After(30 * time.Minute):
-			isCheckSuccess = false
-			break
+		case r := <-ch:
+			if r.err != nil {
+				return false, fmt.Errorf("failed to check vacuum on %s: %s", r.url, r.err)
+			}
+			needed = needed && r.needed
+		case <-deadline:
+			return false, fmt.Errorf("timed out checking vacuum after %v", timeout)
 		}
 	}
-	return isCheckSuccess
+	return needed, nil
 }
-func batchVacuumVolumeCompact(vl *VolumeLayout, vid storage.VolumeId, locationlist *VolumeLocationList) bool {
-	vl.removeFromWritable(vid)
-	ch := make(chan bool, locationlist.Length())
-	for index, dn := range locationlist.list {
-		go func(index int, url string, vid storage.VolumeId) {
-			fmt.Println(index, "Start vacuuming", vid, "on", url)
+func batchVacuumVolumeCompact(vl *VolumeLayout, vid storage.VolumeId, locationlist *VolumeLocationList, timeout time.Duration) error {
+	ch := make(chan error, locationlist.Length())
+	for _, dn := range locationlist.list {
+		go func(url string) {
+			wlog.Info("start vacuuming", "volume", vid, "node", url)
 			if e := vacuumVolume_Compact(url, vid); e != nil {
-				fmt.Println(index, "Error when vacuuming", vid, "on", url, e)
-				ch <- false
+				wlog.Error("failed to vacuum", "volume", vid, "node", url, "error", e)
+				ch <- fmt.Errorf("failed to compact on %s: %s", url, e)
 			} else {
-				fmt.Println(index, "Complete vacuuming", vid, "on", url)
-				ch <- true
+				wlog.Info("completed vacuuming", "volume", vid, "node", url)
+				ch <- nil
 			}
-		}(index, dn.Url(), vid)
+		}(dn.Url())
 	}
-	isVacuumSuccess := true
+	var err error
+	deadline := time.After(timeout)
 	for _ = range locationlist.list {
 		select {
-		case _ = <-ch:
-		case <-time.After(30 * time.Minute):
-			isVacuumSuccess = false
-			break
+		case e := <-ch:
+			if e != nil && err == nil {
+				err = e
+			}
+		case <-deadline:
+			return fmt.Errorf("timed out compacting after %v", timeout)
 		}
 	}
-	return isVacuumSuccess
+	return err
 }
-func batchVacuumVolumeCommit(vl *VolumeLayout, vid storage.VolumeId, locationlist *VolumeLocationList) bool {
-	isCommitSuccess := true
+func batchVacuumVolumeCommit(vl *VolumeLayout, vid storage.VolumeId, locationlist *VolumeLocationList) (uint64, error) {
+	var err error
+	var newSize uint64
 	for _, dn := range locationlist.list {
-		fmt.Println("Start Commiting vacuum", vid, "on", dn.Url())
-		if e := vacuumVolume_Commit(dn.Url(), vid); e != nil {
-			fmt.Println("Error when committing vacuum", vid, "on", dn.Url(), e)
-			isCommitSuccess = false
+		wlog.Info("start committing vacuum", "volume", vid, "node", dn.Url())
+		if size, e := vacuumVolume_Commit(dn.Url(), vid); e != nil {
+			wlog.Error("failed to commit vacuum", "volume", vid, "node", dn.Url(), "error", e)
+			err = fmt.Errorf("failed to commit vacuum on %s: %s", dn.Url(), e)
 		} else {
-			fmt.Println("Complete Commiting vacuum", vid, "on", dn.Url())
-		}
-	}
-	if isCommitSuccess {
-		vl.setVolumeWritable(vid)
-	}
-	return isCommitSuccess
-}
-func (t *Topology) Vacuum(garbageThreshold string) int {
-	for _, vl := range t.replicaType2VolumeLayout {
-		if vl != nil {
-			for vid, locationlist := range vl.vid2location {
-				if batchVacuumVolumeCheck(vl, vid, locationlist, garbageThreshold) {
-					if batchVacuumVolumeCompact(vl, vid, locationlist) {
-						batchVacuumVolumeCommit(vl, vid, locationlist)
-					}
-				}
+			wlog.Info("committed vacuum", "volume", vid, "node", dn.Url())
+			if size > newSize {
+				newSize = size
 			}
 		}
 	}
-	return 0
+	return newSize, err
+}
+func (t *Topology) Vacuum(garbageThreshold float64) int {
+	return t.vacuumScheduler.Run(garbageThreshold)
 }
 
 type VacuumVolumeResult struct {
 	Result bool
+	Size   uint64
 	Error  string
 }
 
-func vacuumVolume_Check(urlLocation string, vid storage.VolumeId, garbageThreshold string) (error, bool) {
+func vacuumVolume_Check(urlLocation string, vid storage.VolumeId, garbageThreshold float64) (error, bool) {
 	values := make(url.Values)
 	values.Add("volume", vid.String())
-	values.Add("garbageThreshold", garbageThreshold)
-	jsonBlob, err := util.Post("http://"+urlLocation+"/admin/vacuum_volume_check", values)
+	values.Add("garbageThreshold", strconv.FormatFloat(garbageThreshold, 'f', -1, 64))
+	jsonBlob, err := util.AdminPost(util.NormalizeUrl(urlLocation)+"/admin/vacuum_volume_check", values)
 	if err != nil {
-		fmt.Println("parameters:", values)
+		wlog.Debug("vacuum parameters", "values", values)
 		return err, false
 	}
 	var ret VacuumVolumeResult
@@ -119,7 +118,7 @@ func vacuumVolume_Check(urlLocation string, vid storage.VolumeId, garbageThresho
 func vacuumVolume_Compact(urlLocation string, vid storage.VolumeId) error {
 	values := make(url.Values)
 	values.Add("volume", vid.String())
-	jsonBlob, err := util.Post("http://"+urlLocation+"/admin/vacuum_volume_compact", values)
+	jsonBlob, err := util.AdminPost(util.NormalizeUrl(urlLocation)+"/admin/vacuum_volume_compact", values)
 	if err != nil {
 		return err
 	}
@@ -132,19 +131,19 @@ func vacuumVolume_Compact(urlLocation string, vid storage.VolumeId) error {
 	}
 	return nil
 }
-func vacuumVolume_Commit(urlLocation string, vid storage.VolumeId) error {
+func vacuumVolume_Commit(urlLocation string, vid storage.VolumeId) (uint64, error) {
 	values := make(url.Values)
 	values.Add("volume", vid.String())
-	jsonBlob, err := util.Post("http://"+urlLocation+"/admin/vacuum_volume_commit", values)
+	jsonBlob, err := util.AdminPost(util.NormalizeUrl(urlLocation)+"/admin/vacuum_volume_commit", values)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	var ret VacuumVolumeResult
 	if err := json.Unmarshal(jsonBlob, &ret); err != nil {
-		return err
+		return 0, err
 	}
 	if ret.Error != "" {
-		return errors.New(ret.Error)
+		return 0, errors.New(ret.Error)
 	}
-	return nil
+	return ret.Size, nil
 }
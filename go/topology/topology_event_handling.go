@@ -1,13 +1,16 @@
 package topology
 
 import (
-	"fmt"
 	"math/rand"
+	"code.google.com/p/weed-fs/go/metrics"
 	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/wlog"
 	"time"
 )
 
-func (t *Topology) StartRefreshWritableVolumes(garbageThreshold string) {
+var deadDataNodes = metrics.NewCounterVec("weed_master_dead_data_nodes_total", "Number of times a data node missed its heartbeats and was removed.")
+
+func (t *Topology) StartRefreshWritableVolumes() {
 	go func() {
 		for {
 			freshThreshHold := time.Now().Unix() - 3*t.pulse //3 times of sleep interval
@@ -15,23 +18,27 @@ func (t *Topology) StartRefreshWritableVolumes(garbageThreshold string) {
 			time.Sleep(time.Duration(float32(t.pulse*1e3)*(1+rand.Float32())) * time.Millisecond)
 		}
 	}()
-	go func(garbageThreshold string) {
-		c := time.Tick(15 * time.Minute)
-		for _ = range c {
-			t.Vacuum(garbageThreshold)
-		}
-	}(garbageThreshold)
+	t.vacuumScheduler.Start()
+	t.tierPolicy.Start()
+	t.locationNotifier.Start()
 	go func() {
 		for {
 			select {
 			case v := <-t.chanFullVolumes:
+				t.accessLock.Lock()
 				t.SetVolumeCapacityFull(v)
+				t.accessLock.Unlock()
 			case dn := <-t.chanRecoveredDataNodes:
+				t.accessLock.Lock()
 				t.RegisterRecoveredDataNode(dn)
-				fmt.Println("DataNode", dn, "is back alive!")
+				t.accessLock.Unlock()
+				wlog.Info("data node is back alive", "node", dn.Url())
 			case dn := <-t.chanDeadDataNodes:
+				t.accessLock.Lock()
 				t.UnRegisterDataNode(dn)
-				fmt.Println("DataNode", dn, "is dead!")
+				t.accessLock.Unlock()
+				deadDataNodes.Inc()
+				wlog.Warn("data node is dead", "node", dn.Url())
 			}
 		}
 	}()
@@ -48,7 +55,7 @@ func (t *Topology) SetVolumeCapacityFull(volumeInfo storage.VolumeInfo) bool {
 }
 func (t *Topology) UnRegisterDataNode(dn *DataNode) {
 	for _, v := range dn.volumes {
-		fmt.Println("Removing Volume", v.Id, "from the dead volume server", dn)
+		wlog.Info("removing volume of dead data node", "volume", v.Id, "node", dn.Url())
 		vl := t.GetVolumeLayout(v.RepType)
 		vl.SetVolumeUnavailable(dn, v.Id)
 	}
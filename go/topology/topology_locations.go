@@ -0,0 +1,92 @@
+package topology
+
+import (
+	"encoding/json"
+	"net/url"
+	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
+	"sync"
+	"time"
+)
+
+// LocationNotifier pushes the locations of volumes whose replicas were added or
+// removed to all volume servers, so the location caches they use for redirects
+// and replicated writes do not wait for their ttl. Changes are sent in batches,
+// once per Interval, to all volume servers at once, each given Timeout to answer.
+type LocationNotifier struct {
+	Interval time.Duration
+	Timeout  time.Duration
+
+	topo    *Topology
+	lock    sync.Mutex
+	changed map[storage.VolumeId]bool
+}
+
+func NewLocationNotifier(topo *Topology) *LocationNotifier {
+	return &LocationNotifier{Interval: time.Second, Timeout: 5 * time.Second, topo: topo, changed: make(map[storage.VolumeId]bool)}
+}
+
+func (ln *LocationNotifier) Changed(vid storage.VolumeId) {
+	ln.lock.Lock()
+	ln.changed[vid] = true
+	ln.lock.Unlock()
+}
+
+func (ln *LocationNotifier) Start() {
+	if ln.Interval <= 0 {
+		return
+	}
+	go func() {
+		for _ = range time.Tick(ln.Interval) {
+			ln.Push()
+		}
+	}()
+}
+
+// Push sends the pending changes, and returns the number of volumes sent.
+// A volume without locations left is sent with none, to drop it from the caches.
+func (ln *LocationNotifier) Push() int {
+	ln.lock.Lock()
+	changed := ln.changed
+	ln.changed = make(map[storage.VolumeId]bool)
+	ln.lock.Unlock()
+	if len(changed) == 0 {
+		return 0
+	}
+	locations := make(map[string][]map[string]string)
+	var nodes []*DataNode
+	ln.topo.accessLock.RLock()
+	for vid := range changed {
+		list := []map[string]string{}
+		for _, dn := range ln.topo.lookup(vid) {
+			list = append(list, map[string]string{"url": util.LocationUrl(dn.Url()), "publicUrl": util.LocationUrl(dn.PublicUrl)})
+		}
+		locations[vid.String()] = list
+	}
+	for _, c := range ln.topo.Children() {
+		for _, r := range c.Children() {
+			for _, d := range r.Children() {
+				nodes = append(nodes, d.(*DataNode))
+			}
+		}
+	}
+	ln.topo.accessLock.RUnlock()
+	bytes, _ := json.Marshal(locations)
+	values := make(url.Values)
+	values.Add("locations", string(bytes))
+	//a hung volume server only delays the push to itself
+	var wg sync.WaitGroup
+	for _, dn := range nodes {
+		wg.Add(1)
+		go func(dn *DataNode) {
+			defer wg.Done()
+			if _, err := util.AdminPostWithTimeout(util.NormalizeUrl(dn.Url())+"/admin/volume_locations", values, ln.Timeout); err != nil {
+				wlog.Warn("failed to push volume locations", "node", dn.Url(), "error", err)
+			}
+		}(dn)
+	}
+	wg.Wait()
+	wlog.Debug("pushed volume locations", "volumes", len(changed))
+	return len(changed)
+}
@@ -0,0 +1,70 @@
+package topology
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"code.google.com/p/weed-fs/go/storage"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLocationNotifierPush(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir)
+	topo := NewTopology("test", "", dir, "seq", 1<<20, 5)
+
+	pushed := make(chan map[string][]map[string]string, 1)
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var locations map[string][]map[string]string
+		json.Unmarshal([]byte(r.FormValue("locations")), &locations)
+		pushed <- locations
+		w.Write([]byte(`{"error":""}`))
+	}))
+	defer live.Close()
+	unblock := make(chan bool)
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer hung.Close()
+	defer close(unblock) //before closing the server, which waits for the hung push
+
+	for _, server := range []*httptest.Server{hung, live} {
+		u, _ := url.Parse(server.URL)
+		host, port, _ := net.SplitHostPort(u.Host)
+		p, _ := strconv.Atoi(port)
+		v := storage.VolumeInfo{Id: 3, RepType: storage.Copy001, Version: storage.CurrentVersion}
+		topo.RegisterVolumes(true, []storage.VolumeInfo{v}, nil, host, p, u.Host, 7)
+	}
+
+	ln := topo.locationNotifier
+	ln.Timeout = 100 * time.Millisecond
+	if n := ln.Push(); n != 1 {
+		t.Fatalf("pushed %d volumes, want 1", n)
+	}
+	if n := ln.Push(); n != 0 {
+		t.Fatalf("pushed %d volumes without changes", n)
+	}
+	start := time.Now()
+	topo.UnRegisterVolume(3, topo.FindDataNode(hung.Listener.Addr().String()))
+	<-pushed //of the first push
+	if n := ln.Push(); n != 1 {
+		t.Fatalf("pushed %d volumes, want 1", n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("push took %v with a hung volume server", elapsed)
+	}
+	select {
+	case locations := <-pushed:
+		if len(locations["3"]) != 1 {
+			t.Errorf("pushed locations %v, want only the live server", locations)
+		}
+	default:
+		t.Error("the live server got no push")
+	}
+}
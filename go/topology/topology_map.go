@@ -3,6 +3,8 @@ package topology
 import ()
 
 func (t *Topology) ToMap() interface{} {
+	t.accessLock.RLock()
+	defer t.accessLock.RUnlock()
 	m := make(map[string]interface{})
 	m["Max"] = t.GetMaxVolumeCount()
 	m["Free"] = t.FreeSpace()
@@ -23,6 +25,8 @@ func (t *Topology) ToMap() interface{} {
 }
 
 func (t *Topology) ToVolumeMap() interface{} {
+	t.accessLock.RLock()
+	defer t.accessLock.RUnlock()
 	m := make(map[string]interface{})
 	m["Max"] = t.GetMaxVolumeCount()
 	m["Free"] = t.FreeSpace()
@@ -48,3 +52,21 @@ func (t *Topology) ToVolumeMap() interface{} {
 	m["DataCenters"] = dcs
 	return m
 }
+
+func (t *Topology) ToStatsMap() interface{} {
+	t.accessLock.RLock()
+	defer t.accessLock.RUnlock()
+	dataNodes := make(map[string]interface{})
+	for _, c := range t.Children() {
+		for _, r := range c.Children() {
+			for _, d := range r.Children() {
+				dn := d.(*DataNode)
+				m := make(map[string]interface{})
+				m["Load"] = dn.load
+				m["Stats"] = dn.Stats()
+				dataNodes[dn.Url()] = m
+			}
+		}
+	}
+	return dataNodes
+}
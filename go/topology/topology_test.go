@@ -0,0 +1,61 @@
+package topology
+
+import (
+	"io/ioutil"
+	"os"
+	"code.google.com/p/weed-fs/go/storage"
+	"testing"
+	"time"
+)
+
+func TestDeletedVolumeIdNotReused(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir)
+	topo := NewTopology("test", "", dir, "seq", 1<<20, 5)
+	v := storage.VolumeInfo{Id: 5, RepType: storage.Copy000, Version: storage.CurrentVersion}
+	topo.RegisterVolumes(true, []storage.VolumeInfo{v}, nil, "127.0.0.1", 8080, "127.0.0.1:8080", 7)
+	dn := topo.FindDataNode("127.0.0.1:8080")
+	if dn == nil {
+		t.Fatal("data node not registered")
+	}
+	if !topo.UnRegisterVolume(5, dn) {
+		t.Fatal("failed to unregister volume 5")
+	}
+	if vid := topo.NextVolumeId(); vid != 6 {
+		t.Errorf("next volume id %d after deleting 5, want 6", vid)
+	}
+
+	//a restarted master hears of no volume 5 in heartbeats
+	topo = NewTopology("test", "", dir, "seq", 1<<20, 5)
+	topo.RegisterVolumes(true, nil, nil, "127.0.0.1", 8080, "127.0.0.1:8080", 7)
+	if vid := topo.NextVolumeId(); vid != 6 {
+		t.Errorf("next volume id %d after a restart, want 6", vid)
+	}
+}
+
+//run with -race: heartbeats change the topology while the background jobs read it
+func TestHeartbeatsWhileSchedulersRun(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir)
+	topo := NewTopology("test", "", dir, "seq", 1<<20, 5)
+	topo.LocationNotifier().Timeout = time.Millisecond
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			v := storage.VolumeInfo{Id: storage.VolumeId(i), Size: 100, DeletedByteCount: 90, RepType: storage.Copy000, Version: storage.CurrentVersion}
+			topo.RegisterVolumes(false, []storage.VolumeInfo{v}, nil, "127.0.0.1", 1+i%3, "127.0.0.1", 7)
+		}
+		done <- true
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			topo.VacuumScheduler().collectCandidates(0.3)
+			topo.TierPolicy().coldReplicas()
+			topo.LocationNotifier().Push()
+			topo.Lookup(1)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package topology
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
+	"sync"
+	"time"
+)
+
+// TierPolicy moves full volumes that are rarely read down to colder storage tiers,
+// one tier per run. A replica moves to a directory of the colder tier on its own
+// data node if there is one, otherwise it is copied to another data node in the same rack.
+type TierPolicy struct {
+	topo *Topology
+
+	Tiers             []string //hottest first, new volumes are assigned to the first one if it has space
+	MaxReadsPerMinute float64  //volumes read less often than this are moved down
+	Interval          time.Duration
+
+	readSamples map[storage.VolumeId]readSample
+	running     bool
+
+	accessLock sync.Mutex
+}
+
+type readSample struct {
+	count uint64
+	at    time.Time
+}
+
+func NewTierPolicy(topo *Topology) *TierPolicy {
+	return &TierPolicy{
+		topo:              topo,
+		Tiers:             []string{storage.DefaultTier},
+		MaxReadsPerMinute: 1,
+		Interval:          time.Hour,
+		readSamples:       make(map[storage.VolumeId]readSample),
+	}
+}
+
+func (tp *TierPolicy) HotTier() string {
+	if len(tp.Tiers) == 0 {
+		return ""
+	}
+	return tp.Tiers[0]
+}
+
+func (tp *TierPolicy) colderTier(tier string) string {
+	for i, t := range tp.Tiers {
+		if t == tier && i+1 < len(tp.Tiers) {
+			return tp.Tiers[i+1]
+		}
+	}
+	return ""
+}
+
+func (tp *TierPolicy) Start() {
+	if tp.Interval <= 0 || len(tp.Tiers) < 2 {
+		return
+	}
+	go func() {
+		c := time.Tick(tp.Interval)
+		for _ = range c {
+			tp.Run()
+		}
+	}()
+}
+
+// Run moves the eligible volume replicas down one tier, and returns the number of replicas moved.
+func (tp *TierPolicy) Run() int {
+	tp.accessLock.Lock()
+	if tp.running {
+		tp.accessLock.Unlock()
+		return 0
+	}
+	tp.running = true
+	tp.accessLock.Unlock()
+	defer func() {
+		tp.accessLock.Lock()
+		tp.running = false
+		tp.accessLock.Unlock()
+	}()
+
+	moved := 0
+	for _, m := range tp.coldReplicas() {
+		if err := tp.moveReplica(m.vl, m.dn, m.v, m.tier); err != nil {
+			wlog.Error("failed to move volume", "volume", m.v.Id, "node", m.dn.Url(), "tier", m.tier, "error", err)
+		} else {
+			wlog.Info("moved volume", "volume", m.v.Id, "node", m.dn.Url(), "tier", m.tier)
+			moved++
+		}
+	}
+	return moved
+}
+
+type tierMove struct {
+	vl   *VolumeLayout
+	dn   *DataNode
+	v    storage.VolumeInfo
+	tier string
+}
+
+//the replicas to move down a tier, picked under the topology lock so the moves can run without it
+func (tp *TierPolicy) coldReplicas() (moves []tierMove) {
+	tp.topo.accessLock.RLock()
+	defer tp.topo.accessLock.RUnlock()
+	for _, vl := range tp.topo.replicaType2VolumeLayout {
+		if vl == nil {
+			continue
+		}
+		for vid, locationlist := range vl.vid2location {
+			if !tp.isCold(vid, locationlist) {
+				continue
+			}
+			for _, dn := range locationlist.list {
+				v, ok := dn.GetVolume(vid)
+				if !ok {
+					continue
+				}
+				if tier := tp.colderTier(v.Tier); tier != "" {
+					moves = append(moves, tierMove{vl: vl, dn: dn, v: v, tier: tier})
+				}
+			}
+		}
+	}
+	return
+}
+
+//full volumes with fewer reads per minute than the threshold since the last run
+func (tp *TierPolicy) isCold(vid storage.VolumeId, locationlist *VolumeLocationList) bool {
+	full := false
+	var reads uint64
+	for _, dn := range locationlist.list {
+		if v, ok := dn.GetVolume(vid); ok {
+			reads += v.Stats.Read.Count
+			if v.Size >= tp.topo.volumeSizeLimit {
+				full = true
+			}
+		}
+	}
+	now := time.Now()
+	tp.accessLock.Lock()
+	last, sampled := tp.readSamples[vid]
+	tp.readSamples[vid] = readSample{count: reads, at: now}
+	tp.accessLock.Unlock()
+	if !full || !sampled || reads < last.count {
+		return false
+	}
+	minutes := now.Sub(last.at).Minutes()
+	return minutes > 0 && float64(reads-last.count)/minutes < tp.MaxReadsPerMinute
+}
+
+func (tp *TierPolicy) moveReplica(vl *VolumeLayout, dn *DataNode, v storage.VolumeInfo, tier string) error {
+	tp.topo.accessLock.RLock()
+	hasTier := dn.HasTier(tier)
+	var target *DataNode
+	if !hasTier {
+		target = tp.pickTarget(dn, v.Id, tier)
+	}
+	tp.topo.accessLock.RUnlock()
+	if hasTier {
+		moved, err := postVolumeAdminTier(dn.Url(), "/admin/move_volume", v.Id, url.Values{"tier": {tier}})
+		if err != nil {
+			return err
+		}
+		v.Tier = tier
+		if moved != "" {
+			v.Tier = moved
+		}
+		tp.topo.accessLock.Lock()
+		dn.AddOrUpdateVolume(v)
+		tp.topo.accessLock.Unlock()
+		return nil
+	}
+	if target == nil {
+		return errors.New("no data node in the rack has tier " + tier)
+	}
+	return tp.topo.MoveVolume(v, dn, target, tier)
+}
+
+// MoveVolume copies a replica of a volume from dn to target, into a directory of
+// the tier or any if empty, and removes it from dn. The source is read only during
+// the copy so that no deletion is lost.
+func (t *Topology) MoveVolume(v storage.VolumeInfo, dn *DataNode, target *DataNode, tier string) error {
+	if !v.ReadOnly {
+		if err := postVolumeAdmin(dn.Url(), "/admin/readonly_volume", v.Id, nil); err != nil {
+			return err
+		}
+	}
+	copiedTier, err := postVolumeAdminTier(target.Url(), "/admin/copy_volume", v.Id, url.Values{"source": {dn.Url()}, "tier": {tier}})
+	if err == nil && !v.ReadOnly {
+		err = postVolumeAdmin(target.Url(), "/admin/writable_volume", v.Id, nil)
+	}
+	if err != nil {
+		if !v.ReadOnly {
+			postVolumeAdmin(dn.Url(), "/admin/writable_volume", v.Id, nil)
+		}
+		return err
+	}
+	copied := v
+	copied.Stats = storage.VolumeStats{}
+	if copiedTier != "" {
+		copied.Tier = copiedTier
+	} else if tier != "" {
+		copied.Tier = tier
+	}
+	t.RegisterNewVolume(copied, target)
+	if err = postVolumeAdmin(dn.Url(), "/admin/delete_volume", v.Id, nil); err != nil {
+		return fmt.Errorf("copied to %s, but failed to delete the source: %s", target.Url(), err)
+	}
+	t.UnRegisterVolume(v.Id, dn)
+	return nil
+}
+
+//a live data node in the same rack with the tier, not holding the volume
+func (tp *TierPolicy) pickTarget(dn *DataNode, vid storage.VolumeId, tier string) (picked *DataNode) {
+	for _, c := range dn.Parent().Children() {
+		candidate := c.(*DataNode)
+		if candidate.Dead || candidate.FreeSpace() <= 0 || !candidate.HasTier(tier) {
+			continue
+		}
+		if _, ok := candidate.GetVolume(vid); ok {
+			continue
+		}
+		if picked == nil || candidate.FreeSpace() > picked.FreeSpace() {
+			picked = candidate
+		}
+	}
+	return
+}
+
+func (tp *TierPolicy) ToMap() interface{} {
+	tp.accessLock.Lock()
+	defer tp.accessLock.Unlock()
+	m := make(map[string]interface{})
+	m["Tiers"] = tp.Tiers
+	m["MaxReadsPerMinute"] = tp.MaxReadsPerMinute
+	m["Interval"] = tp.Interval.String()
+	m["Running"] = tp.running
+	return m
+}
+
+type volumeAdminResult struct {
+	Error string
+	Tier  string //of the directory the volume was placed in
+}
+
+func postVolumeAdmin(urlLocation string, action string, vid storage.VolumeId, values url.Values) error {
+	_, err := postVolumeAdminTier(urlLocation, action, vid, values)
+	return err
+}
+
+//returns the tier the volume server placed the volume in, empty if not reported
+func postVolumeAdminTier(urlLocation string, action string, vid storage.VolumeId, values url.Values) (string, error) {
+	if values == nil {
+		values = make(url.Values)
+	}
+	values.Add("volume", vid.String())
+	jsonBlob, err := util.AdminPost(util.NormalizeUrl(urlLocation)+action, values)
+	if err != nil {
+		return "", err
+	}
+	var ret volumeAdminResult
+	if err := json.Unmarshal(jsonBlob, &ret); err != nil {
+		return "", err
+	}
+	if ret.Error != "" {
+		return "", errors.New(ret.Error)
+	}
+	return ret.Tier, nil
+}
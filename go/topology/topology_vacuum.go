@@ -0,0 +1,313 @@
+package topology
+
+import (
+	"code.google.com/p/weed-fs/go/metrics"
+	"code.google.com/p/weed-fs/go/storage"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	vacuumResults   = metrics.NewCounterVec("weed_master_vacuum_total", "Number of volume vacuums by result.", "result")
+	vacuumReclaimed = metrics.NewCounterVec("weed_master_vacuum_reclaimed_bytes_total", "Bytes reclaimed by vacuuming volumes.")
+)
+
+const (
+	DefaultVacuumHistorySize = 1000
+)
+
+type VacuumRecord struct {
+	VolumeId       storage.VolumeId
+	Replication    string
+	Start          time.Time
+	End            time.Time
+	BytesReclaimed int64
+	Error          string
+}
+
+// VacuumScheduler decides which volumes to compact and when.
+// Volumes with the most reclaimable bytes go first. At most MaxConcurrent volumes
+// are compacted cluster-wide, and at most MaxPerNode on any single data node.
+type VacuumScheduler struct {
+	topo *Topology
+
+	GarbageThreshold float64       //default threshold, can be overridden per volume
+	MaxConcurrent    int           //volumes compacting at the same time in the cluster
+	MaxPerNode       int           //volumes compacting at the same time on one data node
+	QuietPeriod      time.Duration //skip volumes written within this period
+	Timeout          time.Duration //for each check, compact or commit call
+	Interval         time.Duration
+	HistorySize      int
+
+	thresholds map[storage.VolumeId]float64
+	history    []VacuumRecord
+	nodeLoad   map[string]int
+	running    bool
+
+	accessLock sync.Mutex
+}
+
+type vacuumCandidate struct {
+	vl          *VolumeLayout
+	vid         storage.VolumeId
+	locations   *VolumeLocationList //a copy, so replicas joining or leaving meanwhile do not change what is acquired and released
+	reclaimable uint64
+	size        uint64
+	threshold   float64
+}
+
+func NewVacuumScheduler(topo *Topology) *VacuumScheduler {
+	return &VacuumScheduler{
+		topo:             topo,
+		GarbageThreshold: 0.3,
+		MaxConcurrent:    2,
+		MaxPerNode:       1,
+		QuietPeriod:      5 * time.Minute,
+		Timeout:          30 * time.Minute,
+		Interval:         15 * time.Minute,
+		HistorySize:      DefaultVacuumHistorySize,
+		thresholds:       make(map[storage.VolumeId]float64),
+		nodeLoad:         make(map[string]int),
+	}
+}
+
+func (vs *VacuumScheduler) SetVolumeThreshold(vid storage.VolumeId, threshold float64) {
+	vs.accessLock.Lock()
+	defer vs.accessLock.Unlock()
+	if threshold <= 0 {
+		delete(vs.thresholds, vid)
+		return
+	}
+	vs.thresholds[vid] = threshold
+}
+
+func (vs *VacuumScheduler) threshold(vid storage.VolumeId) float64 {
+	vs.accessLock.Lock()
+	defer vs.accessLock.Unlock()
+	if t, ok := vs.thresholds[vid]; ok {
+		return t
+	}
+	return vs.GarbageThreshold
+}
+
+func (vs *VacuumScheduler) Start() {
+	go func() {
+		c := time.Tick(vs.Interval)
+		for _ = range c {
+			vs.Run(0)
+		}
+	}()
+}
+
+// Run vacuums all eligible volumes once and returns the number of volumes compacted.
+// A positive garbageThreshold overrides both the default and the per volume thresholds.
+func (vs *VacuumScheduler) Run(garbageThreshold float64) int {
+	vs.accessLock.Lock()
+	if vs.running {
+		vs.accessLock.Unlock()
+		return 0
+	}
+	vs.running = true
+	vs.accessLock.Unlock()
+	defer func() {
+		vs.accessLock.Lock()
+		vs.running = false
+		vs.accessLock.Unlock()
+	}()
+
+	pending := vs.collectCandidates(garbageThreshold)
+	maxConcurrent := vs.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	done := make(chan bool)
+	running, compacted := 0, 0
+	for len(pending) > 0 || running > 0 {
+		var rest []*vacuumCandidate
+		for _, c := range pending {
+			if running < maxConcurrent && vs.acquireNodes(c.locations) {
+				running++
+				go func(c *vacuumCandidate) {
+					ok := vs.vacuumOneVolume(c)
+					vs.releaseNodes(c.locations)
+					done <- ok
+				}(c)
+			} else {
+				rest = append(rest, c)
+			}
+		}
+		pending = rest
+		if running > 0 {
+			if <-done {
+				compacted++
+			}
+			running--
+		}
+	}
+	return compacted
+}
+
+func (vs *VacuumScheduler) collectCandidates(garbageThreshold float64) []*vacuumCandidate {
+	var candidates []*vacuumCandidate
+	quietSince := time.Now().Add(-vs.QuietPeriod).Unix()
+	vs.topo.accessLock.RLock()
+	defer vs.topo.accessLock.RUnlock()
+	for _, vl := range vs.topo.replicaType2VolumeLayout {
+		if vl == nil {
+			continue
+		}
+		for vid, locationlist := range vl.vid2location {
+			if locationlist.Length() == 0 {
+				continue
+			}
+			snapshot := &VolumeLocationList{list: append([]*DataNode(nil), locationlist.list...)}
+			c := &vacuumCandidate{vl: vl, vid: vid, locations: snapshot, threshold: garbageThreshold}
+			if c.threshold <= 0 {
+				c.threshold = vs.threshold(vid)
+			}
+			skip := false //recently written, or frozen as read only
+			for _, dn := range locationlist.list {
+				v, ok := dn.volumes[vid]
+				if !ok {
+					continue
+				}
+				if v.ModifiedAt > quietSince || v.ReadOnly {
+					skip = true
+				}
+				if v.DeletedByteCount > c.reclaimable {
+					c.reclaimable, c.size = v.DeletedByteCount, v.Size
+				}
+			}
+			if skip || c.reclaimable == 0 || c.size == 0 {
+				continue
+			}
+			if float64(c.reclaimable)/float64(c.size) < c.threshold {
+				continue
+			}
+			candidates = append(candidates, c)
+		}
+	}
+	sort.Sort(byReclaimable(candidates))
+	return candidates
+}
+
+func (vs *VacuumScheduler) acquireNodes(locations *VolumeLocationList) bool {
+	vs.accessLock.Lock()
+	defer vs.accessLock.Unlock()
+	if vs.MaxPerNode > 0 {
+		for _, dn := range locations.list {
+			if vs.nodeLoad[dn.Url()] >= vs.MaxPerNode {
+				return false
+			}
+		}
+	}
+	for _, dn := range locations.list {
+		vs.nodeLoad[dn.Url()]++
+	}
+	return true
+}
+
+func (vs *VacuumScheduler) releaseNodes(locations *VolumeLocationList) {
+	vs.accessLock.Lock()
+	defer vs.accessLock.Unlock()
+	for _, dn := range locations.list {
+		if vs.nodeLoad[dn.Url()]--; vs.nodeLoad[dn.Url()] <= 0 {
+			delete(vs.nodeLoad, dn.Url())
+		}
+	}
+}
+
+//compacts a volume if all replicas agree it needs it; failed checks are recorded
+//in the history like failed compactions, a replica not needing it is not.
+//A writable volume takes no writes while compacting, and is writable again after,
+//whether the compaction worked or not.
+func (vs *VacuumScheduler) vacuumOneVolume(c *vacuumCandidate) bool {
+	record := VacuumRecord{VolumeId: c.vid, Replication: c.vl.repType.String(), Start: time.Now()}
+	needed, err := batchVacuumVolumeCheck(c.vl, c.vid, c.locations, c.threshold, vs.Timeout)
+	if err == nil && !needed {
+		return false
+	}
+	if err == nil {
+		vs.topo.accessLock.Lock()
+		wasWritable := c.vl.removeFromWritable(c.vid)
+		vs.topo.accessLock.Unlock()
+		if err = batchVacuumVolumeCompact(c.vl, c.vid, c.locations, vs.Timeout); err == nil {
+			var newSize uint64
+			if newSize, err = batchVacuumVolumeCommit(c.vl, c.vid, c.locations); err == nil && newSize < c.size {
+				record.BytesReclaimed = int64(c.size - newSize)
+			}
+		}
+		if wasWritable {
+			vs.topo.accessLock.Lock()
+			if locations := c.vl.vid2location[c.vid]; locations != nil && locations.Length() >= c.vl.repType.GetCopyCount() {
+				c.vl.setVolumeWritable(c.vid)
+			}
+			vs.topo.accessLock.Unlock()
+		}
+	}
+	record.End = time.Now()
+	if err != nil {
+		record.Error = err.Error()
+		vacuumResults.Inc("failure")
+	} else {
+		vacuumResults.Inc("success")
+		vacuumReclaimed.Add(float64(record.BytesReclaimed))
+	}
+	vs.addHistory(record)
+	return err == nil
+}
+
+func (vs *VacuumScheduler) addHistory(record VacuumRecord) {
+	vs.accessLock.Lock()
+	defer vs.accessLock.Unlock()
+	vs.history = append(vs.history, record)
+	if vs.HistorySize > 0 && len(vs.history) > vs.HistorySize {
+		vs.history = vs.history[len(vs.history)-vs.HistorySize:]
+	}
+}
+
+// History returns vacuum records, most recent first, optionally only for one volume.
+func (vs *VacuumScheduler) History(vid *storage.VolumeId, limit int) []VacuumRecord {
+	vs.accessLock.Lock()
+	defer vs.accessLock.Unlock()
+	var ret []VacuumRecord
+	for i := len(vs.history) - 1; i >= 0; i-- {
+		if vid != nil && vs.history[i].VolumeId != *vid {
+			continue
+		}
+		ret = append(ret, vs.history[i])
+		if limit > 0 && len(ret) >= limit {
+			break
+		}
+	}
+	return ret
+}
+
+func (vs *VacuumScheduler) ToMap() interface{} {
+	vs.accessLock.Lock()
+	m := make(map[string]interface{})
+	m["GarbageThreshold"] = vs.GarbageThreshold
+	m["MaxConcurrent"] = vs.MaxConcurrent
+	m["MaxPerNode"] = vs.MaxPerNode
+	m["QuietPeriod"] = vs.QuietPeriod.String()
+	m["Running"] = vs.running
+	thresholds := make(map[string]float64)
+	for vid, t := range vs.thresholds {
+		thresholds[vid.String()] = t
+	}
+	m["VolumeThresholds"] = thresholds
+	nodeLoad := make(map[string]int)
+	for url, n := range vs.nodeLoad {
+		nodeLoad[url] = n
+	}
+	m["Compacting"] = nodeLoad
+	vs.accessLock.Unlock()
+	return m
+}
+
+type byReclaimable []*vacuumCandidate
+
+func (s byReclaimable) Len() int           { return len(s) }
+func (s byReclaimable) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byReclaimable) Less(i, j int) bool { return s[i].reclaimable > s[j].reclaimable }
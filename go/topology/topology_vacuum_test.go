@@ -0,0 +1,190 @@
+package topology
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"code.google.com/p/weed-fs/go/storage"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDataNode(hostPort string) *DataNode {
+	host, port, _ := net.SplitHostPort(hostPort)
+	dn := NewDataNode(hostPort)
+	dn.Ip = host
+	dn.Port, _ = strconv.Atoi(port)
+	return dn
+}
+
+//a layout with one volume of replication 001 on the nodes, half of it garbage
+func newVacuumTestLayout(nodes ...*DataNode) (*Topology, *VolumeLayout) {
+	vl := NewVolumeLayout(storage.Copy001, 1<<20, 5)
+	v := storage.VolumeInfo{Id: 1, Size: 1000, DeletedByteCount: 500, RepType: storage.Copy001, Version: storage.CurrentVersion}
+	for _, dn := range nodes {
+		dn.AddOrUpdateVolume(v)
+		vl.RegisterVolume(&v, dn)
+	}
+	return &Topology{replicaType2VolumeLayout: []*VolumeLayout{vl}}, vl
+}
+
+func TestVacuumReleasesAcquiredNodes(t *testing.T) {
+	a, b := newTestDataNode("127.0.0.1:1"), newTestDataNode("127.0.0.1:2")
+	topo, vl := newVacuumTestLayout(a, b)
+	vs := NewVacuumScheduler(topo)
+	candidates := vs.collectCandidates(0.3)
+	if len(candidates) != 1 {
+		t.Fatalf("got %d candidates, want 1", len(candidates))
+	}
+	c := candidates[0]
+	if !vs.acquireNodes(c.locations) {
+		t.Fatal("failed to acquire idle nodes")
+	}
+	//a replica moves while the volume is compacting
+	vl.vid2location[1].Remove(a)
+	vl.vid2location[1].Add(newTestDataNode("127.0.0.1:3"))
+	vs.releaseNodes(c.locations)
+	if len(vs.nodeLoad) != 0 {
+		t.Errorf("node load left after release: %v", vs.nodeLoad)
+	}
+}
+
+func TestVacuumMaxPerNode(t *testing.T) {
+	a, b, c := newTestDataNode("127.0.0.1:1"), newTestDataNode("127.0.0.1:2"), newTestDataNode("127.0.0.1:3")
+	vs := NewVacuumScheduler(nil)
+	first := &VolumeLocationList{list: []*DataNode{a, b}}
+	second := &VolumeLocationList{list: []*DataNode{b, c}}
+	if !vs.acquireNodes(first) {
+		t.Fatal("failed to acquire idle nodes")
+	}
+	if vs.acquireNodes(second) {
+		t.Fatal("acquired a node compacting another volume already")
+	}
+	if vs.nodeLoad[c.Url()] != 0 {
+		t.Errorf("failed acquire left load on %s", c.Url())
+	}
+	vs.releaseNodes(first)
+	if !vs.acquireNodes(second) {
+		t.Fatal("failed to acquire released nodes")
+	}
+}
+
+func TestVacuumHistoryRecordsFailures(t *testing.T) {
+	unblock := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//the threshold of the check picks the answer
+		switch r.FormValue("garbageThreshold") {
+		case "0.1":
+			w.Write([]byte(`{"Error":"disk failure"}`))
+		case "0.2":
+			w.Write([]byte(`{"Result":false}`))
+		default:
+			<-unblock
+		}
+	}))
+	defer server.Close()
+	defer close(unblock) //before closing the server, which waits for the blocked check
+	u, _ := url.Parse(server.URL)
+	dn := newTestDataNode(u.Host)
+	topo, vl := newVacuumTestLayout(dn)
+	vs := NewVacuumScheduler(topo)
+	vs.Timeout = 50 * time.Millisecond
+
+	for _, test := range []struct {
+		threshold float64
+		error     string
+	}{
+		{0.1, "disk failure"},
+		{0.2, ""},
+		{0.3, "timed out"},
+	} {
+		before := len(vs.History(nil, 0))
+		c := &vacuumCandidate{vl: vl, vid: 1, locations: vl.vid2location[1], threshold: test.threshold, size: 1000}
+		if vs.vacuumOneVolume(c) {
+			t.Errorf("threshold %v: compacted", test.threshold)
+		}
+		history := vs.History(nil, 0)
+		if test.error == "" {
+			if len(history) != before {
+				t.Errorf("threshold %v: recorded a volume not needing vacuum: %+v", test.threshold, history[0])
+			}
+			continue
+		}
+		if len(history) != before+1 || !strings.Contains(history[0].Error, test.error) {
+			t.Errorf("threshold %v: history %+v, want an error with %q", test.threshold, history, test.error)
+		}
+	}
+}
+
+func TestVacuumFailureKeepsVolumeWritable(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/admin/vacuum_volume_check") {
+			w.Write([]byte(`{"Result":true}`))
+		} else {
+			w.Write([]byte(`{"Error":"disk failure"}`))
+		}
+	})
+	var nodes []*DataNode
+	for i := 0; i < 2; i++ {
+		server := httptest.NewServer(handler)
+		defer server.Close()
+		u, _ := url.Parse(server.URL)
+		nodes = append(nodes, newTestDataNode(u.Host))
+	}
+	topo, vl := newVacuumTestLayout(nodes...)
+	if vl.GetActiveVolumeCount() != 1 {
+		t.Fatal("the volume should be writable before the vacuum")
+	}
+	c := &vacuumCandidate{vl: vl, vid: 1, locations: vl.vid2location[1], threshold: 0.3, size: 1000}
+	if NewVacuumScheduler(topo).vacuumOneVolume(c) {
+		t.Fatal("a failed compaction should not count as compacted")
+	}
+	if vl.GetActiveVolumeCount() != 1 {
+		t.Fatal("the volume should be writable again after a failed compaction")
+	}
+}
+
+func TestVacuumHistory(t *testing.T) {
+	vs := NewVacuumScheduler(nil)
+	vs.HistorySize = 3
+	for i := 1; i <= 4; i++ {
+		vs.addHistory(VacuumRecord{VolumeId: storage.VolumeId(i % 2)})
+	}
+	all := vs.History(nil, 0)
+	if len(all) != 3 {
+		t.Fatalf("kept %d records, want 3", len(all))
+	}
+	if all[0].VolumeId != 0 || all[1].VolumeId != 1 {
+		t.Errorf("records not most recent first: %+v", all)
+	}
+	vid := storage.VolumeId(1)
+	if ones := vs.History(&vid, 0); len(ones) != 1 {
+		t.Errorf("got %d records of volume 1, want 1", len(ones))
+	}
+	if limited := vs.History(nil, 2); len(limited) != 2 {
+		t.Errorf("got %d records with limit 2", len(limited))
+	}
+}
+
+func TestVacuumLowDiskSpaceVolume(t *testing.T) {
+	a, b := newTestDataNode("127.0.0.1:1"), newTestDataNode("127.0.0.1:2")
+	topo, vl := newVacuumTestLayout(a, b)
+	v := storage.VolumeInfo{Id: 1, Size: 1000, DeletedByteCount: 500, RepType: storage.Copy001, Version: storage.CurrentVersion, LowDiskSpace: true}
+	a.AddOrUpdateVolume(v)
+	vl.RegisterVolume(&v, a)
+	if vl.GetActiveVolumeCount() != 0 || vl.IsReadOnly(1) {
+		t.Fatal("a volume low on disk space should only leave the writables")
+	}
+	if candidates := NewVacuumScheduler(topo).collectCandidates(0.3); len(candidates) != 1 {
+		t.Fatalf("got %d candidates, want the volume low on disk space", len(candidates))
+	}
+	v.LowDiskSpace = false
+	a.AddOrUpdateVolume(v)
+	vl.RegisterVolume(&v, a)
+	if vl.GetActiveVolumeCount() != 1 {
+		t.Fatal("the volume should be writable again once the disk has space")
+	}
+}
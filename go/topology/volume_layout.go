@@ -1,18 +1,22 @@
 package topology
 
 import (
+	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/wlog"
 	"errors"
-	"fmt"
 	"math/rand"
-	"code.google.com/p/weed-fs/go/storage"
 )
 
 type VolumeLayout struct {
 	repType         storage.ReplicationType
 	vid2location    map[storage.VolumeId]*VolumeLocationList
-	writables       []storage.VolumeId // transient array of writable volume id
+	writables       []storage.VolumeId        // transient array of writable volume id
+	readonlyVolumes map[storage.VolumeId]bool // volumes with at least one read only replica
+	lowDiskVolumes  map[storage.VolumeId]bool // volumes with at least one replica on a disk low on space
 	pulse           int64
 	volumeSizeLimit uint64
+
+	locationsChanged func(vid storage.VolumeId) //called when a replica is added or removed
 }
 
 func NewVolumeLayout(repType storage.ReplicationType, volumeSizeLimit uint64, pulse int64) *VolumeLayout {
@@ -20,6 +24,8 @@ func NewVolumeLayout(repType storage.ReplicationType, volumeSizeLimit uint64, pu
 		repType:         repType,
 		vid2location:    make(map[storage.VolumeId]*VolumeLocationList),
 		writables:       *new([]storage.VolumeId),
+		readonlyVolumes: make(map[storage.VolumeId]bool),
+		lowDiskVolumes:  make(map[storage.VolumeId]bool),
 		pulse:           pulse,
 		volumeSizeLimit: volumeSizeLimit,
 	}
@@ -30,26 +36,70 @@ func (vl *VolumeLayout) RegisterVolume(v *storage.VolumeInfo, dn *DataNode) {
 		vl.vid2location[v.Id] = NewVolumeLocationList()
 	}
 	if vl.vid2location[v.Id].Add(dn) {
+		vl.notifyLocations(v.Id)
 		if len(vl.vid2location[v.Id].list) == v.RepType.GetCopyCount() {
 			if vl.isWritable(v) {
 				vl.writables = append(vl.writables, v.Id)
 			}
 		}
 	}
+	vl.updateReadOnly(v)
+}
+
+func (vl *VolumeLayout) notifyLocations(vid storage.VolumeId) {
+	if vl.locationsChanged != nil {
+		vl.locationsChanged(vid)
+	}
 }
 
 func (vl *VolumeLayout) isWritable(v *storage.VolumeInfo) bool {
-	return uint64(v.Size) < vl.volumeSizeLimit && v.Version == storage.CurrentVersion
+	return uint64(v.Size) < vl.volumeSizeLimit && v.Version == storage.CurrentVersion && !v.ReadOnly && !v.LowDiskSpace
+}
+
+//a volume stays out of the writables while any of its replicas is read only or on a disk low on space
+func (vl *VolumeLayout) updateReadOnly(v *storage.VolumeInfo) {
+	readOnly, lowDiskSpace := false, false
+	for _, dn := range vl.vid2location[v.Id].list {
+		if info, ok := dn.volumes[v.Id]; ok {
+			readOnly = readOnly || info.ReadOnly
+			lowDiskSpace = lowDiskSpace || info.LowDiskSpace
+		}
+	}
+	blocked := vl.readonlyVolumes[v.Id] || vl.lowDiskVolumes[v.Id]
+	if readOnly {
+		vl.readonlyVolumes[v.Id] = true
+	} else {
+		delete(vl.readonlyVolumes, v.Id)
+	}
+	if lowDiskSpace {
+		vl.lowDiskVolumes[v.Id] = true
+	} else {
+		delete(vl.lowDiskVolumes, v.Id)
+	}
+	if readOnly || lowDiskSpace {
+		vl.removeFromWritable(v.Id)
+	} else if blocked {
+		if vl.vid2location[v.Id].Length() >= vl.repType.GetCopyCount() && vl.isWritable(v) {
+			vl.setVolumeWritable(v.Id)
+		}
+	}
+}
+
+func (vl *VolumeLayout) IsReadOnly(vid storage.VolumeId) bool {
+	return vl.readonlyVolumes[vid]
 }
 
 func (vl *VolumeLayout) Lookup(vid storage.VolumeId) []*DataNode {
-	return vl.vid2location[vid].list
+	if location := vl.vid2location[vid]; location != nil {
+		return location.list
+	}
+	return nil
 }
 
 func (vl *VolumeLayout) PickForWrite(count int) (*storage.VolumeId, int, *VolumeLocationList, error) {
 	len_writers := len(vl.writables)
 	if len_writers <= 0 {
-		fmt.Println("No more writable volumes!")
+		wlog.Warn("no more writable volumes", "replication", vl.repType)
 		return nil, 0, nil, errors.New("No more writable volumes!")
 	}
 	vid := vl.writables[rand.Intn(len_writers)]
@@ -60,6 +110,10 @@ func (vl *VolumeLayout) PickForWrite(count int) (*storage.VolumeId, int, *Volume
 	return nil, 0, nil, errors.New("Strangely vid " + vid.String() + " is on no machine!")
 }
 
+func (vl *VolumeLayout) ReplicationType() storage.ReplicationType {
+	return vl.repType
+}
+
 func (vl *VolumeLayout) GetActiveVolumeCount() int {
 	return len(vl.writables)
 }
@@ -67,7 +121,7 @@ func (vl *VolumeLayout) GetActiveVolumeCount() int {
 func (vl *VolumeLayout) removeFromWritable(vid storage.VolumeId) bool {
 	for i, v := range vl.writables {
 		if v == vid {
-			fmt.Println("Volume", vid, "becomes unwritable")
+			wlog.Info("volume becomes unwritable", "volume", vid)
 			vl.writables = append(vl.writables[:i], vl.writables[i+1:]...)
 			return true
 		}
@@ -75,20 +129,24 @@ func (vl *VolumeLayout) removeFromWritable(vid storage.VolumeId) bool {
 	return false
 }
 func (vl *VolumeLayout) setVolumeWritable(vid storage.VolumeId) bool {
+	if vl.readonlyVolumes[vid] || vl.lowDiskVolumes[vid] {
+		return false
+	}
 	for _, v := range vl.writables {
 		if v == vid {
 			return false
 		}
 	}
-	fmt.Println("Volume", vid, "becomes writable")
+	wlog.Info("volume becomes writable", "volume", vid)
 	vl.writables = append(vl.writables, vid)
 	return true
 }
 
 func (vl *VolumeLayout) SetVolumeUnavailable(dn *DataNode, vid storage.VolumeId) bool {
 	if vl.vid2location[vid].Remove(dn) {
+		vl.notifyLocations(vid)
 		if vl.vid2location[vid].Length() < vl.repType.GetCopyCount() {
-			fmt.Println("Volume", vid, "has", vl.vid2location[vid].Length(), "replica, less than required", vl.repType.GetCopyCount())
+			wlog.Warn("volume has fewer replicas than required", "volume", vid, "replicas", vl.vid2location[vid].Length(), "required", vl.repType.GetCopyCount())
 			return vl.removeFromWritable(vid)
 		}
 	}
@@ -96,6 +154,7 @@ func (vl *VolumeLayout) SetVolumeUnavailable(dn *DataNode, vid storage.VolumeId)
 }
 func (vl *VolumeLayout) SetVolumeAvailable(dn *DataNode, vid storage.VolumeId) bool {
 	if vl.vid2location[vid].Add(dn) {
+		vl.notifyLocations(vid)
 		if vl.vid2location[vid].Length() >= vl.repType.GetCopyCount() {
 			return vl.setVolumeWritable(vid)
 		}
@@ -103,6 +162,20 @@ func (vl *VolumeLayout) SetVolumeAvailable(dn *DataNode, vid storage.VolumeId) b
 	return false
 }
 
+func (vl *VolumeLayout) UnRegisterVolume(vid storage.VolumeId, dn *DataNode) bool {
+	locations := vl.vid2location[vid]
+	if locations == nil || !locations.Remove(dn) {
+		return false
+	}
+	vl.notifyLocations(vid)
+	if locations.Length() == 0 {
+		delete(vl.vid2location, vid)
+		delete(vl.readonlyVolumes, vid)
+		delete(vl.lowDiskVolumes, vid)
+	}
+	return true
+}
+
 func (vl *VolumeLayout) SetVolumeCapacityFull(vid storage.VolumeId) bool {
 	return vl.removeFromWritable(vid)
 }
@@ -111,6 +184,11 @@ func (vl *VolumeLayout) ToMap() interface{} {
 	m := make(map[string]interface{})
 	m["replication"] = vl.repType.String()
 	m["writables"] = vl.writables
+	var readonlyVolumes []storage.VolumeId
+	for vid := range vl.readonlyVolumes {
+		readonlyVolumes = append(readonlyVolumes, vid)
+	}
+	m["readonly"] = readonlyVolumes
 	//m["locations"] = vl.vid2location
 	return m
 }
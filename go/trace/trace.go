@@ -0,0 +1,93 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"code.google.com/p/weed-fs/go/wlog"
+	"time"
+)
+
+// RequestIdHeader carries the request id from the client through the master,
+// the volume server and the replica calls, and back in the responses.
+const RequestIdHeader = "X-Request-Id"
+
+//spans slower than this are logged as warnings, others at debug level
+var SlowSpan = time.Second
+
+func NewRequestId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//the id of an incoming request, a new one if the caller did not send any
+func RequestId(r *http.Request) string {
+	if id := r.Header.Get(RequestIdHeader); id != "" {
+		return id
+	}
+	return NewRequestId()
+}
+
+//sets the request id header on an outgoing request, if there is an id
+func SetRequestId(r *http.Request, requestId string) {
+	if requestId != "" {
+		r.Header.Set(RequestIdHeader, requestId)
+	}
+}
+
+type contextKey struct{}
+
+//carries the request id through a client library call, to all its requests
+func NewContext(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestId)
+}
+
+//the request id of the context, a new one if it has none
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKey{}).(string); ok && id != "" {
+		return id
+	}
+	return NewRequestId()
+}
+
+// Span times one hop of a request, e.g. a handler or a call to another server.
+type Span struct {
+	name  string
+	start time.Time
+	log   *wlog.Logger
+}
+
+func StartSpan(requestId string, name string, keyvals ...interface{}) *Span {
+	if requestId != "" {
+		keyvals = append([]interface{}{"requestId", requestId}, keyvals...)
+	}
+	return &Span{name: name, start: time.Now(), log: wlog.With(keyvals...)}
+}
+
+//logs the span with its duration, and the keyvals describing its outcome
+func (s *Span) End(keyvals ...interface{}) time.Duration {
+	d := time.Since(s.start)
+	keyvals = append([]interface{}{"ms", float64(d.Nanoseconds()) / 1e6}, keyvals...)
+	if d >= SlowSpan {
+		s.log.Warn("slow span "+s.name, keyvals...)
+	} else {
+		s.log.Debug("span "+s.name, keyvals...)
+	}
+	return d
+}
+
+// Wrap makes sure each request to fn has a request id, visible to fn in the
+// request header and returned to the client in the response header, and logs
+// the time fn took as a span.
+func Wrap(name string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestId := RequestId(r)
+		r.Header.Set(RequestIdHeader, requestId)
+		w.Header().Set(RequestIdHeader, requestId)
+		span := StartSpan(requestId, name, "method", r.Method, "path", r.URL.Path)
+		fn(w, r)
+		span.End()
+	}
+}
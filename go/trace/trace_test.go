@@ -0,0 +1,29 @@
+package trace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrapRequestId(t *testing.T) {
+	var seen string
+	h := Wrap("test", func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get(RequestIdHeader)
+	})
+
+	r, _ := http.NewRequest("GET", "/1,01637037d6", nil)
+	w := httptest.NewRecorder()
+	h(w, r)
+	if seen == "" || w.Header().Get(RequestIdHeader) != seen {
+		t.Fatal("generated request id", seen, w.Header().Get(RequestIdHeader))
+	}
+
+	r, _ = http.NewRequest("GET", "/1,01637037d6", nil)
+	r.Header.Set(RequestIdHeader, "abc")
+	w = httptest.NewRecorder()
+	h(w, r)
+	if seen != "abc" || w.Header().Get(RequestIdHeader) != "abc" {
+		t.Fatal("incoming request id", seen, w.Header().Get(RequestIdHeader))
+	}
+}
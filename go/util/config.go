@@ -11,8 +11,8 @@ package util
 import (
 	"bytes"
 	"encoding/json"
-	"log"
 	"os"
+	"code.google.com/p/weed-fs/go/wlog"
 )
 
 type Config struct {
@@ -32,7 +32,7 @@ func LoadConfig(filename string) *Config {
 	result.filename = filename
 	err := result.parse()
 	if err != nil {
-		log.Fatalf("error loading config file %s: %s", filename, err)
+		wlog.Fatal("error loading config file", "file", filename, "error", err)
 	}
 	return result
 }
@@ -42,7 +42,7 @@ func LoadConfigString(s string) *Config {
 	result := newConfig()
 	err := json.Unmarshal([]byte(s), &result.data)
 	if err != nil {
-		log.Fatalf("error parsing config string %s: %s", s, err)
+		wlog.Fatal("error parsing config string", "config", s, "error", err)
 	}
 	return result
 }
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"code.google.com/p/weed-fs/go/security"
+	"code.google.com/p/weed-fs/go/wlog"
+)
+
+//downloads the url into the file, the file is removed if the download fails.
+//the request is signed like admin posts, as it is used to copy volume files.
+func GetToFile(url string, fileName string) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	security.Sign(req, nil)
+	r, err := Do(req)
+	if err != nil {
+		wlog.Warn("get failed", "url", url, "error", err)
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return errors.New("get " + url + ": " + r.Status)
+	}
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r.Body); err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(fileName)
+	}
+	return err
+}
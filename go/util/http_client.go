@@ -0,0 +1,110 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+//the scheme and client of all requests to masters and volume servers.
+//a cluster runs either with TLS on all servers or on none.
+var (
+	scheme     = "http"
+	client     = http.DefaultClient
+	clientLock sync.RWMutex
+)
+
+//https once SetupTLS was called, http otherwise
+func Scheme() string {
+	clientLock.RLock()
+	defer clientLock.RUnlock()
+	return scheme
+}
+
+func HttpClient() *http.Client {
+	clientLock.RLock()
+	defer clientLock.RUnlock()
+	return client
+}
+
+func Do(req *http.Request) (*http.Response, error) {
+	return HttpClient().Do(req)
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificate found in " + caFile)
+	}
+	return pool, nil
+}
+
+// SetupTLS switches all requests to https. Servers are verified with the certificates
+// in caFile, or the system ones if it is empty. With certFile and keyFile, the requests
+// also present that certificate, for servers requiring mutual TLS.
+func SetupTLS(caFile, certFile, keyFile string) error {
+	config := &tls.Config{}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return err
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	clientLock.Lock()
+	scheme = "https"
+	client = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: config}}
+	clientLock.Unlock()
+	return nil
+}
+
+// ServerTLSConfig is the config of a server listening with TLS. With verifyClient,
+// only clients presenting a certificate signed by one in caFile are accepted.
+func ServerTLSConfig(caFile string, verifyClient bool) (*tls.Config, error) {
+	config := &tls.Config{}
+	if verifyClient {
+		if caFile == "" {
+			return nil, errors.New("verifying client certificates needs a CA certificate")
+		}
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+//the url of a server given as host:port, with the scheme in use. urls already
+//carrying a scheme, like the ones returned by a master running TLS, are kept.
+func NormalizeUrl(server string) string {
+	if strings.Contains(server, "://") {
+		return server
+	}
+	return Scheme() + "://" + server
+}
+
+//the location of a server returned to clients. plain http locations are host:port
+//like before, so existing clients keep working; https ones carry their scheme.
+func LocationUrl(server string) string {
+	if Scheme() == "http" {
+		return server
+	}
+	return NormalizeUrl(server)
+}
@@ -1,23 +1,78 @@
 package util
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"code.google.com/p/weed-fs/go/security"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/wlog"
+	"strings"
+	"time"
 )
 
 func Post(url string, values url.Values) ([]byte, error) {
-	r, err := http.PostForm(url, values)
+	return PostWithRequestId(url, values, "")
+}
+
+//posts the form with the request id header, so the receiving server logs under the same id
+func PostWithRequestId(url string, values url.Values, requestId string) ([]byte, error) {
+	req, err := http.NewRequest("POST", url, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	trace.SetRequestId(req, requestId)
+	span := trace.StartSpan(requestId, "post", "url", url)
+	r, err := Do(req)
 	if err != nil {
-		log.Println("post to", url, err)
+		span.End("error", err)
+		wlog.Warn("post failed", "url", url, "error", err)
 		return nil, err
 	}
 	defer r.Body.Close()
 	b, err := ioutil.ReadAll(r.Body)
+	span.End("status", r.StatusCode)
 	if err != nil {
-		log.Println("read post result from", url, err)
+		wlog.Warn("failed to read post result", "url", url, "error", err)
 		return nil, err
 	}
 	return b, nil
 }
+
+//posts an admin request, signed with the shared secret if one is configured
+func AdminPost(url string, values url.Values) ([]byte, error) {
+	return AdminPostWithTimeout(url, values, 0)
+}
+
+//a timeout of 0 waits as long as the http client does
+func AdminPostWithTimeout(url string, values url.Values, timeout time.Duration) ([]byte, error) {
+	req, err := http.NewRequest("POST", url, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	security.Sign(req, values)
+	r, err := Do(req)
+	if err != nil {
+		wlog.Warn("post failed", "url", url, "error", err)
+		return nil, err
+	}
+	defer r.Body.Close()
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		wlog.Warn("failed to read post result", "url", url, "error", err)
+		return nil, err
+	}
+	if r.StatusCode == http.StatusUnauthorized {
+		return nil, errors.New("unauthorized post to " + url + ": " + string(b))
+	}
+	return b, nil
+}
@@ -0,0 +1,434 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"code.google.com/p/weed-fs/go/filer"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/wlog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxXmlBody = 1 << 20
+
+var lockTokenRegexp = regexp.MustCompile(`<(opaquelocktoken:[^>]+)>`)
+
+// Handler serves the WebDAV methods of class 1 and 2 on the namespace of a filer.
+// Locks are kept in memory, by the handler.
+type Handler struct {
+	Filer *filer.Filer
+	locks *lockManager
+}
+
+func NewHandler(f *filer.Filer) *Handler {
+	return &Handler{Filer: f, locks: newLockManager()}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := filer.CleanPath(r.URL.Path)
+	var status int
+	var err error
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("DAV", "1, 2")
+		w.Header().Set("MS-Author-Via", "DAV")
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, MKCOL, COPY, MOVE, PROPFIND, PROPPATCH, LOCK, UNLOCK")
+	case "GET", "HEAD":
+		status, err = h.handleGet(w, r, p)
+	case "PUT":
+		status, err = h.handlePut(w, r, p)
+	case "DELETE":
+		status, err = h.handleDelete(w, r, p)
+	case "MKCOL":
+		status, err = h.handleMkcol(w, r, p)
+	case "COPY", "MOVE":
+		status, err = h.handleCopyMove(w, r, p)
+	case "PROPFIND":
+		status, err = h.handlePropfind(w, r, p)
+	case "PROPPATCH":
+		status, err = h.handleProppatch(w, r, p)
+	case "LOCK":
+		status, err = h.handleLock(w, r, p)
+	case "UNLOCK":
+		status, err = h.handleUnlock(w, r, p)
+	default:
+		status = http.StatusMethodNotAllowed
+	}
+	if status == 0 {
+		return
+	}
+	if status >= http.StatusInternalServerError {
+		wlog.Error("webdav request failed", "method", r.Method, "path", p, "requestId", trace.RequestId(r), "error", err)
+	}
+	msg := http.StatusText(status)
+	if err != nil {
+		msg = err.Error()
+	}
+	http.Error(w, msg, status)
+}
+
+//the context of the filer operations, carrying the request id to the master and volume servers
+func requestContext(r *http.Request) context.Context {
+	return trace.NewContext(r.Context(), trace.RequestId(r))
+}
+
+func statusOf(err error) int {
+	switch err {
+	case filer.ErrNotFound:
+		return http.StatusNotFound
+	case filer.ErrExists, filer.ErrNotDirectory, filer.ErrNotEmpty:
+		return http.StatusConflict
+	case filer.ErrIsDirectory:
+		return http.StatusMethodNotAllowed
+	case filer.ErrInvalidPath:
+		return http.StatusForbidden
+	case errLocked:
+		return http.StatusLocked
+	}
+	return http.StatusInternalServerError
+}
+
+//the lock tokens submitted in the If header
+func ifTokens(r *http.Request) []string {
+	tokens := []string{}
+	for _, m := range lockTokenRegexp.FindAllStringSubmatch(r.Header.Get("If"), -1) {
+		tokens = append(tokens, m[1])
+	}
+	return tokens
+}
+
+//decodes the xml body of the request if any, v is left as is without a body
+func readXml(r *http.Request, v interface{}) error {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxXmlBody))
+	if err != nil || len(strings.TrimSpace(string(data))) == 0 {
+		return err
+	}
+	return xml.Unmarshal(data, v)
+}
+
+func contentType(e *filer.Entry) string {
+	if e.Mime != "" {
+		return e.Mime
+	}
+	if t := mime.TypeByExtension(path.Ext(e.FullPath)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+//whether the parent collection of p exists, new members can only be created in one
+func (h *Handler) parentExists(p string) bool {
+	parent, err := h.Filer.Get(path.Dir(p))
+	return err == nil && parent.IsDirectory
+}
+
+//whether changing p, and with subtree anything below it, is allowed by the locks.
+//adding or removing p changes the members of its parent, which may be locked too.
+func (h *Handler) unlocked(r *http.Request, p string, subtree bool, membership bool) bool {
+	tokens := ifTokens(r)
+	if !h.locks.confirm(p, subtree, tokens) {
+		return false
+	}
+	return !membership || p == "/" || h.locks.confirm(path.Dir(p), false, tokens)
+}
+
+func (h *Handler) handleGet(w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	e, err := h.Filer.Get(p)
+	if err != nil {
+		return statusOf(err), err
+	}
+	if e.IsDirectory {
+		return h.listDirectory(w, r, e)
+	}
+	w.Header().Set("ETag", etag(e))
+	w.Header().Set("Content-Type", contentType(e))
+	content := h.Filer.Open(requestContext(r), e)
+	defer content.Close()
+	http.ServeContent(w, r, e.Name(), e.Mtime, content)
+	return 0, nil
+}
+
+//a plain html page of the members of a collection, for browsers
+func (h *Handler) listDirectory(w http.ResponseWriter, r *http.Request, e *filer.Entry) (int, error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == "HEAD" {
+		return 0, nil
+	}
+	fmt.Fprintf(w, "<html><head><title>%s</title></head><body><h1>%s</h1><ul>\n", html.EscapeString(e.FullPath), html.EscapeString(e.FullPath))
+	startAfter := ""
+	for {
+		children, err := h.Filer.List(e.FullPath, startAfter, 1024)
+		if err != nil {
+			//the status is sent already, the client sees a short page
+			wlog.Error("failed to list directory", "path", e.FullPath, "error", err)
+			return 0, nil
+		}
+		if len(children) == 0 {
+			break
+		}
+		for _, child := range children {
+			name := child.Name()
+			if child.IsDirectory {
+				name += "/"
+			}
+			fmt.Fprintf(w, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString((&url.URL{Path: name}).EscapedPath()), html.EscapeString(name))
+		}
+		startAfter = children[len(children)-1].Name()
+	}
+	fmt.Fprint(w, "</ul></body></html>\n")
+	return 0, nil
+}
+
+func (h *Handler) handlePut(w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	old, err := h.Filer.Get(p)
+	if err != nil && err != filer.ErrNotFound {
+		return statusOf(err), err
+	}
+	if old != nil && old.IsDirectory {
+		return http.StatusMethodNotAllowed, filer.ErrIsDirectory
+	}
+	if old == nil && !h.parentExists(p) {
+		return http.StatusConflict, nil
+	}
+	if !h.unlocked(r, p, false, old == nil) {
+		return http.StatusLocked, errLocked
+	}
+	entry := &filer.Entry{FullPath: p, Mime: r.Header.Get("Content-Type")}
+	if entry.Mime == "" {
+		entry.Mime = mime.TypeByExtension(path.Ext(p))
+	}
+	if old != nil {
+		//the dead properties stay with the new content
+		entry.Extended = old.Extended
+	}
+	if err = h.Filer.WriteFile(requestContext(r), entry, r.Body); err != nil {
+		return statusOf(err), err
+	}
+	w.Header().Set("ETag", etag(entry))
+	if old != nil {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	return 0, nil
+}
+
+func (h *Handler) handleMkcol(w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	if r.ContentLength != 0 {
+		return http.StatusUnsupportedMediaType, nil
+	}
+	if _, err := h.Filer.Get(p); err == nil {
+		return http.StatusMethodNotAllowed, filer.ErrExists
+	} else if err != filer.ErrNotFound {
+		return statusOf(err), err
+	}
+	if !h.parentExists(p) {
+		return http.StatusConflict, nil
+	}
+	if !h.unlocked(r, p, false, true) {
+		return http.StatusLocked, errLocked
+	}
+	if err := h.Filer.Mkdir(p); err != nil {
+		return statusOf(err), err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return 0, nil
+}
+
+func (h *Handler) handleDelete(w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	if _, err := h.Filer.Get(p); err != nil {
+		return statusOf(err), err
+	}
+	if !h.unlocked(r, p, true, true) {
+		return http.StatusLocked, errLocked
+	}
+	if err := h.Filer.Delete(requestContext(r), p, true); err != nil {
+		return statusOf(err), err
+	}
+	h.locks.remove(p)
+	w.WriteHeader(http.StatusNoContent)
+	return 0, nil
+}
+
+// handleCopyMove copies or moves a resource to the Destination header. An existing
+// destination is replaced, unless the Overwrite header is F.
+func (h *Handler) handleCopyMove(w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || u.Path == "" {
+		return http.StatusBadRequest, fmt.Errorf("invalid destination %q", r.Header.Get("Destination"))
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return http.StatusBadGateway, fmt.Errorf("destination %q is on another server", u.Host)
+	}
+	dest := filer.CleanPath(u.Path)
+	//overwriting an ancestor would delete the source first, and a copy into
+	//the source itself never ends
+	if filer.IsUnder(dest, p) || filer.IsUnder(p, dest) {
+		return http.StatusForbidden, filer.ErrInvalidPath
+	}
+	e, err := h.Filer.Get(p)
+	if err != nil {
+		return statusOf(err), err
+	}
+	move := r.Method == "MOVE"
+	if move && !h.unlocked(r, p, true, true) {
+		return http.StatusLocked, errLocked
+	}
+	if !h.parentExists(dest) {
+		return http.StatusConflict, nil
+	}
+	_, err = h.Filer.Get(dest)
+	exists := err == nil
+	if err != nil && err != filer.ErrNotFound {
+		return statusOf(err), err
+	}
+	if !h.unlocked(r, dest, true, !exists) {
+		return http.StatusLocked, errLocked
+	}
+	if exists {
+		if r.Header.Get("Overwrite") == "F" {
+			return http.StatusPreconditionFailed, filer.ErrExists
+		}
+		if err = h.Filer.Delete(requestContext(r), dest, true); err != nil {
+			return statusOf(err), err
+		}
+	}
+	switch {
+	case move:
+		if err = h.Filer.Rename(p, dest); err == nil {
+			h.locks.remove(p)
+		}
+	case e.IsDirectory && r.Header.Get("Depth") == "0":
+		err = h.Filer.CreateEntry(requestContext(r), &filer.Entry{FullPath: dest, IsDirectory: true, Extended: e.Extended})
+	default:
+		err = h.Filer.Copy(requestContext(r), p, dest)
+	}
+	if err != nil {
+		return statusOf(err), err
+	}
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	return 0, nil
+}
+
+type lockInfo struct {
+	XMLName   xml.Name  `xml:"DAV: lockinfo"`
+	Exclusive *struct{} `xml:"DAV: lockscope>exclusive"`
+	Shared    *struct{} `xml:"DAV: lockscope>shared"`
+	Owner     struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"DAV: owner"`
+}
+
+//the Timeout header, Second-N or Infinite, as at most maxTimeout
+func lockTimeout(r *http.Request) time.Duration {
+	for _, v := range strings.Split(r.Header.Get("Timeout"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "Infinite" {
+			return maxTimeout
+		}
+		if strings.HasPrefix(v, "Second-") {
+			if n, err := strconv.ParseInt(v[len("Second-"):], 10, 64); err == nil && n > 0 {
+				if t := time.Duration(n) * time.Second; t < maxTimeout {
+					return t
+				}
+				return maxTimeout
+			}
+		}
+	}
+	return defaultTimeout
+}
+
+func activeLock(l *lock) string {
+	scope, depth := "<D:shared/>", "infinity"
+	if l.exclusive {
+		scope = "<D:exclusive/>"
+	}
+	if l.depth != infiniteDepth {
+		depth = "0"
+	}
+	ret := "<D:activelock><D:locktype><D:write/></D:locktype><D:lockscope>" + scope + "</D:lockscope><D:depth>" + depth + "</D:depth>"
+	if l.owner != "" {
+		ret += "<D:owner>" + l.owner + "</D:owner>"
+	}
+	return ret + "<D:timeout>Second-" + strconv.FormatInt(int64(l.timeout/time.Second), 10) + "</D:timeout>" +
+		"<D:locktoken><D:href>" + escape(l.token) + "</D:href></D:locktoken>" +
+		"<D:lockroot><D:href>" + href(l.root, false) + "</D:href></D:lockroot></D:activelock>"
+}
+
+// handleLock creates a lock, or refreshes one of the If header without a body. A
+// missing resource is created empty, as a placeholder for the client holding the lock.
+func (h *Handler) handleLock(w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	info := &lockInfo{}
+	if err := readXml(r, info); err != nil {
+		return http.StatusBadRequest, err
+	}
+	timeout := lockTimeout(r)
+	if info.XMLName.Local == "" {
+		l, err := h.locks.refresh(p, ifTokens(r), timeout)
+		if err != nil {
+			return http.StatusPreconditionFailed, err
+		}
+		writeLockDiscovery(w, l, http.StatusOK)
+		return 0, nil
+	}
+	depth := infiniteDepth
+	switch r.Header.Get("Depth") {
+	case "0":
+		depth = 0
+	case "", "infinity":
+	default:
+		return http.StatusBadRequest, fmt.Errorf("invalid depth %q", r.Header.Get("Depth"))
+	}
+	status := http.StatusOK
+	if _, err := h.Filer.Get(p); err == filer.ErrNotFound {
+		if !h.parentExists(p) {
+			return http.StatusConflict, nil
+		}
+		if !h.unlocked(r, p, false, true) {
+			return http.StatusLocked, errLocked
+		}
+		if err = h.Filer.WriteFile(requestContext(r), &filer.Entry{FullPath: p}, strings.NewReader("")); err != nil {
+			return statusOf(err), err
+		}
+		status = http.StatusCreated
+	} else if err != nil {
+		return statusOf(err), err
+	}
+	l, err := h.locks.create(p, depth, info.Shared == nil, info.Owner.Inner, timeout)
+	if err != nil {
+		return statusOf(err), err
+	}
+	w.Header().Set("Lock-Token", "<"+l.token+">")
+	writeLockDiscovery(w, l, status)
+	return 0, nil
+}
+
+func writeLockDiscovery(w http.ResponseWriter, l *lock, status int) {
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header + `<D:prop xmlns:D="DAV:"><D:lockdiscovery>` + activeLock(l) + "</D:lockdiscovery></D:prop>"))
+}
+
+func (h *Handler) handleUnlock(w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	token := strings.Trim(strings.TrimSpace(r.Header.Get("Lock-Token")), "<>")
+	if err := h.locks.unlock(p, token); err != nil {
+		return http.StatusConflict, err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return 0, nil
+}
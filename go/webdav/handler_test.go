@@ -0,0 +1,114 @@
+package webdav
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"code.google.com/p/weed-fs/go/filer"
+	"strings"
+	"testing"
+)
+
+func do(h *Handler, method string, url string, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	//empty files have no chunks, the filer needs no volume servers for them
+	h := NewHandler(filer.NewFiler(filer.NewMemoryStore(), nil))
+	if w := do(h, "MKCOL", "/docs", ""); w.Code != http.StatusCreated {
+		t.Fatalf("mkcol: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(h, "MKCOL", "/docs", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("mkcol existing: %d", w.Code)
+	}
+	if w := do(h, "MKCOL", "/missing/docs", ""); w.Code != http.StatusConflict {
+		t.Fatalf("mkcol without parent: %d", w.Code)
+	}
+	if w := do(h, "PUT", "/docs/a%20b.txt", ""); w.Code != http.StatusCreated {
+		t.Fatalf("put: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(h, "PUT", "/docs/a%20b.txt", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("put again: %d", w.Code)
+	}
+
+	w := do(h, "PROPFIND", "/docs", "", "Depth", "1")
+	body := w.Body.String()
+	if w.Code != http.StatusMultiStatus || !strings.Contains(body, "<D:href>/docs/</D:href>") ||
+		!strings.Contains(body, "<D:href>/docs/a%20b.txt</D:href>") || !strings.Contains(body, "<D:collection/>") {
+		t.Fatalf("propfind: %d %s", w.Code, body)
+	}
+	if w = do(h, "PROPFIND", "/docs", "", "Depth", "infinity"); w.Code != http.StatusForbidden {
+		t.Fatalf("propfind of infinite depth: %d", w.Code)
+	}
+
+	patch := `<D:propertyupdate xmlns:D="DAV:"><D:set><D:prop><color xmlns="urn:x">red</color></D:prop></D:set></D:propertyupdate>`
+	if w = do(h, "PROPPATCH", "/docs/a%20b.txt", patch); w.Code != http.StatusMultiStatus || !strings.Contains(w.Body.String(), "200 OK") {
+		t.Fatalf("proppatch: %d %s", w.Code, w.Body.String())
+	}
+	find := `<D:propfind xmlns:D="DAV:"><D:prop><color xmlns="urn:x"/><D:getetag/><size xmlns="urn:x"/></D:prop></D:propfind>`
+	w = do(h, "PROPFIND", "/docs/a%20b.txt", find, "Depth", "0")
+	if body = w.Body.String(); !strings.Contains(body, `<color xmlns="urn:x">red</color>`) || !strings.Contains(body, "404 Not Found") {
+		t.Fatalf("propfind of dead properties: %s", body)
+	}
+
+	if w = do(h, "COPY", "/docs", "", "Destination", "http://example.com/copy"); w.Code != http.StatusCreated {
+		t.Fatalf("copy: %d %s", w.Code, w.Body.String())
+	}
+	if w = do(h, "MOVE", "/copy/a%20b.txt", "", "Destination", "/docs/a%20b.txt", "Overwrite", "F"); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("move without overwrite: %d", w.Code)
+	}
+	if w = do(h, "MOVE", "/copy/a%20b.txt", "", "Destination", "/docs/a%20b.txt"); w.Code != http.StatusNoContent {
+		t.Fatalf("move: %d %s", w.Code, w.Body.String())
+	}
+	if w = do(h, "GET", "/copy/a%20b.txt", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("get moved file: %d", w.Code)
+	}
+	if w = do(h, "MOVE", "/docs/a%20b.txt", "", "Destination", "/docs"); w.Code != http.StatusForbidden {
+		t.Fatalf("move onto its parent: %d", w.Code)
+	}
+	if w = do(h, "COPY", "/docs", "", "Destination", "/docs/sub"); w.Code != http.StatusForbidden {
+		t.Fatalf("copy into itself: %d", w.Code)
+	}
+	if w = do(h, "GET", "/docs/a%20b.txt", ""); w.Code != http.StatusOK {
+		t.Fatalf("get after refused moves: %d", w.Code)
+	}
+
+	lockinfo := `<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner>me</D:owner></D:lockinfo>`
+	w = do(h, "LOCK", "/docs", lockinfo, "Timeout", "Second-60")
+	token := w.Header().Get("Lock-Token")
+	if w.Code != http.StatusOK || token == "" || !strings.Contains(w.Body.String(), "<D:owner>me</D:owner>") {
+		t.Fatalf("lock: %d %s", w.Code, w.Body.String())
+	}
+	if w = do(h, "LOCK", "/docs/a%20b.txt", lockinfo); w.Code != http.StatusLocked {
+		t.Fatalf("lock below a lock: %d", w.Code)
+	}
+	if w = do(h, "PUT", "/docs/new.txt", ""); w.Code != http.StatusLocked {
+		t.Fatalf("put without the token: %d", w.Code)
+	}
+	if w = do(h, "PUT", "/docs/new.txt", "", "If", "("+token+")"); w.Code != http.StatusCreated {
+		t.Fatalf("put with the token: %d", w.Code)
+	}
+	if w = do(h, "DELETE", "/docs", ""); w.Code != http.StatusLocked {
+		t.Fatalf("delete without the token: %d", w.Code)
+	}
+	if w = do(h, "UNLOCK", "/docs/new.txt", "", "Lock-Token", token); w.Code != http.StatusNoContent {
+		t.Fatalf("unlock: %d", w.Code)
+	}
+	if w = do(h, "DELETE", "/docs", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", w.Code)
+	}
+
+	//a lock on a missing resource creates it
+	if w = do(h, "LOCK", "/locked.txt", lockinfo); w.Code != http.StatusCreated {
+		t.Fatalf("lock of a missing file: %d", w.Code)
+	}
+	if w = do(h, "GET", "/locked.txt", ""); w.Code != http.StatusOK {
+		t.Fatalf("get of a locked file: %d", w.Code)
+	}
+}
@@ -0,0 +1,150 @@
+package webdav
+
+import (
+	"code.google.com/p/weed-fs/go/filer"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	infiniteDepth  = -1
+	defaultTimeout = time.Hour
+	maxTimeout     = 24 * time.Hour
+)
+
+var (
+	errLocked     = errors.New("locked")
+	errNoSuchLock = errors.New("no such lock")
+)
+
+// lock is a write lock on a resource, and on everything below it with an
+// infinite depth. Locks live in memory, they are lost when the server restarts.
+type lock struct {
+	token     string
+	root      string
+	depth     int
+	exclusive bool
+	owner     string //the owner xml of the client, returned as is
+	timeout   time.Duration
+	expires   time.Time
+}
+
+//whether the lock applies to the path, or with subtree to anything below it
+func (l *lock) covers(p string, subtree bool) bool {
+	return l.root == p || l.depth == infiniteDepth && filer.IsUnder(p, l.root) || subtree && filer.IsUnder(l.root, p)
+}
+
+type lockManager struct {
+	lock  sync.Mutex
+	locks map[string]*lock //by token
+	now   func() time.Time
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{locks: make(map[string]*lock), now: time.Now}
+}
+
+//drops the expired locks, the caller holds the lock
+func (m *lockManager) expire() {
+	now := m.now()
+	for token, l := range m.locks {
+		if now.After(l.expires) {
+			delete(m.locks, token)
+		}
+	}
+}
+
+// confirm tells whether a change of the path, or with subtree of anything below
+// it, is allowed: each lock applying must be one of the tokens.
+func (m *lockManager) confirm(p string, subtree bool, tokens []string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expire()
+	for _, l := range m.locks {
+		if l.covers(p, subtree) && !contains(tokens, l.token) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *lockManager) create(p string, depth int, exclusive bool, owner string, timeout time.Duration) (*lock, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expire()
+	for _, l := range m.locks {
+		if (l.exclusive || exclusive) && l.covers(p, depth == infiniteDepth) {
+			return nil, errLocked
+		}
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	l := &lock{token: "opaquelocktoken:" + hex.EncodeToString(token), root: p, depth: depth, exclusive: exclusive, owner: owner, timeout: timeout, expires: m.now().Add(timeout)}
+	m.locks[l.token] = l
+	return l, nil
+}
+
+//extends the first of the tokens that is a lock applying to the path
+func (m *lockManager) refresh(p string, tokens []string, timeout time.Duration) (*lock, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expire()
+	for _, token := range tokens {
+		if l, ok := m.locks[token]; ok && l.covers(p, false) {
+			l.timeout, l.expires = timeout, m.now().Add(timeout)
+			return l, nil
+		}
+	}
+	return nil, errNoSuchLock
+}
+
+func (m *lockManager) unlock(p string, token string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	l, ok := m.locks[token]
+	if !ok || !l.covers(p, false) {
+		return errNoSuchLock
+	}
+	delete(m.locks, token)
+	return nil
+}
+
+//drops the locks of removed resources
+func (m *lockManager) remove(p string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for token, l := range m.locks {
+		if filer.IsUnder(l.root, p) {
+			delete(m.locks, token)
+		}
+	}
+}
+
+//the locks applying to the path
+func (m *lockManager) active(p string) []*lock {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expire()
+	ret := []*lock{}
+	for _, l := range m.locks {
+		if l.covers(p, false) {
+			copied := *l
+			ret = append(ret, &copied)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,276 @@
+package webdav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"path"
+	"code.google.com/p/weed-fs/go/filer"
+	"strconv"
+	"strings"
+)
+
+//dead properties, set by the clients, are kept in Entry.Extended under this
+//prefix and their namespace and name, as the xml they were set with
+const deadPropPrefix = "dav:"
+
+type rawProp struct {
+	XMLName xml.Name
+	Inner   string `xml:",innerxml"`
+}
+
+type propfind struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	Allprop  *struct{} `xml:"DAV: allprop"`
+	Propname *struct{} `xml:"DAV: propname"`
+	Prop     *struct {
+		Props []rawProp `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
+type propertyupdate struct {
+	XMLName xml.Name `xml:"DAV: propertyupdate"`
+	Set     []struct {
+		Props []rawProp `xml:",any"`
+	} `xml:"DAV: set>prop"`
+	Remove []struct {
+		Props []rawProp `xml:",any"`
+	} `xml:"DAV: remove>prop"`
+}
+
+var (
+	propResourceType   = xml.Name{Space: "DAV:", Local: "resourcetype"}
+	propDisplayName    = xml.Name{Space: "DAV:", Local: "displayname"}
+	propContentLength  = xml.Name{Space: "DAV:", Local: "getcontentlength"}
+	propLastModified   = xml.Name{Space: "DAV:", Local: "getlastmodified"}
+	propContentType    = xml.Name{Space: "DAV:", Local: "getcontenttype"}
+	propEtag           = xml.Name{Space: "DAV:", Local: "getetag"}
+	propCreationDate   = xml.Name{Space: "DAV:", Local: "creationdate"}
+	propSupportedLock  = xml.Name{Space: "DAV:", Local: "supportedlock"}
+	propLockDiscovery  = xml.Name{Space: "DAV:", Local: "lockdiscovery"}
+	liveProps          = []xml.Name{propResourceType, propDisplayName, propContentLength, propLastModified, propContentType, propEtag, propCreationDate, propSupportedLock, propLockDiscovery}
+	supportedLockValue = "<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>" + "<D:lockentry><D:lockscope><D:shared/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>"
+)
+
+func deadPropKey(name xml.Name) string {
+	return deadPropPrefix + name.Space + " " + name.Local
+}
+
+func etag(e *filer.Entry) string {
+	if e.Md5 != "" {
+		return `"` + e.Md5 + `"`
+	}
+	return `"` + strconv.FormatInt(e.Mtime.UnixNano(), 16) + "-" + strconv.FormatInt(e.Size, 16) + `"`
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+//the element of a property, in the DAV: namespace with the D prefix of the response
+func element(name xml.Name, inner string) string {
+	if name.Space == "DAV:" {
+		return "<D:" + name.Local + ">" + inner + "</D:" + name.Local + ">"
+	}
+	return "<" + name.Local + ` xmlns="` + escape(name.Space) + `">` + inner + "</" + name.Local + ">"
+}
+
+func href(p string, isDirectory bool) string {
+	if isDirectory && p != "/" {
+		p += "/"
+	}
+	return escape((&url.URL{Path: p}).EscapedPath())
+}
+
+//the value of a live property of an entry
+func (h *Handler) liveProp(e *filer.Entry, name xml.Name) (string, bool) {
+	switch name {
+	case propResourceType:
+		if e.IsDirectory {
+			return "<D:collection/>", true
+		}
+		return "", true
+	case propDisplayName:
+		return escape(path.Base(e.FullPath)), true
+	case propContentLength:
+		return strconv.FormatInt(e.Size, 10), !e.IsDirectory
+	case propLastModified:
+		return e.Mtime.UTC().Format(http.TimeFormat), true
+	case propContentType:
+		return escape(contentType(e)), !e.IsDirectory
+	case propEtag:
+		return escape(etag(e)), !e.IsDirectory
+	case propCreationDate:
+		return e.Mtime.UTC().Format("2006-01-02T15:04:05Z"), true
+	case propSupportedLock:
+		return supportedLockValue, true
+	case propLockDiscovery:
+		value := ""
+		for _, l := range h.locks.active(e.FullPath) {
+			value += activeLock(l)
+		}
+		return value, true
+	}
+	return "", false
+}
+
+//the response of one resource of a PROPFIND
+func (h *Handler) propResponse(e *filer.Entry, pf *propfind) string {
+	found, missing := "", ""
+	switch {
+	case pf.Propname != nil:
+		for _, name := range liveProps {
+			if _, ok := h.liveProp(e, name); ok {
+				found += element(name, "")
+			}
+		}
+		for key := range e.Extended {
+			if name, ok := parseDeadPropKey(key); ok {
+				found += element(name, "")
+			}
+		}
+	case pf.Prop != nil:
+		for _, p := range pf.Prop.Props {
+			if value, ok := h.liveProp(e, p.XMLName); ok {
+				found += element(p.XMLName, value)
+			} else if value, ok := e.Extended[deadPropKey(p.XMLName)]; ok {
+				found += element(p.XMLName, value)
+			} else {
+				missing += element(p.XMLName, "")
+			}
+		}
+	default:
+		for _, name := range liveProps {
+			if value, ok := h.liveProp(e, name); ok {
+				found += element(name, value)
+			}
+		}
+		for key, value := range e.Extended {
+			if name, ok := parseDeadPropKey(key); ok {
+				found += element(name, value)
+			}
+		}
+	}
+	ret := "<D:response><D:href>" + href(e.FullPath, e.IsDirectory) + "</D:href>"
+	if found != "" {
+		ret += propstat(found, http.StatusOK)
+	}
+	if missing != "" {
+		ret += propstat(missing, http.StatusNotFound)
+	}
+	return ret + "</D:response>"
+}
+
+func parseDeadPropKey(key string) (xml.Name, bool) {
+	if !strings.HasPrefix(key, deadPropPrefix) {
+		return xml.Name{}, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, deadPropPrefix), " ", 2)
+	if len(parts) != 2 {
+		return xml.Name{}, false
+	}
+	return xml.Name{Space: parts[0], Local: parts[1]}, true
+}
+
+func propstat(props string, status int) string {
+	return "<D:propstat><D:prop>" + props + "</D:prop><D:status>HTTP/1.1 " + strconv.Itoa(status) + " " + http.StatusText(status) + "</D:status></D:propstat>"
+}
+
+func writeMultistatus(w http.ResponseWriter, responses string) {
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(xml.Header + `<D:multistatus xmlns:D="DAV:">` + responses + "</D:multistatus>"))
+}
+
+func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	e, err := h.Filer.Get(p)
+	if err != nil {
+		return statusOf(err), err
+	}
+	depth := r.Header.Get("Depth")
+	if depth != "0" && depth != "1" {
+		//listing whole trees is refused, as RFC 4918 allows
+		w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(xml.Header + `<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`))
+		return 0, nil
+	}
+	pf := &propfind{}
+	if err = readXml(r, pf); err != nil {
+		return http.StatusBadRequest, err
+	}
+	responses := h.propResponse(e, pf)
+	if depth == "1" && e.IsDirectory {
+		startAfter := ""
+		for {
+			children, err := h.Filer.List(p, startAfter, 1024)
+			if err != nil {
+				return statusOf(err), err
+			}
+			if len(children) == 0 {
+				break
+			}
+			for _, child := range children {
+				responses += h.propResponse(child, pf)
+			}
+			startAfter = children[len(children)-1].Name()
+		}
+	}
+	writeMultistatus(w, responses)
+	return 0, nil
+}
+
+// handleProppatch keeps the dead properties of the request with the entry. Live
+// properties are protected, and a request changing one changes nothing.
+func (h *Handler) handleProppatch(w http.ResponseWriter, r *http.Request, p string) (int, error) {
+	if !h.locks.confirm(p, false, ifTokens(r)) {
+		return http.StatusLocked, errLocked
+	}
+	e, err := h.Filer.Get(p)
+	if err != nil {
+		return statusOf(err), err
+	}
+	update := &propertyupdate{}
+	if err = readXml(r, update); err != nil || update.XMLName.Local == "" {
+		return http.StatusBadRequest, err
+	}
+	protected, changed := "", ""
+	for _, set := range append(update.Set, update.Remove...) {
+		for _, prop := range set.Props {
+			if _, ok := h.liveProp(e, prop.XMLName); ok || prop.XMLName.Space == "DAV:" {
+				protected += element(prop.XMLName, "")
+			} else {
+				changed += element(prop.XMLName, "")
+			}
+		}
+	}
+	if protected != "" {
+		responses := propstat(protected, http.StatusForbidden)
+		if changed != "" {
+			responses += propstat(changed, http.StatusFailedDependency)
+		}
+		writeMultistatus(w, "<D:response><D:href>"+href(p, e.IsDirectory)+"</D:href>"+responses+"</D:response>")
+		return 0, nil
+	}
+	if e.Extended == nil {
+		e.Extended = make(map[string]string)
+	}
+	for _, set := range update.Set {
+		for _, prop := range set.Props {
+			e.Extended[deadPropKey(prop.XMLName)] = prop.Inner
+		}
+	}
+	for _, remove := range update.Remove {
+		for _, prop := range remove.Props {
+			delete(e.Extended, deadPropKey(prop.XMLName))
+		}
+	}
+	if err = h.Filer.CreateEntry(r.Context(), e); err != nil {
+		return statusOf(err), err
+	}
+	writeMultistatus(w, "<D:response><D:href>"+href(p, e.IsDirectory)+"</D:href>"+propstat(changed, http.StatusOK)+"</D:response>")
+	return 0, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"code.google.com/p/weed-fs/go/operation"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	benchmarkServer      *string
+	benchmarkCount       *int
+	benchmarkConcurrency *int
+	benchmarkSize        *string
+	benchmarkReads       *int
+	benchmarkDelete      *bool
+	benchmarkReplication *string
+	benchmarkSeed        *int64
+	benchmarkTLS         *tlsFlags
+)
+
+func init() {
+	cmdBenchmark.Run = runBenchmark // break init cycle
+	cmdBenchmark.IsDebug = cmdBenchmark.Flag.Bool("debug", false, "verbose debug information, same as -v=3")
+	benchmarkServer = cmdBenchmark.Flag.String("server", "localhost:9333", "weedfs master locations, master[,master]...")
+	benchmarkCount = cmdBenchmark.Flag.Int("n", 1024, "number of files to write")
+	benchmarkConcurrency = cmdBenchmark.Flag.Int("c", 16, "number of concurrent clients")
+	benchmarkSize = cmdBenchmark.Flag.String("size", "1K", "size of the files, or min-max for sizes spread evenly between them, e.g. 1K-4M")
+	benchmarkReads = cmdBenchmark.Flag.Int("reads", -1, "number of random reads of the written files, -1 for -n, 0 for none")
+	benchmarkDelete = cmdBenchmark.Flag.Bool("delete", false, "delete the written files at the end")
+	benchmarkReplication = cmdBenchmark.Flag.String("replication", "", "replication type of the files, the master's default if empty")
+	benchmarkSeed = cmdBenchmark.Flag.Int64("seed", 1, "seed of the file sizes, contents and read order, to repeat a run")
+	benchmarkTLS = newClientTLSFlags(&cmdBenchmark.Flag)
+}
+
+var cmdBenchmark = &Command{
+	UsageLine: "benchmark -server=localhost:9333 -n=1024 -c=16 -size=1K",
+	Short:     "measure how fast a cluster writes, reads and deletes files",
+	Long: `write -n files of -size with -c clients, then read them back in a random
+  order, and with -delete delete them. Files are written like with upload, each
+  with its own assign, and read like with download.
+
+  After each phase, it reports the files per second and bytes per second, the
+  latency percentiles of the requests and the number of failed requests.
+  Reads and deletes are of the files written without error, and a read
+  returning a different size than written counts as failed. The exit status is
+  1 if any request failed.
+
+  The same -seed gives the same sizes, contents and read order, so runs against
+  different cluster settings can be compared.
+
+  `,
+}
+
+//a size, in bytes or with a K, M or G suffix
+func parseSize(s string) (int, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := 1
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1024
+	case strings.HasSuffix(s, "M"):
+		unit = 1024 * 1024
+	case strings.HasSuffix(s, "G"):
+		unit = 1024 * 1024 * 1024
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+//the smallest and largest file size of -size
+func parseSizeRange(s string) (minSize, maxSize int, err error) {
+	parts := strings.SplitN(s, "-", 2)
+	if minSize, err = parseSize(parts[0]); err != nil {
+		return
+	}
+	maxSize = minSize
+	if len(parts) == 2 {
+		if maxSize, err = parseSize(parts[1]); err != nil {
+			return
+		}
+	}
+	if maxSize < minSize {
+		err = fmt.Errorf("invalid size range %q", s)
+	}
+	return
+}
+
+// benchmarkPhase collects the results of the requests of one phase.
+type benchmarkPhase struct {
+	name      string
+	start     time.Time
+	elapsed   time.Duration
+	bytes     int64
+	latencies []time.Duration
+	errors    int
+	lastError error
+
+	lock sync.Mutex
+}
+
+func (p *benchmarkPhase) add(latency time.Duration, n int64, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err != nil {
+		p.errors++
+		p.lastError = err
+		return
+	}
+	p.latencies = append(p.latencies, latency)
+	p.bytes += n
+}
+
+//runs fn for 0..n-1 with c clients, and times each call
+func (p *benchmarkPhase) run(n, c int, fn func(i int) (int64, error)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	p.start = time.Now()
+	for w := 0; w < c; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				start := time.Now()
+				n, err := fn(i)
+				p.add(time.Since(start), n, err)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	p.elapsed = time.Since(p.start)
+}
+
+//the latency under which the fraction q of the successful requests took
+func (p *benchmarkPhase) percentile(q float64) time.Duration {
+	if len(p.latencies) == 0 {
+		return 0
+	}
+	i := int(q*float64(len(p.latencies))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(p.latencies) {
+		i = len(p.latencies) - 1
+	}
+	return p.latencies[i]
+}
+
+func (p *benchmarkPhase) report(w io.Writer) {
+	sort.Slice(p.latencies, func(i, j int) bool { return p.latencies[i] < p.latencies[j] })
+	ok := len(p.latencies)
+	seconds := p.elapsed.Seconds()
+	if seconds <= 0 {
+		seconds = 1e-9
+	}
+	var total time.Duration
+	for _, l := range p.latencies {
+		total += l
+	}
+	avg := time.Duration(0)
+	if ok > 0 {
+		avg = total / time.Duration(ok)
+	}
+	fmt.Fprintf(w, "%s: %d files, %d failed, %s in %v\n", p.name, ok, p.errors, formatBytes(uint64(p.bytes)), p.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "  throughput %.1f files/s, %s/s\n", float64(ok)/seconds, formatBytes(uint64(float64(p.bytes)/seconds)))
+	fmt.Fprintf(w, "  latency avg %v, p50 %v, p90 %v, p99 %v, max %v\n", round(avg), round(p.percentile(0.5)), round(p.percentile(0.9)), round(p.percentile(0.99)), round(p.percentile(1)))
+	if p.lastError != nil {
+		fmt.Fprintf(w, "  last error: %v\n", p.lastError)
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
+
+func runBenchmark(cmd *Command, args []string) bool {
+	minSize, maxSize, err := parseSizeRange(*benchmarkSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	if *benchmarkCount <= 0 || *benchmarkConcurrency <= 0 {
+		fmt.Fprintln(os.Stderr, "-n and -c must be positive")
+		return false
+	}
+	if err := benchmarkTLS.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid tls settings:", err)
+		return false
+	}
+	client := operation.NewClient(strings.Split(*benchmarkServer, ",")...)
+	client.Replication = *benchmarkReplication
+
+	//the contents are prefixes of one random block, generated ahead so the
+	//benchmark measures the cluster and not the client
+	r := rand.New(rand.NewSource(*benchmarkSeed))
+	sizes := make([]int, *benchmarkCount)
+	for i := range sizes {
+		sizes[i] = minSize + r.Intn(maxSize-minSize+1)
+	}
+	block := make([]byte, maxSize)
+	r.Read(block)
+	reads := *benchmarkReads
+	if reads < 0 {
+		reads = *benchmarkCount
+	}
+	fmt.Printf("benchmark of %s: %d files of %s with %d clients, %d reads\n", *benchmarkServer, *benchmarkCount, *benchmarkSize, *benchmarkConcurrency, reads)
+
+	fids := make([]string, *benchmarkCount)
+	failed := 0
+	write := &benchmarkPhase{name: "write"}
+	write.run(*benchmarkCount, *benchmarkConcurrency, func(i int) (int64, error) {
+		file := operation.UploadFile{Name: "benchmark.bin", Reader: bytes.NewReader(block[:sizes[i]])}
+		uploaded, err := client.Upload(context.Background(), file, "")
+		if err != nil {
+			return 0, err
+		}
+		fids[i] = uploaded.Fid
+		return int64(sizes[i]), nil
+	})
+	write.report(os.Stdout)
+	failed += write.errors
+	var written []int
+	for i, fid := range fids {
+		if fid != "" {
+			written = append(written, i)
+		}
+	}
+
+	if reads > 0 && len(written) > 0 {
+		order := make([]int, reads)
+		for i := range order {
+			order[i] = written[r.Intn(len(written))]
+		}
+		read := &benchmarkPhase{name: "read"}
+		read.run(reads, *benchmarkConcurrency, func(i int) (int64, error) {
+			j := order[i]
+			file, err := client.Download(context.Background(), fids[j])
+			if err != nil {
+				return 0, err
+			}
+			defer file.Body.Close()
+			n, err := io.Copy(ioutil.Discard, file.Body)
+			if err == nil && n != int64(sizes[j]) {
+				err = fmt.Errorf("%s: read %d bytes, wrote %d", fids[j], n, sizes[j])
+			}
+			return n, err
+		})
+		read.report(os.Stdout)
+		failed += read.errors
+	}
+
+	if *benchmarkDelete && len(written) > 0 {
+		remove := &benchmarkPhase{name: "delete"}
+		remove.run(len(written), *benchmarkConcurrency, func(i int) (int64, error) {
+			j := written[i]
+			return int64(sizes[j]), client.Delete(context.Background(), fids[j])
+		})
+		remove.report(os.Stdout)
+		failed += remove.errors
+	}
+	if failed > 0 {
+		setExitStatus(1)
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"code.google.com/p/weed-fs/go/operation"
+	"code.google.com/p/weed-fs/go/trace"
+	"strings"
+)
+
+var (
+	catServer *string
+	catTLS    *tlsFlags
+)
+
+func init() {
+	cmdCat.Run = runCat // break init cycle
+	cmdCat.IsDebug = cmdCat.Flag.Bool("debug", false, "verbose debug information, same as -v=3")
+	catServer = cmdCat.Flag.String("server", "localhost:9333", "weedfs master locations, master[,master]...")
+	catTLS = newClientTLSFlags(&cmdCat.Flag)
+}
+
+var cmdCat = &Command{
+	UsageLine: "cat -server=localhost:9333 fid1 [fid2 fid3]",
+	Short:     "write the content of files to stdout",
+	Long: `write the content of files to stdout, one after the other.
+  Files are read like with download, uncompressed if stored gzipped.
+
+  `,
+}
+
+func runCat(cmd *Command, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	if err := catTLS.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid tls settings:", err)
+		return false
+	}
+	client := operation.NewClient(strings.Split(*catServer, ",")...)
+	client.Timeout = 0
+	ctx := trace.NewContext(context.Background(), trace.NewRequestId())
+	for _, fid := range args {
+		file, err := client.Download(ctx, fid)
+		if err == nil {
+			_, err = io.Copy(os.Stdout, file.Body)
+			file.Body.Close()
+		}
+		if err != nil {
+			//a later file could be mistaken for the rest of this one, stop here
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fid, err)
+			setExitStatus(1)
+			return true
+		}
+	}
+	return true
+}
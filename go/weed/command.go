@@ -25,7 +25,9 @@ type Command struct {
 	// Flag is a set of flags specific to this command.
 	Flag flag.FlagSet
 
-	IsDebug *bool
+	IsDebug   *bool
+	LogLevel  *int
+	LogFormat *string
 }
 
 // Name returns the command's name: the first word in the usage line.
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"code.google.com/p/weed-fs/go/operation"
+	"code.google.com/p/weed-fs/go/security"
+	"code.google.com/p/weed-fs/go/trace"
+	"strings"
+)
+
+var (
+	deleteServer *string
+	deleteSecret *string
+	deleteTLS    *tlsFlags
+)
+
+func init() {
+	cmdDelete.Run = runDelete // break init cycle
+	cmdDelete.IsDebug = cmdDelete.Flag.Bool("debug", false, "verbose debug information, same as -v=3")
+	deleteServer = cmdDelete.Flag.String("server", "localhost:9333", "weedfs master locations, master[,master]...")
+	deleteSecret = cmdDelete.Flag.String("secret", "", "shared secret of the master and volume servers, to sign the bulk deletes")
+	deleteTLS = newClientTLSFlags(&cmdDelete.Flag)
+}
+
+var cmdDelete = &Command{
+	UsageLine: "delete -server=localhost:9333 fid1 [fid2 fid3]",
+	Short:     "delete files by fid",
+	Long: `delete files by fid from all their replicas, with one request to each
+  volume server holding some of them, and print the result of each file.
+
+  `,
+}
+
+type DeleteFileResult struct {
+	Fid   string `json:"fid"`
+	Error string `json:"error,omitempty"`
+
+	RequestId string `json:"requestId"` //to find the delete in the master and volume server logs
+}
+
+func runDelete(cmd *Command, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	if err := deleteTLS.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid tls settings:", err)
+		return false
+	}
+	security.SetSecret(*deleteSecret)
+	requestId := trace.NewRequestId()
+	client := operation.NewClient(strings.Split(*deleteServer, ",")...)
+	errs := client.DeleteBatch(trace.NewContext(context.Background(), requestId), args)
+	results := make([]DeleteFileResult, len(args))
+	for i, fid := range args {
+		results[i] = DeleteFileResult{Fid: fid, RequestId: requestId}
+		if errs[i] != nil {
+			results[i].Error = errs[i].Error()
+			setExitStatus(1)
+		}
+	}
+	bytes, _ := json.Marshal(results)
+	fmt.Print(string(bytes))
+	return true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"code.google.com/p/weed-fs/go/operation"
+	"code.google.com/p/weed-fs/go/trace"
+	"strings"
+)
+
+var (
+	downloadServer *string
+	downloadDir    *string
+	downloadTLS    *tlsFlags
+)
+
+func init() {
+	cmdDownload.Run = runDownload // break init cycle
+	cmdDownload.IsDebug = cmdDownload.Flag.Bool("debug", false, "verbose debug information, same as -v=3")
+	downloadServer = cmdDownload.Flag.String("server", "localhost:9333", "weedfs master locations, master[,master]...")
+	downloadDir = cmdDownload.Flag.String("o", ".", "directory to write the files to")
+	downloadTLS = newClientTLSFlags(&cmdDownload.Flag)
+}
+
+var cmdDownload = &Command{
+	UsageLine: "download -server=localhost:9333 -o=dir fid1 [fid2 fid3]",
+	Short:     "download files by fid",
+	Long: `download files by fid, into -o under the name they were uploaded with,
+  or the fid if they have none. Files of the same name get their fid added to
+  the name, e.g. a_3,01637037d6.txt, instead of overwriting each other.
+  The volumes are looked up on the master, and the other replicas are tried
+  if one fails. Files stored gzipped are uncompressed.
+
+  `,
+}
+
+//the name to save a downloaded file as, only ever a file directly in the output directory.
+//a name already taken by another file of the same run gets the fid added before its extension.
+func downloadFileName(fid string, name string, taken map[string]bool) string {
+	name = path.Base(strings.Replace(name, "\\", "/", -1))
+	if name == "." || name == "/" || name == ".." {
+		name = fid
+	}
+	if taken[name] {
+		ext := path.Ext(name)
+		name = strings.TrimSuffix(name, ext) + "_" + fid + ext
+	}
+	taken[name] = true
+	return name
+}
+
+//writes the file to the output directory, through a temporary file so a failed
+//download leaves no partial file behind
+func download(ctx context.Context, client *operation.Client, fid string, taken map[string]bool) (string, error) {
+	file, err := client.Download(ctx, fid)
+	if err != nil {
+		return "", err
+	}
+	defer file.Body.Close()
+	target := filepath.Join(*downloadDir, downloadFileName(fid, file.Name, taken))
+	tmp, err := ioutil.TempFile(*downloadDir, ".download")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, file.Body)
+	if err == nil {
+		//TempFile creates the file readable by its owner only
+		err = tmp.Chmod(0644)
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return target, nil
+}
+
+func runDownload(cmd *Command, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	if err := downloadTLS.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid tls settings:", err)
+		return false
+	}
+	client := operation.NewClient(strings.Split(*downloadServer, ",")...)
+	//the files are streamed, the client timeout would cut large ones
+	client.Timeout = 0
+	ctx := trace.NewContext(context.Background(), trace.NewRequestId())
+	taken := make(map[string]bool)
+	for _, fid := range args {
+		target, err := download(ctx, client, fid, taken)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fid, err)
+			setExitStatus(1)
+			continue
+		}
+		fmt.Println(target)
+	}
+	return true
+}
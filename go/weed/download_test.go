@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDownloadFileName(t *testing.T) {
+	taken := make(map[string]bool)
+	for _, test := range []struct {
+		fid, name, want string
+	}{
+		{"3,01", "a.txt", "a.txt"},
+		{"3,02", "../dir/a.txt", "a_3,02.txt"},
+		{"3,03", "", "3,03"},
+		{"3,04", "..", "3,04"},
+		{"3,05", `c:\docs\b`, "b"},
+		{"3,06", "b", "b_3,06"},
+	} {
+		if got := downloadFileName(test.fid, test.name, taken); got != test.want {
+			t.Errorf("%s %q: saved as %q, want %q", test.fid, test.name, got, test.want)
+		}
+	}
+}
@@ -4,11 +4,11 @@ import (
 	"archive/tar"
 	"bytes"
 	"fmt"
-	"log"
 	"os"
 	"path"
 	"code.google.com/p/weed-fs/go/directory"
 	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/wlog"
 	"strconv"
 	"strings"
 	"text/template"
@@ -17,7 +17,7 @@ import (
 
 func init() {
 	cmdExport.Run = runExport // break init cycle
-	cmdExport.IsDebug = cmdExport.Flag.Bool("debug", false, "enable debug mode")
+	cmdExport.IsDebug = cmdExport.Flag.Bool("debug", false, "enable debug mode, same as -v=3")
 }
 
 const (
@@ -39,6 +39,7 @@ var (
 	exportVolumeId   = cmdExport.Flag.Int("volumeId", -1, "a volume id. The volume should already exist in the dir. The volume index file should not exist.")
 	dest             = cmdExport.Flag.String("o", "", "output tar file name, must ends with .tar, or just a \"-\" for stdout")
 	format           = cmdExport.Flag.String("fileNameFormat", defaultFnFormat, "filename format, default to {{.Mime}}/{{.Id}}:{{.Name}}")
+	exportIndexType  = cmdExport.Flag.String("index", "memory", "needle map type: memory, sorted or hybrid")
 	tarFh            *tar.Writer
 	tarHeader        tar.Header
 	fnTmpl           *template.Template
@@ -51,15 +52,15 @@ func runExport(cmd *Command, args []string) bool {
 		return false
 	}
 
-	var err error
 	if *dest != "" {
 		if *dest != "-" && !strings.HasSuffix(*dest, ".tar") {
-			fmt.Println("the output file", *dest, "should be '-' or end with .tar")
+			wlog.Error("the output file should be '-' or end with .tar", "file", *dest)
 			return false
 		}
 
+		var err error
 		if fnTmpl, err = template.New("name").Parse(*format); err != nil {
-			fmt.Println("cannot parse format " + *format + ": " + err.Error())
+			wlog.Error("cannot parse format", "format", *format, "error", err)
 			return false
 		}
 
@@ -68,7 +69,7 @@ func runExport(cmd *Command, args []string) bool {
 			fh = os.Stdout
 		} else {
 			if fh, err = os.Create(*dest); err != nil {
-				log.Fatalf("cannot open output tar %s: %s", *dest, err)
+				wlog.Fatal("cannot open output tar", "file", *dest, "error", err)
 			}
 		}
 		defer fh.Close()
@@ -81,18 +82,25 @@ func runExport(cmd *Command, args []string) bool {
 			AccessTime: t, ChangeTime: t}
 	}
 
+	needleMapKind, err := storage.NewNeedleMapType(*exportIndexType)
+	if err != nil {
+		wlog.Error("invalid needle map type", "error", err)
+		return false
+	}
+
 	fileName := strconv.Itoa(*exportVolumeId)
 	vid := storage.VolumeId(*exportVolumeId)
 	indexFile, err := os.OpenFile(path.Join(*exportVolumePath, fileName+".idx"), os.O_RDONLY, 0644)
 	if err != nil {
-		log.Fatalf("Create Volume Index [ERROR] %s\n", err)
+		wlog.Fatal("failed to open volume index", "error", err)
 	}
 	defer indexFile.Close()
 
-	nm, err := storage.LoadNeedleMap(indexFile)
+	nm, err := storage.LoadNeedleMapper(needleMapKind, indexFile)
 	if err != nil {
-		log.Fatalf("cannot load needle map from %s: %s", indexFile, err)
+		wlog.Fatal("cannot load needle map", "file", indexFile.Name(), "error", err)
 	}
+	defer nm.Close()
 
 	var version storage.Version
 
@@ -100,21 +108,21 @@ func runExport(cmd *Command, args []string) bool {
 		version = superBlock.Version
 		return nil
 	}, func(n *storage.Needle, offset uint32) error {
-		debug("key", n.Id, "offset", offset, "size", n.Size, "disk_size", n.DiskSize(), "gzip", n.IsGzipped())
+		wlog.Debug("needle", "key", n.Id, "offset", offset, "size", n.Size, "diskSize", n.DiskSize(), "gzip", n.IsGzipped())
 		nv, ok := nm.Get(n.Id)
 		if ok && nv.Size > 0 {
 			return walker(vid, n, version)
 		} else {
 			if !ok {
-				debug("This seems deleted", n.Id)
+				wlog.Debug("this seems deleted", "key", n.Id)
 			} else {
-				debug("Id", n.Id, "size", n.Size)
+				wlog.Debug("skipping deleted file", "key", n.Id, "size", n.Size)
 			}
 		}
 		return nil
 	})
 	if err != nil {
-		log.Fatalf("Export Volume File [ERROR] %s\n", err)
+		wlog.Fatal("failed to export volume file", "error", err)
 	}
 	return true
 }
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"code.google.com/p/weed-fs/go/filer"
+	"code.google.com/p/weed-fs/go/metrics"
+	"code.google.com/p/weed-fs/go/operation"
+	"code.google.com/p/weed-fs/go/security"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	cmdFiler.Run = runFiler // break init cycle
+	cmdFiler.IsDebug = cmdFiler.Flag.Bool("debug", false, "enable debug mode, same as -v=3")
+}
+
+var cmdFiler = &Command{
+	UsageLine: "filer -port=8888 -master=localhost:9333 -dir=/tmp/weed-filer",
+	Short:     "start a filer server mapping paths to files",
+	Long: `start a filer server, to store and read files by path instead of by fid.
+  The content is stored on the volume servers, in chunks of -chunkSizeMB.
+  The paths, directories and chunk lists are kept in the -store in -dir.
+
+  GET    /path/to/file                  read a file
+  GET    /path/to/dir/?lastFileName=x   list a directory, -listLimit entries at a time
+  POST   /path/to/file                  create or replace a file, from a multipart "file" or the body
+  POST   /path/to/dir/                  create a file named after the uploaded file name
+  POST   /path/to/dir?op=mkdir          create a directory and its parents
+  POST   /path/to/file?op=rename&to=/x  rename a file or a directory
+  DELETE /path/to/dir?recursive=true    delete a file, or a directory and all in it
+
+  `,
+}
+
+var (
+	fport            = cmdFiler.Flag.Int("port", 8888, "http listen port")
+	filerMaster      = cmdFiler.Flag.String("master", "localhost:9333", "master server locations, master[,master]...")
+	filerDir         = cmdFiler.Flag.String("dir", "/tmp/weed-filer", "directory of the metadata store, created if missing, and used by one server at a time")
+	filerStore       = cmdFiler.Flag.String("store", "log", "metadata store: "+strings.Join(filer.StoreKinds(), " or "))
+	filerChunkSizeMB = cmdFiler.Flag.Int("chunkSizeMB", 8, "files are stored on the volume servers in chunks of this size")
+	filerReplication = cmdFiler.Flag.String("replication", "", "replication type of the stored files, the master's default if empty")
+	filerListLimit   = cmdFiler.Flag.Int("listLimit", 1000, "maximum number of entries in a directory listing")
+	fReadTimeout     = cmdFiler.Flag.Int("readTimeout", 30, "connection read timeout in seconds")
+	filerSecret      = cmdFiler.Flag.String("secret", "", "shared secret of the master and volume servers, to get the tokens to write and delete files")
+	filerTLS         = newServerTLSFlags(&cmdFiler.Flag)
+)
+
+var theFiler *filer.Filer
+
+func filerError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case filer.ErrNotFound:
+		status = http.StatusNotFound
+	case filer.ErrExists, filer.ErrNotDirectory, filer.ErrIsDirectory, filer.ErrNotEmpty:
+		status = http.StatusConflict
+	case filer.ErrInvalidPath:
+		status = http.StatusBadRequest
+	}
+	w.WriteHeader(status)
+	writeJson(w, r, map[string]string{"error": err.Error()})
+}
+
+func filerHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+		filerGetHandler(w, r)
+	case "POST", "PUT":
+		//not FormValue, which would read a multipart body
+		switch r.URL.Query().Get("op") {
+		case "mkdir":
+			filerMkdirHandler(w, r)
+		case "rename":
+			filerRenameHandler(w, r)
+		default:
+			filerPostHandler(w, r)
+		}
+	case "DELETE":
+		filerDeleteHandler(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+//the context of the filer operations, carrying the request id to the master and volume servers
+func filerContext(r *http.Request) context.Context {
+	return trace.NewContext(r.Context(), trace.RequestId(r))
+}
+
+func filerGetHandler(w http.ResponseWriter, r *http.Request) {
+	entry, err := theFiler.Get(r.URL.Path)
+	if err != nil {
+		filerError(w, r, err)
+		return
+	}
+	if entry.IsDirectory {
+		limit, err := strconv.Atoi(r.FormValue("limit"))
+		if err != nil || limit <= 0 || limit > *filerListLimit {
+			limit = *filerListLimit
+		}
+		entries, err := theFiler.List(entry.FullPath, r.FormValue("lastFileName"), limit)
+		if err != nil {
+			filerError(w, r, err)
+			return
+		}
+		ret := map[string]interface{}{"path": entry.FullPath, "entries": entries}
+		if len(entries) == limit {
+			ret["lastFileName"] = entries[len(entries)-1].Name()
+		}
+		writeJson(w, r, ret)
+		return
+	}
+	if entry.Mime != "" {
+		w.Header().Set("Content-Type", entry.Mime)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+	w.Header().Set("Last-Modified", entry.Mtime.UTC().Format(http.TimeFormat))
+	if r.Method == "HEAD" {
+		return
+	}
+	if err = theFiler.Read(filerContext(r), entry, w); err != nil {
+		//the status is sent already, the client sees a short body
+		wlog.Error("failed to read file", "path", entry.FullPath, "requestId", trace.RequestId(r), "error", err)
+	}
+}
+
+//the content of a multipart "file" upload or else the body, with its name if any and mime type
+func uploadedContent(r *http.Request) (content io.Reader, name string, mimeType string, err error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return r.Body, "", r.Header.Get("Content-Type"), nil
+	}
+	form, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", "", err
+	}
+	for {
+		part, err := form.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("no file in the multipart form")
+			}
+			return nil, "", "", err
+		}
+		if part.FormName() == "file" || part.FileName() != "" {
+			mimeType = part.Header.Get("Content-Type")
+			if mimeType == "" || mimeType == "application/octet-stream" {
+				mimeType = mime.TypeByExtension(path.Ext(part.FileName()))
+			}
+			return part, part.FileName(), mimeType, nil
+		}
+	}
+}
+
+func filerPostHandler(w http.ResponseWriter, r *http.Request) {
+	content, name, mimeType, err := uploadedContent(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJson(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+	fullPath := r.URL.Path
+	if strings.HasSuffix(fullPath, "/") {
+		if name == "" {
+			filerError(w, r, filer.ErrInvalidPath)
+			return
+		}
+		fullPath += path.Base(name)
+	}
+	entry, err := theFiler.CreateFile(filerContext(r), fullPath, mimeType, content)
+	if err != nil {
+		filerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJson(w, r, entry)
+}
+
+func filerMkdirHandler(w http.ResponseWriter, r *http.Request) {
+	if err := theFiler.Mkdir(r.URL.Path); err != nil {
+		filerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJson(w, r, map[string]string{"path": filer.CleanPath(r.URL.Path)})
+}
+
+func filerRenameHandler(w http.ResponseWriter, r *http.Request) {
+	to := r.FormValue("to")
+	if to == "" {
+		filerError(w, r, filer.ErrInvalidPath)
+		return
+	}
+	if err := theFiler.Rename(r.URL.Path, to); err != nil {
+		filerError(w, r, err)
+		return
+	}
+	writeJson(w, r, map[string]string{"path": filer.CleanPath(to)})
+}
+
+func filerDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	recursive := r.FormValue("recursive") == "true"
+	if err := theFiler.Delete(filerContext(r), r.URL.Path, recursive); err != nil {
+		filerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	writeJson(w, r, map[string]string{"path": filer.CleanPath(r.URL.Path)})
+}
+
+func runFiler(cmd *Command, args []string) bool {
+	if err := filerTLS.setup(); err != nil {
+		wlog.Fatal("invalid tls settings", "error", err)
+	}
+	security.SetSecret(*filerSecret)
+	store, err := filer.OpenStore(*filerStore, *filerDir)
+	if err != nil {
+		wlog.Fatal("failed to open filer store", "store", *filerStore, "dir", *filerDir, "error", err)
+	}
+	defer store.Close()
+	theFiler = filer.NewFiler(store, operation.NewClient(strings.Split(*filerMaster, ",")...))
+	theFiler.ChunkSize = int64(*filerChunkSizeMB) * 1024 * 1024
+	theFiler.Replication = *filerReplication
+
+	hm := metrics.NewHandlerMetrics("filer")
+	http.HandleFunc("/metrics", metrics.Handler)
+	http.HandleFunc("/", instrument(hm, "filer", filerHandler))
+
+	wlog.Info("starting weed filer", "version", VERSION, "port", *fport, "store", *filerStore, "dir", *filerDir, "scheme", util.Scheme())
+	srv := &http.Server{
+		Addr:        ":" + strconv.Itoa(*fport),
+		Handler:     http.DefaultServeMux,
+		ReadTimeout: time.Duration(*fReadTimeout) * time.Second,
+	}
+	if e := filerTLS.listenAndServe(srv); e != nil {
+		wlog.Fatal("failed to start", "error", e)
+	}
+	return true
+}
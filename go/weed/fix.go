@@ -1,16 +1,16 @@
 package main
 
 import (
-	"log"
 	"os"
 	"path"
 	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/wlog"
 	"strconv"
 )
 
 func init() {
 	cmdFix.Run = runFix // break init cycle
-	cmdFix.IsDebug = cmdFix.Flag.Bool("debug", false, "enable debug mode")
+	cmdFix.IsDebug = cmdFix.Flag.Bool("debug", false, "enable debug mode, same as -v=3")
 }
 
 var cmdFix = &Command{
@@ -24,6 +24,7 @@ var cmdFix = &Command{
 var (
 	fixVolumePath = cmdFix.Flag.String("dir", "/tmp", "data directory to store files")
 	fixVolumeId   = cmdFix.Flag.Int("volumeId", -1, "a volume id. The volume should already exist in the dir. The volume index file should not exist.")
+	fixIndexType  = cmdFix.Flag.String("index", "memory", "needle map type to prepare: memory, sorted or hybrid")
 )
 
 func runFix(cmd *Command, args []string) bool {
@@ -31,11 +32,20 @@ func runFix(cmd *Command, args []string) bool {
 	if *fixVolumeId == -1 {
 		return false
 	}
+	needleMapKind, err := storage.NewNeedleMapType(*fixIndexType)
+	if err != nil {
+		wlog.Error("invalid index type", "error", err)
+		return false
+	}
 
 	fileName := strconv.Itoa(*fixVolumeId)
+	//the sorted index and checkpoint were generated from the old index file
+	if err := os.Remove(path.Join(*fixVolumePath, fileName+".sdx")); err != nil && !os.IsNotExist(err) {
+		wlog.Fatal("failed to remove sorted index", "error", err)
+	}
 	indexFile, err := os.OpenFile(path.Join(*fixVolumePath, fileName+".idx"), os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
-		log.Fatalf("Create Volume Index [ERROR] %s\n", err)
+		wlog.Fatal("failed to create volume index", "error", err)
 	}
 	defer indexFile.Close()
 
@@ -46,18 +56,33 @@ func runFix(cmd *Command, args []string) bool {
 	err = storage.ScanVolumeFile(*fixVolumePath, vid, func(superBlock storage.SuperBlock) error {
 		return nil
 	}, func(n *storage.Needle, offset uint32) error {
-		debug("key", n.Id, "offset", offset, "size", n.Size, "disk_size", n.DiskSize(), "gzip", n.IsGzipped())
+		wlog.Debug("needle", "key", n.Id, "offset", offset, "size", n.Size, "diskSize", n.DiskSize(), "gzip", n.IsGzipped())
 		if n.Size > 0 {
 			count, pe := nm.Put(n.Id, offset/storage.NeedlePaddingSize, n.Size)
-			debug("saved", count, "with error", pe)
+			wlog.Debug("saved", "key", n.Id, "bytes", count, "error", pe)
 		} else {
-			debug("skipping deleted file ...")
+			wlog.Debug("skipping deleted file", "key", n.Id)
 			nm.Delete(n.Id)
 		}
 		return nil
 	})
 	if err != nil {
-		log.Fatalf("Export Volume File [ERROR] %s\n", err)
+		wlog.Fatal("failed to scan volume file", "error", err)
+	}
+
+	if err = nm.Checkpoint(); err != nil {
+		wlog.Fatal("failed to checkpoint volume index", "error", err)
+	}
+	if needleMapKind != storage.NeedleMapInMemory {
+		nm.Close()
+		if indexFile, err = os.OpenFile(path.Join(*fixVolumePath, fileName+".idx"), os.O_RDONLY, 0644); err != nil {
+			wlog.Fatal("failed to open volume index", "error", err)
+		}
+		prepared, err := storage.LoadNeedleMapper(needleMapKind, indexFile)
+		if err != nil {
+			wlog.Fatal("failed to prepare needle map", "index", *fixIndexType, "error", err)
+		}
+		prepared.Close()
 	}
 
 	return true
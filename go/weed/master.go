@@ -3,11 +3,16 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"log"
 	"net/http"
+	"code.google.com/p/weed-fs/go/metrics"
+	"code.google.com/p/weed-fs/go/operation"
 	"code.google.com/p/weed-fs/go/replication"
+	"code.google.com/p/weed-fs/go/security"
 	"code.google.com/p/weed-fs/go/storage"
 	"code.google.com/p/weed-fs/go/topology"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
 	"runtime"
 	"strconv"
 	"strings"
@@ -16,7 +21,7 @@ import (
 
 func init() {
 	cmdMaster.Run = runMaster // break init cycle
-	cmdMaster.IsDebug = cmdMaster.Flag.Bool("debug", false, "enable debug mode")
+	cmdMaster.IsDebug = cmdMaster.Flag.Bool("debug", false, "enable debug mode, same as -v=3")
 }
 
 var cmdMaster = &Command{
@@ -38,34 +43,82 @@ var (
 	mReadTimeout      = cmdMaster.Flag.Int("readTimeout", 3, "connection read timeout in seconds")
 	mMaxCpu           = cmdMaster.Flag.Int("maxCpu", 0, "maximum number of CPUs. 0 means all available CPUs")
 	garbageThreshold  = cmdMaster.Flag.String("garbageThreshold", "0.3", "threshold to vacuum and reclaim spaces")
+	vacuumInterval    = cmdMaster.Flag.Int("vacuumIntervalMinutes", 15, "minutes between automatic vacuum runs")
+	vacuumConcurrency = cmdMaster.Flag.Int("vacuumConcurrency", 2, "maximum number of volumes compacting at the same time")
+	vacuumPerNode     = cmdMaster.Flag.Int("vacuumPerNode", 1, "maximum number of volumes compacting at the same time on one volume server")
+	vacuumQuietPeriod = cmdMaster.Flag.Int("vacuumQuietSeconds", 300, "skip vacuuming volumes written within this number of seconds")
+	vacuumTimeout     = cmdMaster.Flag.Int("vacuumTimeoutMinutes", 30, "timeout in minutes for each vacuum step on a volume server")
+	tiers             = cmdMaster.Flag.String("tiers", "hot", "storage tiers, hottest first. new volumes go to the first one, or another tier with a warning when it has no space, e.g. hot,warm,cold")
+	tierReadsPerMin   = cmdMaster.Flag.Float64("tierReadsPerMinute", 1, "full volumes read less often than this move to the next colder tier")
+	tierInterval      = cmdMaster.Flag.Int("tierIntervalMinutes", 60, "minutes between automatic tier moves, 0 to disable")
+	mSecret           = cmdMaster.Flag.String("secret", "", "shared secret to sign and verify admin requests, same on the master and all volume servers")
+	mWhiteList        = cmdMaster.Flag.String("whiteList", "", "ip addresses or CIDR blocks allowed to call admin requests without a signature, ip[,ip]...")
+	fileTokenTTL      = cmdMaster.Flag.Int("fileTokenSeconds", 60, "seconds the write and delete token of an assigned or looked up fid is valid, with a secret")
+	locationPush      = cmdMaster.Flag.Int("locationPushSeconds", 1, "seconds between pushes of changed volume locations to the volume servers, 0 to disable")
+	masterTLS         = newServerTLSFlags(&cmdMaster.Flag)
 )
 
 var topo *topology.Topology
 var vg *replication.VolumeGrowth
+var masterGuard *security.Guard
 
+var (
+	masterAssignedFileIds = metrics.NewCounterVec("weed_master_assigned_file_ids_total", "Number of file ids assigned by replication type.", "replication")
+	masterAssignFailures  = metrics.NewCounterVec("weed_master_assign_failures_total", "Number of failed assign requests by replication type.", "replication")
+	masterLookups         = metrics.NewCounterVec("weed_master_lookups_total", "Number of volume lookups by result: found, not_found or invalid.", "result")
+)
+
+//looking up a full fid from a white listed or signed client also returns a token to delete it.
+//several volumeId values are looked up at once, and answered in a map by volume id or fid.
 func dirLookupHandler(w http.ResponseWriter, r *http.Request) {
-	vid := r.FormValue("volumeId")
+	r.ParseForm()
+	ids := r.Form["volumeId"]
+	if len(ids) > 1 {
+		results := make(map[string]interface{})
+		for _, id := range ids {
+			results[id], _ = lookupVolume(r, id)
+		}
+		writeJson(w, r, results)
+		return
+	}
+	m, status := lookupVolume(r, r.FormValue("volumeId"))
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+	writeJson(w, r, m)
+}
+
+func lookupVolume(r *http.Request, vid string) (map[string]interface{}, int) {
+	fid := vid
 	commaSep := strings.Index(vid, ",")
 	if commaSep > 0 {
 		vid = vid[0:commaSep]
 	}
 	volumeId, err := storage.NewVolumeId(vid)
-	if err == nil {
-		machines := topo.Lookup(volumeId)
-		if machines != nil {
-			ret := []map[string]string{}
-			for _, dn := range machines {
-				ret = append(ret, map[string]string{"url": dn.Url(), "publicUrl": dn.PublicUrl})
-			}
-			writeJson(w, r, map[string]interface{}{"locations": ret})
-		} else {
-			w.WriteHeader(http.StatusNotFound)
-			writeJson(w, r, map[string]string{"error": "volume id " + volumeId.String() + " not found. "})
-		}
+	if err != nil {
+		masterLookups.Inc("invalid")
+		return map[string]interface{}{"error": "unknown volumeId format " + vid}, http.StatusNotAcceptable
+	}
+	var machines []*topology.DataNode
+	if r.FormValue("order") == "load" {
+		machines = topo.LookupByLoad(volumeId)
 	} else {
-		w.WriteHeader(http.StatusNotAcceptable)
-		writeJson(w, r, map[string]string{"error": "unknown volumeId format " + vid})
+		machines = topo.Lookup(volumeId)
+	}
+	if machines == nil {
+		masterLookups.Inc("not_found")
+		return map[string]interface{}{"error": "volume id " + volumeId.String() + " not found. "}, http.StatusNotFound
+	}
+	masterLookups.Inc("found")
+	ret := []map[string]string{}
+	for _, dn := range machines {
+		ret = append(ret, map[string]string{"url": util.LocationUrl(dn.Url()), "publicUrl": util.LocationUrl(dn.PublicUrl)})
+	}
+	m := map[string]interface{}{"locations": ret}
+	if commaSep > 0 && security.HasSecret() && masterGuard.Check(r) == nil {
+		m["auth"] = security.GenerateFileToken(fid, time.Duration(*fileTokenTTL)*time.Second)
 	}
+	return m, http.StatusOK
 }
 
 func dirAssignHandler(w http.ResponseWriter, r *http.Request) {
@@ -85,17 +138,26 @@ func dirAssignHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	if topo.GetVolumeLayout(rt).GetActiveVolumeCount() <= 0 {
 		if topo.FreeSpace() <= 0 {
+			masterAssignFailures.Inc(rt.String())
 			w.WriteHeader(http.StatusNotFound)
 			writeJson(w, r, map[string]string{"error": "No free volumes left!"})
 			return
 		} else {
-			vg.GrowByType(rt, topo)
+			span := trace.StartSpan(r.Header.Get(trace.RequestIdHeader), "grow", "replication", repType)
+			count, err := vg.GrowByType(rt, topo)
+			span.End("volumes", count, "error", err)
 		}
 	}
 	fid, count, dn, err := topo.PickForWrite(rt, c)
 	if err == nil {
-		writeJson(w, r, map[string]interface{}{"fid": fid, "url": dn.Url(), "publicUrl": dn.PublicUrl, "count": count})
+		masterAssignedFileIds.Add(float64(count), rt.String())
+		m := map[string]interface{}{"fid": fid, "url": util.LocationUrl(dn.Url()), "publicUrl": util.LocationUrl(dn.PublicUrl), "count": count}
+		if token := security.GenerateFileToken(fid, time.Duration(*fileTokenTTL)*time.Second); token != "" {
+			m["auth"] = token
+		}
+		writeJson(w, r, m)
 	} else {
+		masterAssignFailures.Inc(rt.String())
 		w.WriteHeader(http.StatusNotAcceptable)
 		writeJson(w, r, map[string]string{"error": err.Error()})
 	}
@@ -113,8 +175,10 @@ func dirJoinHandler(w http.ResponseWriter, r *http.Request) {
 	publicUrl := r.FormValue("publicUrl")
 	volumes := new([]storage.VolumeInfo)
 	json.Unmarshal([]byte(r.FormValue("volumes")), volumes)
-	debug(s, "volumes", r.FormValue("volumes"))
-	topo.RegisterVolumes(init, *volumes, ip, port, publicUrl, maxVolumeCount)
+	disks := new([]storage.DiskInfo)
+	json.Unmarshal([]byte(r.FormValue("disks")), disks)
+	wlog.Debug("join", "node", s, "volumes", r.FormValue("volumes"), "disks", r.FormValue("disks"))
+	topo.RegisterVolumes(init, *volumes, *disks, ip, port, publicUrl, maxVolumeCount)
 	m := make(map[string]interface{})
 	m["VolumeSizeLimit"] = uint64(*volumeSizeLimitMB) * 1024 * 1024
 	writeJson(w, r, m)
@@ -128,15 +192,58 @@ func dirStatusHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func volumeVacuumHandler(w http.ResponseWriter, r *http.Request) {
-	gcThreshold := r.FormValue("garbageThreshold")
-	if gcThreshold == "" {
-		gcThreshold = *garbageThreshold
+	gcThreshold := 0.0
+	if r.FormValue("garbageThreshold") != "" {
+		var err error
+		if gcThreshold, err = strconv.ParseFloat(r.FormValue("garbageThreshold"), 64); err != nil {
+			w.WriteHeader(http.StatusNotAcceptable)
+			writeJson(w, r, map[string]string{"error": "garbageThreshold " + r.FormValue("garbageThreshold") + " is not a valid float number!"})
+			return
+		}
 	}
-	debug("garbageThreshold =", gcThreshold)
+	wlog.Debug("vacuum", "garbageThreshold", gcThreshold)
 	topo.Vacuum(gcThreshold)
 	dirStatusHandler(w, r)
 }
 
+func volumeVacuumThresholdHandler(w http.ResponseWriter, r *http.Request) {
+	volumeId, err := storage.NewVolumeId(r.FormValue("volumeId"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotAcceptable)
+		writeJson(w, r, map[string]string{"error": "unknown volumeId format " + r.FormValue("volumeId")})
+		return
+	}
+	gcThreshold := 0.0
+	if r.FormValue("garbageThreshold") != "" {
+		if gcThreshold, err = strconv.ParseFloat(r.FormValue("garbageThreshold"), 64); err != nil {
+			w.WriteHeader(http.StatusNotAcceptable)
+			writeJson(w, r, map[string]string{"error": "garbageThreshold " + r.FormValue("garbageThreshold") + " is not a valid float number!"})
+			return
+		}
+	}
+	topo.VacuumScheduler().SetVolumeThreshold(volumeId, gcThreshold)
+	volumeVacuumStatusHandler(w, r)
+}
+
+func volumeVacuumStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var vid *storage.VolumeId
+	if r.FormValue("volumeId") != "" {
+		volumeId, err := storage.NewVolumeId(r.FormValue("volumeId"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotAcceptable)
+			writeJson(w, r, map[string]string{"error": "unknown volumeId format " + r.FormValue("volumeId")})
+			return
+		}
+		vid = &volumeId
+	}
+	limit, _ := strconv.Atoi(r.FormValue("limit"))
+	m := make(map[string]interface{})
+	m["Version"] = VERSION
+	m["Scheduler"] = topo.VacuumScheduler().ToMap()
+	m["History"] = topo.VacuumScheduler().History(vid, limit)
+	writeJson(w, r, m)
+}
+
 func volumeGrowHandler(w http.ResponseWriter, r *http.Request) {
 	count := 0
 	rt, err := storage.NewReplicationTypeFromString(r.FormValue("replication"))
@@ -160,10 +267,133 @@ func volumeGrowHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func volumeReadOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	volumeId, err := storage.NewVolumeId(r.FormValue("volumeId"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotAcceptable)
+		writeJson(w, r, map[string]string{"error": "unknown volumeId format " + r.FormValue("volumeId")})
+		return
+	}
+	readOnly, err := strconv.ParseBool(r.FormValue("readonly"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotAcceptable)
+		writeJson(w, r, map[string]string{"error": "readonly " + r.FormValue("readonly") + " is not a valid boolean!"})
+		return
+	}
+	machines := topo.Lookup(volumeId)
+	if len(machines) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		writeJson(w, r, map[string]string{"error": "volume id " + volumeId.String() + " not found. "})
+		return
+	}
+	failures := make(map[string]string)
+	for _, dn := range machines {
+		if err := operation.SetVolumeReadOnly(dn, volumeId, readOnly); err != nil {
+			failures[dn.Url()] = err.Error()
+		}
+	}
+	if len(failures) > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJson(w, r, map[string]interface{}{"error": "failed to update volume " + volumeId.String(), "locations": failures})
+		return
+	}
+	topo.SetVolumeReadOnly(volumeId, readOnly)
+	writeJson(w, r, map[string]interface{}{"volumeId": volumeId, "readonly": readOnly})
+}
+
+func volumeDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	volumeId, err := storage.NewVolumeId(r.FormValue("volume"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotAcceptable)
+		writeJson(w, r, map[string]string{"error": "unknown volume id format " + r.FormValue("volume")})
+		return
+	}
+	machines := topo.Lookup(volumeId)
+	if len(machines) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		writeJson(w, r, map[string]string{"error": "volume id " + volumeId.String() + " not found. "})
+		return
+	}
+	if force, _ := strconv.ParseBool(r.FormValue("force")); !force {
+		for _, dn := range machines {
+			if v, ok := dn.GetVolume(volumeId); ok && v.FileCount > v.DeleteCount {
+				w.WriteHeader(http.StatusNotAcceptable)
+				writeJson(w, r, map[string]string{"error": "volume id " + volumeId.String() + " still has " + strconv.Itoa(v.FileCount-v.DeleteCount) + " files on " + dn.Url() + ", use force=true to delete it anyway"})
+				return
+			}
+		}
+	}
+	failures := make(map[string]string)
+	var deleted []string
+	for _, dn := range machines {
+		if err := operation.DeleteVolume(dn, volumeId); err != nil {
+			failures[dn.Url()] = err.Error()
+			continue
+		}
+		deleted = append(deleted, dn.Url())
+		topo.UnRegisterVolume(volumeId, dn)
+	}
+	if len(failures) > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJson(w, r, map[string]interface{}{"error": "failed to delete volume " + volumeId.String(), "deleted": deleted, "locations": failures})
+		return
+	}
+	writeJson(w, r, map[string]interface{}{"volume": volumeId, "deleted": deleted})
+}
+
+func volumeMoveHandler(w http.ResponseWriter, r *http.Request) {
+	volumeId, err := storage.NewVolumeId(r.FormValue("volumeId"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotAcceptable)
+		writeJson(w, r, map[string]string{"error": "unknown volumeId format " + r.FormValue("volumeId")})
+		return
+	}
+	from, to := topo.FindDataNode(r.FormValue("from")), topo.FindDataNode(r.FormValue("to"))
+	if from == nil || to == nil || to.Dead {
+		w.WriteHeader(http.StatusNotAcceptable)
+		writeJson(w, r, map[string]string{"error": "no live data nodes " + r.FormValue("from") + " and " + r.FormValue("to")})
+		return
+	}
+	v, ok := from.GetVolume(volumeId)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		writeJson(w, r, map[string]string{"error": "volume id " + volumeId.String() + " not found on " + from.Url()})
+		return
+	}
+	if _, ok = to.GetVolume(volumeId); ok {
+		w.WriteHeader(http.StatusNotAcceptable)
+		writeJson(w, r, map[string]string{"error": "volume id " + volumeId.String() + " is on " + to.Url() + " already"})
+		return
+	}
+	if to.FreeSpace() <= 0 {
+		w.WriteHeader(http.StatusNotAcceptable)
+		writeJson(w, r, map[string]string{"error": "no free volume slot on " + to.Url()})
+		return
+	}
+	if err = topo.MoveVolume(v, from, to, r.FormValue("tier")); err != nil {
+		wlog.Error("failed to move volume", "volume", volumeId, "from", from.Url(), "to", to.Url(), "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJson(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+	wlog.Info("moved volume", "volume", volumeId, "from", from.Url(), "to", to.Url())
+	writeJson(w, r, map[string]interface{}{"volumeId": volumeId, "from": from.Url(), "to": to.Url()})
+}
+
+func volumeTierHandler(w http.ResponseWriter, r *http.Request) {
+	moved := topo.TierPolicy().Run()
+	m := make(map[string]interface{})
+	m["Version"] = VERSION
+	m["Moved"] = moved
+	m["Policy"] = topo.TierPolicy().ToMap()
+	writeJson(w, r, m)
+}
+
 func volumeStatusHandler(w http.ResponseWriter, r *http.Request) {
 	m := make(map[string]interface{})
 	m["Version"] = VERSION
 	m["Volumes"] = topo.ToVolumeMap()
+	m["DataNodes"] = topo.ToStatsMap()
 	writeJson(w, r, m)
 }
 
@@ -171,12 +401,12 @@ func redirectHandler(w http.ResponseWriter, r *http.Request) {
 	vid, _, _ := parseURLPath(r.URL.Path)
 	volumeId, err := storage.NewVolumeId(vid)
 	if err != nil {
-		debug("parsing error:", err, r.URL.Path)
+		wlog.Debug("invalid volume id", "path", r.URL.Path, "error", err)
 		return
 	}
 	machines := topo.Lookup(volumeId)
 	if machines != nil && len(machines) > 0 {
-		http.Redirect(w, r, "http://"+machines[0].PublicUrl+r.URL.Path, http.StatusMovedPermanently)
+		http.Redirect(w, r, util.NormalizeUrl(machines[0].PublicUrl)+r.URL.Path, http.StatusMovedPermanently)
 	} else {
 		w.WriteHeader(http.StatusNotFound)
 		writeJson(w, r, map[string]string{"error": "volume id " + volumeId.String() + " not found. "})
@@ -190,28 +420,67 @@ func runMaster(cmd *Command, args []string) bool {
 	runtime.GOMAXPROCS(*mMaxCpu)
 	topo = topology.NewTopology("topo", *confFile, *metaFolder, "weed", uint64(*volumeSizeLimitMB)*1024*1024, *mpulse)
 	vg = replication.NewDefaultVolumeGrowth()
-	log.Println("Volume Size Limit is", *volumeSizeLimitMB, "MB")
-	http.HandleFunc("/dir/assign", dirAssignHandler)
-	http.HandleFunc("/dir/lookup", dirLookupHandler)
-	http.HandleFunc("/dir/join", dirJoinHandler)
-	http.HandleFunc("/dir/status", dirStatusHandler)
-	http.HandleFunc("/vol/grow", volumeGrowHandler)
-	http.HandleFunc("/vol/status", volumeStatusHandler)
-	http.HandleFunc("/vol/vacuum", volumeVacuumHandler)
+	vs := topo.VacuumScheduler()
+	if gcThreshold, err := strconv.ParseFloat(*garbageThreshold, 64); err == nil {
+		vs.GarbageThreshold = gcThreshold
+	} else {
+		wlog.Fatal("garbageThreshold is not a valid float number", "garbageThreshold", *garbageThreshold)
+	}
+	vs.Interval = time.Duration(*vacuumInterval) * time.Minute
+	vs.MaxConcurrent = *vacuumConcurrency
+	vs.MaxPerNode = *vacuumPerNode
+	vs.QuietPeriod = time.Duration(*vacuumQuietPeriod) * time.Second
+	vs.Timeout = time.Duration(*vacuumTimeout) * time.Minute
+	tp := topo.TierPolicy()
+	tp.Tiers = strings.Split(*tiers, ",")
+	tp.MaxReadsPerMinute = *tierReadsPerMin
+	tp.Interval = time.Duration(*tierInterval) * time.Minute
+	topo.LocationNotifier().Interval = time.Duration(*locationPush) * time.Second
+	wlog.Info("volume size limit", "MB", *volumeSizeLimitMB)
+	metrics.NewGaugeFunc("weed_master_writable_volumes", "Number of writable volumes by replication type.", func(emit func(float64, ...string)) {
+		for _, vl := range topo.VolumeLayouts() {
+			rt := vl.ReplicationType()
+			emit(float64(vl.GetActiveVolumeCount()), rt.String())
+		}
+	}, "replication")
+	if err := masterTLS.setup(); err != nil {
+		wlog.Fatal("invalid tls settings", "error", err)
+	}
+	security.SetSecret(*mSecret)
+	guard, err := security.NewGuard(*mSecret, strings.Split(*mWhiteList, ","))
+	if err != nil {
+		wlog.Fatal("invalid white list", "error", err)
+	}
+	masterGuard = guard
+	hm := metrics.NewHandlerMetrics("master")
+	http.HandleFunc("/dir/assign", instrument(hm, "assign", dirAssignHandler))
+	http.HandleFunc("/dir/lookup", instrument(hm, "lookup", dirLookupHandler))
+	http.HandleFunc("/dir/join", instrument(hm, "join", guard.Secure(dirJoinHandler)))
+	http.HandleFunc("/dir/status", instrument(hm, "dir_status", dirStatusHandler))
+	http.HandleFunc("/vol/grow", instrument(hm, "grow", guard.Secure(volumeGrowHandler)))
+	http.HandleFunc("/vol/status", instrument(hm, "vol_status", volumeStatusHandler))
+	http.HandleFunc("/vol/vacuum", instrument(hm, "vacuum", guard.Secure(volumeVacuumHandler)))
+	http.HandleFunc("/vol/vacuum/threshold", instrument(hm, "vacuum_threshold", guard.Secure(volumeVacuumThresholdHandler)))
+	http.HandleFunc("/vol/vacuum/status", instrument(hm, "vacuum_status", volumeVacuumStatusHandler))
+	http.HandleFunc("/vol/readonly", instrument(hm, "readonly", guard.Secure(volumeReadOnlyHandler)))
+	http.HandleFunc("/vol/delete", instrument(hm, "delete", guard.Secure(volumeDeleteHandler)))
+	http.HandleFunc("/vol/move", instrument(hm, "move", guard.Secure(volumeMoveHandler)))
+	http.HandleFunc("/vol/tier", instrument(hm, "tier", guard.Secure(volumeTierHandler)))
+	http.HandleFunc("/metrics", metrics.Handler)
 
 	http.HandleFunc("/", redirectHandler)
 
-	topo.StartRefreshWritableVolumes(*garbageThreshold)
+	topo.StartRefreshWritableVolumes()
 
-	log.Println("Start Weed Master", VERSION, "at port", strconv.Itoa(*mport))
+	wlog.Info("starting weed master", "version", VERSION, "port", *mport, "scheme", util.Scheme())
 	srv := &http.Server{
 		Addr:        ":" + strconv.Itoa(*mport),
 		Handler:     http.DefaultServeMux,
 		ReadTimeout: time.Duration(*mReadTimeout) * time.Second,
 	}
-	e := srv.ListenAndServe()
+	e := masterTLS.listenAndServe(srv)
 	if e != nil {
-		log.Fatalf("Fail to start:%s", e.Error())
+		wlog.Fatal("failed to start", "error", e)
 	}
 	return true
 }
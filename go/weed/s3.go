@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"code.google.com/p/weed-fs/go/filer"
+	"code.google.com/p/weed-fs/go/metrics"
+	"code.google.com/p/weed-fs/go/operation"
+	"code.google.com/p/weed-fs/go/s3api"
+	"code.google.com/p/weed-fs/go/security"
+	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	cmdS3.Run = runS3 // break init cycle
+	cmdS3.IsDebug = cmdS3.Flag.Bool("debug", false, "enable debug mode, same as -v=3")
+}
+
+var cmdS3 = &Command{
+	UsageLine: "s3 -port=8333 -master=localhost:9333 -dir=/tmp/weed-s3 -accessKey=key -secretKey=secret",
+	Short:     "start an S3 compatible gateway",
+	Long: `start a server speaking a subset of the S3 REST API, with path style urls,
+  e.g. aws --endpoint-url http://localhost:8333 s3 cp file s3://bucket/key
+
+  Buckets are directories in /buckets of a filer store in -dir, and objects the
+  files in them, stored on the volume servers of -master.
+  Supported: list, create and delete buckets; PUT, GET with Range, HEAD, DELETE of
+  objects and bulk deletes; ListObjects and ListObjectsV2 with prefix and delimiter;
+  multipart uploads. Not supported: copies, versioning, ACLs, policies and tagging.
+
+  Requests must be signed with Signature V4 by one of the -accessKey or -keys,
+  in headers or presigned urls. Without keys, all requests are allowed.
+  -keys is a json file of the secret keys by access key, {"accessKey":"secretKey"}.
+
+  `,
+}
+
+var (
+	s3port        = cmdS3.Flag.Int("port", 8333, "http listen port")
+	s3Master      = cmdS3.Flag.String("master", "localhost:9333", "master server locations, master[,master]...")
+	s3Dir         = cmdS3.Flag.String("dir", "/tmp/weed-s3", "directory of the metadata store, created if missing, and used by one server at a time")
+	s3Store       = cmdS3.Flag.String("store", "log", "metadata store: "+strings.Join(filer.StoreKinds(), " or "))
+	s3ChunkSizeMB = cmdS3.Flag.Int("chunkSizeMB", 8, "objects are stored on the volume servers in chunks of this size")
+	s3Replication = cmdS3.Flag.String("replication", "", "replication type of the stored objects, the master's default if empty")
+	s3Region      = cmdS3.Flag.String("region", "us-east-1", "region reported to the clients")
+	s3AccessKey   = cmdS3.Flag.String("accessKey", "", "access key id of the clients")
+	s3SecretKey   = cmdS3.Flag.String("secretKey", "", "secret key of -accessKey")
+	s3Keys        = cmdS3.Flag.String("keys", "", "json file of more secret keys by access key id")
+	s3ReadTimeout = cmdS3.Flag.Int("readTimeout", 30, "connection read timeout in seconds")
+	s3Secret      = cmdS3.Flag.String("secret", "", "shared secret of the master and volume servers, to get the tokens to write and delete files")
+	s3TLS         = newServerTLSFlags(&cmdS3.Flag)
+)
+
+func loadS3Keys() (map[string]string, error) {
+	keys := make(map[string]string)
+	if *s3Keys != "" {
+		data, err := ioutil.ReadFile(*s3Keys)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &keys); err != nil {
+			return nil, err
+		}
+	}
+	if *s3AccessKey != "" {
+		keys[*s3AccessKey] = *s3SecretKey
+	}
+	return keys, nil
+}
+
+func runS3(cmd *Command, args []string) bool {
+	if err := s3TLS.setup(); err != nil {
+		wlog.Fatal("invalid tls settings", "error", err)
+	}
+	security.SetSecret(*s3Secret)
+	keys, err := loadS3Keys()
+	if err != nil {
+		wlog.Fatal("failed to load the s3 keys", "file", *s3Keys, "error", err)
+	}
+	if len(keys) == 0 {
+		wlog.Warn("no s3 keys configured, all requests are allowed")
+	}
+	store, err := filer.OpenStore(*s3Store, *s3Dir)
+	if err != nil {
+		wlog.Fatal("failed to open filer store", "store", *s3Store, "dir", *s3Dir, "error", err)
+	}
+	defer store.Close()
+	f := filer.NewFiler(store, operation.NewClient(strings.Split(*s3Master, ",")...))
+	f.ChunkSize = int64(*s3ChunkSizeMB) * 1024 * 1024
+	f.Replication = *s3Replication
+	s3 := s3api.NewServer(f, keys)
+	s3.Region = *s3Region
+
+	hm := metrics.NewHandlerMetrics("s3")
+	http.HandleFunc("/metrics", metrics.Handler)
+	http.HandleFunc("/", instrument(hm, "s3", s3.ServeHTTP))
+
+	wlog.Info("starting weed s3", "version", VERSION, "port", *s3port, "store", *s3Store, "dir", *s3Dir, "keys", len(keys), "scheme", util.Scheme())
+	srv := &http.Server{
+		Addr:        ":" + strconv.Itoa(*s3port),
+		Handler:     http.DefaultServeMux,
+		ReadTimeout: time.Duration(*s3ReadTimeout) * time.Second,
+	}
+	if e := s3TLS.listenAndServe(srv); e != nil {
+		wlog.Fatal("failed to start", "error", e)
+	}
+	return true
+}
@@ -2,52 +2,268 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"code.google.com/p/weed-fs/go/operation"
+	"code.google.com/p/weed-fs/go/security"
+	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/util"
+	"sort"
+	"strings"
 )
 
 func init() {
 	cmdShell.Run = runShell // break init cycle
+	cmdShell.IsDebug = cmdShell.Flag.Bool("debug", false, "verbose debug information, same as -v=3")
 }
 
 var cmdShell = &Command{
-	UsageLine: "shell",
-	Short:     "run interactive commands, now just echo",
-	Long: `run interactive commands.
+	UsageLine: "shell -master=localhost:9333 [command args...]",
+	Short:     "run admin commands against a cluster",
+	Long: `run admin commands against the cluster of -master, one per line.
+  Type "help" for the commands, or "help volume" for the ones starting with
+  volume. A unique prefix of a command runs it, e.g. "volume.l" for volume.list,
+  and an ambiguous one lists the matching commands.
+
+  With a command in the arguments, only that command is run. When the input is
+  not a terminal, e.g. weed shell < runbook, the lines are run as a script:
+  empty lines and lines starting with # are skipped, and the first failing
+  command stops the script with exit status 1.
+
+  The commands changing volumes sign their requests with -secret, the shared
+  secret of the master and volume servers if they have one.
 
   `,
 }
 
-var ()
+var (
+	shellMaster = cmdShell.Flag.String("master", "localhost:9333", "master server locations, master[,master]...")
+	shellSecret = cmdShell.Flag.String("secret", "", "shared secret of the master and volume servers, to sign the admin requests")
+	shellTLS    = newClientTLSFlags(&cmdShell.Flag)
+)
 
-func runShell(command *Command, args []string) bool {
-	r := bufio.NewReader(os.Stdin)
-	o := bufio.NewWriter(os.Stdout)
-	e := bufio.NewWriter(os.Stderr)
-	prompt := func() {
-		o.WriteString("> ")
-		o.Flush()
-	}
-	readLine := func() string {
-		ret, err := r.ReadString('\n')
-		if err != nil {
-			fmt.Fprint(e, err)
-			os.Exit(1)
+type shellCommand struct {
+	name  string
+	args  string
+	short string
+	run   func(sh *shell, args []string) error
+}
+
+//filled by the files of the commands, sorted by name when the shell starts
+var shellCommands []*shellCommand
+
+type shell struct {
+	masters []string
+	client  *operation.Client
+	out     io.Writer
+}
+
+//the commands named name, or starting with it if none is
+func findShellCommands(name string) []*shellCommand {
+	var found []*shellCommand
+	for _, c := range shellCommands {
+		if c.name == name {
+			return []*shellCommand{c}
 		}
-		return ret
-	}
-	execCmd := func(cmd string) int {
-		if cmd != "" {
-			o.WriteString(cmd)
+		if strings.HasPrefix(c.name, name) {
+			found = append(found, c)
 		}
-		return 0
 	}
+	return found
+}
+
+func (sh *shell) help(args []string) error {
+	prefix := ""
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	found := findShellCommands(prefix)
+	if len(found) == 0 {
+		return fmt.Errorf("no command starting with %q", prefix)
+	}
+	if len(found) == 1 && prefix != "" {
+		c := found[0]
+		fmt.Fprintf(sh.out, "%s %s\n  %s\n", c.name, c.args, c.short)
+		return nil
+	}
+	for _, c := range found {
+		fmt.Fprintf(sh.out, "  %-16s %s\n", c.name, c.short)
+	}
+	return nil
+}
+
+//runs one line of input
+func (sh *shell) exec(line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return nil
+	}
+	if args[0] == "help" || args[0] == "?" {
+		return sh.help(args[1:])
+	}
+	found := findShellCommands(args[0])
+	switch {
+	case len(found) == 0:
+		return fmt.Errorf("unknown command %q, type help for the commands", args[0])
+	case len(found) > 1:
+		sh.help(args[:1])
+		return fmt.Errorf("ambiguous command %q", args[0])
+	}
+	return found[0].run(sh, args[1:])
+}
+
+//the flags of a command, which prints its own errors and usage
+func (sh *shell) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(sh.out)
+	return fs
+}
+
+func runShell(command *Command, args []string) bool {
+	if err := shellTLS.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid tls settings:", err)
+		return false
+	}
+	security.SetSecret(*shellSecret)
+	sort.Slice(shellCommands, func(i, j int) bool { return shellCommands[i].name < shellCommands[j].name })
+	sh := &shell{masters: strings.Split(*shellMaster, ","), out: os.Stdout}
+	sh.client = operation.NewClient(sh.masters...)
+	sh.client.Timeout = 0
 
-	cmd := ""
+	if len(args) > 0 {
+		if err := sh.exec(strings.Join(args, " ")); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			setExitStatus(1)
+		}
+		return true
+	}
+	interactive := false
+	if fi, err := os.Stdin.Stat(); err == nil {
+		interactive = fi.Mode()&os.ModeCharDevice != 0
+	}
+	in := bufio.NewScanner(os.Stdin)
+	in.Buffer(make([]byte, 64*1024), 1024*1024)
 	for {
-		prompt()
-		cmd = readLine()
-		execCmd(cmd)
+		if interactive {
+			fmt.Print("> ")
+		}
+		if !in.Scan() {
+			break
+		}
+		line := strings.TrimSpace(in.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "exit" || line == "quit" {
+			break
+		}
+		if err := sh.exec(line); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			if !interactive {
+				setExitStatus(1)
+				return true
+			}
+		}
+	}
+	if err := in.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		setExitStatus(1)
 	}
 	return true
 }
+
+//posts to the first master answering and decodes its json reply into ret
+func (sh *shell) masterPost(path string, values url.Values, ret interface{}) error {
+	var err error
+	for _, m := range sh.masters {
+		var body []byte
+		if body, err = util.AdminPost(util.NormalizeUrl(m)+path, values); err != nil {
+			continue
+		}
+		var reply struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &reply) == nil && reply.Error != "" {
+			return errors.New(reply.Error)
+		}
+		return json.Unmarshal(body, ret)
+	}
+	return err
+}
+
+//a signed request for the .dat or .idx file of a volume on a volume server
+func volumeFile(server string, method string, vid storage.VolumeId, ext string) (*http.Response, error) {
+	values := url.Values{"volume": {vid.String()}, "ext": {ext}}
+	req, err := http.NewRequest(method, util.NormalizeUrl(server)+"/admin/volume_file?"+values.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	security.Sign(req, nil)
+	resp, err := util.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", req.URL.Path+"?"+req.URL.RawQuery, resp.Status)
+	}
+	return resp, nil
+}
+
+func init() {
+	shellCommands = append(shellCommands,
+		&shellCommand{"fid.lookup", "fid", "show the locations of a file, and read it from each replica", shellFidLookup},
+		&shellCommand{"fid.cat", "fid", "write the content of a file", shellFidCat},
+	)
+}
+
+func shellFidLookup(sh *shell, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: fid.lookup fid")
+	}
+	fid := args[0]
+	vid, err := storage.NewVolumeId(strings.SplitN(fid, ",", 2)[0])
+	if err != nil {
+		return operation.ErrInvalidFid
+	}
+	locations, err := sh.client.Lookup(trace.NewContext(context.Background(), trace.NewRequestId()), vid)
+	if err != nil {
+		return err
+	}
+	for _, l := range locations {
+		u := util.NormalizeUrl(l.PublicUrl) + "/" + fid
+		resp, err := util.HttpClient().Head(u)
+		if err != nil {
+			fmt.Fprintf(sh.out, "%s\t%v\n", u, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			fmt.Fprintf(sh.out, "%s\t%s\n", u, resp.Status)
+			continue
+		}
+		fmt.Fprintf(sh.out, "%s\t%d bytes\t%s\t%s\n", u, resp.ContentLength, resp.Header.Get("Content-Type"), resp.Header.Get("Content-Disposition"))
+	}
+	return nil
+}
+
+func shellFidCat(sh *shell, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: fid.cat fid")
+	}
+	file, err := sh.client.Download(trace.NewContext(context.Background(), trace.NewRequestId()), args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Body.Close()
+	_, err = io.Copy(sh.out, file.Body)
+	return err
+}
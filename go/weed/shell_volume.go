@@ -0,0 +1,653 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/util"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	shellCommands = append(shellCommands,
+		&shellCommand{"volume.list", "[-volumeId=N]", "show the data centers, racks, nodes and volumes with their sizes and garbage ratios", shellVolumeList},
+		&shellCommand{"volume.grow", "-replication=000 -count=1", "create new writable volumes", shellVolumeGrow},
+		&shellCommand{"volume.vacuum", "[-garbageThreshold=0.3]", "compact the volumes with more garbage than the threshold, and show the results", shellVolumeVacuum},
+		&shellCommand{"volume.fsck", "[-volumeId=N]", "check that the replicas of volumes have the same files, all within their data files", shellVolumeFsck},
+		&shellCommand{"volume.move", "-volumeId=N -from=host:port -to=host:port [-tier=t] [-force]", "move a replica of a volume to another node", shellVolumeMove},
+		&shellCommand{"node.drain", "-node=host:port [-dryRun]", "move all volumes of a node to other nodes, keeping the replica placement", shellNodeDrain},
+		&shellCommand{"cluster.check", "", "report volumes missing replicas or violating their replica placement, and replicas disagreeing", shellClusterCheck},
+	)
+}
+
+type shellNode struct {
+	Url        string
+	PublicUrl  string
+	DataCenter string
+	Rack       string
+	Max        int
+	Free       int
+	Volumes    []storage.VolumeInfo
+}
+
+func (n *shellNode) volume(vid storage.VolumeId) (storage.VolumeInfo, bool) {
+	for _, v := range n.Volumes {
+		if v.Id == vid {
+			return v, true
+		}
+	}
+	return storage.VolumeInfo{}, false
+}
+
+//the cluster as the master sees it, the nodes sorted by data center, rack and url
+type shellTopology struct {
+	Nodes []*shellNode
+}
+
+func (sh *shell) topology() (*shellTopology, error) {
+	var volumes struct {
+		Volumes struct {
+			DataCenters map[string]map[string]map[string][]storage.VolumeInfo
+		}
+	}
+	if err := sh.masterPost("/vol/status", nil, &volumes); err != nil {
+		return nil, err
+	}
+	var status struct {
+		Topology struct {
+			DataCenters []struct {
+				Racks []struct {
+					DataNodes []struct {
+						Url       string
+						PublicUrl string
+						Max       int
+						Free      int
+					}
+				}
+			}
+		}
+	}
+	if err := sh.masterPost("/dir/status", nil, &status); err != nil {
+		return nil, err
+	}
+	t := &shellTopology{}
+	for dc, racks := range volumes.Volumes.DataCenters {
+		for rack, nodes := range racks {
+			for u, list := range nodes {
+				sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+				t.Nodes = append(t.Nodes, &shellNode{Url: u, PublicUrl: u, DataCenter: dc, Rack: rack, Volumes: list})
+			}
+		}
+	}
+	for _, dc := range status.Topology.DataCenters {
+		for _, rack := range dc.Racks {
+			for _, dn := range rack.DataNodes {
+				if n := t.node(dn.Url); n != nil {
+					n.PublicUrl, n.Max, n.Free = dn.PublicUrl, dn.Max, dn.Free
+				}
+			}
+		}
+	}
+	sort.Slice(t.Nodes, func(i, j int) bool {
+		a, b := t.Nodes[i], t.Nodes[j]
+		if a.DataCenter != b.DataCenter {
+			return a.DataCenter < b.DataCenter
+		}
+		if a.Rack != b.Rack {
+			return a.Rack < b.Rack
+		}
+		return a.Url < b.Url
+	})
+	return t, nil
+}
+
+func (t *shellTopology) node(u string) *shellNode {
+	for _, n := range t.Nodes {
+		if n.Url == u || n.PublicUrl == u {
+			return n
+		}
+	}
+	return nil
+}
+
+//the nodes holding a replica of the volume
+func (t *shellTopology) replicas(vid storage.VolumeId) (nodes []*shellNode) {
+	for _, n := range t.Nodes {
+		if _, ok := n.volume(vid); ok {
+			nodes = append(nodes, n)
+		}
+	}
+	return
+}
+
+//all volume ids, in order
+func (t *shellTopology) volumeIds() []storage.VolumeId {
+	seen := make(map[storage.VolumeId]bool)
+	var ids []storage.VolumeId
+	for _, n := range t.Nodes {
+		for _, v := range n.Volumes {
+			if !seen[v.Id] {
+				seen[v.Id] = true
+				ids = append(ids, v.Id)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatUint(n, 10) + "B"
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func garbageRatio(v storage.VolumeInfo) float64 {
+	if v.Size == 0 {
+		return 0
+	}
+	return float64(v.DeletedByteCount) / float64(v.Size)
+}
+
+//parses a -volumeId flag value, false if it is empty
+func parseShellVolumeId(s string) (storage.VolumeId, bool, error) {
+	if s == "" {
+		return 0, false, nil
+	}
+	vid, err := storage.NewVolumeId(s)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid volume id %q", s)
+	}
+	return vid, true, nil
+}
+
+func shellVolumeList(sh *shell, args []string) error {
+	fs := sh.flags("volume.list")
+	volumeId := fs.String("volumeId", "", "only this volume")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	vid, only, err := parseShellVolumeId(*volumeId)
+	if err != nil {
+		return err
+	}
+	t, err := sh.topology()
+	if err != nil {
+		return err
+	}
+	dc, rack := "", ""
+	var count int
+	var size, garbage uint64
+	for _, n := range t.Nodes {
+		if n.DataCenter != dc {
+			dc, rack = n.DataCenter, ""
+			fmt.Fprintf(sh.out, "DataCenter %s\n", dc)
+		}
+		if n.Rack != rack {
+			rack = n.Rack
+			fmt.Fprintf(sh.out, "  Rack %s\n", rack)
+		}
+		fmt.Fprintf(sh.out, "    Node %s volumes %d/%d free %d\n", n.Url, len(n.Volumes), n.Max, n.Free)
+		for _, v := range n.Volumes {
+			if only && v.Id != vid {
+				continue
+			}
+			flags := ""
+			if v.ReadOnly {
+				flags = " readonly"
+			}
+			if v.LowDiskSpace {
+				flags += " lowdisk"
+			}
+			fmt.Fprintf(sh.out, "      volume %d replication %s tier %s size %s files %d deleted %d garbage %.1f%%%s\n",
+				v.Id, v.RepType, v.Tier, formatBytes(v.Size), v.FileCount-v.DeleteCount, v.DeleteCount, 100*garbageRatio(v), flags)
+			count++
+			size += v.Size
+			garbage += v.DeletedByteCount
+		}
+	}
+	fmt.Fprintf(sh.out, "%d nodes, %d volume replicas, %s, %s garbage\n", len(t.Nodes), count, formatBytes(size), formatBytes(garbage))
+	return nil
+}
+
+func shellVolumeGrow(sh *shell, args []string) error {
+	fs := sh.flags("volume.grow")
+	replication := fs.String("replication", "000", "replication type of the new volumes")
+	count := fs.Int("count", 1, "number of volumes to create")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var ret struct {
+		Count int `json:"count"`
+	}
+	if err := sh.masterPost("/vol/grow", url.Values{"replication": {*replication}, "count": {strconv.Itoa(*count)}}, &ret); err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "created %d volumes of replication %s\n", ret.Count, *replication)
+	return nil
+}
+
+type shellVacuumStatus struct {
+	History []struct {
+		VolumeId       storage.VolumeId
+		Start          time.Time
+		End            time.Time
+		BytesReclaimed int64
+		Error          string
+	}
+}
+
+func shellVolumeVacuum(sh *shell, args []string) error {
+	fs := sh.flags("volume.vacuum")
+	threshold := fs.Float64("garbageThreshold", 0.3, "compact the volumes with a higher ratio of deleted bytes")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var before, after shellVacuumStatus
+	if err := sh.masterPost("/vol/vacuum/status", url.Values{"limit": {"1"}}, &before); err != nil {
+		return err
+	}
+	var status interface{}
+	if err := sh.masterPost("/vol/vacuum", url.Values{"garbageThreshold": {strconv.FormatFloat(*threshold, 'f', -1, 64)}}, &status); err != nil {
+		return err
+	}
+	if err := sh.masterPost("/vol/vacuum/status", url.Values{"limit": {"1000"}}, &after); err != nil {
+		return err
+	}
+	compacted := 0
+	for i := len(after.History) - 1; i >= 0; i-- {
+		r := after.History[i]
+		if len(before.History) > 0 && !r.Start.After(before.History[0].Start) {
+			continue
+		}
+		compacted++
+		if r.Error != "" {
+			fmt.Fprintf(sh.out, "volume %d failed after %v: %s\n", r.VolumeId, r.End.Sub(r.Start), r.Error)
+		} else {
+			fmt.Fprintf(sh.out, "volume %d reclaimed %s in %v\n", r.VolumeId, formatBytes(uint64(r.BytesReclaimed)), r.End.Sub(r.Start))
+		}
+	}
+	if compacted == 0 {
+		fmt.Fprintf(sh.out, "no volume compacted, none has more than %.0f%% garbage and no recent writes, or a vacuum is running already\n", 100**threshold)
+	}
+	return nil
+}
+
+//the live needles of a replica by key, from its index file
+type replicaIndex struct {
+	entries map[uint64][2]uint32 //offset and size
+	beyond  int                  //entries pointing past the end of the data file
+}
+
+func readReplicaIndex(server string, vid storage.VolumeId) (*replicaIndex, error) {
+	resp, err := volumeFile(server, "HEAD", vid, ".dat")
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	datSize := resp.ContentLength
+	if resp, err = volumeFile(server, "GET", vid, ".idx"); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	idx := &replicaIndex{entries: make(map[uint64][2]uint32)}
+	for i := 0; i+16 <= len(data); i += 16 {
+		key := util.BytesToUint64(data[i : i+8])
+		offset, size := util.BytesToUint32(data[i+8:i+12]), util.BytesToUint32(data[i+12:i+16])
+		if offset > 0 {
+			idx.entries[key] = [2]uint32{offset, size}
+		} else {
+			delete(idx.entries, key)
+		}
+	}
+	for _, e := range idx.entries {
+		end := int64(e[0])*storage.NeedlePaddingSize + storage.NeedleHeaderSize + int64(e[1]) + storage.NeedleChecksumSize
+		if end > datSize {
+			idx.beyond++
+		}
+	}
+	return idx, nil
+}
+
+//checks one volume, and returns its problems
+func fsckVolume(vid storage.VolumeId, replicas []*shellNode) (files int, problems []string) {
+	indexes := make([]*replicaIndex, len(replicas))
+	for i, n := range replicas {
+		idx, err := readReplicaIndex(n.Url, vid)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", n.Url, err))
+			continue
+		}
+		if idx.beyond > 0 {
+			problems = append(problems, fmt.Sprintf("%s: %d files past the end of the data file", n.Url, idx.beyond))
+		}
+		indexes[i] = idx
+		if len(idx.entries) > files {
+			files = len(idx.entries)
+		}
+	}
+	keys := make(map[uint64]bool)
+	for _, idx := range indexes {
+		if idx != nil {
+			for key := range idx.entries {
+				keys[key] = true
+			}
+		}
+	}
+	//the sizes are not compared, the replicas of a write may differ in name or mime
+	missing := make([]int, len(replicas))
+	for key := range keys {
+		for i, idx := range indexes {
+			if idx != nil {
+				if _, ok := idx.entries[key]; !ok {
+					missing[i]++
+				}
+			}
+		}
+	}
+	for i, n := range replicas {
+		if missing[i] > 0 {
+			problems = append(problems, fmt.Sprintf("%s: %d of %d files missing", n.Url, missing[i], len(keys)))
+		}
+	}
+	return files, problems
+}
+
+func shellVolumeFsck(sh *shell, args []string) error {
+	fs := sh.flags("volume.fsck")
+	volumeId := fs.String("volumeId", "", "only this volume")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	vid, only, err := parseShellVolumeId(*volumeId)
+	if err != nil {
+		return err
+	}
+	t, err := sh.topology()
+	if err != nil {
+		return err
+	}
+	ids := t.volumeIds()
+	if only {
+		ids = []storage.VolumeId{vid}
+	}
+	broken := 0
+	for _, id := range ids {
+		replicas := t.replicas(id)
+		if len(replicas) == 0 {
+			return fmt.Errorf("volume %d not found", id)
+		}
+		files, problems := fsckVolume(id, replicas)
+		if len(problems) == 0 {
+			fmt.Fprintf(sh.out, "volume %d ok, %d files on %d replicas\n", id, files, len(replicas))
+			continue
+		}
+		broken++
+		fmt.Fprintf(sh.out, "volume %d:\n", id)
+		for _, p := range problems {
+			fmt.Fprintf(sh.out, "  %s\n", p)
+		}
+	}
+	if broken > 0 {
+		return fmt.Errorf("%d of %d volumes have problems", broken, len(ids))
+	}
+	return nil
+}
+
+//why the replicas of a volume do not match its replication type, empty if they do
+func placementViolation(rt storage.ReplicationType, replicas []*shellNode) string {
+	if len(replicas) < rt.GetCopyCount() {
+		return fmt.Sprintf("%d of %d replicas", len(replicas), rt.GetCopyCount())
+	}
+	if len(replicas) > rt.GetCopyCount() {
+		return fmt.Sprintf("%d replicas, %d expected", len(replicas), rt.GetCopyCount())
+	}
+	dcs, racks := make(map[string]int), make(map[string]bool)
+	for _, n := range replicas {
+		dcs[n.DataCenter]++
+		racks[n.DataCenter+"/"+n.Rack] = true
+	}
+	switch rt {
+	case storage.Copy001:
+		if len(racks) != 1 {
+			return "replicas on different racks, expected on one rack"
+		}
+	case storage.Copy010:
+		if len(dcs) != 1 || len(racks) != 2 {
+			return "expected on two racks of one data center"
+		}
+	case storage.Copy100, storage.Copy200:
+		if len(dcs) != len(replicas) {
+			return "expected each replica in its own data center"
+		}
+	case storage.Copy110:
+		ok := len(dcs) == 2 && len(racks) == 3
+		for _, count := range dcs {
+			ok = ok && (count == 1 || count == 2)
+		}
+		if !ok {
+			return "expected two replicas on different racks of one data center, and one in another"
+		}
+	}
+	return ""
+}
+
+func shellClusterCheck(sh *shell, args []string) error {
+	t, err := sh.topology()
+	if err != nil {
+		return err
+	}
+	problems := 0
+	report := func(format string, a ...interface{}) {
+		problems++
+		fmt.Fprintf(sh.out, format+"\n", a...)
+	}
+	for _, n := range t.Nodes {
+		if n.Max > 0 && len(n.Volumes) > n.Max {
+			report("node %s: %d volumes, more than its %d", n.Url, len(n.Volumes), n.Max)
+		}
+	}
+	ids := t.volumeIds()
+	for _, vid := range ids {
+		replicas := t.replicas(vid)
+		first, _ := replicas[0].volume(vid)
+		if v := placementViolation(first.RepType, replicas); v != "" {
+			report("volume %d replication %s: %s, on %s", vid, first.RepType, v, nodeUrls(replicas))
+		}
+		for _, n := range replicas[1:] {
+			v, _ := n.volume(vid)
+			if v.RepType != first.RepType {
+				report("volume %d: replication %s on %s, %s on %s", vid, first.RepType, replicas[0].Url, v.RepType, n.Url)
+			}
+			if v.ReadOnly != first.ReadOnly {
+				report("volume %d: read only on only some replicas, on %s", vid, nodeUrls(replicas))
+			}
+			if v.FileCount-v.DeleteCount != first.FileCount-first.DeleteCount {
+				report("volume %d: %d files on %s, %d on %s", vid, first.FileCount-first.DeleteCount, replicas[0].Url, v.FileCount-v.DeleteCount, n.Url)
+			}
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problems in %d volumes on %d nodes", problems, len(ids), len(t.Nodes))
+	}
+	fmt.Fprintf(sh.out, "ok, %d volumes on %d nodes\n", len(ids), len(t.Nodes))
+	return nil
+}
+
+func nodeUrls(nodes []*shellNode) string {
+	urls := make([]string, len(nodes))
+	for i, n := range nodes {
+		urls[i] = n.Url
+	}
+	return strings.Join(urls, ",")
+}
+
+//moves a replica through the master, which keeps the volume locations up to date,
+//and updates the local view of the topology
+func (sh *shell) moveVolume(v storage.VolumeInfo, from, to *shellNode, tier string) error {
+	var ret interface{}
+	if err := sh.masterPost("/vol/move", url.Values{"volumeId": {v.Id.String()}, "from": {from.Url}, "to": {to.Url}, "tier": {tier}}, &ret); err != nil {
+		return err
+	}
+	from.Volumes = removeVolumeInfo(from.Volumes, v.Id)
+	from.Free++
+	to.Volumes = append(to.Volumes, v)
+	to.Free--
+	return nil
+}
+
+func removeVolumeInfo(list []storage.VolumeInfo, vid storage.VolumeId) []storage.VolumeInfo {
+	ret := list[:0]
+	for _, v := range list {
+		if v.Id != vid {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+//the replicas of a volume once moved from a node to another
+func movedReplicas(replicas []*shellNode, from, to *shellNode) []*shellNode {
+	ret := []*shellNode{to}
+	for _, n := range replicas {
+		if n != from {
+			ret = append(ret, n)
+		}
+	}
+	return ret
+}
+
+func shellVolumeMove(sh *shell, args []string) error {
+	fs := sh.flags("volume.move")
+	volumeId := fs.String("volumeId", "", "volume to move")
+	source := fs.String("from", "", "node holding the replica to move")
+	target := fs.String("to", "", "node to move it to")
+	tier := fs.String("tier", "", "tier of the directory on the target, any if empty")
+	force := fs.Bool("force", false, "move even if the replica placement is violated after")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	vid, ok, err := parseShellVolumeId(*volumeId)
+	if err != nil || !ok || *source == "" || *target == "" {
+		return errors.New("usage: volume.move -volumeId=N -from=host:port -to=host:port")
+	}
+	t, err := sh.topology()
+	if err != nil {
+		return err
+	}
+	from, to := t.node(*source), t.node(*target)
+	if from == nil || to == nil {
+		return fmt.Errorf("unknown node %s", map[bool]string{true: *source, false: *target}[from == nil])
+	}
+	v, ok := from.volume(vid)
+	if !ok {
+		return fmt.Errorf("volume %d is not on %s", vid, from.Url)
+	}
+	if _, ok = to.volume(vid); ok {
+		return fmt.Errorf("volume %d is on %s already", vid, to.Url)
+	}
+	if violation := placementViolation(v.RepType, movedReplicas(t.replicas(vid), from, to)); violation != "" && !*force {
+		return fmt.Errorf("after the move, volume %d replication %s would have %s; use -force to move anyway", vid, v.RepType, violation)
+	}
+	start := time.Now()
+	if err = sh.moveVolume(v, from, to, *tier); err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "moved volume %d (%s) from %s to %s in %v\n", vid, formatBytes(v.Size), from.Url, to.Url, time.Since(start))
+	return nil
+}
+
+//the node to move a replica to: one keeping the placement, with free space, in the
+//same rack or else data center if possible, with the most free space
+func drainTarget(t *shellTopology, v storage.VolumeInfo, from *shellNode) *shellNode {
+	replicas := t.replicas(v.Id)
+	var picked *shellNode
+	score := func(n *shellNode) int {
+		s := 0
+		if n.DataCenter == from.DataCenter {
+			s++
+			if n.Rack == from.Rack {
+				s++
+			}
+		}
+		return s
+	}
+	for _, n := range t.Nodes {
+		if n == from || n.Free <= 0 {
+			continue
+		}
+		if _, ok := n.volume(v.Id); ok {
+			continue
+		}
+		if placementViolation(v.RepType, movedReplicas(replicas, from, n)) != "" {
+			continue
+		}
+		if picked == nil || score(n) > score(picked) || score(n) == score(picked) && n.Free > picked.Free {
+			picked = n
+		}
+	}
+	return picked
+}
+
+func shellNodeDrain(sh *shell, args []string) error {
+	fs := sh.flags("node.drain")
+	node := fs.String("node", "", "node to move all volumes from")
+	dryRun := fs.Bool("dryRun", false, "only show where the volumes would go")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *node == "" {
+		return errors.New("usage: node.drain -node=host:port [-dryRun]")
+	}
+	t, err := sh.topology()
+	if err != nil {
+		return err
+	}
+	from := t.node(*node)
+	if from == nil {
+		return fmt.Errorf("unknown node %s", *node)
+	}
+	volumes := append([]storage.VolumeInfo{}, from.Volumes...)
+	failed := 0
+	for _, v := range volumes {
+		to := drainTarget(t, v, from)
+		if to == nil {
+			failed++
+			fmt.Fprintf(sh.out, "volume %d: no node with free space keeps replication %s\n", v.Id, v.RepType)
+			continue
+		}
+		if *dryRun {
+			fmt.Fprintf(sh.out, "volume %d (%s) would move to %s\n", v.Id, formatBytes(v.Size), to.Url)
+			//count the move, so the next volumes see the space it takes
+			from.Volumes = removeVolumeInfo(from.Volumes, v.Id)
+			to.Volumes, to.Free = append(to.Volumes, v), to.Free-1
+			continue
+		}
+		if err = sh.moveVolume(v, from, to, ""); err != nil {
+			failed++
+			fmt.Fprintf(sh.out, "volume %d: %v\n", v.Id, err)
+			continue
+		}
+		fmt.Fprintf(sh.out, "volume %d (%s) moved to %s\n", v.Id, formatBytes(v.Size), to.Url)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d volumes left on %s", failed, len(volumes), from.Url)
+	}
+	return nil
+}
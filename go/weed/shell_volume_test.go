@@ -0,0 +1,98 @@
+package main
+
+import (
+	"code.google.com/p/weed-fs/go/storage"
+	"strings"
+	"testing"
+)
+
+func TestParseShellVolumeId(t *testing.T) {
+	for _, test := range []struct {
+		in    string
+		vid   storage.VolumeId
+		given bool
+		err   bool
+	}{
+		{"", 0, false, false},
+		{"7", 7, true, false},
+		{"x", 0, false, true},
+		{"-1", 0, false, true},
+	} {
+		vid, given, err := parseShellVolumeId(test.in)
+		if vid != test.vid || given != test.given || (err != nil) != test.err {
+			t.Errorf("%q: got %d %v %v", test.in, vid, given, err)
+		}
+	}
+}
+
+func shellTestNode(u, dc, rack string, free int, vids ...storage.VolumeId) *shellNode {
+	n := &shellNode{Url: u, DataCenter: dc, Rack: rack, Free: free}
+	for _, vid := range vids {
+		n.Volumes = append(n.Volumes, storage.VolumeInfo{Id: vid, RepType: storage.Copy001})
+	}
+	return n
+}
+
+func TestPlacementViolation(t *testing.T) {
+	a1 := shellTestNode("a1", "dc1", "r1", 1)
+	a2 := shellTestNode("a2", "dc1", "r1", 1)
+	b1 := shellTestNode("b1", "dc1", "r2", 1)
+	c1 := shellTestNode("c1", "dc2", "r1", 1)
+	d1 := shellTestNode("d1", "dc3", "r1", 1)
+	for _, test := range []struct {
+		rt        storage.ReplicationType
+		replicas  []*shellNode
+		violation string
+	}{
+		{storage.Copy000, []*shellNode{a1}, ""},
+		{storage.Copy000, []*shellNode{a1, a2}, "2 replicas"},
+		{storage.Copy001, []*shellNode{a1, a2}, ""},
+		{storage.Copy001, []*shellNode{a1}, "1 of 2"},
+		{storage.Copy001, []*shellNode{a1, b1}, "different racks"},
+		{storage.Copy010, []*shellNode{a1, b1}, ""},
+		{storage.Copy010, []*shellNode{a1, a2}, "two racks"},
+		{storage.Copy010, []*shellNode{a1, c1}, "two racks"},
+		{storage.Copy100, []*shellNode{a1, c1}, ""},
+		{storage.Copy100, []*shellNode{a1, b1}, "own data center"},
+		{storage.Copy200, []*shellNode{a1, c1, d1}, ""},
+		{storage.Copy200, []*shellNode{a1, b1, c1}, "own data center"},
+		{storage.Copy110, []*shellNode{a1, b1, c1}, ""},
+		{storage.Copy110, []*shellNode{a1, a2, c1}, "different racks"},
+		{storage.Copy110, []*shellNode{a1, c1, d1}, "different racks"},
+	} {
+		got := placementViolation(test.rt, test.replicas)
+		if test.violation == "" && got != "" || test.violation != "" && !strings.Contains(got, test.violation) {
+			t.Errorf("%s on %d replicas: got %q, want %q", test.rt, len(test.replicas), got, test.violation)
+		}
+	}
+}
+
+func TestMovedReplicas(t *testing.T) {
+	a, b, c := shellTestNode("a", "", "", 1), shellTestNode("b", "", "", 1), shellTestNode("c", "", "", 1)
+	moved := movedReplicas([]*shellNode{a, b}, a, c)
+	if len(moved) != 2 || moved[0] != c || moved[1] != b {
+		t.Errorf("moved replicas %v, want c and b", moved)
+	}
+	if moved = movedReplicas([]*shellNode{b}, a, c); len(moved) != 2 {
+		t.Errorf("moved replicas of a node not holding one: %v", moved)
+	}
+}
+
+func TestDrainTarget(t *testing.T) {
+	from := shellTestNode("a1", "dc1", "r1", 1, 1)
+	holder := shellTestNode("a2", "dc1", "r1", 5, 1)
+	full := shellTestNode("a3", "dc1", "r1", 0)
+	sameRack := shellTestNode("a4", "dc1", "r1", 2)
+	sameRackFreer := shellTestNode("a5", "dc1", "r1", 3)
+	otherRack := shellTestNode("b1", "dc1", "r2", 9)
+	topo := &shellTopology{Nodes: []*shellNode{from, holder, full, sameRack, sameRackFreer, otherRack}}
+	v, _ := from.volume(1)
+
+	if picked := drainTarget(topo, v, from); picked != sameRackFreer {
+		t.Errorf("picked %v, want the freer node of the same rack", picked)
+	}
+	sameRack.Free, sameRackFreer.Free = 0, 0
+	if picked := drainTarget(topo, v, from); picked != nil {
+		t.Errorf("picked %s, which breaks the placement of replication 001", picked.Url)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"code.google.com/p/weed-fs/go/util"
+)
+
+// tlsFlags are the TLS settings of a command. Servers listen with TLS when given
+// a certificate and key, and present the same certificate when calling other
+// servers, so the cluster can require mutual TLS. Clients use https when given
+// a CA or a certificate, or with -https.
+type tlsFlags struct {
+	server bool
+	cert   *string
+	key    *string
+	caCert *string
+	mutual *bool
+	https  *bool
+}
+
+func newServerTLSFlags(fs *flag.FlagSet) *tlsFlags {
+	return &tlsFlags{
+		server: true,
+		cert:   fs.String("cert", "", "certificate file, to serve and call other servers over https"),
+		key:    fs.String("key", "", "private key file of the certificate"),
+		caCert: fs.String("caCert", "", "CA certificate file to verify other servers and clients with, the system ones if empty"),
+		mutual: fs.Bool("mutualTLS", false, "only accept clients presenting a certificate signed by -caCert"),
+	}
+}
+
+func newClientTLSFlags(fs *flag.FlagSet) *tlsFlags {
+	return &tlsFlags{
+		cert:   fs.String("cert", "", "client certificate file, for servers requiring mutual TLS"),
+		key:    fs.String("key", "", "private key file of the client certificate"),
+		caCert: fs.String("caCert", "", "CA certificate file to verify the servers with, the system ones if empty"),
+		https:  fs.Bool("https", false, "call the servers over https, implied by -caCert or -cert"),
+	}
+}
+
+func (f *tlsFlags) enabled() bool {
+	if f.server {
+		return *f.cert != "" || *f.key != ""
+	}
+	return *f.https || *f.caCert != "" || *f.cert != ""
+}
+
+//switches the requests of this process to https, if enabled
+func (f *tlsFlags) setup() error {
+	if !f.enabled() {
+		if f.server && *f.mutual {
+			return errors.New("-mutualTLS needs -cert and -key")
+		}
+		return nil
+	}
+	return util.SetupTLS(*f.caCert, *f.cert, *f.key)
+}
+
+func (f *tlsFlags) listenAndServe(srv *http.Server) error {
+	if !f.enabled() {
+		return srv.ListenAndServe()
+	}
+	config, err := util.ServerTLSConfig(*f.caCert, *f.mutual)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = config
+	return srv.ListenAndServeTLS(*f.cert, *f.key)
+}
@@ -1,111 +1,329 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"net/url"
+	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"code.google.com/p/weed-fs/go/operation"
-	"code.google.com/p/weed-fs/go/util"
-	"strconv"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/wlog"
+	"sync"
+	"time"
 )
 
-var uploadReplication *string
+//the manifest of a directory upload, in the directory unless -manifest is given
+const defaultManifestName = ".weed_upload_manifest"
+
+var (
+	uploadReplication *string
+	uploadDir         *string
+	uploadManifest    *string
+	uploadConcurrency *int
+	uploadRetries     *int
+	uploadTLS         *tlsFlags
+)
 
 func init() {
 	cmdUpload.Run = runUpload // break init cycle
-	cmdUpload.IsDebug = cmdUpload.Flag.Bool("debug", false, "verbose debug information")
+	cmdUpload.IsDebug = cmdUpload.Flag.Bool("debug", false, "verbose debug information, same as -v=3")
 	server = cmdUpload.Flag.String("server", "localhost:9333", "weedfs master location")
 	uploadReplication = cmdUpload.Flag.String("replication", "000", "replication type(000,001,010,100,110,200)")
+	uploadDir = cmdUpload.Flag.String("dir", "", "upload all files below this directory instead of the listed files")
+	uploadManifest = cmdUpload.Flag.String("manifest", "", "manifest file of a -dir upload, "+defaultManifestName+" in the directory if empty")
+	uploadConcurrency = cmdUpload.Flag.Int("concurrency", 8, "number of files of a -dir upload uploaded at the same time")
+	uploadRetries = cmdUpload.Flag.Int("retries", 2, "extra tries of each file of a -dir upload, with a new file id each")
+	uploadTLS = newClientTLSFlags(&cmdUpload.Flag)
 }
 
 var cmdUpload = &Command{
-	UsageLine: "upload -server=localhost:9333 file1 [file2 file3]",
-	Short:     "upload one or a list of files",
+	UsageLine: "upload -server=localhost:9333 [-dir=path] file1 [file2 file3]",
+	Short:     "upload one or a list of files, or a directory tree",
 	Long: `upload one or a list of files. 
   It uses consecutive file keys for the list of files.
   e.g. If the file1 uses key k, file2 can be read via k_1
 
+  With -dir, all files below the directory are uploaded, -concurrency at a time,
+  each with its own file id. A failed file is tried again with a new file id.
+  Each uploaded file is added to the manifest, one json object per line with
+  its path in the directory, fid, size, mtime and md5. Running the same upload
+  again only uploads the files missing from the manifest, or changed since,
+  with another size, mtime or md5. The old fid of a changed file is deleted once
+  the new one is in the manifest.
+
   `,
 }
 
-type AssignResult struct {
-	Fid       string `json:"fid"`
-	Url       string `json:"url"`
-	PublicUrl string `json:"publicUrl"`
-	Count     int
-	Error     string `json:"error"`
+type SubmitResult struct {
+	Fid   string `json:"fid"`
+	Size  int    `json:"size"`
+	Error string `json:"error"`
+
+	RequestId string `json:"requestId"` //to find the upload in the master and volume server logs
 }
 
-func assign(count int) (*AssignResult, error) {
-	values := make(url.Values)
-	values.Add("count", strconv.Itoa(count))
-	values.Add("replication", *uploadReplication)
-	jsonBlob, err := util.Post("http://"+*server+"/dir/assign", values)
-	debug("assign result :", string(jsonBlob))
-	if err != nil {
-		return nil, err
+//all files of one submit share a request id
+func submit(files []string) []SubmitResult {
+	requestId := trace.NewRequestId()
+	client := operation.NewClient(*server)
+	client.Replication = *uploadReplication
+	//the files are streamed, the client timeout would cut large ones
+	client.Timeout = 0
+	results := make([]SubmitResult, len(files))
+	var uploads []operation.UploadFile
+	var opened []int //index in files of each upload
+	for index, file := range files {
+		results[index].RequestId = requestId
+		wlog.Debug("start uploading file", "file", file)
+		fh, err := os.Open(file)
+		if err != nil {
+			wlog.Error("failed to open file", "file", file, "error", err)
+			results[index].Error = err.Error()
+			continue
+		}
+		defer fh.Close()
+		uploads = append(uploads, operation.UploadFile{Name: path.Base(file), Reader: fh})
+		opened = append(opened, index)
 	}
-	var ret AssignResult
-	err = json.Unmarshal(jsonBlob, &ret)
-	if err != nil {
-		return nil, err
+	if len(uploads) == 0 {
+		return results
 	}
-	if ret.Count <= 0 {
-		return nil, errors.New(ret.Error)
+	uploaded, errs := client.UploadBatch(trace.NewContext(context.Background(), requestId), uploads, "")
+	for i, index := range opened {
+		if errs[i] != nil {
+			results[index].Error = errs[i].Error()
+			continue
+		}
+		results[index].Fid, results[index].Size = uploaded[i].Fid, uploaded[i].Size
+	}
+	return results
+}
+
+// ManifestEntry is a file of a directory upload, a line of the manifest.
+type ManifestEntry struct {
+	Path  string    `json:"path"` //slash separated, relative to the directory
+	Fid   string    `json:"fid"`
+	Size  int64     `json:"size"`
+	Mtime time.Time `json:"mtime"`
+	Md5   string    `json:"md5"`
+}
+
+type DirUploadResult struct {
+	Manifest string             `json:"manifest"`
+	Uploaded int                `json:"uploaded"`
+	Skipped  int                `json:"skipped"`
+	Failed   []DirUploadFailure `json:"failed,omitempty"`
+	Orphaned []string           `json:"orphaned,omitempty"` //fids of replaced files which failed to be deleted
+
+	RequestId string `json:"requestId"`
+}
+
+type DirUploadFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+//the uploaded files of a manifest by path, the last line of a path wins, and the
+//length of its complete lines. a last line cut short by an interrupted upload is
+//ignored, and must be cut off before appending to the manifest.
+func readManifest(name string) (map[string]ManifestEntry, int64, error) {
+	entries := make(map[string]ManifestEntry)
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return entries, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	var complete int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			complete += int64(len(line))
+			var e ManifestEntry
+			if json.Unmarshal(line, &e) == nil && e.Path != "" {
+				entries[e.Path] = e
+			}
+		}
+		if err == io.EOF {
+			return entries, complete, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
 	}
-	return &ret, nil
 }
 
-func upload(filename string, server string, fid string) (int, error) {
-	debug("Start uploading file:", filename)
-	fh, err := os.Open(filename)
+func fileMd5(file string) (string, error) {
+	fh, err := os.Open(file)
 	if err != nil {
-		debug("Failed to open file:", filename)
-		return 0, err
+		return "", err
 	}
-	ret, e := operation.Upload("http://"+server+"/"+fid, path.Base(filename), fh)
-	if e != nil {
-		return 0, e
+	defer fh.Close()
+	hash := md5.New()
+	if _, err = io.Copy(hash, fh); err != nil {
+		return "", err
 	}
-	return ret.Size, e
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-type SubmitResult struct {
-	Fid   string `json:"fid"`
-	Size  int    `json:"size"`
-	Error string `json:"error"`
+//uploads one file, with a new assign on each try
+func uploadDirFile(ctx context.Context, client *operation.Client, file string, info os.FileInfo) (*ManifestEntry, error) {
+	var err error
+	for try := 0; try <= *uploadRetries; try++ {
+		var fh *os.File
+		if fh, err = os.Open(file); err != nil {
+			return nil, err
+		}
+		hash := md5.New()
+		var uploaded *operation.UploadedFile
+		uploaded, err = client.Upload(ctx, operation.UploadFile{Name: filepath.Base(file), Reader: io.TeeReader(fh, hash)}, "")
+		fh.Close()
+		if err == nil {
+			return &ManifestEntry{Fid: uploaded.Fid, Size: info.Size(), Mtime: info.ModTime(), Md5: hex.EncodeToString(hash.Sum(nil))}, nil
+		}
+		wlog.Warn("failed to upload file", "file", file, "try", try+1, "error", err)
+	}
+	return nil, err
 }
 
-func submit(files []string) []SubmitResult {
-	ret, err := assign(len(files))
+func uploadTree(dir string) (*DirUploadResult, error) {
+	manifestName := *uploadManifest
+	if manifestName == "" {
+		manifestName = filepath.Join(dir, defaultManifestName)
+	}
+	done, complete, err := readManifest(manifestName)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return nil, err
 	}
-	results := make([]SubmitResult, len(files))
-	for index, file := range files {
-		fid := ret.Fid
-		if index > 0 {
-			fid = fid + "_" + strconv.Itoa(index)
+	manifest, err := os.OpenFile(manifestName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer manifest.Close()
+	if stat, err := manifest.Stat(); err == nil && stat.Size() > complete {
+		if err = manifest.Truncate(complete); err != nil {
+			return nil, err
 		}
-		results[index].Size, err = upload(file, ret.PublicUrl, fid)
+	}
+	manifestPath, _ := filepath.Abs(manifestName)
+
+	requestId := trace.NewRequestId()
+	ctx := trace.NewContext(context.Background(), requestId)
+	client := operation.NewClient(*server)
+	client.Replication = *uploadReplication
+	client.Timeout = 0
+	result := &DirUploadResult{Manifest: manifestName, RequestId: requestId}
+	var lock sync.Mutex //of the result and the manifest
+
+	type job struct {
+		file     string
+		rel      string
+		info     os.FileInfo
+		previous *ManifestEntry //the last upload of the file, deleted once replaced
+	}
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < *uploadConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if p := j.previous; p != nil && p.Size == j.info.Size() && p.Mtime.Equal(j.info.ModTime()) {
+					if sum, err := fileMd5(j.file); err == nil && sum == p.Md5 {
+						lock.Lock()
+						result.Skipped++
+						lock.Unlock()
+						continue
+					}
+				}
+				e, err := uploadDirFile(ctx, client, j.file, j.info)
+				lock.Lock()
+				if err == nil {
+					e.Path = j.rel
+					line, _ := json.Marshal(e)
+					_, err = manifest.Write(append(line, '\n'))
+				}
+				if err != nil {
+					result.Failed = append(result.Failed, DirUploadFailure{Path: j.rel, Error: err.Error()})
+				} else {
+					result.Uploaded++
+				}
+				lock.Unlock()
+				if err == nil && j.previous != nil {
+					if err = client.Delete(ctx, j.previous.Fid); err != nil && err != operation.ErrNotFound {
+						wlog.Warn("failed to delete replaced file", "file", j.file, "fid", j.previous.Fid, "error", err)
+						lock.Lock()
+						result.Orphaned = append(result.Orphaned, j.previous.Fid)
+						lock.Unlock()
+					}
+				}
+			}
+		}()
+	}
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
 		if err != nil {
-			fid = ""
-			results[index].Error = err.Error()
+			//an unreadable file or directory fails alone, like a failed upload
+			lock.Lock()
+			result.Failed = append(result.Failed, DirUploadFailure{Path: filepath.ToSlash(file), Error: err.Error()})
+			lock.Unlock()
+			return nil
 		}
-		results[index].Fid = fid
-	}
-	return results
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if abs, _ := filepath.Abs(file); abs == manifestPath {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		var previous *ManifestEntry
+		if e, ok := done[rel]; ok {
+			previous = &e
+		}
+		jobs <- job{file, rel, info, previous}
+		return nil
+	})
+	close(jobs)
+	wg.Wait()
+	return result, err
 }
 
 func runUpload(cmd *Command, args []string) bool {
-	*IsDebug = true
-	if len(cmdUpload.Flag.Args()) == 0 {
+	if len(cmdUpload.Flag.Args()) == 0 && *uploadDir == "" {
+		return false
+	}
+	if err := uploadTLS.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid tls settings:", err)
 		return false
 	}
+	if *uploadDir != "" {
+		if *uploadConcurrency < 1 {
+			*uploadConcurrency = 1
+		}
+		result, err := uploadTree(*uploadDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to upload", *uploadDir+":", err)
+			setExitStatus(1)
+		}
+		if result != nil {
+			if len(result.Failed) > 0 {
+				setExitStatus(1)
+			}
+			bytes, _ := json.Marshal(result)
+			fmt.Print(string(bytes))
+		}
+		return true
+	}
 	results := submit(args)
 	bytes, _ := json.Marshal(results)
 	fmt.Print(string(bytes))
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadManifestTornLine(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, defaultManifestName)
+	lines := `{"path":"a","fid":"3,01","size":1}` + "\n" + `{"path":"b","fid":"3,02","size":2}` + "\n"
+	ioutil.WriteFile(name, []byte(lines+`{"path":"c","fid":"3,03","si`), 0644)
+
+	entries, complete, err := readManifest(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries["b"].Fid != "3,02" {
+		t.Errorf("read entries %v", entries)
+	}
+	if complete != int64(len(lines)) {
+		t.Errorf("complete lines end at %d, want %d", complete, len(lines))
+	}
+}
+
+func TestUploadTreeDeletesReplacedFiles(t *testing.T) {
+	var lock sync.Mutex
+	assigned, deleted := 0, []string{}
+	var cluster *httptest.Server
+	cluster = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		host := strings.TrimPrefix(cluster.URL, "http://")
+		switch {
+		case r.URL.Path == "/dir/assign":
+			assigned++
+			fmt.Fprintf(w, `{"fid":"3,%02x","url":"%s","publicUrl":"%s","count":1}`, assigned, host, host)
+		case r.URL.Path == "/dir/lookup":
+			fmt.Fprintf(w, `{"locations":[{"url":"%s","publicUrl":"%s"}]}`, host, host)
+		case r.Method == "POST":
+			fmt.Fprint(w, `{"size":1}`)
+		case r.Method == "DELETE":
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/"))
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprint(w, `{"size":1}`)
+		}
+	}))
+	defer cluster.Close()
+	savedServer, savedManifest := *server, *uploadManifest
+	defer func() { *server, *uploadManifest = savedServer, savedManifest }()
+	*server, *uploadManifest = strings.TrimPrefix(cluster.URL, "http://"), ""
+
+	dir, _ := ioutil.TempDir("", "weed")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b"), []byte("b"), 0644)
+	if result, err := uploadTree(dir); err != nil || result.Uploaded != 2 {
+		t.Fatalf("first upload: %+v, %v", result, err)
+	}
+	entries, _, _ := readManifest(filepath.Join(dir, defaultManifestName))
+	replaced := entries["b"].Fid
+
+	later := time.Now().Add(time.Minute)
+	ioutil.WriteFile(filepath.Join(dir, "b"), []byte("c"), 0644)
+	os.Chtimes(filepath.Join(dir, "b"), later, later)
+	result, err := uploadTree(dir)
+	if err != nil || result.Uploaded != 1 || result.Skipped != 1 || len(result.Orphaned) != 0 {
+		t.Fatalf("second upload: %+v, %v", result, err)
+	}
+	if len(deleted) != 1 || deleted[0] != replaced {
+		t.Fatalf("deleted %v, want the replaced %s", deleted, replaced)
+	}
+	if entries, _, _ = readManifest(filepath.Join(dir, defaultManifestName)); entries["b"].Fid == replaced {
+		t.Fatalf("manifest still has the replaced fid %s", replaced)
+	}
+}
@@ -2,13 +2,18 @@ package main
 
 import (
 	"bytes"
-	"log"
+	"encoding/json"
 	"math/rand"
 	"mime"
 	"net/http"
 	"os"
+	"code.google.com/p/weed-fs/go/metrics"
 	"code.google.com/p/weed-fs/go/operation"
+	"code.google.com/p/weed-fs/go/security"
 	"code.google.com/p/weed-fs/go/storage"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/wlog"
 	"runtime"
 	"strconv"
 	"strings"
@@ -17,7 +22,7 @@ import (
 
 func init() {
 	cmdVolume.Run = runVolume // break init cycle
-	cmdVolume.IsDebug = cmdVolume.Flag.Bool("debug", false, "enable debug mode")
+	cmdVolume.IsDebug = cmdVolume.Flag.Bool("debug", false, "enable debug mode, same as -v=3")
 }
 
 var cmdVolume = &Command{
@@ -29,35 +34,134 @@ var cmdVolume = &Command{
 }
 
 var (
-	vport          = cmdVolume.Flag.Int("port", 8080, "http listen port")
-	volumeFolder   = cmdVolume.Flag.String("dir", "/tmp", "directory to store data files")
-	ip             = cmdVolume.Flag.String("ip", "localhost", "ip or server name")
-	publicUrl      = cmdVolume.Flag.String("publicUrl", "", "Publicly accessible <ip|server_name>:<port>")
-	masterNode     = cmdVolume.Flag.String("mserver", "localhost:9333", "master server location")
-	vpulse         = cmdVolume.Flag.Int("pulseSeconds", 5, "number of seconds between heartbeats, must be smaller than the master's setting")
-	maxVolumeCount = cmdVolume.Flag.Int("max", 5, "maximum number of volumes")
-	vReadTimeout   = cmdVolume.Flag.Int("readTimeout", 3, "connection read timeout in seconds")
-	vMaxCpu        = cmdVolume.Flag.Int("maxCpu", 0, "maximum number of CPUs. 0 means all available CPUs")
-
-	store *storage.Store
+	vport           = cmdVolume.Flag.Int("port", 8080, "http listen port")
+	volumeFolders   = cmdVolume.Flag.String("dir", "/tmp", "directories to store data files. dir[,dir]...")
+	ip              = cmdVolume.Flag.String("ip", "localhost", "ip or server name")
+	publicUrl       = cmdVolume.Flag.String("publicUrl", "", "Publicly accessible <ip|server_name>:<port>")
+	masterNode      = cmdVolume.Flag.String("mserver", "localhost:9333", "master server location")
+	vpulse          = cmdVolume.Flag.Int("pulseSeconds", 5, "number of seconds between heartbeats, must be smaller than the master's setting")
+	volumeTiers     = cmdVolume.Flag.String("tier", storage.DefaultTier, "storage tiers of the directories, tier[,tier]..., e.g. hot, warm or cold")
+	maxVolumeCounts = cmdVolume.Flag.String("max", "5", "maximum numbers of volumes, count[,count]... for each directory, or one count for all. 0 means limited by disk space only")
+	vReadTimeout    = cmdVolume.Flag.Int("readTimeout", 3, "connection read timeout in seconds")
+	vMaxCpu         = cmdVolume.Flag.Int("maxCpu", 0, "maximum number of CPUs. 0 means all available CPUs")
+	vIndexType      = cmdVolume.Flag.String("index", "memory", "needle map type: memory, sorted(memory mapped, for read only volumes) or hybrid")
+	vCheckpoint     = cmdVolume.Flag.Int("checkpointMinutes", 10, "minutes between needle map checkpoints for faster restarts, 0 to disable")
+	vMinFreeSpace   = cmdVolume.Flag.Uint("minFreeSpaceMB", 100, "free space in MegaBytes kept on each disk, writes are refused below it")
+	vSecret         = cmdVolume.Flag.String("secret", "", "shared secret to sign and verify admin requests, same on the master and all volume servers")
+	vWhiteList      = cmdVolume.Flag.String("whiteList", "", "ip addresses or CIDR blocks allowed to call admin requests without a signature, ip[,ip]...")
+	vLocationTTL    = cmdVolume.Flag.Int("locationCacheSeconds", 60, "seconds the volume locations looked up from the master are cached, 0 to disable")
+	volumeTLS       = newServerTLSFlags(&cmdVolume.Flag)
+
+	store       *storage.Store
+	volumeGuard *security.Guard
 )
 
 var fileNameEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
 
+var (
+	volumeReadBytes         = metrics.NewCounterVec("weed_volume_read_bytes_total", "Bytes of file content served.")
+	volumeWrittenBytes      = metrics.NewCounterVec("weed_volume_written_bytes_total", "Bytes of file content written.")
+	volumeCrcErrors         = metrics.NewCounterVec("weed_volume_crc_errors_total", "Number of reads failing the CRC check.")
+	volumeHeartbeatFailures = metrics.NewCounterVec("weed_volume_heartbeat_failures_total", "Number of failed heartbeats to the master.")
+)
+
 func statusHandler(w http.ResponseWriter, r *http.Request) {
 	m := make(map[string]interface{})
 	m["Version"] = VERSION
 	m["Volumes"] = store.Status()
+	m["Disks"] = store.DiskStatus()
 	writeJson(w, r, m)
 }
+//the tier a volume was placed in, which can differ from the one asked for
+func volumeTier(volumeIdString string) string {
+	if vid, err := storage.NewVolumeId(volumeIdString); err == nil {
+		return store.VolumeTier(vid)
+	}
+	return ""
+}
 func assignVolumeHandler(w http.ResponseWriter, r *http.Request) {
-	err := store.AddVolume(r.FormValue("volume"), r.FormValue("replicationType"))
+	err := store.AddVolume(r.FormValue("volume"), r.FormValue("replicationType"), r.FormValue("tier"))
+	if err == nil {
+		writeJson(w, r, map[string]string{"error": "", "tier": volumeTier(r.FormValue("volume"))})
+	} else {
+		writeJson(w, r, map[string]string{"error": err.Error()})
+	}
+	wlog.Debug("assign volume", "volume", r.FormValue("volume"), "replication", r.FormValue("replicationType"), "tier", r.FormValue("tier"), "error", err)
+}
+//the master pushes the locations of volumes whose replicas changed
+func volumeLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	locations := make(map[string][]operation.Location)
+	if err := json.Unmarshal([]byte(r.FormValue("locations")), &locations); err != nil {
+		writeJson(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+	for vid, list := range locations {
+		if volumeId, err := storage.NewVolumeId(vid); err == nil {
+			operation.VolumeLocations.Set(volumeId, list)
+		}
+	}
+	wlog.Debug("volume locations pushed", "volumes", len(locations))
+	writeJson(w, r, map[string]string{"error": ""})
+}
+func moveVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	err := store.MoveVolume(r.FormValue("volume"), r.FormValue("tier"))
+	if err == nil {
+		writeJson(w, r, map[string]string{"error": "", "tier": volumeTier(r.FormValue("volume"))})
+	} else {
+		writeJson(w, r, map[string]string{"error": err.Error()})
+	}
+	wlog.Debug("move volume", "volume", r.FormValue("volume"), "tier", r.FormValue("tier"), "error", err)
+}
+func copyVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	err := store.CopyVolume(r.FormValue("volume"), r.FormValue("source"), r.FormValue("tier"))
+	if err == nil {
+		writeJson(w, r, map[string]string{"error": "", "tier": volumeTier(r.FormValue("volume"))})
+	} else {
+		writeJson(w, r, map[string]string{"error": err.Error()})
+	}
+	wlog.Debug("copy volume", "volume", r.FormValue("volume"), "source", r.FormValue("source"), "tier", r.FormValue("tier"), "error", err)
+}
+func volumeFileHandler(w http.ResponseWriter, r *http.Request) {
+	volumeId, err := storage.NewVolumeId(r.FormValue("volume"))
+	if err != nil {
+		wlog.Debug("invalid volume id", "volume", r.FormValue("volume"), "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ext := r.FormValue("ext")
+	v := store.GetVolume(volumeId)
+	if v == nil || ext != ".dat" && ext != ".idx" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	http.ServeFile(w, r, v.FileName()+ext)
+}
+func readonlyVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	err := store.SetVolumeReadOnly(r.FormValue("volume"), true)
 	if err == nil {
 		writeJson(w, r, map[string]string{"error": ""})
 	} else {
 		writeJson(w, r, map[string]string{"error": err.Error()})
 	}
-	debug("assign volume =", r.FormValue("volume"), ", replicationType =", r.FormValue("replicationType"), ", error =", err)
+	wlog.Debug("readonly volume", "volume", r.FormValue("volume"), "error", err)
+}
+func writableVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	err := store.SetVolumeReadOnly(r.FormValue("volume"), false)
+	if err == nil {
+		writeJson(w, r, map[string]string{"error": ""})
+	} else {
+		writeJson(w, r, map[string]string{"error": err.Error()})
+	}
+	wlog.Debug("writable volume", "volume", r.FormValue("volume"), "error", err)
+}
+func deleteVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	err := store.DeleteVolume(r.FormValue("volume"))
+	if err == nil {
+		writeJson(w, r, map[string]string{"error": ""})
+	} else {
+		writeJson(w, r, map[string]string{"error": err.Error()})
+	}
+	wlog.Debug("delete volume", "volume", r.FormValue("volume"), "error", err)
 }
 func vacuumVolumeCheckHandler(w http.ResponseWriter, r *http.Request) {
 	err, ret := store.CheckCompactVolume(r.FormValue("volume"), r.FormValue("garbageThreshold"))
@@ -66,7 +170,7 @@ func vacuumVolumeCheckHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		writeJson(w, r, map[string]interface{}{"error": err.Error(), "result": false})
 	}
-	debug("checked compacting volume =", r.FormValue("volume"), "garbageThreshold =", r.FormValue("garbageThreshold"), "vacuum =", ret)
+	wlog.Debug("checked compacting volume", "volume", r.FormValue("volume"), "garbageThreshold", r.FormValue("garbageThreshold"), "vacuum", ret)
 }
 func vacuumVolumeCompactHandler(w http.ResponseWriter, r *http.Request) {
 	err := store.CompactVolume(r.FormValue("volume"))
@@ -75,20 +179,26 @@ func vacuumVolumeCompactHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		writeJson(w, r, map[string]string{"error": err.Error()})
 	}
-	debug("compacted volume =", r.FormValue("volume"), ", error =", err)
+	wlog.Debug("compacted volume", "volume", r.FormValue("volume"), "error", err)
 }
 func vacuumVolumeCommitHandler(w http.ResponseWriter, r *http.Request) {
 	err := store.CommitCompactVolume(r.FormValue("volume"))
 	if err == nil {
-		writeJson(w, r, map[string]interface{}{"error": ""})
+		m := map[string]interface{}{"error": ""}
+		if vid, e := storage.NewVolumeId(r.FormValue("volume")); e == nil {
+			if v := store.GetVolume(vid); v != nil {
+				m["size"] = v.Size()
+			}
+		}
+		writeJson(w, r, m)
 	} else {
 		writeJson(w, r, map[string]string{"error": err.Error()})
 	}
-	debug("commit compact volume =", r.FormValue("volume"), ", error =", err)
+	wlog.Debug("commit compact volume", "volume", r.FormValue("volume"), "error", err)
 }
 func storeHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
-	case "GET":
+	case "GET", "HEAD":
 		GetHandler(w, r)
 	case "DELETE":
 		DeleteHandler(w, r)
@@ -99,36 +209,51 @@ func storeHandler(w http.ResponseWriter, r *http.Request) {
 func GetHandler(w http.ResponseWriter, r *http.Request) {
 	n := new(storage.Needle)
 	vid, fid, ext := parseURLPath(r.URL.Path)
+	requestId := r.Header.Get(trace.RequestIdHeader)
+	l := wlog.With("volume", vid, "fid", fid, "requestId", requestId)
 	volumeId, err := storage.NewVolumeId(vid)
 	if err != nil {
-		debug("parsing error:", err, r.URL.Path)
+		l.Debug("invalid volume id", "path", r.URL.Path, "error", err)
 		return
 	}
 	n.ParsePath(fid)
 
-	debug("volume", volumeId, "reading", n)
+	l.Debug("reading")
 	if !store.HasVolume(volumeId) {
-		lookupResult, err := operation.Lookup(*masterNode, volumeId)
-		debug("volume", volumeId, "found on", lookupResult, "error", err)
-		if err == nil {
-			http.Redirect(w, r, "http://"+lookupResult.Locations[0].PublicUrl+r.URL.Path, http.StatusMovedPermanently)
-		} else {
-			debug("lookup error:", err, r.URL.Path)
+		//the reader, or the server redirecting it here, had stale locations, and so
+		//may the cache, which would send the reader back where it came from
+		operation.VolumeLocations.Invalidate(volumeId)
+		lookupResult, err := operation.Lookup(*masterNode, volumeId, requestId)
+		if err != nil {
+			l.Debug("lookup failed", "error", err)
 			w.WriteHeader(http.StatusNotFound)
+			return
 		}
+		location, found := otherLocation(lookupResult.Locations)
+		if !found {
+			l.Debug("no other location", "locations", len(lookupResult.Locations))
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		l.Debug("redirecting", "location", location.PublicUrl)
+		http.Redirect(w, r, util.NormalizeUrl(location.PublicUrl)+r.URL.Path, http.StatusMovedPermanently)
 		return
 	}
 	cookie := n.Cookie
 	count, e := store.Read(volumeId, n)
-	debug("read bytes", count, "error", e)
+	if e == storage.ErrCrcMismatch {
+		volumeCrcErrors.Inc()
+		l.Error("read failed", "error", e)
+	}
 	if e != nil || count <= 0 {
-		debug("read error:", e, r.URL.Path)
+		l.Debug("read failed", "bytes", count, "error", e)
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 	if n.Cookie != cookie {
-		log.Println("request with unmaching cookie from ", r.RemoteAddr, "agent", r.UserAgent())
+		l.Warn("request with unmatching cookie", "remote", r.RemoteAddr, "agent", r.UserAgent())
 		w.WriteHeader(http.StatusNotFound)
+		writeJson(w, r, map[string]string{"error": "cookie mismatch"})
 		return
 	}
 	if n.NameSize > 0 {
@@ -157,26 +282,58 @@ func GetHandler(w http.ResponseWriter, r *http.Request) {
 				w.Header().Set("Content-Encoding", "gzip")
 			} else {
 				if n.Data, err = storage.UnGzipData(n.Data); err != nil {
-					debug("lookup error:", err, r.URL.Path)
+					l.Warn("failed to uncompress", "error", err)
 				}
 			}
 		}
 	}
 	w.Header().Set("Content-Length", strconv.Itoa(len(n.Data)))
+	if r.Method == "HEAD" {
+		return
+	}
 	w.Write(n.Data)
+	volumeReadBytes.Add(float64(len(n.Data)))
+}
+//writes and deletes need the file token issued by the master for the fid,
+//replicas forward them signed with the shared secret instead
+func authorizeFileRequest(w http.ResponseWriter, r *http.Request, vid, fid string) bool {
+	if !security.HasSecret() {
+		return true
+	}
+	var err error
+	if r.URL.Query().Get("type") == "standard" {
+		err = volumeGuard.Check(r)
+	} else {
+		err = security.VerifyFileToken(security.FileToken(r), vid+","+fid)
+	}
+	if err != nil {
+		wlog.Warn("unauthorized file request", "method", r.Method, "volume", vid, "fid", fid, "remote", r.RemoteAddr, "error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		writeJson(w, r, map[string]string{"error": "unauthorized: " + err.Error()})
+		return false
+	}
+	return true
 }
 func PostHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
-	vid, _, _ := parseURLPath(r.URL.Path)
+	vid, fid, _ := parseURLPath(r.URL.Path)
+	requestId := r.Header.Get(trace.RequestIdHeader)
+	l := wlog.With("volume", vid, "fid", fid, "requestId", requestId)
+	if !authorizeFileRequest(w, r, vid, fid) {
+		return
+	}
 	volumeId, e := storage.NewVolumeId(vid)
 	if e != nil {
+		l.Debug("invalid volume id", "path", r.URL.Path, "error", e)
 		writeJson(w, r, e)
 	} else {
 		needle, filename, ne := storage.NewNeedle(r)
 		if ne != nil {
 			writeJson(w, r, ne)
 		} else {
+			span := trace.StartSpan(requestId, "local write", "volume", vid, "fid", fid)
 			ret, err := store.Write(volumeId, needle)
+			span.End("bytes", ret, "error", err)
 			errorStatus := ""
 			needToReplicate := !store.HasVolume(volumeId)
 			if err != nil {
@@ -191,8 +348,8 @@ func PostHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			if needToReplicate { //send to other replica locations
 				if r.FormValue("type") != "standard" {
-					if !distributedOperation(volumeId, func(location operation.Location) bool {
-						_, err := operation.Upload("http://"+location.Url+r.URL.Path+"?type=standard", filename, bytes.NewReader(needle.Data))
+					if !distributedOperation(volumeId, requestId, func(location operation.Location) bool {
+						_, err := operation.Upload(util.NormalizeUrl(location.Url)+r.URL.Path+"?type=standard", filename, bytes.NewReader(needle.Data), requestId, "")
 						return err == nil
 					}) {
 						ret = 0
@@ -202,11 +359,13 @@ func PostHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			m := make(map[string]interface{})
 			if errorStatus == "" {
+				volumeWrittenBytes.Add(float64(len(needle.Data)))
 				w.WriteHeader(http.StatusCreated)
 			} else {
+				l.Error("write failed", "error", errorStatus)
 				store.Delete(volumeId, needle)
-				distributedOperation(volumeId, func(location operation.Location) bool {
-					return nil == operation.Delete("http://"+location.Url+r.URL.Path+"?type=standard")
+				distributedOperation(volumeId, requestId, func(location operation.Location) bool {
+					return deletedOnReplica(operation.Delete(util.NormalizeUrl(location.Url)+r.URL.Path+"?type=standard", requestId, ""))
 				})
 				w.WriteHeader(http.StatusInternalServerError)
 				m["error"] = errorStatus
@@ -222,7 +381,12 @@ func DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	volumeId, _ := storage.NewVolumeId(vid)
 	n.ParsePath(fid)
 
-	debug("deleting", n)
+	requestId := r.Header.Get(trace.RequestIdHeader)
+	l := wlog.With("volume", vid, "fid", fid, "requestId", requestId)
+	if !authorizeFileRequest(w, r, vid, fid) {
+		return
+	}
+	l.Debug("deleting")
 
 	cookie := n.Cookie
 	count, ok := store.Read(volumeId, n)
@@ -230,19 +394,24 @@ func DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	if ok != nil {
 		m := make(map[string]uint32)
 		m["size"] = 0
+		w.WriteHeader(http.StatusNotFound)
 		writeJson(w, r, m)
 		return
 	}
 
 	if n.Cookie != cookie {
-		log.Println("delete with unmaching cookie from ", r.RemoteAddr, "agent", r.UserAgent())
+		l.Warn("delete with unmatching cookie", "remote", r.RemoteAddr, "agent", r.UserAgent())
+		w.WriteHeader(http.StatusNotFound)
+		writeJson(w, r, map[string]string{"error": "cookie mismatch"})
 		return
 	}
 
 	n.Size = 0
 	ret, err := store.Delete(volumeId, n)
 	if err != nil {
-		log.Println("delete error:", err)
+		l.Error("delete failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJson(w, r, map[string]string{"error": err.Error()})
 		return
 	}
 
@@ -252,8 +421,8 @@ func DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	if needToReplicate { //send to other replica locations
 		if r.FormValue("type") != "standard" {
-			if !distributedOperation(volumeId, func(location operation.Location) bool {
-				return nil == operation.Delete("http://"+location.Url+r.URL.Path+"?type=standard")
+			if !distributedOperation(volumeId, requestId, func(location operation.Location) bool {
+				return deletedOnReplica(operation.Delete(util.NormalizeUrl(location.Url)+r.URL.Path+"?type=standard", requestId, ""))
 			}) {
 				ret = 0
 			}
@@ -271,13 +440,110 @@ func DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	writeJson(w, r, m)
 }
 
+//deletes the files of all fid values at once, and forwards the deleted ones to each
+//replica with one request per volume. There are no per-file tokens in a bulk
+//delete, so with a secret only white listed or signed callers may use it.
+func batchDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if security.HasSecret() {
+		if err := volumeGuard.Check(r); err != nil {
+			wlog.Warn("unauthorized bulk delete", "remote", r.RemoteAddr, "error", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			writeJson(w, r, map[string]string{"error": "unauthorized: " + err.Error()})
+			return
+		}
+	}
+	requestId := r.Header.Get(trace.RequestIdHeader)
+	fids := r.Form["fid"]
+	results := make([]operation.DeleteResult, len(fids))
+	deleted := make(map[storage.VolumeId][]int)
+	for i, fid := range fids {
+		results[i] = deleteFile(fid, requestId)
+		if results[i].Status == http.StatusAccepted {
+			volumeId, _ := storage.NewVolumeId(fid[:strings.Index(fid, ",")])
+			deleted[volumeId] = append(deleted[volumeId], i)
+		}
+	}
+	if r.FormValue("type") != "standard" {
+		for volumeId, indexes := range deleted {
+			if v := store.GetVolume(volumeId); v == nil || !v.NeedToReplicate() {
+				continue
+			}
+			volumeFids := make([]string, len(indexes))
+			for j, i := range indexes {
+				volumeFids[j] = fids[i]
+			}
+			if !distributedOperation(volumeId, requestId, func(location operation.Location) bool {
+				replicaResults, err := operation.DeleteFiles(util.NormalizeUrl(location.Url)+"/delete?type=standard", volumeFids, requestId)
+				if err != nil {
+					return false
+				}
+				for _, ret := range replicaResults {
+					if ret.Status >= 300 && ret.Status != http.StatusNotFound {
+						return false
+					}
+				}
+				return true
+			}) {
+				for _, i := range indexes {
+					results[i].Status = http.StatusInternalServerError
+					results[i].Error = "failed to delete on replicas of volume " + volumeId.String()
+				}
+			}
+		}
+	}
+	writeJson(w, r, results)
+}
+
+//a replica missing the file already has it deleted
+func deletedOnReplica(err error) bool {
+	return err == nil || err == operation.ErrNotFound
+}
+
+//deletes one file locally, checking its cookie like DeleteHandler
+func deleteFile(fid string, requestId string) operation.DeleteResult {
+	ret := operation.DeleteResult{Fid: fid}
+	l := wlog.With("fid", fid, "requestId", requestId)
+	commaSep := strings.Index(fid, ",")
+	if commaSep <= 0 {
+		ret.Status, ret.Error = http.StatusNotAcceptable, "invalid fid"
+		return ret
+	}
+	volumeId, err := storage.NewVolumeId(fid[:commaSep])
+	if err != nil {
+		ret.Status, ret.Error = http.StatusNotAcceptable, "invalid fid"
+		return ret
+	}
+	n := new(storage.Needle)
+	n.ParsePath(fid[commaSep+1:])
+	cookie := n.Cookie
+	count, err := store.Read(volumeId, n)
+	if err != nil || count <= 0 {
+		ret.Status, ret.Error = http.StatusNotFound, "not found"
+		return ret
+	}
+	if n.Cookie != cookie {
+		l.Warn("delete with unmatching cookie")
+		ret.Status, ret.Error = http.StatusNotFound, "cookie mismatch"
+		return ret
+	}
+	n.Size = 0
+	if _, err = store.Delete(volumeId, n); err != nil {
+		l.Error("delete failed", "error", err)
+		ret.Status, ret.Error = http.StatusInternalServerError, err.Error()
+		return ret
+	}
+	ret.Status, ret.Size = http.StatusAccepted, uint32(count)
+	return ret
+}
+
 func parseURLPath(path string) (vid, fid, ext string) {
 
 	sepIndex := strings.LastIndex(path, "/")
 	commaIndex := strings.LastIndex(path[sepIndex:], ",")
 	if commaIndex <= 0 {
 		if "favicon.ico" != path[sepIndex+1:] {
-			log.Println("unknown file id", path[sepIndex+1:])
+			wlog.Debug("unknown file id", "fid", path[sepIndex+1:])
 		}
 		return
 	}
@@ -292,16 +558,35 @@ func parseURLPath(path string) (vid, fid, ext string) {
 	return
 }
 
-func distributedOperation(volumeId storage.VolumeId, op func(location operation.Location) bool) bool {
-	if lookupResult, lookupErr := operation.Lookup(*masterNode, volumeId); lookupErr == nil {
+func selfUrl() string {
+	return util.LocationUrl(*ip + ":" + strconv.Itoa(*vport))
+}
+
+//the first of the locations that is not this server
+func otherLocation(locations []operation.Location) (operation.Location, bool) {
+	self := selfUrl()
+	for _, location := range locations {
+		if location.Url != self {
+			return location, true
+		}
+	}
+	return operation.Location{}, false
+}
+
+//runs op on all other replica locations in parallel, each call timed as a span of the request
+func distributedOperation(volumeId storage.VolumeId, requestId string, op func(location operation.Location) bool) bool {
+	if lookupResult, lookupErr := operation.Lookup(*masterNode, volumeId, requestId); lookupErr == nil {
 		length := 0
-		selfUrl := (*ip + ":" + strconv.Itoa(*vport))
+		self := selfUrl()
 		results := make(chan bool)
 		for _, location := range lookupResult.Locations {
-			if location.Url != selfUrl {
+			if location.Url != self {
 				length++
 				go func(location operation.Location, results chan bool) {
-					results <- op(location)
+					span := trace.StartSpan(requestId, "replica", "volume", volumeId, "location", location.Url)
+					ok := op(location)
+					span.End("ok", ok)
+					results <- ok
 				}(location, results)
 			}
 		}
@@ -309,40 +594,108 @@ func distributedOperation(volumeId storage.VolumeId, op func(location operation.
 		for i := 0; i < length; i++ {
 			ret = ret && <-results
 		}
+		if !ret {
+			//the failing replica may have moved, look it up again next time
+			operation.VolumeLocations.Invalidate(volumeId)
+		}
 		return ret
 	} else {
-		log.Println("Failed to lookup for", volumeId, lookupErr.Error())
+		wlog.Error("failed to lookup", "volume", volumeId, "requestId", requestId, "error", lookupErr)
 	}
 	return false
 }
 
+//per volume gauges, read from the store on each scrape
+func registerVolumeMetrics() {
+	metrics.NewGaugeFunc("weed_volume_size_bytes", "Content size of each volume.", func(emit func(float64, ...string)) {
+		for _, v := range store.Status() {
+			emit(float64(v.Size), v.Id.String())
+		}
+	}, "volume")
+	metrics.NewGaugeFunc("weed_volume_file_count", "Number of files written to each volume, including deleted ones.", func(emit func(float64, ...string)) {
+		for _, v := range store.Status() {
+			emit(float64(v.FileCount), v.Id.String())
+		}
+	}, "volume")
+	metrics.NewGaugeFunc("weed_volume_garbage_ratio", "Ratio of deleted bytes to content size of each volume.", func(emit func(float64, ...string)) {
+		for _, v := range store.Status() {
+			if v.Size > 0 {
+				emit(float64(v.DeletedByteCount)/float64(v.Size), v.Id.String())
+			} else {
+				emit(0, v.Id.String())
+			}
+		}
+	}, "volume")
+}
+
 func runVolume(cmd *Command, args []string) bool {
 	if *vMaxCpu < 1 {
 		*vMaxCpu = runtime.NumCPU()
 	}
 	runtime.GOMAXPROCS(*vMaxCpu)
-	fileInfo, err := os.Stat(*volumeFolder)
+	locations, err := storage.NewDiskLocations(*volumeFolders, *maxVolumeCounts, *volumeTiers)
 	if err != nil {
-		log.Fatalf("No Existing Folder:%s", *volumeFolder)
+		wlog.Fatal("invalid data directories", "error", err)
 	}
-	if !fileInfo.IsDir() {
-		log.Fatalf("Volume Folder should not be a file:%s", *volumeFolder)
+	for _, location := range locations {
+		fileInfo, err := os.Stat(location.Directory)
+		if err != nil {
+			wlog.Fatal("no existing folder", "dir", location.Directory)
+		}
+		if !fileInfo.IsDir() {
+			wlog.Fatal("volume folder should not be a file", "dir", location.Directory)
+		}
+		perm := fileInfo.Mode().Perm()
+		wlog.Info("volume folder", "dir", location.Directory, "permission", perm)
 	}
-	perm := fileInfo.Mode().Perm()
-	log.Println("Volume Folder permission:", perm)
 
 	if *publicUrl == "" {
 		*publicUrl = *ip + ":" + strconv.Itoa(*vport)
 	}
 
-	store = storage.NewStore(*vport, *ip, *publicUrl, *volumeFolder, *maxVolumeCount)
+	needleMapKind, err := storage.NewNeedleMapType(*vIndexType)
+	if err != nil {
+		wlog.Fatal("invalid needle map type", "error", err)
+	}
+
+	store = storage.NewStore(*vport, *ip, *publicUrl, locations, needleMapKind)
+	store.MinFreeSpace = uint64(*vMinFreeSpace) * 1024 * 1024
 	defer store.Close()
-	http.HandleFunc("/", storeHandler)
-	http.HandleFunc("/status", statusHandler)
-	http.HandleFunc("/admin/assign_volume", assignVolumeHandler)
-	http.HandleFunc("/admin/vacuum_volume_check", vacuumVolumeCheckHandler)
-	http.HandleFunc("/admin/vacuum_volume_compact", vacuumVolumeCompactHandler)
-	http.HandleFunc("/admin/vacuum_volume_commit", vacuumVolumeCommitHandler)
+	registerVolumeMetrics()
+	if err := volumeTLS.setup(); err != nil {
+		wlog.Fatal("invalid tls settings", "error", err)
+	}
+	security.SetSecret(*vSecret)
+	guard, err := security.NewGuard(*vSecret, strings.Split(*vWhiteList, ","))
+	if err != nil {
+		wlog.Fatal("invalid white list", "error", err)
+	}
+	volumeGuard = guard
+	operation.VolumeLocations = operation.NewLocationCache(time.Duration(*vLocationTTL) * time.Second)
+	hm := metrics.NewHandlerMetrics("volume")
+	http.HandleFunc("/", instrument(hm, "store", storeHandler))
+	http.HandleFunc("/status", instrument(hm, "status", statusHandler))
+	http.HandleFunc("/delete", instrument(hm, "bulk_delete", batchDeleteHandler))
+	http.HandleFunc("/metrics", metrics.Handler)
+	http.HandleFunc("/admin/assign_volume", instrument(hm, "assign_volume", guard.Secure(assignVolumeHandler)))
+	http.HandleFunc("/admin/readonly_volume", instrument(hm, "readonly_volume", guard.Secure(readonlyVolumeHandler)))
+	http.HandleFunc("/admin/writable_volume", instrument(hm, "writable_volume", guard.Secure(writableVolumeHandler)))
+	http.HandleFunc("/admin/delete_volume", instrument(hm, "delete_volume", guard.Secure(deleteVolumeHandler)))
+	http.HandleFunc("/admin/move_volume", instrument(hm, "move_volume", guard.Secure(moveVolumeHandler)))
+	http.HandleFunc("/admin/copy_volume", instrument(hm, "copy_volume", guard.Secure(copyVolumeHandler)))
+	http.HandleFunc("/admin/volume_file", instrument(hm, "volume_file", guard.Secure(volumeFileHandler)))
+	http.HandleFunc("/admin/vacuum_volume_check", instrument(hm, "vacuum_volume_check", guard.Secure(vacuumVolumeCheckHandler)))
+	http.HandleFunc("/admin/vacuum_volume_compact", instrument(hm, "vacuum_volume_compact", guard.Secure(vacuumVolumeCompactHandler)))
+	http.HandleFunc("/admin/volume_locations", instrument(hm, "volume_locations", guard.Secure(volumeLocationsHandler)))
+	http.HandleFunc("/admin/vacuum_volume_commit", instrument(hm, "vacuum_volume_commit", guard.Secure(vacuumVolumeCommitHandler)))
+
+	if *vCheckpoint > 0 {
+		go func() {
+			for _ = range time.Tick(time.Duration(*vCheckpoint) * time.Minute) {
+				store.Checkpoint()
+			}
+		}()
+	}
 
 	go func() {
 		connected := true
@@ -352,27 +705,29 @@ func runVolume(cmd *Command, args []string) bool {
 			if err == nil {
 				if !connected {
 					connected = true
-					log.Println("Reconnected with master")
+					wlog.Info("reconnected with master", "master", *masterNode)
 				}
 			} else {
+				volumeHeartbeatFailures.Inc()
 				if connected {
 					connected = false
+					wlog.Warn("lost connection with master", "master", *masterNode, "error", err)
 				}
 			}
 			time.Sleep(time.Duration(float32(*vpulse*1e3)*(1+rand.Float32())) * time.Millisecond)
 		}
 	}()
-	log.Println("store joined at", *masterNode)
+	wlog.Info("store joined", "master", *masterNode)
 
-	log.Println("Start Weed volume server", VERSION, "at http://"+*ip+":"+strconv.Itoa(*vport))
+	wlog.Info("starting weed volume server", "version", VERSION, "url", util.NormalizeUrl(*ip+":"+strconv.Itoa(*vport)))
 	srv := &http.Server{
 		Addr:        ":" + strconv.Itoa(*vport),
 		Handler:     http.DefaultServeMux,
 		ReadTimeout: (time.Duration(*vReadTimeout) * time.Second),
 	}
-	e := srv.ListenAndServe()
+	e := volumeTLS.listenAndServe(srv)
 	if e != nil {
-		log.Fatalf("Fail to start:%s", e.Error())
+		wlog.Fatal("failed to start", "error", e)
 	}
 	return true
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"code.google.com/p/weed-fs/go/operation"
+	"code.google.com/p/weed-fs/go/storage"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetHandlerRedirect(t *testing.T) {
+	locations := `{"url":"localhost:8080","publicUrl":"localhost:8080"},{"url":"other:8080","publicUrl":"other:8080"}`
+	lookups := 0
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		fmt.Fprintf(w, `{"locations":[%s]}`, locations)
+	}))
+	defer master.Close()
+	savedStore, savedMaster, savedIp, savedPort := store, *masterNode, *ip, *vport
+	defer func() { store, *masterNode, *ip, *vport = savedStore, savedMaster, savedIp, savedPort }()
+	store = storage.NewStore(8080, "localhost", "localhost:8080", nil, storage.NeedleMapInMemory)
+	*masterNode, *ip, *vport = strings.TrimPrefix(master.URL, "http://"), "localhost", 8080
+	defer operation.VolumeLocations.Invalidate(7)
+
+	//a stale cached location pointing back at this server
+	operation.VolumeLocations.Set(7, []operation.Location{{Url: "localhost:8080", PublicUrl: "localhost:8080"}})
+	w := httptest.NewRecorder()
+	GetHandler(w, httptest.NewRequest("GET", "/7,01637037d6", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "http://other:8080/7,01637037d6" {
+		t.Fatalf("got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if lookups != 1 {
+		t.Fatalf("%d lookups on the master, the cached locations were used", lookups)
+	}
+
+	locations = `{"url":"localhost:8080","publicUrl":"localhost:8080"}`
+	w = httptest.NewRecorder()
+	GetHandler(w, httptest.NewRequest("GET", "/7,01637037d6", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("redirected to this server: %d to %q", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"code.google.com/p/weed-fs/go/filer"
+	"code.google.com/p/weed-fs/go/metrics"
+	"code.google.com/p/weed-fs/go/operation"
+	"code.google.com/p/weed-fs/go/security"
+	"code.google.com/p/weed-fs/go/util"
+	"code.google.com/p/weed-fs/go/webdav"
+	"code.google.com/p/weed-fs/go/wlog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	cmdWebdav.Run = runWebdav // break init cycle
+	cmdWebdav.IsDebug = cmdWebdav.Flag.Bool("debug", false, "enable debug mode, same as -v=3")
+}
+
+var cmdWebdav = &Command{
+	UsageLine: "webdav -port=7333 -master=localhost:9333 -dir=/tmp/weed-webdav",
+	Short:     "start a WebDAV server",
+	Long: `start a WebDAV server, to mount the files as a network drive,
+  e.g. with davfs2, the Finder or the Windows explorer.
+
+  The paths, directories and chunk lists are kept in the filer -store in -dir,
+  in the format "weed filer" uses, and the content on the volume servers of -master,
+  in chunks of -chunkSizeMB.
+  Supported: OPTIONS, GET with Range, HEAD, PUT, DELETE, MKCOL, COPY, MOVE,
+  PROPFIND of depth 0 and 1, PROPPATCH, LOCK and UNLOCK. Locks are kept in memory,
+  and are lost when the server restarts.
+
+  `,
+}
+
+var (
+	webdavPort        = cmdWebdav.Flag.Int("port", 7333, "http listen port")
+	webdavMaster      = cmdWebdav.Flag.String("master", "localhost:9333", "master server locations, master[,master]...")
+	webdavDir         = cmdWebdav.Flag.String("dir", "/tmp/weed-webdav", "directory of the metadata store, created if missing, and used by one server at a time")
+	webdavStore       = cmdWebdav.Flag.String("store", "log", "metadata store: "+strings.Join(filer.StoreKinds(), " or "))
+	webdavChunkSizeMB = cmdWebdav.Flag.Int("chunkSizeMB", 8, "files are stored on the volume servers in chunks of this size")
+	webdavReplication = cmdWebdav.Flag.String("replication", "", "replication type of the stored files, the master's default if empty")
+	webdavReadTimeout = cmdWebdav.Flag.Int("readTimeout", 0, "connection read timeout in seconds, 0 for none, as large files are uploaded in one request")
+	webdavSecret      = cmdWebdav.Flag.String("secret", "", "shared secret of the master and volume servers, to get the tokens to write and delete files")
+	webdavTLS         = newServerTLSFlags(&cmdWebdav.Flag)
+)
+
+func runWebdav(cmd *Command, args []string) bool {
+	if err := webdavTLS.setup(); err != nil {
+		wlog.Fatal("invalid tls settings", "error", err)
+	}
+	security.SetSecret(*webdavSecret)
+	store, err := filer.OpenStore(*webdavStore, *webdavDir)
+	if err != nil {
+		wlog.Fatal("failed to open filer store", "store", *webdavStore, "dir", *webdavDir, "error", err)
+	}
+	defer store.Close()
+	f := filer.NewFiler(store, operation.NewClient(strings.Split(*webdavMaster, ",")...))
+	f.ChunkSize = int64(*webdavChunkSizeMB) * 1024 * 1024
+	f.Replication = *webdavReplication
+
+	hm := metrics.NewHandlerMetrics("webdav")
+	http.HandleFunc("/metrics", metrics.Handler)
+	http.HandleFunc("/", instrument(hm, "webdav", webdav.NewHandler(f).ServeHTTP))
+
+	wlog.Info("starting weed webdav", "version", VERSION, "port", *webdavPort, "store", *webdavStore, "dir", *webdavDir, "scheme", util.Scheme())
+	srv := &http.Server{
+		Addr:        ":" + strconv.Itoa(*webdavPort),
+		Handler:     http.DefaultServeMux,
+		ReadTimeout: time.Duration(*webdavReadTimeout) * time.Second,
+	}
+	if e := webdavTLS.listenAndServe(srv); e != nil {
+		wlog.Fatal("failed to start", "error", e)
+	}
+	return true
+}
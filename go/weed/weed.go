@@ -8,6 +8,9 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"code.google.com/p/weed-fs/go/metrics"
+	"code.google.com/p/weed-fs/go/trace"
+	"code.google.com/p/weed-fs/go/wlog"
 	"strings"
 	"sync"
 	"text/template"
@@ -16,22 +19,50 @@ import (
 	"unicode/utf8"
 )
 
-var IsDebug *bool
 var server *string
 
 var commands = []*Command{
 	cmdFix,
 	cmdMaster,
 	cmdUpload,
+	cmdDownload,
+	cmdCat,
+	cmdDelete,
 	cmdShell,
 	cmdVersion,
 	cmdVolume,
 	cmdExport,
+	cmdFiler,
+	cmdS3,
+	cmdWebdav,
+	cmdBenchmark,
 }
 
 var exitStatus = 0
 var exitMu sync.Mutex
 
+//every command takes the same logging flags
+func init() {
+	for _, cmd := range commands {
+		cmd.LogLevel = cmd.Flag.Int("v", int(wlog.InfoLevel), "log level: 0 errors, 1 warnings, 2 info, 3 debug")
+		cmd.LogFormat = cmd.Flag.String("logFormat", "text", "log format: text or json")
+	}
+}
+
+func setupLogging(cmd *Command) error {
+	format, err := wlog.NewFormat(*cmd.LogFormat)
+	if err != nil {
+		return err
+	}
+	wlog.SetFormat(format)
+	level := wlog.Level(*cmd.LogLevel)
+	if cmd.IsDebug != nil && *cmd.IsDebug {
+		level = wlog.DebugLevel
+	}
+	wlog.SetLevel(level)
+	return nil
+}
+
 func setExitStatus(n int) {
 	exitMu.Lock()
 	if exitStatus < n {
@@ -66,7 +97,11 @@ func main() {
 			cmd.Flag.Usage = func() { cmd.Usage() }
 			cmd.Flag.Parse(args[1:])
 			args = cmd.Flag.Args()
-			IsDebug = cmd.IsDebug
+			if err := setupLogging(cmd); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				setExitStatus(2)
+				exit()
+			}
 			if !cmd.Run(cmd, args) {
 				fmt.Fprintf(os.Stderr, "\n")
 				cmd.Flag.Usage()
@@ -173,6 +208,11 @@ func exitIfErrors() {
 		exit()
 	}
 }
+//counts, times and traces the requests to a handler
+func instrument(hm *metrics.HandlerMetrics, name string, fn http.HandlerFunc) http.HandlerFunc {
+	return hm.Wrap(name, trace.Wrap(name, fn))
+}
+
 func writeJson(w http.ResponseWriter, r *http.Request, obj interface{}) {
 	w.Header().Set("Content-Type", "application/javascript")
 	var bytes []byte
@@ -191,9 +231,3 @@ func writeJson(w http.ResponseWriter, r *http.Request, obj interface{}) {
 		w.Write([]uint8(")"))
 	}
 }
-
-func debug(params ...interface{}) {
-	if *IsDebug {
-		fmt.Println(params)
-	}
-}
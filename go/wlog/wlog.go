@@ -0,0 +1,240 @@
+package wlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the verbosity of a log line. A logger set to a level writes the lines
+// of that level and all lower ones, so -v=0 only shows errors and -v=3 everything.
+type Level int
+
+const (
+	ErrorLevel Level = iota
+	WarnLevel
+	InfoLevel
+	DebugLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case ErrorLevel:
+		return "error"
+	case WarnLevel:
+		return "warn"
+	case InfoLevel:
+		return "info"
+	}
+	return "debug"
+}
+
+type Format int
+
+const (
+	TextFormat Format = iota
+	JsonFormat
+)
+
+func NewFormat(s string) (Format, error) {
+	switch s {
+	case "text", "":
+		return TextFormat, nil
+	case "json":
+		return JsonFormat, nil
+	}
+	return TextFormat, errors.New("Unknown log format:" + s)
+}
+
+var (
+	level  = InfoLevel
+	format = TextFormat
+	output = io.Writer(os.Stderr)
+	lock   sync.Mutex
+)
+
+func SetLevel(l Level) {
+	lock.Lock()
+	level = l
+	lock.Unlock()
+}
+
+func SetFormat(f Format) {
+	lock.Lock()
+	format = f
+	lock.Unlock()
+}
+
+func SetOutput(w io.Writer) {
+	lock.Lock()
+	output = w
+	lock.Unlock()
+}
+
+//whether lines of level l are written, to skip building expensive fields
+func V(l Level) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	return l <= level
+}
+
+// Logger writes lines carrying its own key/value fields in front of the ones passed
+// to each call, e.g. a request scoped logger with the volume id and fid.
+type Logger struct {
+	fields []interface{}
+}
+
+var std = &Logger{}
+
+//keyvals are alternating keys and values: "volume", vid, "fid", fid
+func With(keyvals ...interface{}) *Logger {
+	return std.With(keyvals...)
+}
+
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	return &Logger{fields: append(fields, keyvals...)}
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.write(DebugLevel, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.write(InfoLevel, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.write(WarnLevel, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.write(ErrorLevel, msg, keyvals)
+}
+
+//logs at error level regardless of the verbosity, then exits
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.write(-1, msg, keyvals)
+	os.Exit(1)
+}
+
+func Debug(msg string, keyvals ...interface{}) {
+	std.write(DebugLevel, msg, keyvals)
+}
+
+func Info(msg string, keyvals ...interface{}) {
+	std.write(InfoLevel, msg, keyvals)
+}
+
+func Warn(msg string, keyvals ...interface{}) {
+	std.write(WarnLevel, msg, keyvals)
+}
+
+func Error(msg string, keyvals ...interface{}) {
+	std.write(ErrorLevel, msg, keyvals)
+}
+
+func Fatal(msg string, keyvals ...interface{}) {
+	std.write(-1, msg, keyvals)
+	os.Exit(1)
+}
+
+func (l *Logger) write(lv Level, msg string, keyvals []interface{}) {
+	lock.Lock()
+	defer lock.Unlock()
+	if lv > level {
+		return
+	}
+	if lv < ErrorLevel {
+		lv = ErrorLevel
+	}
+	fields := l.fields
+	if len(keyvals) > 0 {
+		fields = append(append(make([]interface{}, 0, len(l.fields)+len(keyvals)), l.fields...), keyvals...)
+	}
+	var buf bytes.Buffer
+	if format == JsonFormat {
+		writeJson(&buf, time.Now(), lv, msg, fields)
+	} else {
+		writeText(&buf, time.Now(), lv, msg, fields)
+	}
+	output.Write(buf.Bytes())
+}
+
+//a key without a value, from an odd number of keyvals, gets a nil value
+func pair(fields []interface{}, i int) (key string, value interface{}) {
+	key = fmt.Sprint(fields[i])
+	if i+1 < len(fields) {
+		value = fields[i+1]
+	}
+	return
+}
+
+func writeText(buf *bytes.Buffer, t time.Time, lv Level, msg string, fields []interface{}) {
+	buf.WriteString(t.Format("2006-01-02 15:04:05.000"))
+	buf.WriteByte(' ')
+	buf.WriteString(strings.ToUpper(lv.String()))
+	buf.WriteByte(' ')
+	buf.WriteString(msg)
+	for i := 0; i < len(fields); i += 2 {
+		key, value := pair(fields, i)
+		buf.WriteByte(' ')
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(quoteText(formatValue(value)))
+	}
+	buf.WriteByte('\n')
+}
+
+func quoteText(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func writeJson(buf *bytes.Buffer, t time.Time, lv Level, msg string, fields []interface{}) {
+	buf.WriteString(`{"time":`)
+	writeJsonValue(buf, t.Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJsonValue(buf, lv.String())
+	buf.WriteString(`,"msg":`)
+	writeJsonValue(buf, msg)
+	for i := 0; i < len(fields); i += 2 {
+		key, value := pair(fields, i)
+		buf.WriteByte(',')
+		writeJsonValue(buf, key)
+		buf.WriteByte(':')
+		switch value.(type) {
+		case nil, bool, int, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			writeJsonValue(buf, value)
+		default:
+			writeJsonValue(buf, formatValue(value))
+		}
+	}
+	buf.WriteString("}\n")
+}
+
+func writeJsonValue(buf *bytes.Buffer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+//fmt also takes care of errors, Stringers and nil pointers
+func formatValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,35 @@
+package wlog
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoggerOutput(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	SetLevel(InfoLevel)
+	defer SetOutput(os.Stderr)
+
+	l := With("volume", 3, "fid", "3,01637037d6")
+	l.Debug("reading")
+	if buf.Len() != 0 {
+		t.Fatal("debug line written at info level:", buf.String())
+	}
+	l.Info("read failed", "error", errors.New("not found"))
+	if line := buf.String(); !strings.HasSuffix(line, ` INFO read failed volume=3 fid=3,01637037d6 error="not found"`+"\n") {
+		t.Fatal("text line:", line)
+	}
+
+	buf.Reset()
+	SetFormat(JsonFormat)
+	defer SetFormat(TextFormat)
+	l.Warn("slow", "ms", 12)
+	line := buf.String()
+	if !strings.Contains(line, `"level":"warn","msg":"slow","volume":3,"fid":"3,01637037d6","ms":12}`) {
+		t.Fatal("json line:", line)
+	}
+}